@@ -13,8 +13,17 @@ import (
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/gcs"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/cloudfront"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/diskcache"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/encrypt"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/faultinject"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/georedirect"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/redirect"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/replicate"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/middleware/signedurl"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/ocilayout"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/s3-aws"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/s3-aws-artifactory"
+	_ "github.com/distribution/distribution/v3/registry/storage/driver/shardedfs"
 )
 
 func main() {
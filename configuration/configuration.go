@@ -174,6 +174,9 @@ type Configuration struct {
 
 	Proxy Proxy `yaml:"proxy,omitempty"`
 
+	// Tracing configures the export of OpenTelemetry traces.
+	Tracing Tracing `yaml:"tracing,omitempty"`
+
 	// Validation configures validation options for the registry.
 	Validation struct {
 		// Enabled enables the other options in this section. This field is
@@ -192,6 +195,9 @@ type Configuration struct {
 				// that URLs in pushed manifests must not match.
 				Deny []string `yaml:"deny,omitempty"`
 			} `yaml:"urls,omitempty"`
+			// Policies restrict the content of manifests pushed to the
+			// repositories they select.
+			Policies []ManifestPolicy `yaml:"policies,omitempty"`
 		} `yaml:"manifests,omitempty"`
 	} `yaml:"validation,omitempty"`
 
@@ -206,9 +212,127 @@ type Configuration struct {
 			// the class in authorized resources.
 			Classes []string `yaml:"classes"`
 		} `yaml:"repository,omitempty"`
+
+		// Tag configures policies for tags
+		Tag struct {
+			// Immutable is a list of rules selecting tags which, once
+			// pushed, may not be pointed at a different manifest.
+			Immutable []ImmutableTagRule `yaml:"immutable,omitempty"`
+		} `yaml:"tag,omitempty"`
+
+		// Admission configures external services which admit or deny
+		// manifest pushes
+		Admission struct {
+			// Webhooks is a list of services which are asked, in order,
+			// to review each manifest before it is stored.
+			Webhooks []AdmissionWebhook `yaml:"webhooks,omitempty"`
+		} `yaml:"admission,omitempty"`
+
+		// Signature configures verification of cosign signatures
+		Signature struct {
+			// Keys are the public keys trusted to sign manifests.
+			Keys []SignatureKey `yaml:"keys,omitempty"`
+
+			// Rules select the repositories whose manifests must be
+			// signed. The first rule matching a repository applies.
+			Rules []SignatureRule `yaml:"rules,omitempty"`
+		} `yaml:"signature,omitempty"`
+
+		// Quarantine keeps newly pushed manifests from being pulled until
+		// they are released by an administrator
+		Quarantine struct {
+			// Enabled quarantines the manifests pushed to the selected
+			// repositories.
+			Enabled bool `yaml:"enabled,omitempty"`
+
+			// Repositories is a list of glob patterns matched against
+			// repository names. All repositories are selected if it is
+			// empty.
+			Repositories []string `yaml:"repositories,omitempty"`
+		} `yaml:"quarantine,omitempty"`
 	} `yaml:"policy,omitempty"`
 }
 
+// SignatureKey configures a public key trusted to sign manifests.
+type SignatureKey struct {
+	// Name identifies the key in signature rules.
+	Name string `yaml:"name"`
+
+	// Path is the path of the PEM encoded public key.
+	Path string `yaml:"path"`
+}
+
+// SignatureRule requires the manifests of a set of repositories to be
+// signed.
+type SignatureRule struct {
+	// Repository is a glob pattern (https://pkg.go.dev/path#Match) matched
+	// against the repository name. An empty pattern matches every repository.
+	Repository string `yaml:"repository,omitempty"`
+
+	// Push requires manifests to be signed before they can be tagged.
+	Push bool `yaml:"push,omitempty"`
+
+	// Pull requires manifests to be signed before they can be pulled.
+	Pull bool `yaml:"pull,omitempty"`
+
+	// Keys are the names of the keys trusted by the rule. All keys are
+	// trusted if it is empty.
+	Keys []string `yaml:"keys,omitempty"`
+}
+
+// AdmissionWebhook configures an HTTP service which reviews manifest pushes.
+type AdmissionWebhook struct {
+	Name     string        `yaml:"name"`     // identifies the webhook in logs and errors
+	URL      string        `yaml:"url"`      // post url for the webhook
+	Headers  http.Header   `yaml:"headers"`  // static headers that should be added to all requests
+	Timeout  time.Duration `yaml:"timeout"`  // HTTP timeout
+	FailOpen bool          `yaml:"failopen"` // admit pushes when the webhook cannot be reached
+}
+
+// ManifestPolicy restricts the content of manifests pushed to a set of
+// repositories. Zero values leave the corresponding property unrestricted.
+type ManifestPolicy struct {
+	// Repository is a glob pattern (https://pkg.go.dev/path#Match) matched
+	// against the repository name. An empty pattern matches every repository.
+	Repository string `yaml:"repository,omitempty"`
+
+	// MediaTypes lists the media types of the manifests which may be pushed.
+	MediaTypes []string `yaml:"mediatypes,omitempty"`
+
+	// ArtifactTypes lists the artifact types of the manifests which may be
+	// pushed. The artifact type of an image manifest defaults to the media
+	// type of its config.
+	ArtifactTypes []string `yaml:"artifacttypes,omitempty"`
+
+	// MaxSize is the maximum size of a manifest in bytes.
+	MaxSize int64 `yaml:"maxsize,omitempty"`
+
+	// MaxLayerSize is the maximum size of a layer in bytes.
+	MaxLayerSize int64 `yaml:"maxlayersize,omitempty"`
+
+	// MaxLayers is the maximum number of layers of an image manifest.
+	MaxLayers int `yaml:"maxlayers,omitempty"`
+
+	// Annotations lists the annotations manifests must have.
+	Annotations []string `yaml:"annotations,omitempty"`
+
+	// Platforms lists the platforms, as os/architecture[/variant], which
+	// image indexes and manifest lists must reference.
+	Platforms []string `yaml:"platforms,omitempty"`
+}
+
+// ImmutableTagRule selects a set of tags which may not be overwritten once
+// they exist.
+type ImmutableTagRule struct {
+	// Repository is a glob pattern (https://pkg.go.dev/path#Match) matched
+	// against the repository name. An empty pattern matches every repository.
+	Repository string `yaml:"repository,omitempty"`
+
+	// Tag is a regular expression (https://godoc.org/regexp/syntax) which
+	// must match the whole tag name.
+	Tag string `yaml:"tag"`
+}
+
 // Catalog is composed of MaxEntries.
 // Catalog endpoint (/v2/_catalog) configuration, it provides the configuration
 // options to control the maximum number of entries returned by the catalog endpoint.
@@ -217,6 +341,11 @@ type Catalog struct {
 	// to the catalog endpoint will return at most MaxEntries entries.
 	// An empty or a negative value will set a default of 1000 maximum entries by default.
 	MaxEntries int `yaml:"maxentries,omitempty"`
+
+	// AccessFilter only returns the repositories the caller can pull, as
+	// decided by the access controller, instead of requiring access to the
+	// whole catalog.
+	AccessFilter bool `yaml:"accessfilter,omitempty"`
 }
 
 // LogHook is composed of hook Level and Type.
@@ -613,6 +742,37 @@ type Middleware struct {
 	Options Parameters `yaml:"options"`
 }
 
+// Tracing configures the export of the OpenTelemetry spans recorded by the
+// registry.
+type Tracing struct {
+	// Enabled enables tracing.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// ServiceName is the name of the service the spans are exported for,
+	// registry by default.
+	ServiceName string `yaml:"servicename,omitempty"`
+
+	// SampleRatio is the ratio of the traces started by the registry which
+	// are recorded, all of them by default. Traces started by clients are
+	// recorded if the clients sampled them.
+	SampleRatio float64 `yaml:"sampleratio,omitempty"`
+
+	// Exporter is where the spans are exported to: otlphttp, the default,
+	// file or stdout.
+	Exporter string `yaml:"exporter,omitempty"`
+
+	// Endpoint is the URL of the OTLP/HTTP endpoint of the otlphttp
+	// exporter.
+	Endpoint string `yaml:"endpoint,omitempty"`
+
+	// Headers are sent with the requests of the otlphttp exporter, such as
+	// for authentication.
+	Headers map[string]string `yaml:"headers,omitempty"`
+
+	// Path is the file the file exporter appends to.
+	Path string `yaml:"path,omitempty"`
+}
+
 // Proxy configures the registry as a pull through cache
 type Proxy struct {
 	// RemoteURL is the URL of the remote registry
@@ -33,6 +33,27 @@ func (err ErrTagUnknown) Error() string {
 	return fmt.Sprintf("unknown tag=%s", err.Tag)
 }
 
+// ErrTagImmutable is returned when an attempt is made to point an immutable
+// tag at a different manifest.
+type ErrTagImmutable struct {
+	Tag    string
+	Digest digest.Digest
+}
+
+func (err ErrTagImmutable) Error() string {
+	return fmt.Sprintf("tag=%s is immutable and already points to %s", err.Tag, err.Digest)
+}
+
+// ErrTagExists is returned if a tag cannot be restored because it has been
+// pointed at a different manifest since it was deleted.
+type ErrTagExists struct {
+	Tag string
+}
+
+func (err ErrTagExists) Error() string {
+	return fmt.Sprintf("tag %s already exists", err.Tag)
+}
+
 // ErrRepositoryUnknown is returned if the named repository is not known by
 // the registry.
 type ErrRepositoryUnknown struct {
@@ -43,6 +64,16 @@ func (err ErrRepositoryUnknown) Error() string {
 	return fmt.Sprintf("unknown repository name=%s", err.Name)
 }
 
+// ErrRepositoryExists is returned if a repository is created under a name
+// which is already in use.
+type ErrRepositoryExists struct {
+	Name string
+}
+
+func (err ErrRepositoryExists) Error() string {
+	return fmt.Sprintf("repository name=%s already exists", err.Name)
+}
+
 // ErrRepositoryNameInvalid should be used to denote an invalid repository
 // name. Reason may set, indicating the cause of invalidity.
 type ErrRepositoryNameInvalid struct {
@@ -98,6 +129,16 @@ func (errs ErrManifestVerification) Error() string {
 	return fmt.Sprintf("errors verifying manifest: %v", strings.Join(parts, ","))
 }
 
+// ErrManifestPolicy is returned when a manifest breaks a content policy
+// configured for its repository.
+type ErrManifestPolicy struct {
+	Reason string
+}
+
+func (err ErrManifestPolicy) Error() string {
+	return fmt.Sprintf("manifest violates policy: %s", err.Reason)
+}
+
 // ErrManifestBlobUnknown returned when a referenced blob cannot be found.
 type ErrManifestBlobUnknown struct {
 	Digest digest.Digest
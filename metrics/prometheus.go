@@ -13,4 +13,7 @@ var (
 
 	// NotificationsNamespace is the prometheus namespace of notification related metrics
 	NotificationsNamespace = metrics.NewNamespace(NamespacePrefix, "notifications", nil)
+
+	// TracingNamespace is the prometheus namespace of tracing related metrics
+	TracingNamespace = metrics.NewNamespace(NamespacePrefix, "tracing", nil)
 )
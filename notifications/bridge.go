@@ -90,6 +90,14 @@ func (b *bridge) ManifestDeleted(repo reference.Named, dgst digest.Digest) error
 	return b.createManifestDeleteEventAndWrite(EventActionDelete, repo, dgst)
 }
 
+func (b *bridge) ManifestQuarantined(repo reference.Named, dgst digest.Digest) error {
+	return b.createManifestDeleteEventAndWrite(EventActionQuarantine, repo, dgst)
+}
+
+func (b *bridge) ManifestReleased(repo reference.Named, dgst digest.Digest) error {
+	return b.createManifestDeleteEventAndWrite(EventActionRelease, repo, dgst)
+}
+
 func (b *bridge) BlobPushed(repo reference.Named, desc distribution.Descriptor) error {
 	return b.createBlobEventAndWrite(EventActionPush, repo, desc)
 }
@@ -126,6 +134,14 @@ func (b *bridge) RepoDeleted(repo reference.Named) error {
 	return b.sink.Write(*event)
 }
 
+func (b *bridge) RepoRenamed(from, to reference.Named) error {
+	event := b.createEvent(EventActionRename)
+	event.Target.Repository = to.Name()
+	event.Target.FromRepository = from.Name()
+
+	return b.sink.Write(*event)
+}
+
 func (b *bridge) createManifestDeleteEventAndWrite(action string, repo reference.Named, dgst digest.Digest) error {
 	event := b.createEvent(action)
 	event.Target.Repository = repo.Name()
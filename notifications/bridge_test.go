@@ -115,6 +115,30 @@ func TestEventBridgeManifestDeleted(t *testing.T) {
 	}
 }
 
+func TestEventBridgeManifestQuarantined(t *testing.T) {
+	for _, action := range []string{EventActionQuarantine, EventActionRelease} {
+		l := createTestEnv(t, testSinkFn(func(event events.Event) error {
+			checkDeleted(t, action, event)
+			if event.(Event).Action != action {
+				t.Fatalf("unexpected event action: %q != %q", event.(Event).Action, action)
+			}
+			if event.(Event).Target.Digest != dgst {
+				t.Fatalf("unexpected digest on event target: %q != %q", event.(Event).Target.Digest, dgst)
+			}
+			return nil
+		}))
+
+		repoRef, _ := reference.WithName(repo)
+		notify := l.ManifestQuarantined
+		if action == EventActionRelease {
+			notify = l.ManifestReleased
+		}
+		if err := notify(repoRef, dgst); err != nil {
+			t.Fatalf("unexpected error notifying manifest %s: %v", action, err)
+		}
+	}
+}
+
 func TestEventBridgeTagDeleted(t *testing.T) {
 	l := createTestEnv(t, testSinkFn(func(event events.Event) error {
 		checkDeleted(t, EventActionDelete, event)
@@ -142,6 +166,25 @@ func TestEventBridgeRepoDeleted(t *testing.T) {
 	}
 }
 
+func TestEventBridgeRepoRenamed(t *testing.T) {
+	l := createTestEnv(t, testSinkFn(func(event events.Event) error {
+		ev := event.(Event)
+		if ev.Action != EventActionRename {
+			t.Fatalf("unexpected event action: %q != %q", ev.Action, EventActionRename)
+		}
+		if ev.Target.Repository != "test/renamed" || ev.Target.FromRepository != repo {
+			t.Fatalf("unexpected event target: %#v", ev.Target)
+		}
+		return nil
+	}))
+
+	fromRef, _ := reference.WithName(repo)
+	toRef, _ := reference.WithName("test/renamed")
+	if err := l.RepoRenamed(fromRef, toRef); err != nil {
+		t.Fatalf("unexpected error notifying repo rename: %v", err)
+	}
+}
+
 func createTestEnv(t *testing.T, fn testSinkFn) Listener {
 	manifest := schema2.Manifest{
 		Versioned: manifest.Versioned{
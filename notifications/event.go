@@ -10,10 +10,13 @@ import (
 
 // EventAction constants used in action field of Event.
 const (
-	EventActionPull   = "pull"
-	EventActionPush   = "push"
-	EventActionMount  = "mount"
-	EventActionDelete = "delete"
+	EventActionPull       = "pull"
+	EventActionPush       = "push"
+	EventActionMount      = "mount"
+	EventActionDelete     = "delete"
+	EventActionRename     = "rename"
+	EventActionQuarantine = "quarantine"
+	EventActionRelease    = "release"
 )
 
 const (
@@ -64,7 +67,7 @@ type Event struct {
 		Repository string `json:"repository,omitempty"`
 
 		// FromRepository identifies the named repository which a blob was mounted
-		// from if appropriate.
+		// from, or which a repository was renamed from, if appropriate.
 		FromRepository string `json:"fromRepository,omitempty"`
 
 		// URL provides a direct link to the content.
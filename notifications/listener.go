@@ -17,6 +17,8 @@ type ManifestListener interface {
 	ManifestPushed(repo reference.Named, sm distribution.Manifest, options ...distribution.ManifestServiceOption) error
 	ManifestPulled(repo reference.Named, sm distribution.Manifest, options ...distribution.ManifestServiceOption) error
 	ManifestDeleted(repo reference.Named, dgst digest.Digest) error
+	ManifestQuarantined(repo reference.Named, dgst digest.Digest) error
+	ManifestReleased(repo reference.Named, dgst digest.Digest) error
 }
 
 // BlobListener describes a listener that can respond to layer related events.
@@ -31,6 +33,7 @@ type BlobListener interface {
 type RepoListener interface {
 	TagDeleted(repo reference.Named, tag string) error
 	RepoDeleted(repo reference.Named) error
+	RepoRenamed(from, to reference.Named) error
 }
 
 // Listener combines all repository events into a single interface.
@@ -69,6 +72,18 @@ func (nl *removerListener) Remove(ctx context.Context, name reference.Named) err
 	return nl.listener.RepoDeleted(name)
 }
 
+// Rename renames the repository if the wrapped remover supports it.
+func (nl *removerListener) Rename(ctx context.Context, from, to reference.Named) error {
+	renamer, ok := nl.RepositoryRemover.(distribution.RepositoryRenamer)
+	if !ok {
+		return distribution.ErrUnsupported
+	}
+	if err := renamer.Rename(ctx, from, to); err != nil {
+		return err
+	}
+	return nl.listener.RepoRenamed(from, to)
+}
+
 func (rl *repositoryListener) Manifests(ctx context.Context, options ...distribution.ManifestServiceOption) (distribution.ManifestService, error) {
 	manifests, err := rl.Repository.Manifests(ctx, options...)
 	if err != nil {
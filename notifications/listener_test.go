@@ -109,6 +109,21 @@ func (tl *testListener) RepoDeleted(repo reference.Named) error {
 	return nil
 }
 
+func (tl *testListener) ManifestQuarantined(repo reference.Named, dgst digest.Digest) error {
+	tl.ops["manifest:quarantine"]++
+	return nil
+}
+
+func (tl *testListener) ManifestReleased(repo reference.Named, dgst digest.Digest) error {
+	tl.ops["manifest:release"]++
+	return nil
+}
+
+func (tl *testListener) RepoRenamed(from, to reference.Named) error {
+	tl.ops["repo:rename"]++
+	return nil
+}
+
 // checkTestRepository takes the registry through all of its operations,
 // carrying out generic checks.
 func checkTestRepository(t *testing.T, repository distribution.Repository, remover distribution.RepositoryRemover) {
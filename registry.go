@@ -59,6 +59,11 @@ type RepositoryRemover interface {
 	Remove(ctx context.Context, name reference.Named) error
 }
 
+// RepositoryRenamer moves a repository to a new name
+type RepositoryRenamer interface {
+	Rename(ctx context.Context, from, to reference.Named) error
+}
+
 // ManifestServiceOption is a function argument for Manifest Service methods
 type ManifestServiceOption interface {
 	Apply(ManifestService) error
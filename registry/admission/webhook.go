@@ -0,0 +1,169 @@
+// Package admission asks external services to admit or deny manifests
+// before they are stored in the registry.
+package admission
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/opencontainers/go-digest"
+)
+
+// defaultTimeout is used for webhooks which do not configure a timeout.
+const defaultTimeout = 5 * time.Second
+
+// maxResponseSize limits the size of the decision read from a webhook.
+const maxResponseSize = 1 << 20
+
+// Request is posted to a webhook for each manifest push.
+type Request struct {
+	// Repository is the name of the repository the manifest is pushed to.
+	Repository string `json:"repository"`
+
+	// Tag is the tag the manifest is pushed with, if any.
+	Tag string `json:"tag,omitempty"`
+
+	// Digest is the digest of the manifest.
+	Digest digest.Digest `json:"digest"`
+
+	// MediaType is the media type of the manifest.
+	MediaType string `json:"mediaType"`
+
+	// Manifest is the manifest as pushed.
+	Manifest json.RawMessage `json:"manifest"`
+
+	// User is the name of the authenticated user pushing the manifest, if
+	// any.
+	User string `json:"user,omitempty"`
+}
+
+// Response is the decision returned by a webhook.
+type Response struct {
+	// Allowed admits the manifest.
+	Allowed bool `json:"allowed"`
+
+	// Reason explains why the manifest was denied. It is returned to the
+	// client.
+	Reason string `json:"reason,omitempty"`
+}
+
+// ErrDenied is returned when a webhook denies a manifest.
+type ErrDenied struct {
+	Webhook string
+	Reason  string
+}
+
+func (err ErrDenied) Error() string {
+	if err.Reason == "" {
+		return fmt.Sprintf("manifest denied by %s", err.Webhook)
+	}
+	return fmt.Sprintf("manifest denied by %s: %s", err.Webhook, err.Reason)
+}
+
+// ErrUnavailable is returned when a webhook which fails closed cannot be
+// reached or does not return a decision.
+type ErrUnavailable struct {
+	Webhook string
+	Err     error
+}
+
+func (err ErrUnavailable) Error() string {
+	return fmt.Sprintf("admission webhook %s unavailable: %v", err.Webhook, err.Err)
+}
+
+// Webhook reviews manifests by posting them to an HTTP service.
+type Webhook struct {
+	name     string
+	url      string
+	headers  http.Header
+	failOpen bool
+	client   *http.Client
+}
+
+// NewWebhook returns a webhook posting requests to url. A zero timeout
+// selects a default. If failOpen is set, manifests are admitted when the
+// service cannot be reached or does not return a decision in time.
+func NewWebhook(name, url string, timeout time.Duration, headers http.Header, failOpen bool) *Webhook {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Webhook{
+		name:     name,
+		url:      url,
+		headers:  headers,
+		failOpen: failOpen,
+		client:   &http.Client{Timeout: timeout},
+	}
+}
+
+// Review asks the webhook whether the manifest described by req may be
+// stored. It returns ErrDenied if the webhook denies it, and ErrUnavailable
+// if no decision could be obtained and the webhook fails closed.
+func (w *Webhook) Review(ctx context.Context, req Request) error {
+	resp, err := w.post(ctx, req)
+	if err != nil {
+		if w.failOpen {
+			dcontext.GetLogger(ctx).Warnf("admission webhook %s unavailable, admitting manifest %s: %v", w.name, req.Digest, err)
+			return nil
+		}
+		return ErrUnavailable{Webhook: w.name, Err: err}
+	}
+
+	if !resp.Allowed {
+		return ErrDenied{Webhook: w.name, Reason: resp.Reason}
+	}
+	return nil
+}
+
+func (w *Webhook) post(ctx context.Context, req Request) (Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Response{}, err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return Response{}, err
+	}
+	for k, v := range w.headers {
+		r.Header[k] = v
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := w.client.Do(r)
+	if err != nil {
+		return Response{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Response{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var decision Response
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(&decision); err != nil {
+		return Response{}, fmt.Errorf("invalid response: %v", err)
+	}
+	return decision, nil
+}
+
+// Chain reviews manifests with a list of webhooks in order. A manifest is
+// admitted if every webhook admits it.
+type Chain []*Webhook
+
+// Review asks each webhook in turn whether the manifest described by req may
+// be stored, stopping at the first which does not admit it.
+func (c Chain) Review(ctx context.Context, req Request) error {
+	for _, w := range c {
+		if err := w.Review(ctx, req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package admission
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func TestWebhookReview(t *testing.T) {
+	var received Request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch received.Tag {
+		case "slow":
+			time.Sleep(200 * time.Millisecond)
+		case "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(Response{
+			Allowed: received.Tag != "unsigned",
+			Reason:  "missing signature",
+		})
+	}))
+	defer server.Close()
+
+	ctx := context.Background()
+	headers := http.Header{"Authorization": {"Bearer secret"}}
+	request := func(tag string) Request {
+		manifest := json.RawMessage(`{"schemaVersion":2}`)
+		return Request{
+			Repository: "foo/bar",
+			Tag:        tag,
+			Digest:     digest.FromBytes(manifest),
+			MediaType:  "application/vnd.oci.image.manifest.v1+json",
+			Manifest:   manifest,
+			User:       "alice",
+		}
+	}
+
+	closed := NewWebhook("policy", server.URL, 100*time.Millisecond, headers, false)
+	if err := closed.Review(ctx, request("signed")); err != nil {
+		t.Fatalf("expected manifest to be admitted: %v", err)
+	}
+	if received.Repository != "foo/bar" || received.User != "alice" || string(received.Manifest) != `{"schemaVersion":2}` {
+		t.Fatalf("unexpected request received: %+v", received)
+	}
+
+	err := closed.Review(ctx, request("unsigned"))
+	if denied, ok := err.(ErrDenied); !ok || denied.Reason != "missing signature" || denied.Webhook != "policy" {
+		t.Fatalf("expected manifest to be denied, got %v", err)
+	}
+
+	for _, tag := range []string{"slow", "broken"} {
+		if _, ok := closed.Review(ctx, request(tag)).(ErrUnavailable); !ok {
+			t.Fatalf("expected a webhook failing closed to be unavailable for %s", tag)
+		}
+	}
+
+	open := NewWebhook("policy", server.URL, 100*time.Millisecond, headers, true)
+	for _, tag := range []string{"slow", "broken"} {
+		if err := open.Review(ctx, request(tag)); err != nil {
+			t.Fatalf("expected a webhook failing open to admit %s: %v", tag, err)
+		}
+	}
+	// a webhook failing open still honors denials
+	if _, ok := open.Review(ctx, request("unsigned")).(ErrDenied); !ok {
+		t.Fatalf("expected a webhook failing open to deny")
+	}
+
+	chain := Chain{open, closed}
+	if _, ok := chain.Review(ctx, request("broken")).(ErrUnavailable); !ok {
+		t.Fatalf("expected the chain to stop at the webhook failing closed")
+	}
+}
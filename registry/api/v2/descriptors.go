@@ -112,6 +112,47 @@ var (
 		},
 	}
 
+	catalogFilterParameters = []ParameterDescriptor{
+		{
+			Name:        "prefix",
+			Type:        "string",
+			Description: "Only return repositories whose name starts with prefix.",
+			Format:      "<string>",
+			Required:    false,
+		},
+		{
+			Name:        "q",
+			Type:        "string",
+			Description: "Only return repositories whose name contains q.",
+			Format:      "<string>",
+			Required:    false,
+		},
+	}
+
+	tagDetailsParameters = []ParameterDescriptor{
+		{
+			Name:        "details",
+			Type:        "boolean",
+			Description: "Return the digest, media type and size of the manifest each tag points at and the time the tag was last modified.",
+			Format:      "true",
+			Required:    false,
+		},
+		{
+			Name:        "sort",
+			Type:        "string",
+			Description: "Sort the tags by `name` or by `date` of last modification. Defaults to `name`.",
+			Format:      "name|date",
+			Required:    false,
+		},
+		{
+			Name:        "order",
+			Type:        "string",
+			Description: "Sort the tags in `asc` or `desc` order. Defaults to `asc`.",
+			Format:      "asc|desc",
+			Required:    false,
+		},
+	}
+
 	unauthorizedResponseDescriptor = ResponseDescriptor{
 		Name:        "Authentication Required",
 		StatusCode:  http.StatusUnauthorized,
@@ -236,6 +277,13 @@ const (
         ...
     ]
 }`
+
+	maintenanceBody = `{
+	"readOnly": <bool>,
+	"reason": <reason>,
+	"retryAfter": <seconds>,
+	"since": <time>
+}`
 )
 
 // APIDescriptor exports descriptions of the layout of the v2 registry API.
@@ -509,6 +557,62 @@ var routeDescriptors = []RouteDescriptor{
 							tooManyRequestsDescriptor,
 						},
 					},
+					{
+						Name:            "Tags Details",
+						Description:     "Return the tags for the specified repository together with the manifests they point at, sorted by name or by the time they were last modified. This is an extension which is not available on a pull-through cache.",
+						PathParameters:  []ParameterDescriptor{nameParameterDescriptor},
+						QueryParameters: append(paginationParameters, tagDetailsParameters...),
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "A list of tags and their manifests for the named repository.",
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+									linkHeader,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "name": <name>,
+    "tags": [
+        {
+            "name": <tag>,
+            "digest": <digest>,
+            "mediaType": <media type>,
+            "size": <size>,
+            "lastModified": <time>
+        },
+        ...
+    ],
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							invalidPaginationResponseDescriptor,
+							{
+								Name:        "Invalid sort order",
+								Description: "The received sort or order parameter is not supported.",
+								StatusCode:  http.StatusBadRequest,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodePaginationSortInvalid,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
 				},
 			},
 		},
@@ -650,6 +754,18 @@ var routeDescriptors = []RouteDescriptor{
 									errcode.ErrorCodeUnsupported,
 								},
 							},
+							{
+								Name:        "Tag Immutable",
+								Description: "The tag is protected by an immutability rule and already refers to a different manifest.",
+								StatusCode:  http.StatusConflict,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeTagImmutable,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
 						},
 					},
 				},
@@ -1537,8 +1653,9 @@ var routeDescriptors = []RouteDescriptor{
 				Description: "Retrieve a sorted, json list of repositories available in the registry.",
 				Requests: []RequestDescriptor{
 					{
-						Name:        "Catalog Fetch",
-						Description: "Request an unabridged list of repositories available.  The implementation may impose a maximum limit and return a partial set with pagination links.",
+						Name:            "Catalog Fetch",
+						Description:     "Request an unabridged list of repositories available.  The implementation may impose a maximum limit and return a partial set with pagination links.",
+						QueryParameters: catalogFilterParameters,
 						Successes: []ResponseDescriptor{
 							{
 								Description: "Returns the unabridged list of repositories as a json response.",
@@ -1566,7 +1683,7 @@ var routeDescriptors = []RouteDescriptor{
 					{
 						Name:            "Catalog Fetch Paginated",
 						Description:     "Return the specified portion of repositories.",
-						QueryParameters: paginationParameters,
+						QueryParameters: append(paginationParameters, catalogFilterParameters...),
 						Successes: []ResponseDescriptor{
 							{
 								StatusCode: http.StatusOK,
@@ -1599,6 +1716,527 @@ var routeDescriptors = []RouteDescriptor{
 			},
 		},
 	},
+	{
+		Name:        RouteNameUsage,
+		Path:        "/v2/_registry/usage",
+		Entity:      "Usage",
+		Description: "Report the storage used by each repository in the registry. This is an administrative extension which is only available when an access controller is configured, and requires access to the `registry:admin:*` resource.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Walk all repositories and return their logical, shared and exclusive size, manifest and tag counts and push times. This can be slow on large registries.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"repositories": [
+		{
+			"name": <name>,
+			"manifests": <count>,
+			"tags": <count>,
+			"blobs": <count>,
+			"size": <bytes>,
+			"sharedSize": <bytes>,
+			"exclusiveSize": <bytes>,
+			"oldestPush": <time>,
+			"newestPush": <time>
+		},
+		...
+	],
+	"size": <bytes>
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							{
+								Description: "The registry does not support the operation, either because no access controller is configured or because it is a pull-through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameMaintenance,
+		Path:        "/v2/_registry/maintenance",
+		Entity:      "Maintenance",
+		Description: "Get or set the maintenance mode of the registry at runtime. This is an administrative extension which is only available when an access controller is configured, and requires access to the `registry:admin:*` resource.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Return the maintenance mode set at runtime.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      maintenanceBody,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							{
+								Description: "The registry does not support the operation because no access controller is configured.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+			{
+				Method:      http.MethodPut,
+				Description: "Switch the registry in or out of read-only mode. While it is read-only, uploads, manifest pushes and deletes fail with `503 Service Unavailable` and a `Retry-After` header.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format: `{
+	"readOnly": <bool>,
+	"reason": <reason>,
+	"retryAfter": <seconds>
+}`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      maintenanceBody,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid State",
+								Description: "The request body is not a valid maintenance state.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeMaintenanceInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							{
+								Description: "The registry does not support the operation because no access controller is configured.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameRepository,
+		Path:        "/v2/_registry/repositories/{name:" + reference.NameRegexp.String() + "}",
+		Entity:      "Repository",
+		Description: "Delete or rename a whole repository. This is an extension which is only available when an access controller is configured and deletion is enabled in the storage configuration.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodDelete,
+				Description: "Delete the tags, manifests and layer links of the repository identified by `name`. Requires `delete` access to the repository. The blobs referenced by the repository are removed by the next garbage collection.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusAccepted,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							{
+								Description: "The registry does not support the operation, either because no access controller is configured, deletion is disabled or it is a pull-through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+			{
+				Method:      http.MethodPost,
+				Description: "Rename the repository identified by `name`, linking its layers, manifests and tags into the repository named by the `to` query parameter and removing it. Requires `delete` access to the repository and `push` access to the new repository.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "to",
+								Type:        "query",
+								Format:      "<name>",
+								Regexp:      reference.NameRegexp,
+								Required:    true,
+								Description: "The new name of the repository.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusAccepted,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Name",
+								Description: "The new name of the repository is missing or invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeNameInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Name Conflict",
+								Description: "A repository with the new name already exists.",
+								StatusCode:  http.StatusConflict,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeNameConflict,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							{
+								Description: "The registry does not support the operation, either because no access controller is configured, deletion is disabled or it is a pull-through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameTrash,
+		Path:        "/v2/_registry/trash/{name:" + reference.NameRegexp.String() + "}",
+		Entity:      "Trash",
+		Description: "List and restore the soft deleted manifests and tags of a repository. This is an administrative extension which is only available when an access controller is configured and soft deletion is enabled, and requires access to the `registry:admin:*` resource.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "List the soft deleted manifests and tags of the repository which can still be restored.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"manifests": [
+		{
+			"digest": <digest>,
+			"references": [<digest>, ...],
+			"deletedAt": <time>,
+			"deletedBy": <user>,
+			"expiresAt": <time>
+		},
+		...
+	],
+	"tags": [
+		{
+			"digest": <digest>,
+			"tag": <tag>,
+			"deletedAt": <time>,
+			"deletedBy": <user>,
+			"expiresAt": <time>
+		},
+		...
+	]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							{
+								Description: "The registry does not support the operation, either because no access controller is configured, soft deletion is disabled or it is a pull-through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+			{
+				Method:      http.MethodPost,
+				Description: "Restore a soft deleted manifest, together with the deleted tags pointing at it, or a soft deleted tag, together with its manifest. Exactly one of the `digest` and `tag` query parameters must be given.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "digest",
+								Type:        "query",
+								Format:      "<digest>",
+								Regexp:      digest.DigestRegexp,
+								Description: "Digest of the manifest to restore.",
+							},
+							{
+								Name:        "tag",
+								Type:        "query",
+								Format:      "<tag>",
+								Regexp:      reference.TagRegexp,
+								Description: "Name of the tag to restore.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusAccepted,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Parameters",
+								Description: "Neither or both of `digest` and `tag` were given, or they are invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeDigestInvalid,
+									ErrorCodeTagInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Unknown Entry",
+								Description: "The manifest or tag is not in the recycle bin, or its retention has expired.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Tag Exists",
+								Description: "The tag has been pointed at a different manifest since it was deleted.",
+								StatusCode:  http.StatusConflict,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeTagExists,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							{
+								Description: "The registry does not support the operation, either because no access controller is configured, soft deletion is disabled or it is a pull-through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameQuarantine,
+		Path:        "/v2/_registry/quarantine/{name:" + reference.NameRegexp.String() + "}",
+		Entity:      "Quarantine",
+		Description: "List and release the quarantined manifests of a repository. This is an administrative extension which is only available when an access controller is configured and quarantine is enabled, and requires access to the `registry:admin:*` resource.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "List the quarantined manifests of the repository.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"manifests": [
+		{
+			"digest": <digest>,
+			"state": "quarantined",
+			"references": [<digest>, ...],
+			"quarantinedAt": <time>,
+			"quarantinedBy": <user>
+		},
+		...
+	]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							{
+								Description: "The registry does not support the operation, either because no access controller is configured, quarantine is not enabled for the repository or it is a pull-through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+			{
+				Method:      http.MethodPost,
+				Description: "Release a quarantined manifest, together with the blobs first added to the repository for it.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "digest",
+								Type:        "query",
+								Format:      "<digest>",
+								Regexp:      digest.DigestRegexp,
+								Required:    true,
+								Description: "Digest of the manifest to release.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusAccepted,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Name:        "Invalid Digest",
+								Description: "The `digest` parameter is missing or invalid.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeDigestInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Name:        "Unknown Manifest",
+								Description: "The manifest is not quarantined.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							deniedResponseDescriptor,
+							{
+								Description: "The registry does not support the operation, either because no access controller is configured, quarantine is not enabled for the repository or it is a pull-through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
 }
 
 var routeDescriptorsMap map[string]RouteDescriptor
@@ -63,6 +63,40 @@ var (
 		HTTPStatusCode: http.StatusBadRequest,
 	})
 
+	// ErrorCodeTagImmutable is returned when a manifest push attempts to
+	// point an immutable tag at a different manifest.
+	ErrorCodeTagImmutable = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "TAG_IMMUTABLE",
+		Message: "tag is immutable",
+		Description: `During a manifest upload, if the tag is protected by an
+		immutability rule and already refers to a different manifest, this
+		error will be returned. Pushing the same manifest again succeeds.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
+	// ErrorCodeTagExists is returned when a soft deleted tag cannot be
+	// restored because it has been reused.
+	ErrorCodeTagExists = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "TAG_EXISTS",
+		Message: "tag already exists",
+		Description: `When restoring a tag from the recycle bin, if the tag
+		has been pointed at a different manifest since it was deleted, this
+		error will be returned.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
+	// ErrorCodeQuarantined is returned when pulling a manifest or blob which
+	// is quarantined.
+	ErrorCodeQuarantined = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "QUARANTINED",
+		Message: "content is quarantined",
+		Description: `When a manifest or blob is pulled, if it has been
+		pushed while quarantine is enforced and has not been released yet,
+		this error will be returned to callers which are not registry
+		administrators.`,
+		HTTPStatusCode: http.StatusForbidden,
+	})
+
 	// ErrorCodeNameUnknown when the repository name is not known.
 	ErrorCodeNameUnknown = errcode.Register(errGroup, errcode.ErrorDescriptor{
 		Value:   "NAME_UNKNOWN",
@@ -72,6 +106,16 @@ var (
 		HTTPStatusCode: http.StatusNotFound,
 	})
 
+	// ErrorCodeNameConflict is returned when a repository is renamed to a
+	// name which is already in use.
+	ErrorCodeNameConflict = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "NAME_CONFLICT",
+		Message: "repository name already in use",
+		Description: `This is returned if a repository is renamed to a name
+		which already holds layers or manifests.`,
+		HTTPStatusCode: http.StatusConflict,
+	})
+
 	// ErrorCodeManifestUnknown returned when image manifest is unknown.
 	ErrorCodeManifestUnknown = errcode.Register(errGroup, errcode.ErrorDescriptor{
 		Value:   "MANIFEST_UNKNOWN",
@@ -155,4 +199,24 @@ var (
 		the maximum allowed.`,
 		HTTPStatusCode: http.StatusBadRequest,
 	})
+
+	// ErrorCodePaginationSortInvalid is returned when the `sort` or `order`
+	// parameter is not supported.
+	ErrorCodePaginationSortInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "PAGINATION_SORT_INVALID",
+		Message: "invalid sort order requested",
+		Description: `Returned when the "sort" parameter is not one of "name"
+		or "date", or the "order" parameter is not one of "asc" or "desc".`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeMaintenanceInvalid is returned when the requested maintenance
+	// state is invalid.
+	ErrorCodeMaintenanceInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "MAINTENANCE_INVALID",
+		Message: "invalid maintenance state",
+		Description: `Returned when the body of a request changing the
+		maintenance mode of the registry is not a valid maintenance state.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
 )
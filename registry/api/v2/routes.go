@@ -16,6 +16,11 @@ const (
 	RouteNameBlobUpload      = "blob-upload"
 	RouteNameBlobUploadChunk = "blob-upload-chunk"
 	RouteNameCatalog         = "catalog"
+	RouteNameUsage           = "usage"
+	RouteNameRepository      = "repository"
+	RouteNameTrash           = "trash"
+	RouteNameQuarantine      = "quarantine"
+	RouteNameMaintenance     = "maintenance"
 )
 
 var (
@@ -95,6 +95,32 @@ func TestRouter(t *testing.T) {
 				"digest": "sha256:abcdef0919234",
 			},
 		},
+		{
+			RouteName:  RouteNameRepository,
+			RequestURI: "/v2/_registry/repositories/foo/bar",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameTrash,
+			RequestURI: "/v2/_registry/trash/foo/bar",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
+		{
+			RouteName:  RouteNameMaintenance,
+			RequestURI: "/v2/_registry/maintenance",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameQuarantine,
+			RequestURI: "/v2/_registry/quarantine/foo/bar",
+			Vars: map[string]string{
+				"name": "foo/bar",
+			},
+		},
 		{
 			RouteName:  RouteNameBlobUpload,
 			RequestURI: "/v2/foo/bar/blobs/uploads/",
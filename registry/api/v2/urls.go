@@ -127,6 +127,69 @@ func (ub *URLBuilder) BuildCatalogURL(values ...url.Values) (string, error) {
 	return appendValuesURL(catalogURL, values...).String(), nil
 }
 
+// BuildUsageURL constructs a url to get the storage usage of the registry
+func (ub *URLBuilder) BuildUsageURL() (string, error) {
+	route := ub.cloneRoute(RouteNameUsage)
+
+	usageURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return usageURL.String(), nil
+}
+
+// BuildMaintenanceURL constructs a url to get or set the maintenance mode of
+// the registry
+func (ub *URLBuilder) BuildMaintenanceURL() (string, error) {
+	route := ub.cloneRoute(RouteNameMaintenance)
+
+	maintenanceURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return maintenanceURL.String(), nil
+}
+
+// BuildRepositoryURL constructs a url to administer the named repository.
+func (ub *URLBuilder) BuildRepositoryURL(name reference.Named, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameRepository)
+
+	repositoryURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(repositoryURL, values...).String(), nil
+}
+
+// BuildTrashURL constructs a url to list or restore the soft deleted
+// manifests and tags of the named repository.
+func (ub *URLBuilder) BuildTrashURL(name reference.Named, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameTrash)
+
+	trashURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(trashURL, values...).String(), nil
+}
+
+// BuildQuarantineURL constructs a url to list or release the quarantined
+// manifests of the named repository.
+func (ub *URLBuilder) BuildQuarantineURL(name reference.Named, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameQuarantine)
+
+	quarantineURL, err := route.URL("name", name.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(quarantineURL, values...).String(), nil
+}
+
 // BuildTagsURL constructs a url to list the tags in the named repository.
 func (ub *URLBuilder) BuildTagsURL(name reference.Named, values ...url.Values) (string, error) {
 	route := ub.cloneRoute(RouteNameTags)
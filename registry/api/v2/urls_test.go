@@ -54,6 +54,16 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 				})
 			},
 		},
+		{
+			description:  "test repository url with to query parameter",
+			expectedPath: "/v2/_registry/repositories/foo/bar?to=foo%2Fbaz",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildRepositoryURL(fooBarRef, url.Values{
+					"to": []string{"foo/baz"},
+				})
+			},
+		},
 		{
 			description:  "test manifest url tagged ref",
 			expectedPath: "/v2/foo/bar/manifests/tag",
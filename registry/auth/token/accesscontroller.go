@@ -15,8 +15,15 @@ import (
 	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/registry/auth"
 	"github.com/docker/libtrust"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// tracer starts the spans of the token verifications.
+var tracer = otel.Tracer("github.com/distribution/distribution/v3/registry/auth/token")
+
 // accessSet maps a typed, named resource to
 // a set of actions requested or authorized.
 type accessSet map[auth.Resource]actionSet
@@ -253,24 +260,12 @@ func (ac *accessController) Authorized(ctx context.Context, accessItems ...auth.
 		return nil, challenge
 	}
 
-	token, err := NewToken(rawToken)
+	token, err := ac.verify(ctx, rawToken)
 	if err != nil {
 		challenge.err = err
 		return nil, challenge
 	}
 
-	verifyOpts := VerifyOptions{
-		TrustedIssuers:    []string{ac.issuer},
-		AcceptedAudiences: []string{ac.service},
-		Roots:             ac.rootCerts,
-		TrustedKeys:       ac.trustedKeys,
-	}
-
-	if err = token.Verify(verifyOpts); err != nil {
-		challenge.err = err
-		return nil, challenge
-	}
-
 	accessSet := token.accessSet()
 	for _, access := range accessItems {
 		if !accessSet.contains(access) {
@@ -284,6 +279,32 @@ func (ac *accessController) Authorized(ctx context.Context, accessItems ...auth.
 	return auth.WithUser(ctx, auth.UserInfo{Name: token.Claims.Subject}), nil
 }
 
+// verify parses rawToken and verifies that it was issued for this registry
+// by the trusted issuer.
+func (ac *accessController) verify(ctx context.Context, rawToken string) (*Token, error) {
+	_, span := tracer.Start(ctx, "auth.token.Verify", trace.WithAttributes(
+		attribute.String("auth.token.issuer", ac.issuer),
+		attribute.String("auth.token.service", ac.service),
+	))
+	defer span.End()
+
+	token, err := NewToken(rawToken)
+	if err == nil {
+		err = token.Verify(VerifyOptions{
+			TrustedIssuers:    []string{ac.issuer},
+			AcceptedAudiences: []string{ac.service},
+			Roots:             ac.rootCerts,
+			TrustedKeys:       ac.trustedKeys,
+		})
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return nil, err
+	}
+	return token, nil
+}
+
 // init handles registering the token auth backend.
 func init() {
 	auth.Register("token", auth.InitFunc(newAccessController))
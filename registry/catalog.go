@@ -0,0 +1,37 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/distribution/distribution/v3/registry/handlers"
+	"github.com/distribution/distribution/v3/registry/storage"
+	rediscache "github.com/distribution/distribution/v3/registry/storage/cache/redis"
+	"github.com/spf13/cobra"
+)
+
+// RebuildCatalogCmd is the cobra command that corresponds to the
+// rebuild-catalog subcommand
+var RebuildCatalogCmd = &cobra.Command{
+	Use:   "rebuild-catalog <config>",
+	Short: "`rebuild-catalog` rebuilds the catalog index from the storage",
+	Long:  "`rebuild-catalog` walks all repositories and brings the redis catalog index in line with the storage",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, config, driver, registry := openStorage(cmd, args)
+
+		if config.Storage["cache"]["catalog"] != "redis" {
+			fmt.Fprintln(os.Stderr, "configuration error: rebuild-catalog requires a redis catalog index")
+			os.Exit(1)
+		}
+		if config.Redis.Addr == "" {
+			fmt.Fprintln(os.Stderr, "configuration error: redis configuration required to use for catalog index")
+			os.Exit(1)
+		}
+
+		index := rediscache.NewRedisCatalogIndex(handlers.NewRedisClient(config.Redis))
+		if err := storage.RebuildCatalogIndex(ctx, driver, registry, index); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rebuild catalog index: %v", err)
+			os.Exit(1)
+		}
+	},
+}
@@ -0,0 +1,117 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/spf13/cobra"
+)
+
+var (
+	duFormat string
+	duSort   string
+)
+
+// DuCmd is the cobra command that corresponds to the du subcommand
+var DuCmd = &cobra.Command{
+	Use:   "du <config>",
+	Short: "`du` reports the storage used by each repository",
+	Long:  "`du` walks all repositories and reports their logical, shared and exclusive size, manifest and tag counts and push times",
+	Run: func(cmd *cobra.Command, args []string) {
+		if duFormat != "table" && duFormat != "json" {
+			fmt.Fprintf(os.Stderr, "unsupported output format %q\n", duFormat)
+			cmd.Usage()
+			os.Exit(1)
+		}
+		if err := sortUsage(nil, duSort); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx, _, driver, registry := openStorage(cmd, args)
+
+		report, err := storage.Usage(ctx, driver, registry)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to compute usage: %v", err)
+			os.Exit(1)
+		}
+		_ = sortUsage(report.Repositories, duSort)
+
+		if duFormat == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(report)
+		} else {
+			err = writeUsageTable(os.Stdout, report)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write usage: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// sortUsage orders usages by the named key. Sizes are sorted largest first.
+func sortUsage(usages []storage.RepositoryUsage, key string) error {
+	var less func(a, b storage.RepositoryUsage) bool
+	switch key {
+	case "name":
+		less = func(a, b storage.RepositoryUsage) bool { return a.Name < b.Name }
+	case "size":
+		less = func(a, b storage.RepositoryUsage) bool { return a.Size > b.Size }
+	case "exclusive":
+		less = func(a, b storage.RepositoryUsage) bool { return a.ExclusiveSize > b.ExclusiveSize }
+	default:
+		return fmt.Errorf("unsupported sort key %q", key)
+	}
+	sort.SliceStable(usages, func(i, j int) bool {
+		return less(usages[i], usages[j])
+	})
+	return nil
+}
+
+func writeUsageTable(w io.Writer, report storage.UsageReport) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "REPOSITORY\tSIZE\tSHARED\tEXCLUSIVE\tMANIFESTS\tTAGS\tOLDEST PUSH\tNEWEST PUSH")
+	for _, usage := range report.Repositories {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			usage.Name,
+			formatSize(usage.Size),
+			formatSize(usage.SharedSize),
+			formatSize(usage.ExclusiveSize),
+			usage.Manifests,
+			usage.Tags,
+			formatPushTime(usage.OldestPush),
+			formatPushTime(usage.NewestPush))
+	}
+	fmt.Fprintf(tw, "TOTAL\t%s\t\t\t\t\t\t\n", formatSize(report.Size))
+	return tw.Flush()
+}
+
+// formatSize renders a byte count using binary units.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+func formatPushTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.UTC().Format(time.RFC3339)
+}
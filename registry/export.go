@@ -0,0 +1,82 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/reference"
+	"github.com/spf13/cobra"
+)
+
+// ExportCmd is the cobra command that corresponds to the export subcommand
+var ExportCmd = &cobra.Command{
+	Use:   "export <config> <repository>[:<tag>|@<digest>] <path>",
+	Short: "`export` writes a repository to an OCI image layout",
+	Long:  "`export` writes all tags of a repository, or a single tag or digest, to an OCI image layout directory, or to an uncompressed tarball if the path ends in .tar",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 3 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		ref, err := parseRepositoryReference(args[1])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx, _, _, registry := openStorage(cmd, args)
+
+		result, err := storage.ExportOCILayout(ctx, registry, ref, args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to export %s: %v\n", args[1], err)
+			os.Exit(1)
+		}
+		fmt.Printf("exported %d manifests, %d blobs (%s) and %d tags to %s\n",
+			result.Manifests, result.Blobs, formatSize(result.Bytes), len(result.Tags), args[2])
+	},
+}
+
+// ImportCmd is the cobra command that corresponds to the import subcommand
+var ImportCmd = &cobra.Command{
+	Use:   "import <config> <path> <repository>",
+	Short: "`import` loads an OCI image layout into a repository",
+	Long:  "`import` loads the manifests listed in an OCI image layout directory or uncompressed tarball into a repository, tagging them with their org.opencontainers.image.ref.name annotation",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) != 3 {
+			cmd.Usage()
+			os.Exit(1)
+		}
+		name, err := reference.WithName(args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid repository name %q: %v\n", args[2], err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx, _, _, registry := openStorage(cmd, args)
+
+		result, err := storage.ImportOCILayout(ctx, registry, name, args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to import %s: %v\n", args[1], err)
+			os.Exit(1)
+		}
+		fmt.Printf("imported %d manifests, %d blobs (%s) and %d tags into %s\n",
+			result.Manifests, result.Blobs, formatSize(result.Bytes), len(result.Tags), name.Name())
+	},
+}
+
+// parseRepositoryReference parses a repository name with an optional tag or
+// digest.
+func parseRepositoryReference(s string) (reference.Named, error) {
+	ref, err := reference.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reference %q: %v", s, err)
+	}
+	named, ok := ref.(reference.Named)
+	if !ok {
+		return nil, fmt.Errorf("invalid reference %q: repository name required", s)
+	}
+	return named, nil
+}
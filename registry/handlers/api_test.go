@@ -3,7 +3,14 @@ package handlers
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
@@ -13,26 +20,38 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/manifest"
 	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/admission"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/signature"
+	"github.com/distribution/distribution/v3/registry/storage"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	"github.com/distribution/distribution/v3/testutil"
 	"github.com/distribution/reference"
+	events "github.com/docker/go-events"
 	"github.com/gorilla/handlers"
 	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 var headerConfig = http.Header{
@@ -447,6 +466,156 @@ func TestCatalogAPI(t *testing.T) {
 	}
 }
 
+// denySecretsAccessController authenticates every caller but denies access to
+// repositories whose name contains "secret".
+type denySecretsAccessController struct{}
+
+type denySecretsChallenge struct{}
+
+func (denySecretsChallenge) Error() string { return "access denied" }
+
+func (denySecretsChallenge) SetHeaders(r *http.Request, w http.ResponseWriter) {}
+
+func (denySecretsAccessController) Authorized(ctx context.Context, access ...auth.Access) (context.Context, error) {
+	for _, a := range access {
+		if a.Type == "repository" && strings.Contains(a.Name, "secret") {
+			return nil, denySecretsChallenge{}
+		}
+		if a.Type == "registry" {
+			return nil, denySecretsChallenge{}
+		}
+	}
+	return ctx, nil
+}
+
+// TestCatalogAPIFilters tests filtering the catalog by prefix, query and
+// access.
+func TestCatalogAPIFilters(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	for _, image := range []string{"foo/aaaa", "foo/bbbb", "foo/secret", "foo-bar/cccc", "foobar", "secret/dddd", "zzzz"} {
+		createRepository(env, t, image, "sometag")
+	}
+
+	getCatalog := func(values url.Values) ([]string, string) {
+		t.Helper()
+		catalogURL, err := env.builder.BuildCatalogURL(values)
+		if err != nil {
+			t.Fatalf("unexpected error building catalog url: %v", err)
+		}
+		resp, err := http.Get(catalogURL)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		defer resp.Body.Close()
+		checkResponse(t, "issuing catalog api check", resp, http.StatusOK)
+
+		var ctlg struct {
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&ctlg); err != nil {
+			t.Fatalf("error decoding catalog: %v", err)
+		}
+		return ctlg.Repositories, resp.Header.Get("Link")
+	}
+
+	for _, tc := range []struct {
+		values   url.Values
+		expected []string
+	}{
+		{url.Values{"prefix": {"foo/"}}, []string{"foo/aaaa", "foo/bbbb", "foo/secret"}},
+		{url.Values{"prefix": {"foo"}}, []string{"foo/aaaa", "foo/bbbb", "foo/secret", "foo-bar/cccc", "foobar"}},
+		{url.Values{"prefix": {"foo/"}, "last": {"foo/aaaa"}}, []string{"foo/bbbb", "foo/secret"}},
+		{url.Values{"q": {"secret"}}, []string{"foo/secret", "secret/dddd"}},
+		{url.Values{"prefix": {"foo/"}, "q": {"b"}}, []string{"foo/bbbb"}},
+		{url.Values{"prefix": {"none"}}, []string{}},
+	} {
+		repos, _ := getCatalog(tc.values)
+		if !reflect.DeepEqual(repos, tc.expected) {
+			t.Fatalf("unexpected repositories for %v: %v != %v", tc.values, repos, tc.expected)
+		}
+	}
+
+	// the link to the next page keeps the filters
+	repos, link := getCatalog(url.Values{"prefix": {"foo"}, "n": {"2"}})
+	if !reflect.DeepEqual(repos, []string{"foo/aaaa", "foo/bbbb"}) {
+		t.Fatalf("unexpected first page: %v", repos)
+	}
+	values := checkLink(t, link, 2, "foo/bbbb")
+	if values.Get("prefix") != "foo" {
+		t.Fatalf("expected link to keep the prefix: %s", link)
+	}
+	repos, _ = getCatalog(values)
+	if !reflect.DeepEqual(repos, []string{"foo/secret", "foo-bar/cccc"}) {
+		t.Fatalf("unexpected second page: %v", repos)
+	}
+
+	// with an access filter, only the repositories the caller can pull are
+	// returned and access to the whole catalog is not required
+	env.app.accessController = denySecretsAccessController{}
+	env.app.Config.Catalog.AccessFilter = true
+	repos, link = getCatalog(url.Values{"n": {"3"}})
+	if !reflect.DeepEqual(repos, []string{"foo/aaaa", "foo/bbbb", "foo-bar/cccc"}) {
+		t.Fatalf("unexpected filtered repositories: %v", repos)
+	}
+	values = checkLink(t, link, 3, "foo-bar/cccc")
+	repos, link = getCatalog(values)
+	if !reflect.DeepEqual(repos, []string{"foobar", "zzzz"}) || link != "" {
+		t.Fatalf("unexpected filtered repositories: %v, link %q", repos, link)
+	}
+}
+
+// TestCatalogAPIFiltersLastBatch tests that the repositories left in the last
+// batch when a filtered page is full are listed on the next page.
+func TestCatalogAPIFiltersLastBatch(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	for _, image := range []string{"aaaa", "bbbb", "cccc/secret", "dddd", "eeee"} {
+		createRepository(env, t, image, "sometag")
+	}
+	env.app.accessController = denySecretsAccessController{}
+	env.app.Config.Catalog.AccessFilter = true
+
+	// the first batch keeps two repositories, and the last one, [dddd eeee],
+	// fills the page with dddd
+	var listed []string
+	values := url.Values{"n": {"3"}}
+	for page := 0; values != nil; page++ {
+		if page == 3 {
+			t.Fatalf("too many pages: %v", listed)
+		}
+		catalogURL, err := env.builder.BuildCatalogURL(values)
+		if err != nil {
+			t.Fatalf("unexpected error building catalog url: %v", err)
+		}
+		resp, err := http.Get(catalogURL)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		checkResponse(t, "issuing catalog api check", resp, http.StatusOK)
+		var ctlg struct {
+			Repositories []string `json:"repositories"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&ctlg)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("error decoding catalog: %v", err)
+		}
+		listed = append(listed, ctlg.Repositories...)
+
+		values = nil
+		if link := resp.Header.Get("Link"); link != "" {
+			values = checkLink(t, link, 3, ctlg.Repositories[len(ctlg.Repositories)-1])
+		}
+	}
+
+	if expected := []string{"aaaa", "bbbb", "dddd", "eeee"}; !reflect.DeepEqual(listed, expected) {
+		t.Fatalf("unexpected repositories: %v != %v", listed, expected)
+	}
+}
+
 // TestTagsAPI tests the /v2/<name>/tags/list endpoint
 func TestTagsAPI(t *testing.T) {
 	env := newTestEnv(t, false)
@@ -603,6 +772,91 @@ func TestTagsAPI(t *testing.T) {
 	}
 }
 
+// TestTagsAPIDetails tests sorting the tags by date and listing them with the
+// details of their manifests.
+func TestTagsAPIDetails(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("test")
+	if err != nil {
+		t.Fatalf("unable to parse reference: %v", err)
+	}
+
+	digests := make(map[string]digest.Digest)
+	for _, tag := range []string{"b", "a", "c"} {
+		digests[tag] = createRepository(env, t, imageName.Name(), tag)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	getTags := func(values url.Values, body interface{}) *http.Response {
+		t.Helper()
+		tagsURL, err := env.builder.BuildTagsURL(imageName, values)
+		if err != nil {
+			t.Fatalf("unexpected error building tags URL: %v", err)
+		}
+		resp, err := http.Get(tagsURL)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		if body != nil {
+			defer resp.Body.Close()
+			checkResponse(t, "listing tags", resp, http.StatusOK)
+			if err := json.NewDecoder(resp.Body).Decode(body); err != nil {
+				t.Fatalf("unexpected error decoding response body: %v", err)
+			}
+		}
+		return resp
+	}
+
+	for _, tc := range []struct {
+		values   url.Values
+		expected []string
+		link     string
+	}{
+		{url.Values{"sort": {"date"}}, []string{"b", "a", "c"}, ""},
+		{url.Values{"sort": {"date"}, "order": {"desc"}, "n": {"2"}}, []string{"c", "a"}, `</v2/test/tags/list?last=a&n=2&order=desc&sort=date>; rel="next"`},
+		{url.Values{"sort": {"date"}, "order": {"desc"}, "n": {"2"}, "last": {"a"}}, []string{"b"}, ""},
+		{url.Values{"sort": {"date"}, "last": {"unknown"}}, []string{}, ""},
+		{url.Values{"order": {"desc"}, "last": {"bb"}}, []string{"b", "a"}, ""},
+	} {
+		var body tagsAPIResponse
+		resp := getTags(tc.values, &body)
+		if !reflect.DeepEqual(body.Tags, tc.expected) {
+			t.Fatalf("unexpected tags for %v: %v != %v", tc.values, body.Tags, tc.expected)
+		}
+		if link := resp.Header.Get("Link"); link != tc.link {
+			t.Fatalf("unexpected link for %v: %q != %q", tc.values, link, tc.link)
+		}
+	}
+
+	// reading the manifests of the tags is not reported as a pull
+	sink := &recordingSink{}
+	env.app.events.sink = sink
+	var body tagDetailsAPIResponse
+	resp := getTags(url.Values{"details": {"true"}, "n": {"2"}}, &body)
+	if len(sink.events) != 0 {
+		t.Fatalf("unexpected events listing tag details: %+v", sink.events)
+	}
+	if len(body.Tags) != 2 || body.Tags[0].Name != "a" || body.Tags[1].Name != "b" {
+		t.Fatalf("unexpected tag details: %+v", body.Tags)
+	}
+	for _, detail := range body.Tags {
+		if detail.Digest != digests[detail.Name] || detail.MediaType != schema2.MediaTypeManifest ||
+			detail.Size == 0 || detail.LastModified.IsZero() {
+			t.Fatalf("unexpected details of tag %s: %+v", detail.Name, detail)
+		}
+	}
+	if link := resp.Header.Get("Link"); link != `</v2/test/tags/list?details=true&last=b&n=2>; rel="next"` {
+		t.Fatalf("unexpected link: %q", link)
+	}
+
+	resp = getTags(url.Values{"sort": {"size"}}, nil)
+	defer resp.Body.Close()
+	checkResponse(t, "listing tags with an invalid sort", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "listing tags with an invalid sort", resp, v2.ErrorCodePaginationSortInvalid)
+}
+
 func checkLink(t *testing.T, urlStr string, numEntries int, last string) url.Values {
 	re := regexp.MustCompile("<(/v2/_catalog.*)>; rel=\"next\"")
 	matches := re.FindStringSubmatch(urlStr)
@@ -633,6 +887,603 @@ func contains(elems []string, e string) bool {
 	return false
 }
 
+// TestUsageAPI tests the administrative storage usage endpoint.
+func TestUsageAPI(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	usageURL, err := env.builder.BuildUsageURL()
+	if err != nil {
+		t.Fatalf("unexpected error building usage url: %v", err)
+	}
+
+	// administrative routes are unavailable without an access controller
+	resp, err := http.Get(usageURL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "getting usage without access controller", resp, http.StatusMethodNotAllowed)
+	checkBodyHasErrorCodes(t, "getting usage without access controller", resp, errcode.ErrorCodeUnsupported)
+
+	createRepository(env, t, "foo/bar", "latest")
+
+	env.app.accessController, err = auth.GetAccessController("silly", map[string]interface{}{
+		"realm":   "realm-test",
+		"service": "service-test",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating access controller: %v", err)
+	}
+
+	resp, err = http.Get(usageURL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "getting usage without credentials", resp, http.StatusUnauthorized)
+
+	req, err := http.NewRequest(http.MethodGet, usageURL, nil)
+	if err != nil {
+		t.Fatalf("unexpected error creating request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer sillytoken")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "getting usage", resp, http.StatusOK)
+
+	var report storage.UsageReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		t.Fatalf("error decoding fetched usage: %v", err)
+	}
+	if len(report.Repositories) != 1 {
+		t.Fatalf("unexpected repositories in usage: %+v", report.Repositories)
+	}
+	usage := report.Repositories[0]
+	if usage.Name != "foo/bar" || usage.Manifests != 1 || usage.Tags != 1 || usage.Size == 0 || usage.Size != report.Size {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+}
+
+func TestRepositoryAPI(t *testing.T) {
+	env := newTestEnv(t, true)
+	defer env.Shutdown()
+
+	fooRef, _ := reference.WithName("foo/bar")
+	bazRef, _ := reference.WithName("foo/baz")
+	createRepository(env, t, fooRef.Name(), "latest")
+
+	fooURL, err := env.builder.BuildRepositoryURL(fooRef)
+	if err != nil {
+		t.Fatalf("unexpected error building repository url: %v", err)
+	}
+
+	// administrative routes are unavailable without an access controller
+	resp, err := httpDelete(fooURL)
+	if err != nil {
+		t.Fatalf("unexpected error issuing request: %v", err)
+	}
+	defer resp.Body.Close()
+	checkResponse(t, "deleting repository without access controller", resp, http.StatusMethodNotAllowed)
+	checkBodyHasErrorCodes(t, "deleting repository without access controller", resp, errcode.ErrorCodeUnsupported)
+
+	env.app.accessController, err = auth.GetAccessController("silly", map[string]interface{}{
+		"realm":   "realm-test",
+		"service": "service-test",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating access controller: %v", err)
+	}
+
+	do := func(method, url string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer sillytoken")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		return resp
+	}
+
+	// rename foo/bar to foo/baz
+	renameURL, err := env.builder.BuildRepositoryURL(fooRef, url.Values{"to": []string{bazRef.Name()}})
+	if err != nil {
+		t.Fatalf("unexpected error building repository url: %v", err)
+	}
+	resp = do(http.MethodPost, renameURL)
+	defer resp.Body.Close()
+	checkResponse(t, "renaming repository", resp, http.StatusAccepted)
+
+	tagRef, _ := reference.WithTag(bazRef, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	resp = do(http.MethodGet, manifestURL)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest from renamed repository", resp, http.StatusOK)
+
+	resp = do(http.MethodPost, renameURL)
+	defer resp.Body.Close()
+	checkResponse(t, "renaming unknown repository", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "renaming unknown repository", resp, v2.ErrorCodeNameUnknown)
+
+	accessController := env.app.accessController
+	env.app.accessController = nil
+	createRepository(env, t, fooRef.Name(), "latest")
+	env.app.accessController = accessController
+
+	resp = do(http.MethodPost, renameURL)
+	defer resp.Body.Close()
+	checkResponse(t, "renaming onto existing repository", resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, "renaming onto existing repository", resp, v2.ErrorCodeNameConflict)
+
+	invalidURL, err := env.builder.BuildRepositoryURL(fooRef, url.Values{"to": []string{"Invalid Name"}})
+	if err != nil {
+		t.Fatalf("unexpected error building repository url: %v", err)
+	}
+	resp = do(http.MethodPost, invalidURL)
+	defer resp.Body.Close()
+	checkResponse(t, "renaming to invalid name", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "renaming to invalid name", resp, v2.ErrorCodeNameInvalid)
+
+	// delete foo/baz
+	bazURL, err := env.builder.BuildRepositoryURL(bazRef)
+	if err != nil {
+		t.Fatalf("unexpected error building repository url: %v", err)
+	}
+	resp = do(http.MethodDelete, bazURL)
+	defer resp.Body.Close()
+	checkResponse(t, "deleting repository", resp, http.StatusAccepted)
+
+	resp = do(http.MethodGet, manifestURL)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest from deleted repository", resp, http.StatusNotFound)
+
+	resp = do(http.MethodDelete, bazURL)
+	defer resp.Body.Close()
+	checkResponse(t, "deleting unknown repository", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "deleting unknown repository", resp, v2.ErrorCodeNameUnknown)
+
+	catalogURL, err := env.builder.BuildCatalogURL()
+	if err != nil {
+		t.Fatalf("unexpected error building catalog url: %v", err)
+	}
+	resp = do(http.MethodGet, catalogURL)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching catalog", resp, http.StatusOK)
+
+	var ctlg struct {
+		Repositories []string `json:"repositories"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ctlg); err != nil {
+		t.Fatalf("error decoding catalog: %v", err)
+	}
+	if len(ctlg.Repositories) != 1 || ctlg.Repositories[0] != fooRef.Name() {
+		t.Fatalf("unexpected repositories in catalog: %v", ctlg.Repositories)
+	}
+}
+
+func TestTrashAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"delete": configuration.Parameters{
+				"enabled":   true,
+				"soft":      true,
+				"retention": "1h",
+			},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	fooRef, _ := reference.WithName("foo/bar")
+	dgst := createRepository(env, t, fooRef.Name(), "latest")
+
+	var err error
+	env.app.accessController, err = auth.GetAccessController("silly", map[string]interface{}{
+		"realm":   "realm-test",
+		"service": "service-test",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating access controller: %v", err)
+	}
+
+	do := func(method, url string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %v", err)
+		}
+		req.Header.Set("Authorization", "Bearer sillytoken")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		return resp
+	}
+
+	digestRef, _ := reference.WithDigest(fooRef, dgst)
+	digestURL, err := env.builder.BuildManifestURL(digestRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	tagRef, _ := reference.WithTag(fooRef, "latest")
+	tagURL, err := env.builder.BuildManifestURL(tagRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+
+	resp := do(http.MethodDelete, digestURL)
+	defer resp.Body.Close()
+	checkResponse(t, "deleting manifest", resp, http.StatusAccepted)
+
+	resp = do(http.MethodGet, tagURL)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching soft deleted manifest", resp, http.StatusNotFound)
+
+	trashURL, err := env.builder.BuildTrashURL(fooRef)
+	if err != nil {
+		t.Fatalf("unexpected error building trash url: %v", err)
+	}
+	resp = do(http.MethodGet, trashURL)
+	defer resp.Body.Close()
+	checkResponse(t, "listing trash", resp, http.StatusOK)
+
+	var listing storage.TrashListing
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		t.Fatalf("error decoding trash listing: %v", err)
+	}
+	if len(listing.Manifests) != 1 || listing.Manifests[0].Digest != dgst {
+		t.Fatalf("unexpected trashed manifests: %v", listing.Manifests)
+	}
+	if len(listing.Tags) != 1 || listing.Tags[0].Tag != "latest" {
+		t.Fatalf("unexpected trashed tags: %v", listing.Tags)
+	}
+	if expiry := listing.Manifests[0].ExpiresAt.Sub(listing.Manifests[0].DeletedAt); expiry != time.Hour {
+		t.Fatalf("unexpected retention of trashed manifest: %v", expiry)
+	}
+
+	restoreURL, err := env.builder.BuildTrashURL(fooRef, url.Values{"digest": []string{dgst.String()}})
+	if err != nil {
+		t.Fatalf("unexpected error building trash url: %v", err)
+	}
+	resp = do(http.MethodPost, restoreURL)
+	defer resp.Body.Close()
+	checkResponse(t, "restoring manifest", resp, http.StatusAccepted)
+
+	resp = do(http.MethodGet, tagURL)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching restored manifest", resp, http.StatusOK)
+
+	resp = do(http.MethodPost, restoreURL)
+	defer resp.Body.Close()
+	checkResponse(t, "restoring manifest not in trash", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "restoring manifest not in trash", resp, v2.ErrorCodeManifestUnknown)
+
+	resp = do(http.MethodPost, trashURL)
+	defer resp.Body.Close()
+	checkResponse(t, "restoring without digest or tag", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "restoring without digest or tag", resp, v2.ErrorCodeTagInvalid)
+}
+
+// adminHeaderAccessController authenticates every caller but only grants
+// registry administration to callers presenting the "admin" token.
+type adminHeaderAccessController struct{}
+
+func (adminHeaderAccessController) Authorized(ctx context.Context, access ...auth.Access) (context.Context, error) {
+	for _, a := range access {
+		if a.Type != "registry" {
+			continue
+		}
+		req, err := dcontext.GetRequest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if req.Header.Get("Authorization") != "Bearer admin" {
+			return nil, denySecretsChallenge{}
+		}
+	}
+	return ctx, nil
+}
+
+func TestQuarantineAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"silly": configuration.Parameters{
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+		},
+	}
+	config.Policy.Quarantine.Enabled = true
+	config.Policy.Quarantine.Repositories = []string{"foo/*"}
+	config.HTTP.Headers = headerConfig
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+	env.app.accessController = adminHeaderAccessController{}
+
+	do := func(method, url string, admin bool) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %v", err)
+		}
+		req.Header.Set("Accept", schema2.MediaTypeManifest)
+		if admin {
+			req.Header.Set("Authorization", "Bearer admin")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		return resp
+	}
+
+	fooRef, _ := reference.WithName("foo/bar")
+	dgst := createRepository(env, t, fooRef.Name(), "latest")
+
+	tagRef, _ := reference.WithTag(fooRef, "latest")
+	tagURL, err := env.builder.BuildManifestURL(tagRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	quarantineURL, err := env.builder.BuildQuarantineURL(fooRef)
+	if err != nil {
+		t.Fatalf("unexpected error building quarantine url: %v", err)
+	}
+	releaseURL, err := env.builder.BuildQuarantineURL(fooRef, url.Values{"digest": {dgst.String()}})
+	if err != nil {
+		t.Fatalf("unexpected error building quarantine url: %v", err)
+	}
+
+	resp := do(http.MethodGet, tagURL, false)
+	defer resp.Body.Close()
+	checkResponse(t, "pulling quarantined manifest", resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, "pulling quarantined manifest", resp, v2.ErrorCodeQuarantined)
+
+	resp = do(http.MethodHead, tagURL, false)
+	defer resp.Body.Close()
+	checkResponse(t, "checking quarantined manifest", resp, http.StatusOK)
+
+	// administrators, such as scanners, can pull quarantined content
+	resp = do(http.MethodGet, tagURL, true)
+	defer resp.Body.Close()
+	checkResponse(t, "pulling quarantined manifest as admin", resp, http.StatusOK)
+	var m schema2.Manifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		t.Fatalf("unexpected error decoding manifest: %v", err)
+	}
+
+	layerRef, _ := reference.WithDigest(fooRef, m.Layers[0].Digest)
+	layerURL, err := env.builder.BuildBlobURL(layerRef)
+	if err != nil {
+		t.Fatalf("unexpected error building blob url: %v", err)
+	}
+	resp = do(http.MethodGet, layerURL, false)
+	defer resp.Body.Close()
+	checkResponse(t, "pulling quarantined layer", resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, "pulling quarantined layer", resp, v2.ErrorCodeQuarantined)
+
+	resp = do(http.MethodGet, layerURL, true)
+	defer resp.Body.Close()
+	checkResponse(t, "pulling quarantined layer as admin", resp, http.StatusOK)
+
+	resp = do(http.MethodGet, quarantineURL, false)
+	defer resp.Body.Close()
+	checkResponse(t, "listing quarantine", resp, http.StatusUnauthorized)
+
+	resp = do(http.MethodGet, quarantineURL, true)
+	defer resp.Body.Close()
+	checkResponse(t, "listing quarantine as admin", resp, http.StatusOK)
+	var listing struct {
+		Manifests []storage.QuarantineRecord `json:"manifests"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		t.Fatalf("unexpected error decoding quarantine: %v", err)
+	}
+	if len(listing.Manifests) != 1 || listing.Manifests[0].Digest != dgst {
+		t.Fatalf("unexpected quarantined manifests: %v", listing.Manifests)
+	}
+
+	// a manifest which fails to be stored leaves no quarantine record
+	unknownManifest := &schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config: distribution.Descriptor{
+			Digest:    "sha256:1a9ec845ee94c202b2d5da74a24f0ed2058318bfa9879fa541efaecba272e86b",
+			Size:      3253,
+			MediaType: schema2.MediaTypeImageConfig,
+		},
+	}
+	failedRef, _ := reference.WithTag(fooRef, "failed")
+	failedURL, err := env.builder.BuildManifestURL(failedRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	resp = putManifest(t, "putting manifest with unknown config", failedURL, schema2.MediaTypeManifest, unknownManifest)
+	defer resp.Body.Close()
+	checkResponse(t, "putting manifest with unknown config", resp, http.StatusBadRequest)
+
+	resp = do(http.MethodGet, quarantineURL, true)
+	defer resp.Body.Close()
+	checkResponse(t, "listing quarantine after failed push", resp, http.StatusOK)
+	if err := json.NewDecoder(resp.Body).Decode(&listing); err != nil {
+		t.Fatalf("unexpected error decoding quarantine: %v", err)
+	}
+	if len(listing.Manifests) != 1 || listing.Manifests[0].Digest != dgst {
+		t.Fatalf("unexpected quarantined manifests after failed push: %v", listing.Manifests)
+	}
+
+	resp = do(http.MethodPost, releaseURL, true)
+	defer resp.Body.Close()
+	checkResponse(t, "releasing manifest", resp, http.StatusAccepted)
+
+	resp = do(http.MethodGet, tagURL, false)
+	defer resp.Body.Close()
+	checkResponse(t, "pulling released manifest", resp, http.StatusOK)
+
+	resp = do(http.MethodGet, layerURL, false)
+	defer resp.Body.Close()
+	checkResponse(t, "pulling released layer", resp, http.StatusOK)
+
+	resp = do(http.MethodPost, releaseURL, true)
+	defer resp.Body.Close()
+	checkResponse(t, "releasing manifest again", resp, http.StatusNotFound)
+	checkBodyHasErrorCodes(t, "releasing manifest again", resp, v2.ErrorCodeManifestUnknown)
+
+	// repositories not selected by the policy are not quarantined
+	otherRef, _ := reference.WithName("other/bar")
+	createRepository(env, t, otherRef.Name(), "latest")
+	otherTagRef, _ := reference.WithTag(otherRef, "latest")
+	otherTagURL, err := env.builder.BuildManifestURL(otherTagRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+	resp = do(http.MethodGet, otherTagURL, false)
+	defer resp.Body.Close()
+	checkResponse(t, "pulling unquarantined manifest", resp, http.StatusOK)
+
+	otherQuarantineURL, err := env.builder.BuildQuarantineURL(otherRef)
+	if err != nil {
+		t.Fatalf("unexpected error building quarantine url: %v", err)
+	}
+	resp = do(http.MethodGet, otherQuarantineURL, true)
+	defer resp.Body.Close()
+	checkResponse(t, "listing quarantine of unselected repository", resp, http.StatusMethodNotAllowed)
+	checkBodyHasErrorCodes(t, "listing quarantine of unselected repository", resp, errcode.ErrorCodeUnsupported)
+}
+
+func TestMaintenanceAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Auth: configuration.Auth{
+			"silly": configuration.Parameters{
+				"realm":   "realm-test",
+				"service": "service-test",
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+	env.app.accessController = adminHeaderAccessController{}
+
+	do := func(method, url, body string, admin bool) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %v", err)
+		}
+		if admin {
+			req.Header.Set("Authorization", "Bearer admin")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error issuing request: %v", err)
+		}
+		return resp
+	}
+
+	fooRef, _ := reference.WithName("foo/bar")
+	dgst := createRepository(env, t, fooRef.Name(), "latest")
+
+	maintenanceURL, err := env.builder.BuildMaintenanceURL()
+	if err != nil {
+		t.Fatalf("unexpected error building maintenance url: %v", err)
+	}
+	uploadURL, err := env.builder.BuildBlobUploadURL(fooRef)
+	if err != nil {
+		t.Fatalf("unexpected error building upload url: %v", err)
+	}
+	digestRef, _ := reference.WithDigest(fooRef, dgst)
+	manifestURL, err := env.builder.BuildManifestURL(digestRef)
+	if err != nil {
+		t.Fatalf("unexpected error building manifest url: %v", err)
+	}
+
+	resp := do(http.MethodPut, maintenanceURL, `{"readOnly": true}`, false)
+	defer resp.Body.Close()
+	checkResponse(t, "entering read-only mode without access", resp, http.StatusUnauthorized)
+
+	resp = do(http.MethodPut, maintenanceURL, `{"readOnly": true, "retryAfter": -1}`, true)
+	defer resp.Body.Close()
+	checkResponse(t, "entering read-only mode with negative retry", resp, http.StatusBadRequest)
+	checkBodyHasErrorCodes(t, "entering read-only mode with negative retry", resp, v2.ErrorCodeMaintenanceInvalid)
+
+	resp = do(http.MethodPut, maintenanceURL, `{"readOnly": true, "reason": "garbage collection", "retryAfter": 120}`, true)
+	defer resp.Body.Close()
+	checkResponse(t, "entering read-only mode", resp, http.StatusOK)
+
+	resp = do(http.MethodGet, maintenanceURL, "", true)
+	defer resp.Body.Close()
+	checkResponse(t, "getting maintenance state", resp, http.StatusOK)
+	var state MaintenanceState
+	if err := json.NewDecoder(resp.Body).Decode(&state); err != nil {
+		t.Fatalf("unexpected error decoding maintenance state: %v", err)
+	}
+	if !state.ReadOnly || state.Reason != "garbage collection" || state.RetryAfter != 120 {
+		t.Fatalf("unexpected maintenance state: %v", state)
+	}
+
+	resp = do(http.MethodPost, uploadURL, "", false)
+	defer resp.Body.Close()
+	checkResponse(t, "starting upload in read-only mode", resp, http.StatusServiceUnavailable)
+	checkHeaders(t, resp, http.Header{"Retry-After": []string{"120"}})
+	checkBodyHasErrorCodes(t, "starting upload in read-only mode", resp, errcode.ErrorCodeUnavailable)
+
+	resp = do(http.MethodDelete, manifestURL, "", false)
+	defer resp.Body.Close()
+	checkResponse(t, "deleting manifest in read-only mode", resp, http.StatusServiceUnavailable)
+	checkBodyHasErrorCodes(t, "deleting manifest in read-only mode", resp, errcode.ErrorCodeUnavailable)
+
+	resp = do(http.MethodGet, manifestURL, "", false)
+	defer resp.Body.Close()
+	checkResponse(t, "pulling manifest in read-only mode", resp, http.StatusOK)
+
+	resp = do(http.MethodPut, maintenanceURL, `{"readOnly": false}`, true)
+	defer resp.Body.Close()
+	checkResponse(t, "leaving read-only mode", resp, http.StatusOK)
+
+	resp = do(http.MethodPost, uploadURL, "", false)
+	defer resp.Body.Close()
+	checkResponse(t, "starting upload after read-only mode", resp, http.StatusAccepted)
+
+	// the mode can also be changed without the api, e.g. from a signal
+	if err := env.app.SetReadOnly(env.ctx, true, ""); err != nil {
+		t.Fatalf("unexpected error setting read-only mode: %v", err)
+	}
+	resp = do(http.MethodPost, uploadURL, "", false)
+	defer resp.Body.Close()
+	checkResponse(t, "starting upload in read-only mode set at runtime", resp, http.StatusServiceUnavailable)
+	checkHeaders(t, resp, http.Header{"Retry-After": []string{"60"}})
+}
+
 func TestURLPrefix(t *testing.T) {
 	config := configuration.Configuration{
 		Storage: configuration.Storage{
@@ -1345,6 +2196,322 @@ func TestManifestAPI_DeleteTag_Unknown(t *testing.T) {
 	checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeManifestUnknown)
 }
 
+func TestManifestAPI_ImmutableTag(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Policy.Tag.Immutable = []configuration.ImmutableTagRule{
+		{Repository: "foo/*", Tag: `v[0-9.]+`},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+
+	latestDigest := createRepository(env, t, imageName.Name(), "latest")
+	releaseDigest := createRepository(env, t, imageName.Name(), "v1.0.0")
+
+	fetchPayload := func(dgst digest.Digest) []byte {
+		ref, _ := reference.WithDigest(imageName, dgst)
+		u, err := env.builder.BuildManifestURL(ref)
+		checkErr(t, err, "building manifest url")
+		req, _ := http.NewRequest(http.MethodGet, u, nil)
+		req.Header.Set("Accept", schema2.MediaTypeManifest)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching manifest")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching manifest", resp, http.StatusOK)
+		p, err := io.ReadAll(resp.Body)
+		checkErr(t, err, "reading manifest")
+		return p
+	}
+
+	releaseRef, _ := reference.WithTag(imageName, "v1.0.0")
+	releaseURL, err := env.builder.BuildManifestURL(releaseRef)
+	checkErr(t, err, "building tag url")
+
+	putPayload := func(payload []byte) *http.Response {
+		req, _ := http.NewRequest(http.MethodPut, releaseURL, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", schema2.MediaTypeManifest)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "putting manifest")
+		return resp
+	}
+
+	msg := "overwriting immutable tag"
+	resp := putPayload(fetchPayload(latestDigest))
+	defer resp.Body.Close()
+	checkResponse(t, msg, resp, http.StatusConflict)
+	checkBodyHasErrorCodes(t, msg, resp, v2.ErrorCodeTagImmutable)
+
+	msg = "re-pushing immutable tag with the same manifest"
+	resp = putPayload(fetchPayload(releaseDigest))
+	defer resp.Body.Close()
+	checkResponse(t, msg, resp, http.StatusCreated)
+
+	// the mutable tag may still be overwritten
+	createRepository(env, t, imageName.Name(), "latest")
+}
+
+func TestManifestAPI_Admission(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		down     bool
+		received []admission.Request
+	)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req admission.Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		received = append(received, req)
+		_ = json.NewEncoder(w).Encode(admission.Response{
+			Allowed: req.Tag != "denied",
+			Reason:  "tag not allowed",
+		})
+	}))
+	defer webhook.Close()
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Policy.Admission.Webhooks = []configuration.AdmissionWebhook{
+		{Name: "policy", URL: webhook.URL, Timeout: time.Second},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+
+	dgst := createRepository(env, t, imageName.Name(), "latest")
+	mu.Lock()
+	if len(received) == 0 {
+		t.Fatalf("expected the webhook to review the manifest")
+	}
+	if req := received[len(received)-1]; req.Repository != "foo/bar" || req.Tag != "latest" ||
+		req.Digest != dgst || req.MediaType != schema2.MediaTypeManifest || len(req.Manifest) == 0 {
+		t.Fatalf("unexpected admission request: %+v", req)
+	}
+	mu.Unlock()
+
+	ref, _ := reference.WithDigest(imageName, dgst)
+	u, err := env.builder.BuildManifestURL(ref)
+	checkErr(t, err, "building manifest url")
+	req, _ := http.NewRequest(http.MethodGet, u, nil)
+	req.Header.Set("Accept", schema2.MediaTypeManifest)
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "fetching manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest", resp, http.StatusOK)
+	payload, err := io.ReadAll(resp.Body)
+	checkErr(t, err, "reading manifest")
+
+	putTag := func(tag string) *http.Response {
+		tagRef, _ := reference.WithTag(imageName, tag)
+		u, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building tag url")
+		req, _ := http.NewRequest(http.MethodPut, u, bytes.NewReader(payload))
+		req.Header.Set("Content-Type", schema2.MediaTypeManifest)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "putting manifest")
+		return resp
+	}
+
+	msg := "pushing a denied manifest"
+	resp = putTag("denied")
+	defer resp.Body.Close()
+	checkResponse(t, msg, resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, msg, resp, errcode.ErrorCodeDenied)
+
+	mu.Lock()
+	down = true
+	mu.Unlock()
+
+	msg = "pushing while a webhook failing closed is down"
+	resp = putTag("other")
+	defer resp.Body.Close()
+	checkResponse(t, msg, resp, http.StatusServiceUnavailable)
+	checkBodyHasErrorCodes(t, msg, resp, errcode.ErrorCodeUnavailable)
+
+	env.app.admission = admission.Chain{admission.NewWebhook("policy", webhook.URL, time.Second, nil, true)}
+	msg = "pushing while a webhook failing open is down"
+	resp = putTag("other")
+	defer resp.Body.Close()
+	checkResponse(t, msg, resp, http.StatusCreated)
+}
+
+func TestManifestAPI_SignaturePolicy(t *testing.T) {
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	checkErr(t, err, "generating key")
+	der, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	checkErr(t, err, "marshaling key")
+	keyPath := filepath.Join(t.TempDir(), "cosign.pub")
+	err = os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644)
+	checkErr(t, err, "writing key")
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Policy.Signature.Keys = []configuration.SignatureKey{{Name: "release", Path: keyPath}}
+	config.Policy.Signature.Rules = []configuration.SignatureRule{
+		{Repository: "foo/*", Push: true, Pull: true},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, err := reference.WithName("foo/bar")
+	checkErr(t, err, "building image name")
+
+	policy := env.app.signaturePolicy
+	env.app.signaturePolicy = nil
+	dgst := createRepository(env, t, imageName.Name(), "latest")
+	env.app.signaturePolicy = policy
+
+	tagURL := func(tag string) string {
+		ref, _ := reference.WithTag(imageName, tag)
+		u, err := env.builder.BuildManifestURL(ref)
+		checkErr(t, err, "building manifest url")
+		return u
+	}
+	getTag := func(tag string, accept string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, tagURL(tag), nil)
+		req.Header.Set("Accept", accept)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching manifest")
+		return resp
+	}
+	putTag := func(tag string, contentType string, payload []byte) *http.Response {
+		req, _ := http.NewRequest(http.MethodPut, tagURL(tag), bytes.NewReader(payload))
+		req.Header.Set("Content-Type", contentType)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "putting manifest")
+		return resp
+	}
+
+	env.app.signaturePolicy = nil
+	resp := getTag("latest", schema2.MediaTypeManifest)
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest", resp, http.StatusOK)
+	payload, err := io.ReadAll(resp.Body)
+	checkErr(t, err, "reading manifest")
+	env.app.signaturePolicy = policy
+
+	msg := "pulling an unsigned manifest"
+	resp = getTag("latest", schema2.MediaTypeManifest)
+	defer resp.Body.Close()
+	checkResponse(t, msg, resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, msg, resp, errcode.ErrorCodeDenied)
+
+	msg = "tagging an unsigned manifest"
+	resp = putTag("v2", schema2.MediaTypeManifest, payload)
+	defer resp.Body.Close()
+	checkResponse(t, msg, resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, msg, resp, errcode.ErrorCodeDenied)
+
+	// sign the manifest the way cosign does
+	signingPayload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"foo/bar"},"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},"optional":null}`, dgst))
+	h := sha256.Sum256(signingPayload)
+	sig, err := ecdsa.SignASN1(rand.Reader, priv, h[:])
+	checkErr(t, err, "signing")
+	configBlob := []byte(`{}`)
+	for _, blob := range [][]byte{signingPayload, configBlob} {
+		uploadURLBase, _ := startPushLayer(t, env, imageName)
+		pushLayer(t, env.builder, imageName, digest.FromBytes(blob), uploadURLBase, bytes.NewReader(blob))
+	}
+	sigManifest, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: ocischema.SchemaVersion,
+		Config: distribution.Descriptor{
+			MediaType: v1.MediaTypeImageConfig,
+			Digest:    digest.FromBytes(configBlob),
+			Size:      int64(len(configBlob)),
+		},
+		Layers: []distribution.Descriptor{{
+			MediaType:   signature.MediaTypeSimpleSigning,
+			Digest:      digest.FromBytes(signingPayload),
+			Size:        int64(len(signingPayload)),
+			Annotations: map[string]string{signature.AnnotationSignature: base64.StdEncoding.EncodeToString(sig)},
+		}},
+	})
+	checkErr(t, err, "building signature manifest")
+	_, sigPayload, err := sigManifest.Payload()
+	checkErr(t, err, "getting signature manifest payload")
+
+	msg = "pushing a signature"
+	resp = putTag(signature.Tag(dgst), v1.MediaTypeImageManifest, sigPayload)
+	defer resp.Body.Close()
+	checkResponse(t, msg, resp, http.StatusCreated)
+
+	msg = "pulling a signature"
+	resp = getTag(signature.Tag(dgst), v1.MediaTypeImageManifest)
+	defer resp.Body.Close()
+	checkResponse(t, msg, resp, http.StatusOK)
+
+	msg = "pulling a signed manifest"
+	resp = getTag("latest", schema2.MediaTypeManifest)
+	defer resp.Body.Close()
+	checkResponse(t, msg, resp, http.StatusOK)
+
+	msg = "tagging a signed manifest"
+	resp = putTag("v2", schema2.MediaTypeManifest, payload)
+	defer resp.Body.Close()
+	checkResponse(t, msg, resp, http.StatusCreated)
+
+	// reading the signatures is not reported as a pull
+	sink := &recordingSink{}
+	env.app.events.sink = sink
+	msg = "pulling a signed manifest"
+	resp = getTag("latest", schema2.MediaTypeManifest)
+	defer resp.Body.Close()
+	checkResponse(t, msg, resp, http.StatusOK)
+	if len(sink.events) != 1 || sink.events[0].Action != notifications.EventActionPull || sink.events[0].Target.Digest != dgst {
+		t.Fatalf("expected a single pull event for %s, got %+v", dgst, sink.events)
+	}
+}
+
+// recordingSink records the notification events written to it.
+type recordingSink struct {
+	mu     sync.Mutex
+	events []notifications.Event
+}
+
+func (s *recordingSink) Write(event events.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event.(notifications.Event))
+	return nil
+}
+
+func (s *recordingSink) Close() error {
+	return nil
+}
+
 func TestManifestAPI_DeleteTag_ReadOnly(t *testing.T) {
 	env := newTestEnv(t, false)
 	defer env.Shutdown()
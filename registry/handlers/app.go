@@ -24,13 +24,16 @@ import (
 	"github.com/distribution/distribution/v3/health/checks"
 	prometheus "github.com/distribution/distribution/v3/metrics"
 	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/admission"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/distribution/v3/registry/auth"
 	registrymiddleware "github.com/distribution/distribution/v3/registry/middleware/registry"
 	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
 	"github.com/distribution/distribution/v3/registry/proxy"
+	"github.com/distribution/distribution/v3/registry/signature"
 	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/cache"
 	memorycache "github.com/distribution/distribution/v3/registry/storage/cache/memory"
 	rediscache "github.com/distribution/distribution/v3/registry/storage/cache/redis"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
@@ -44,6 +47,11 @@ import (
 	"github.com/redis/go-redis/extra/redisotel/v9"
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // randomSecretSize is the number of random bytes to generate if no secret
@@ -53,6 +61,10 @@ const randomSecretSize = 32
 // defaultCheckInterval is the default time in between health checks
 const defaultCheckInterval = 10 * time.Second
 
+// tracer starts the spans of the requests served. It records them once a
+// TracerProvider is installed, see the tracing package.
+var tracer = otel.Tracer("github.com/distribution/distribution/v3/registry/handlers")
+
 // App is a global registry application object. Shared resources can be placed
 // on this object that will be accessible from all requests. Any writable
 // fields should be protected.
@@ -84,6 +96,20 @@ type App struct {
 
 	// readOnly is true if the registry is in a read-only maintenance mode
 	readOnly bool
+
+	// maintenance holds the maintenance mode set at runtime.
+	maintenance *maintenanceMode
+
+	// trashRetention is the time soft deleted manifests and tags can be
+	// restored for. It is zero if soft deletion is disabled.
+	trashRetention time.Duration
+
+	// admission reviews manifests before they are stored.
+	admission admission.Chain
+
+	// signaturePolicy requires manifests to be signed to be tagged or
+	// pulled.
+	signaturePolicy *signature.Policy
 }
 
 // NewApp takes a configuration and returns a configured app, ready to serve
@@ -107,6 +133,11 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	app.register(v2.RouteNameBlob, blobDispatcher)
 	app.register(v2.RouteNameBlobUpload, blobUploadDispatcher)
 	app.register(v2.RouteNameBlobUploadChunk, blobUploadDispatcher)
+	app.register(v2.RouteNameUsage, adminDispatcher(usageDispatcher))
+	app.register(v2.RouteNameRepository, adminDispatcher(repositoryDispatcher))
+	app.register(v2.RouteNameTrash, adminDispatcher(trashDispatcher))
+	app.register(v2.RouteNameQuarantine, adminDispatcher(quarantineDispatcher))
+	app.register(v2.RouteNameMaintenance, adminDispatcher(maintenanceDispatcher))
 
 	// override the storage driver's UA string for registry outbound HTTP requests
 	storageParams := config.Storage.Parameters()
@@ -125,6 +156,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	}
 
 	purgeConfig := uploadPurgeDefaultConfig()
+	var verifyConfig map[interface{}]interface{}
 	if mc, ok := config.Storage["maintenance"]; ok {
 		if v, ok := mc["uploadpurging"]; ok {
 			purgeConfig, ok = v.(map[interface{}]interface{})
@@ -132,6 +164,12 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 				panic("uploadpurging config key must contain additional keys")
 			}
 		}
+		if v, ok := mc["verify"]; ok {
+			verifyConfig, ok = v.(map[interface{}]interface{})
+			if !ok {
+				panic("verify config key must contain additional keys")
+			}
+		}
 		if v, ok := mc["readonly"]; ok {
 			readOnly, ok := v.(map[interface{}]interface{})
 			if !ok {
@@ -146,17 +184,20 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
-	startUploadPurger(app, app.driver, dcontext.GetLogger(app), purgeConfig)
-
-	app.driver, err = applyStorageMiddleware(app.driver, config.Middleware["storage"])
+	app.driver, err = ApplyStorageMiddleware(app.driver, config.Middleware["storage"])
 	if err != nil {
 		panic(err)
 	}
 
+	// the maintenance tasks read stored content, which must go through the
+	// middlewares to be decrypted
+	startUploadPurger(app, app.driver, dcontext.GetLogger(app), purgeConfig)
+
 	app.configureSecret(config)
 	app.configureEvents(config)
 	app.configureRedis(config)
 	app.configureLogHook(config)
+	app.configureMaintenance()
 
 	options := registrymiddleware.GetRegistryOptions()
 
@@ -177,7 +218,22 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		e, ok := d["enabled"]
 		if ok {
 			if deleteEnabled, ok := e.(bool); ok && deleteEnabled {
-				options = append(options, storage.EnableDelete)
+				if soft, ok := d["soft"].(bool); ok && soft {
+					options = append(options, storage.EnableSoftDelete)
+					app.trashRetention = storage.DefaultTrashRetention
+					if r, ok := d["retention"]; ok {
+						retention, ok := r.(string)
+						if !ok {
+							panic(fmt.Sprintf("invalid type for delete retention config: %#v", r))
+						}
+						app.trashRetention, err = time.ParseDuration(retention)
+						if err != nil || app.trashRetention <= 0 {
+							panic(fmt.Sprintf("invalid delete retention %q", retention))
+						}
+					}
+				} else {
+					options = append(options, storage.EnableDelete)
+				}
 			}
 		}
 	}
@@ -236,8 +292,77 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
+	// configure manifest policies
+	if config.Validation.Enabled && len(config.Validation.Manifests.Policies) > 0 && !app.isCache {
+		policies := make([]storage.ManifestPolicy, 0, len(config.Validation.Manifests.Policies))
+		for _, policy := range config.Validation.Manifests.Policies {
+			policies = append(policies, storage.ManifestPolicy{
+				Repository:    policy.Repository,
+				MediaTypes:    policy.MediaTypes,
+				ArtifactTypes: policy.ArtifactTypes,
+				MaxSize:       policy.MaxSize,
+				MaxLayerSize:  policy.MaxLayerSize,
+				MaxLayers:     policy.MaxLayers,
+				Annotations:   policy.Annotations,
+				Platforms:     policy.Platforms,
+			})
+		}
+		options = append(options, storage.ManifestPolicies(policies...))
+	}
+
+	// configure tag immutability
+	if len(config.Policy.Tag.Immutable) > 0 {
+		rules := make([]storage.ImmutableTagRule, 0, len(config.Policy.Tag.Immutable))
+		for _, rule := range config.Policy.Tag.Immutable {
+			// Anchor the expression so that it must match the whole tag.
+			re, err := regexp.Compile(fmt.Sprintf("^(?:%s)$", rule.Tag))
+			if err != nil {
+				panic(fmt.Sprintf("policy.tag.immutable: %s", err))
+			}
+			rules = append(rules, storage.ImmutableTagRule{
+				Repository: rule.Repository,
+				Tag:        re,
+			})
+		}
+		options = append(options, storage.ImmutableTags(rules...))
+	}
+
+	// configure admission webhooks
+	for _, webhook := range config.Policy.Admission.Webhooks {
+		if webhook.URL == "" {
+			panic(fmt.Sprintf("policy.admission: no url configured for webhook %q", webhook.Name))
+		}
+		app.admission = append(app.admission, admission.NewWebhook(webhook.Name, webhook.URL, webhook.Timeout, webhook.Headers, webhook.FailOpen))
+		dcontext.GetLogger(app).Infof("using admission webhook %q", webhook.Name)
+	}
+
+	// configure signature verification
+	if len(config.Policy.Signature.Rules) > 0 {
+		app.signaturePolicy = newSignaturePolicy(config)
+	}
+
 	// configure storage caches
+	var catalogIndex cache.CatalogIndex
+	var descriptorCache cache.BlobDescriptorCacheProvider
 	if cc, ok := config.Storage["cache"]; ok {
+		switch c := cc["catalog"]; c {
+		case nil, "":
+		case "redis":
+			if app.redis == nil {
+				panic("redis configuration required to use for catalog index")
+			}
+			catalogIndex = rediscache.NewRedisCatalogIndex(app.redis)
+			dcontext.GetLogger(app).Infof("using redis catalog index")
+		case "inmemory":
+			catalogIndex = memorycache.NewInMemoryCatalogIndex()
+			dcontext.GetLogger(app).Infof("using inmemory catalog index")
+		default:
+			panic(fmt.Sprintf("unknown catalog index type %q", c))
+		}
+		if catalogIndex != nil {
+			options = append(options, storage.CatalogIndex(catalogIndex))
+		}
+
 		v, ok := cc["blobdescriptor"]
 		if !ok {
 			// Backwards compatible: "layerinfo" == "blobdescriptor"
@@ -253,6 +378,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 				dcontext.GetLogger(app).Warnf("blobdescriptorsize parameter is not supported with redis cache")
 			}
 			cacheProvider := rediscache.NewRedisBlobDescriptorCacheProvider(app.redis)
+			descriptorCache = cacheProvider
 			localOptions := append(options, storage.BlobDescriptorCacheProvider(cacheProvider))
 			app.registry, err = storage.NewRegistry(app, app.driver, localOptions...)
 			if err != nil {
@@ -271,6 +397,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 			}
 
 			cacheProvider := memorycache.NewInMemoryBlobDescriptorCacheProvider(blobDescriptorSize)
+			descriptorCache = cacheProvider
 			localOptions := append(options, storage.BlobDescriptorCacheProvider(cacheProvider))
 			app.registry, err = storage.NewRegistry(app, app.driver, localOptions...)
 			if err != nil {
@@ -278,7 +405,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 			}
 			dcontext.GetLogger(app).Infof("using inmemory blob descriptor cache")
 		default:
-			if v != "" {
+			if v != nil && v != "" {
 				dcontext.GetLogger(app).Warnf("unknown cache type %q, caching disabled", config.Storage["cache"])
 			}
 		}
@@ -292,6 +419,11 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
+	if catalogIndex != nil {
+		startCatalogIndexRebuild(app, app.driver, app.registry, catalogIndex)
+	}
+	startVerifier(app, app.driver, descriptorCache, dcontext.GetLogger(app), verifyConfig)
+
 	app.registry, err = applyRegistryMiddleware(app, app.registry, app.driver, config.Middleware["registry"])
 	if err != nil {
 		panic(err)
@@ -308,6 +440,15 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		dcontext.GetLogger(app).Debugf("configured %q access controller", authType)
 	}
 
+	// quarantined content can only be pulled and released by registry
+	// administrators, who cannot be told apart without an access controller
+	if config.Policy.Quarantine.Enabled {
+		if app.accessController == nil {
+			panic("policy.quarantine requires an access controller")
+		}
+		dcontext.GetLogger(app).Info("quarantining pushed manifests")
+	}
+
 	// configure as a pull through cache
 	if config.Proxy.RemoteURL != "" {
 		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, config.Proxy)
@@ -496,13 +637,18 @@ func (app *App) configureRedis(cfg *configuration.Configuration) {
 		return
 	}
 
-	app.redis = app.createPool(cfg.Redis)
+	app.redis = NewRedisClient(cfg.Redis)
 
 	// Enable metrics instrumentation.
 	if err := redisotel.InstrumentMetrics(app.redis); err != nil {
 		dcontext.GetLogger(app).Errorf("failed to instrument metrics on redis: %v", err)
 	}
 
+	// Enable tracing instrumentation.
+	if err := redisotel.InstrumentTracing(app.redis); err != nil {
+		dcontext.GetLogger(app).Errorf("failed to instrument tracing on redis: %v", err)
+	}
+
 	// setup expvar
 	registry := expvar.Get("registry")
 	if registry == nil {
@@ -518,7 +664,18 @@ func (app *App) configureRedis(cfg *configuration.Configuration) {
 	}))
 }
 
-func (app *App) createPool(cfg configuration.Redis) *redis.Client {
+// configureMaintenance sets up the store of the maintenance mode set at
+// runtime, which is shared through redis if it is configured.
+func (app *App) configureMaintenance() {
+	if app.redis != nil {
+		app.maintenance = newMaintenanceMode(&redisMaintenanceStore{client: app.redis})
+		return
+	}
+	app.maintenance = newMaintenanceMode(&memoryMaintenanceStore{})
+}
+
+// NewRedisClient returns a client for the redis server described by cfg.
+func NewRedisClient(cfg configuration.Redis) *redis.Client {
 	return redis.NewClient(&redis.Options{
 		Addr: cfg.Addr,
 		OnConnect: func(ctx context.Context, cn *redis.Conn) error {
@@ -589,6 +746,8 @@ func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Prepare the context with our own little decorations.
 	ctx := r.Context()
 	ctx = dcontext.WithRequest(ctx, r)
+	// continue the trace of the client, if any, in the spans of the request
+	ctx = otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(r.Header))
 	ctx, w = dcontext.WithResponseWriter(ctx, w)
 	ctx = dcontext.WithLogger(ctx, dcontext.GetRequestLogger(ctx))
 	r = r.WithContext(ctx)
@@ -617,8 +776,32 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 			}
 		}
 
+		routeName := mux.CurrentRoute(r).GetName()
+		ctx, span := tracer.Start(r.Context(), r.Method+" "+routeName,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.method", r.Method),
+				attribute.String("http.target", r.URL.Path),
+				attribute.String("registry.route", routeName),
+			))
+		r = r.WithContext(ctx)
+
 		context := app.context(w, r)
 
+		defer func() {
+			if name := getName(context); name != "" {
+				span.SetAttributes(attribute.String("registry.repository", name))
+			}
+			// client errors are not failures of the registry
+			if status, ok := context.Value("http.response.status").(int); ok {
+				span.SetAttributes(attribute.Int("http.status_code", status))
+				if status >= 500 {
+					span.SetStatus(codes.Error, http.StatusText(status))
+				}
+			}
+			span.End()
+		}()
+
 		defer func() {
 			// Automated error response handling here. Handlers may return their
 			// own errors if they need different behavior (such as range errors
@@ -639,6 +822,10 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 		// Add username to request logging
 		context.Context = dcontext.WithLogger(context.Context, dcontext.GetLogger(context.Context, auth.UserNameKey))
 
+		if !app.writable(context, w, r, mux.CurrentRoute(r).GetName()) {
+			return
+		}
+
 		// sync up context on the request.
 		r = r.WithContext(context)
 
@@ -674,6 +861,8 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 				return
 			}
 
+			context.storageRepository = repository
+
 			// assign and decorate the authorized repository with an event bridge.
 			context.Repository, context.RepositoryRemover = notifications.Listen(
 				repository,
@@ -775,14 +964,30 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 
 	var accessRecords []auth.Access
 
-	if repo != "" {
+	routeName := mux.CurrentRoute(r).GetName()
+	switch {
+	case repo != "" && (routeName == v2.RouteNameTrash || routeName == v2.RouteNameQuarantine):
+		// the recycle bin and quarantine of a repository are administered
+		// by registry administrators only.
+		accessRecords = append(accessRecords, adminAccess)
+	case repo != "" && routeName == v2.RouteNameRepository && r.Method == http.MethodPost:
+		// renaming a repository deletes it and pushes its content to the
+		// new name.
+		accessRecords = appendAccessRecords(accessRecords, http.MethodDelete, repo)
+		if toRepo := r.FormValue("to"); toRepo != "" {
+			accessRecords = appendAccessRecords(accessRecords, http.MethodPost, toRepo)
+		}
+	case repo != "":
 		accessRecords = appendAccessRecords(accessRecords, r.Method, repo)
 		if fromRepo := r.FormValue("from"); fromRepo != "" {
 			// mounting a blob from one repository to another requires pull (GET)
 			// access to the source repository.
 			accessRecords = appendAccessRecords(accessRecords, http.MethodGet, fromRepo)
 		}
-	} else {
+	case routeName == v2.RouteNameCatalog && app.Config.Catalog.AccessFilter:
+		// the catalog is filtered by the access of the caller to each
+		// repository, so the caller only needs to be authenticated.
+	default:
 		// Only allow the name not to be set on the base route.
 		if app.nameRequired(r) {
 			// For this to be properly secured, repo must always be set for a
@@ -796,7 +1001,7 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 			}
 			return fmt.Errorf("forbidden: no repository name")
 		}
-		accessRecords = appendCatalogAccessRecord(accessRecords, r)
+		accessRecords = appendRegistryAccessRecord(accessRecords, r)
 	}
 
 	ctx, err := app.accessController.Authorized(context.Context, accessRecords...)
@@ -847,7 +1052,22 @@ func (app *App) nameRequired(r *http.Request) bool {
 		return true
 	}
 	routeName := route.GetName()
-	return routeName != v2.RouteNameBase && routeName != v2.RouteNameCatalog
+	_, registryScoped := registryRouteResources[routeName]
+	return routeName != v2.RouteNameBase && !registryScoped
+}
+
+// adminDispatcher wraps the dispatcher of an administrative route. These
+// routes can alter or expose the whole registry, so they are only available
+// when an access controller is configured.
+func adminDispatcher(dispatch dispatchFunc) dispatchFunc {
+	return func(ctx *Context, r *http.Request) http.Handler {
+		if ctx.App.accessController == nil {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx.Errors = append(ctx.Errors, errcode.ErrorCodeUnsupported.WithMessage("administrative routes require an access controller"))
+			})
+		}
+		return dispatch(ctx, r)
+	}
 }
 
 // apiBase implements a simple yes-man for doing overall checks against the
@@ -895,15 +1115,24 @@ func appendAccessRecords(records []auth.Access, method string, repo string) []au
 	return records
 }
 
-// Add the access record for the catalog if it's our current route
-func appendCatalogAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
+// registryRouteResources maps the routes which are not scoped to a
+// repository to the name of the registry resource guarding them.
+var registryRouteResources = map[string]string{
+	v2.RouteNameCatalog:     "catalog",
+	v2.RouteNameUsage:       "admin",
+	v2.RouteNameMaintenance: "admin",
+}
+
+// Add the access record for the registry resource guarding the current
+// route, if any
+func appendRegistryAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
 	route := mux.CurrentRoute(r)
 	routeName := route.GetName()
 
-	if routeName == v2.RouteNameCatalog {
+	if name, ok := registryRouteResources[routeName]; ok {
 		resource := auth.Resource{
 			Type: "registry",
-			Name: "catalog",
+			Name: name,
 		}
 
 		accessRecords = append(accessRecords,
@@ -939,8 +1168,8 @@ func applyRepoMiddleware(ctx context.Context, repository distribution.Repository
 	return repository, nil
 }
 
-// applyStorageMiddleware wraps a storage driver with the configured middlewares
-func applyStorageMiddleware(driver storagedriver.StorageDriver, middlewares []configuration.Middleware) (storagedriver.StorageDriver, error) {
+// ApplyStorageMiddleware wraps a storage driver with the configured middlewares
+func ApplyStorageMiddleware(driver storagedriver.StorageDriver, middlewares []configuration.Middleware) (storagedriver.StorageDriver, error) {
 	for _, mw := range middlewares {
 		smw, err := storagemiddleware.Get(mw.Name, mw.Options, driver)
 		if err != nil {
@@ -1035,3 +1264,137 @@ func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageD
 		}
 	}()
 }
+
+func badVerifyConfig(reason string) {
+	panic(fmt.Sprintf("Unable to parse verify configuration: %s", reason))
+}
+
+// startVerifier schedules a goroutine which will periodically verify the
+// integrity of the blob store. The verifier is disabled unless configured.
+// The descriptors of quarantined blobs are cleared from descriptorCache, if
+// set.
+func startVerifier(ctx context.Context, storageDriver storagedriver.StorageDriver, descriptorCache cache.BlobDescriptorCacheProvider, log dcontext.Logger, config map[interface{}]interface{}) {
+	if config["enabled"] != true {
+		return
+	}
+
+	intervalDuration := 168 * time.Hour
+	if interval, ok := config["interval"]; ok {
+		intervalStr, ok := interval.(string)
+		if !ok {
+			badVerifyConfig("interval is not a string")
+		}
+		var err error
+		intervalDuration, err = time.ParseDuration(intervalStr)
+		if err != nil {
+			badVerifyConfig(fmt.Sprintf("Cannot parse interval: %s", err.Error()))
+		}
+	}
+
+	opts := storage.VerifyOpts{BlobDescriptorCache: descriptorCache}
+	if rateLimit, ok := config["ratelimit"]; ok {
+		rateLimitInt, ok := rateLimit.(int)
+		if !ok || rateLimitInt < 0 {
+			badVerifyConfig("ratelimit is not a positive integer")
+		}
+		opts.RateLimit = int64(rateLimitInt)
+	}
+	if quarantine, ok := config["quarantine"]; ok {
+		opts.Quarantine, ok = quarantine.(bool)
+		if !ok {
+			badVerifyConfig("cannot parse quarantine")
+		}
+	}
+
+	registry, err := storage.NewRegistry(ctx, storageDriver)
+	if err != nil {
+		badVerifyConfig(fmt.Sprintf("Cannot construct registry: %s", err.Error()))
+	}
+
+	go func() {
+		randInt, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+		if err != nil {
+			log.Infof("Failed to generate random jitter: %v", err)
+			randInt = big.NewInt(30)
+		}
+		jitter := time.Duration(randInt.Int64()%60) * time.Minute
+		log.Infof("Starting storage verification in %s", jitter)
+		time.Sleep(jitter)
+
+		for {
+			result, err := storage.Verify(ctx, storageDriver, registry, opts)
+			if err != nil {
+				log.Errorf("Storage verification failed: %v", err)
+			} else {
+				log.Infof("Storage verification checked %d blobs, %d links and %d manifests and found %d problems",
+					result.Blobs, result.Links, result.Manifests, len(result.Problems))
+			}
+			log.Infof("Starting storage verification in %s", intervalDuration)
+			time.Sleep(intervalDuration)
+		}
+	}()
+}
+
+// startCatalogIndexRebuild schedules a goroutine which builds the catalog
+// index from the storage if it has not been built yet. Until then the
+// catalog is served by walking the storage.
+func startCatalogIndexRebuild(ctx context.Context, storageDriver storagedriver.StorageDriver, registry distribution.Namespace, index cache.CatalogIndex) {
+	log := dcontext.GetLogger(ctx)
+	go func() {
+		ready, err := index.Ready(ctx)
+		if err != nil {
+			log.Errorf("Failed to check catalog index: %v", err)
+			return
+		}
+		if ready {
+			return
+		}
+
+		log.Infof("Rebuilding catalog index")
+		if err := storage.RebuildCatalogIndex(ctx, storageDriver, registry, index); err != nil {
+			log.Errorf("Failed to rebuild catalog index: %v", err)
+			return
+		}
+		log.Infof("Catalog index rebuilt")
+	}()
+}
+
+// newSignaturePolicy loads the keys and rules of the signature policy. It
+// panics if the configuration is invalid.
+func newSignaturePolicy(config *configuration.Configuration) *signature.Policy {
+	keys := make(map[string]*signature.PublicKey)
+	var allKeys []*signature.PublicKey
+	for _, k := range config.Policy.Signature.Keys {
+		key, err := signature.LoadPublicKey(k.Name, k.Path)
+		if err != nil {
+			panic(fmt.Sprintf("policy.signature: %v", err))
+		}
+		if _, ok := keys[k.Name]; ok {
+			panic(fmt.Sprintf("policy.signature: duplicate key %q", k.Name))
+		}
+		keys[k.Name] = key
+		allKeys = append(allKeys, key)
+	}
+
+	rules := make([]signature.Rule, 0, len(config.Policy.Signature.Rules))
+	for _, r := range config.Policy.Signature.Rules {
+		rule := signature.Rule{
+			Repository: r.Repository,
+			Push:       r.Push,
+			Pull:       r.Pull,
+			Keys:       allKeys,
+		}
+		if len(r.Keys) > 0 {
+			rule.Keys = nil
+			for _, name := range r.Keys {
+				key, ok := keys[name]
+				if !ok {
+					panic(fmt.Sprintf("policy.signature: unknown key %q", name))
+				}
+				rule.Keys = append(rule.Keys, key)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return signature.NewPolicy(rules...)
+}
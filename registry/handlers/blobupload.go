@@ -73,6 +73,10 @@ func (buh *blobUploadHandler) StartBlobUpload(w http.ResponseWriter, r *http.Req
 	if mountDigest != "" && fromRepo != "" {
 		opt, err := buh.createBlobMountOption(fromRepo, mountDigest)
 		if opt != nil && err == nil {
+			if err := buh.quarantineBlob(digest.Digest(mountDigest)); err != nil {
+				buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+				return
+			}
 			options = append(options, opt)
 		}
 	}
@@ -211,6 +215,11 @@ func (buh *blobUploadHandler) PutBlobUploadComplete(w http.ResponseWriter, r *ht
 		return
 	}
 
+	if err := buh.quarantineBlob(dgst); err != nil {
+		buh.Errors = append(buh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
 	desc, err := buh.Upload.Commit(buh, distribution.Descriptor{
 		Digest: dgst,
 
@@ -319,6 +328,21 @@ func (buh *blobUploadHandler) ResumeBlobUpload(ctx *Context, r *http.Request) ht
 	return nil
 }
 
+// quarantineBlob puts the blob dgst into quarantine, before it is linked
+// into the repository, if quarantine is enforced for the repository and the
+// blob is new to it. The blob is released along with the first manifest
+// referencing it.
+func (buh *blobUploadHandler) quarantineBlob(dgst digest.Digest) error {
+	quarantine := buh.App.quarantine(buh.Repository.Named().Name())
+	if quarantine == nil {
+		return nil
+	}
+	if _, err := buh.Repository.Blobs(buh).Stat(buh, dgst); err != distribution.ErrBlobUnknown {
+		return err
+	}
+	return quarantine.QuarantineBlob(buh, dgst)
+}
+
 // blobUploadResponse provides a standard request for uploading blobs and
 // chunk responses. This sets the correct headers but the response status is
 // left to the caller.
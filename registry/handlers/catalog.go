@@ -7,9 +7,12 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/storage/cache"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/gorilla/handlers"
 )
@@ -65,14 +68,22 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 		entries = maximumConfiguredEntries
 	}
 
-	repos := make([]string, entries)
-	filled := 0
+	prefix := q.Get("prefix")
+	query := q.Get("q")
+
+	repos := make([]string, 0, entries)
 
 	// entries is guaranteed to be >= 0 and < maximumConfiguredEntries
 	if entries == 0 {
 		moreEntries = false
-	} else {
-		returnedRepositories, err := ch.App.registry.Repositories(ch.Context, repos, lastEntry)
+	}
+
+	// fetch repositories in batches until enough of them pass the filters,
+	// since the filters may drop any number of them.
+	batch := make([]string, entries)
+	cursor := catalogStart(lastEntry, prefix)
+	for moreEntries && len(repos) < entries {
+		returnedRepositories, err := ch.App.registry.Repositories(ch.Context, batch, cursor)
 		if err != nil {
 			_, pathNotFound := err.(driver.PathNotFoundError)
 			if err != io.EOF && !pathNotFound {
@@ -82,14 +93,50 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 			// err is either io.EOF or not PathNotFoundError
 			moreEntries = false
 		}
-		filled = returnedRepositories
+		if returnedRepositories == 0 {
+			moreEntries = false
+		}
+
+		for i, name := range batch[:returnedRepositories] {
+			cursor = name
+			if !strings.HasPrefix(name, prefix) {
+				if cache.CatalogSortKey(name) > cache.CatalogSortKey(prefix) {
+					// repositories are sorted, so none of the
+					// remaining ones can have the prefix.
+					moreEntries = false
+					break
+				}
+				continue
+			}
+			if !strings.Contains(name, query) {
+				continue
+			}
+			allowed, err := ch.canPull(name)
+			if err != nil {
+				ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+				return
+			}
+			if !allowed {
+				continue
+			}
+
+			repos = append(repos, name)
+			if len(repos) == entries {
+				if i < returnedRepositories-1 {
+					// the rest of the batch is listed on the next
+					// page, even if it was the last one.
+					moreEntries = true
+				}
+				break
+			}
+		}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 
 	// Add a link header if there are more entries to retrieve
 	if moreEntries {
-		lastEntry = repos[filled-1]
+		lastEntry = repos[len(repos)-1]
 		urlStr, err := createLinkEntry(r.URL.String(), entries, lastEntry)
 		if err != nil {
 			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
@@ -100,13 +147,60 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 
 	enc := json.NewEncoder(w)
 	if err := enc.Encode(catalogAPIResponse{
-		Repositories: repos[0:filled],
+		Repositories: repos,
 	}); err != nil {
 		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
 }
 
+// canPull reports whether the caller may pull the named repository. All
+// repositories are returned unless the catalog is filtered by access.
+func (ch *catalogHandler) canPull(name string) (bool, error) {
+	if !ch.App.Config.Catalog.AccessFilter || ch.App.accessController == nil {
+		return true, nil
+	}
+
+	_, err := ch.App.accessController.Authorized(ch.Context, auth.Access{
+		Resource: auth.Resource{
+			Type: "repository",
+			Name: name,
+		},
+		Action: "pull",
+	})
+	if err != nil {
+		if _, ok := err.(auth.Challenge); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// catalogStart returns the position after which to start listing
+// repositories, skipping those which sort before prefix.
+func catalogStart(last, prefix string) string {
+	if prefix == "" {
+		return last
+	}
+
+	// all names with the prefix sort after the prefix without its trailing
+	// separator, or after the prefix with its last byte decremented.
+	var start string
+	if strings.HasSuffix(prefix, "/") {
+		start = strings.TrimSuffix(prefix, "/")
+	} else {
+		b := []byte(prefix)
+		b[len(b)-1]--
+		start = string(b)
+	}
+
+	if cache.CatalogSortKey(last) > cache.CatalogSortKey(start) {
+		return last
+	}
+	return start
+}
+
 // Use the original URL from the request to create a new URL for
 // the link header
 func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string, error) {
@@ -115,9 +209,10 @@ func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string,
 		return "", err
 	}
 
-	v := url.Values{}
-	v.Add("n", strconv.Itoa(maxEntries))
-	v.Add("last", lastEntry)
+	// keep the other query parameters, such as filters
+	v := calledURL.Query()
+	v.Set("n", strconv.Itoa(maxEntries))
+	v.Set("last", lastEntry)
 
 	calledURL.RawQuery = v.Encode()
 
@@ -25,6 +25,12 @@ type Context struct {
 	// should be scoped to a single repository. This field may be nil.
 	Repository distribution.Repository
 
+	// storageRepository is the repository for the current request without
+	// the notifications listener and the repository middlewares, for the
+	// reads the registry makes on its own behalf, which are not reported as
+	// pulls.
+	storageRepository distribution.Repository
+
 	// RepositoryRemover provides method to delete a repository
 	RepositoryRemover distribution.RepositoryRemover
 
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/gorilla/handlers"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// maintenanceRedisKey is the redis key holding the maintenance state
+	// shared by the replicas of the registry.
+	maintenanceRedisKey = "registry:maintenance"
+
+	// maintenanceRefreshInterval bounds how long a replica may take to
+	// notice a maintenance state change made by another replica.
+	maintenanceRefreshInterval = time.Second
+
+	// defaultRetryAfter is the delay clients are asked to wait before
+	// retrying a write rejected in read-only mode, if none is set.
+	defaultRetryAfter = 60
+)
+
+// MaintenanceState is the maintenance mode the registry has been switched
+// into at runtime.
+type MaintenanceState struct {
+	// ReadOnly rejects uploads, manifest pushes and deletes.
+	ReadOnly bool `json:"readOnly"`
+
+	// Reason is reported to clients whose writes are rejected.
+	Reason string `json:"reason,omitempty"`
+
+	// RetryAfter is the number of seconds clients are asked to wait before
+	// retrying rejected writes.
+	RetryAfter int `json:"retryAfter,omitempty"`
+
+	// Since is the time the state was set.
+	Since time.Time `json:"since"`
+}
+
+// maintenanceStore persists the maintenance state.
+type maintenanceStore interface {
+	get(ctx context.Context) (MaintenanceState, error)
+	set(ctx context.Context, state MaintenanceState) error
+}
+
+// memoryMaintenanceStore keeps the maintenance state of a single replica.
+type memoryMaintenanceStore struct {
+	mu    sync.Mutex
+	state MaintenanceState
+}
+
+func (s *memoryMaintenanceStore) get(ctx context.Context) (MaintenanceState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state, nil
+}
+
+func (s *memoryMaintenanceStore) set(ctx context.Context, state MaintenanceState) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = state
+	return nil
+}
+
+// redisMaintenanceStore shares the maintenance state between the replicas
+// using the same redis.
+type redisMaintenanceStore struct {
+	client *redis.Client
+}
+
+func (s *redisMaintenanceStore) get(ctx context.Context) (MaintenanceState, error) {
+	var state MaintenanceState
+	content, err := s.client.Get(ctx, maintenanceRedisKey).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return state, nil
+		}
+		return state, err
+	}
+	err = json.Unmarshal(content, &state)
+	return state, err
+}
+
+func (s *redisMaintenanceStore) set(ctx context.Context, state MaintenanceState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, maintenanceRedisKey, content, 0).Err()
+}
+
+// maintenanceMode caches the maintenance state, refreshing it from the store
+// at most every maintenanceRefreshInterval.
+type maintenanceMode struct {
+	store maintenanceStore
+
+	mu        sync.Mutex
+	state     MaintenanceState
+	refreshed time.Time
+}
+
+func newMaintenanceMode(store maintenanceStore) *maintenanceMode {
+	return &maintenanceMode{store: store}
+}
+
+// get returns the current maintenance state. If the store cannot be read,
+// the last known state is returned.
+func (m *maintenanceMode) get(ctx context.Context) MaintenanceState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if time.Since(m.refreshed) < maintenanceRefreshInterval {
+		return m.state
+	}
+	state, err := m.store.get(ctx)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error reading maintenance state: %v", err)
+		return m.state
+	}
+	m.state = state
+	m.refreshed = time.Now()
+	return m.state
+}
+
+func (m *maintenanceMode) set(ctx context.Context, state MaintenanceState) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.store.set(ctx, state); err != nil {
+		return err
+	}
+	m.state = state
+	m.refreshed = time.Now()
+	return nil
+}
+
+// SetReadOnly switches the registry in or out of read-only mode at runtime.
+// When redis is configured, the state is shared with the other replicas
+// using it, which follow within a second. The read-only mode of the
+// configuration file applies regardless.
+func (app *App) SetReadOnly(ctx context.Context, readOnly bool, reason string) error {
+	state := MaintenanceState{
+		ReadOnly: readOnly,
+		Since:    time.Now().UTC(),
+	}
+	if readOnly {
+		state.Reason = reason
+		state.RetryAfter = defaultRetryAfter
+	}
+	return app.maintenance.set(ctx, state)
+}
+
+// writable appends an error to the context and returns false if the request
+// writes to the registry while it is in read-only mode at runtime. The
+// maintenance route itself stays writable so that the mode can be left.
+func (app *App) writable(ctx *Context, w http.ResponseWriter, r *http.Request, routeName string) bool {
+	if app.maintenance == nil || r.Method == http.MethodGet || r.Method == http.MethodHead || routeName == v2.RouteNameMaintenance {
+		return true
+	}
+
+	state := app.maintenance.get(ctx)
+	if !state.ReadOnly {
+		return true
+	}
+
+	retryAfter := state.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = defaultRetryAfter
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+
+	message := "registry is in read-only mode"
+	if state.Reason != "" {
+		message = fmt.Sprintf("%s: %s", message, state.Reason)
+	}
+	ctx.Errors = append(ctx.Errors, errcode.ErrorCodeUnavailable.WithMessage(message))
+	return false
+}
+
+// maintenanceDispatcher takes the request context and builds the appropriate
+// handler for the maintenance mode of the registry.
+func maintenanceDispatcher(ctx *Context, r *http.Request) http.Handler {
+	maintenanceHandler := &maintenanceHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(maintenanceHandler.GetMaintenance),
+		http.MethodPut: http.HandlerFunc(maintenanceHandler.PutMaintenance),
+	}
+}
+
+// maintenanceHandler reports and changes the maintenance mode.
+type maintenanceHandler struct {
+	*Context
+}
+
+// GetMaintenance returns the maintenance state as json.
+func (mh *maintenanceHandler) GetMaintenance(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(mh).Debug("GetMaintenance")
+
+	mh.writeState(w, mh.App.maintenance.get(mh))
+}
+
+// PutMaintenance sets the maintenance state from the json request body.
+func (mh *maintenanceHandler) PutMaintenance(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(mh).Debug("PutMaintenance")
+
+	var state MaintenanceState
+	if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+		mh.Errors = append(mh.Errors, v2.ErrorCodeMaintenanceInvalid.WithDetail(err.Error()))
+		return
+	}
+	if state.RetryAfter < 0 {
+		mh.Errors = append(mh.Errors, v2.ErrorCodeMaintenanceInvalid.WithDetail("retryAfter must not be negative"))
+		return
+	}
+	if !state.ReadOnly {
+		state = MaintenanceState{}
+	} else if state.RetryAfter == 0 {
+		state.RetryAfter = defaultRetryAfter
+	}
+	state.Since = time.Now().UTC()
+
+	if err := mh.App.maintenance.set(mh, state); err != nil {
+		mh.Errors = append(mh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	dcontext.GetLogger(mh).Infof("maintenance state set: read-only=%t, reason=%q", state.ReadOnly, state.Reason)
+
+	mh.writeState(w, state)
+}
+
+func (mh *maintenanceHandler) writeState(w http.ResponseWriter, state MaintenanceState) {
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(state); err != nil {
+		mh.Errors = append(mh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
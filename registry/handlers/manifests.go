@@ -12,9 +12,11 @@ import (
 	"github.com/distribution/distribution/v3/manifest/manifestlist"
 	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/admission"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/signature"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
 	"github.com/gorilla/handlers"
@@ -146,6 +148,13 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 		}
 		return
 	}
+	if r.Method == http.MethodGet && !imh.checkQuarantine(imh.Digest, false) {
+		return
+	}
+	if err := imh.App.signaturePolicy.VerifyPull(imh, imh.storageRepository, manifest, imh.Digest, imh.Tag); err != nil {
+		imh.Errors = append(imh.Errors, signatureError(err))
+		return
+	}
 	// determine the type of the returned manifest
 	manifestType := manifestSchema2
 	manifestList, isManifestList := manifest.(*manifestlist.DeserializedManifestList)
@@ -280,8 +289,27 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := imh.admit(r, desc.MediaType, jsonBuf.Bytes()); err != nil {
+		imh.Errors = append(imh.Errors, err)
+		return
+	}
+
+	if err := imh.App.signaturePolicy.VerifyPush(imh, imh.storageRepository, manifest, imh.Digest, imh.Tag); err != nil {
+		imh.Errors = append(imh.Errors, signatureError(err))
+		return
+	}
+
+	quarantined, err := imh.quarantineManifest(manifests, manifest)
+	if err != nil {
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
 	_, err = manifests.Put(imh, manifest, options...)
 	if err != nil {
+		if quarantined {
+			imh.unquarantineManifest(manifests)
+		}
 		// TODO(stevvooe): These error handling switches really need to be
 		// handled by an app global mapper.
 		if err == distribution.ErrUnsupported {
@@ -310,6 +338,8 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 					}
 				}
 			}
+		case distribution.ErrManifestPolicy:
+			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestInvalid.WithDetail(err.Error()))
 		case errcode.Error:
 			imh.Errors = append(imh.Errors, err)
 		default:
@@ -323,12 +353,23 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		tags := imh.Repository.Tags(imh)
 		err = tags.Tag(imh, imh.Tag, desc)
 		if err != nil {
-			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			switch err := err.(type) {
+			case distribution.ErrTagImmutable:
+				imh.Errors = append(imh.Errors, v2.ErrorCodeTagImmutable.WithDetail(err))
+			default:
+				imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			}
 			return
 		}
 
 	}
 
+	if quarantined {
+		if err := imh.App.eventBridge(imh.Context, r).ManifestQuarantined(imh.Repository.Named(), imh.Digest); err != nil {
+			dcontext.GetLogger(imh).Errorf("error dispatching manifest quarantine to listener: %v", err)
+		}
+	}
+
 	// Construct a canonical url for the uploaded manifest.
 	ref, err := reference.WithDigest(imh.Repository.Named(), imh.Digest)
 	if err != nil {
@@ -351,6 +392,78 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 	dcontext.GetLogger(imh).Debug("Succeeded in putting manifest!")
 }
 
+// admit asks the configured admission webhooks whether the manifest may be
+// stored.
+func (imh *manifestHandler) admit(r *http.Request, mediaType string, payload []byte) error {
+	if len(imh.App.admission) == 0 {
+		return nil
+	}
+
+	err := imh.App.admission.Review(imh, admission.Request{
+		Repository: imh.Repository.Named().Name(),
+		Tag:        imh.Tag,
+		Digest:     imh.Digest,
+		MediaType:  mediaType,
+		Manifest:   payload,
+		User:       getUserName(imh, r),
+	})
+	switch err := err.(type) {
+	case nil:
+		return nil
+	case admission.ErrDenied:
+		return errcode.ErrorCodeDenied.WithMessage(err.Error())
+	default:
+		dcontext.GetLogger(imh).Errorf("failed to review manifest: %v", err)
+		return errcode.ErrorCodeUnavailable.WithDetail(err.Error())
+	}
+}
+
+// quarantineManifest puts the manifest being pushed into quarantine, before
+// it is stored, if quarantine is enforced for the repository and the
+// manifest is new to it. Pushing a manifest which is already stored leaves
+// its state unchanged.
+func (imh *manifestHandler) quarantineManifest(manifests distribution.ManifestService, manifest distribution.Manifest) (bool, error) {
+	quarantine := imh.App.quarantine(imh.Repository.Named().Name())
+	if quarantine == nil {
+		return false, nil
+	}
+	exists, err := manifests.Exists(imh, imh.Digest)
+	if err != nil || exists {
+		return false, err
+	}
+
+	var references []digest.Digest
+	for _, ref := range manifest.References() {
+		references = append(references, ref.Digest)
+	}
+	if err := quarantine.Quarantine(imh, imh.Digest, references); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// unquarantineManifest removes the quarantine record written for a manifest
+// which then failed to be stored. The record is kept if the manifest was
+// stored by a concurrent push after all.
+func (imh *manifestHandler) unquarantineManifest(manifests distribution.ManifestService) {
+	exists, err := manifests.Exists(imh, imh.Digest)
+	if err == nil && !exists {
+		err = imh.App.quarantine(imh.Repository.Named().Name()).Remove(imh, imh.Digest)
+	}
+	if err != nil {
+		dcontext.GetLogger(imh).Errorf("failed to remove the quarantine record of manifest %s: %v", imh.Digest, err)
+	}
+}
+
+// signatureError maps an error verifying the signature of a manifest to an
+// API error.
+func signatureError(err error) error {
+	if err, ok := err.(signature.ErrUnsigned); ok {
+		return errcode.ErrorCodeDenied.WithMessage(err.Error())
+	}
+	return errcode.ErrorCodeUnknown.WithDetail(err)
+}
+
 // applyResourcePolicy checks whether the resource class matches what has
 // been authorized and allowed by the policy configuration.
 func (imh *manifestHandler) applyResourcePolicy(manifest distribution.Manifest) error {
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+)
+
+// adminAccess is the access record guarding administrative routes.
+var adminAccess = auth.Access{
+	Resource: auth.Resource{
+		Type: "registry",
+		Name: "admin",
+	},
+	Action: "*",
+}
+
+// quarantineDispatcher takes the request context and builds the appropriate
+// handler for the quarantine of a repository.
+func quarantineDispatcher(ctx *Context, r *http.Request) http.Handler {
+	quarantineHandler := &quarantineHandler{
+		Context: ctx,
+	}
+
+	qhandler := handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(quarantineHandler.GetQuarantine),
+	}
+
+	if !ctx.readOnly {
+		qhandler[http.MethodPost] = http.HandlerFunc(quarantineHandler.ReleaseQuarantine)
+	}
+
+	return qhandler
+}
+
+// quarantineHandler lists and releases quarantined manifests.
+type quarantineHandler struct {
+	*Context
+}
+
+type quarantineAPIResponse struct {
+	Manifests []storage.QuarantineRecord `json:"manifests"`
+}
+
+// GetQuarantine returns the quarantined manifests of the repository as json.
+func (qh *quarantineHandler) GetQuarantine(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(qh).Debug("GetQuarantine")
+
+	quarantine := qh.App.quarantine(qh.Repository.Named().Name())
+	if quarantine == nil {
+		qh.Errors = append(qh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	records, err := quarantine.List(qh)
+	if err != nil {
+		qh.Errors = append(qh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(quarantineAPIResponse{Manifests: records}); err != nil {
+		qh.Errors = append(qh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// ReleaseQuarantine releases the manifest given by the digest query
+// parameter.
+func (qh *quarantineHandler) ReleaseQuarantine(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(qh).Debug("ReleaseQuarantine")
+
+	quarantine := qh.App.quarantine(qh.Repository.Named().Name())
+	if quarantine == nil {
+		qh.Errors = append(qh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	dgst, err := digest.Parse(r.FormValue("digest"))
+	if err != nil {
+		qh.Errors = append(qh.Errors, v2.ErrorCodeDigestInvalid.WithDetail(err))
+		return
+	}
+
+	if err := quarantine.Release(qh, dgst); err != nil {
+		switch err := err.(type) {
+		case distribution.ErrManifestUnknownRevision:
+			qh.Errors = append(qh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+		default:
+			qh.Errors = append(qh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	if err := qh.App.eventBridge(qh.Context, r).ManifestReleased(qh.Repository.Named(), dgst); err != nil {
+		dcontext.GetLogger(qh).Errorf("error dispatching manifest release to listener: %v", err)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// quarantine returns the quarantine of the named repository, or nil if
+// quarantine is not enforced for it.
+func (app *App) quarantine(name string) *storage.RepositoryQuarantine {
+	if !app.Config.Policy.Quarantine.Enabled || app.isCache {
+		return nil
+	}
+	if len(app.Config.Policy.Quarantine.Repositories) > 0 {
+		matched := false
+		for _, pattern := range app.Config.Policy.Quarantine.Repositories {
+			if ok, _ := path.Match(pattern, name); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil
+		}
+	}
+	return storage.NewRepositoryQuarantine(app.driver, name)
+}
+
+// isAdmin reports whether the caller is a registry administrator, who may
+// pull quarantined content.
+func (app *App) isAdmin(ctx context.Context) (bool, error) {
+	if app.accessController == nil {
+		return false, nil
+	}
+	if _, err := app.accessController.Authorized(ctx, adminAccess); err != nil {
+		if _, ok := err.(auth.Challenge); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// checkQuarantine appends an error to the context and returns false if the
+// manifest or blob dgst of the repository is quarantined and the caller is
+// not a registry administrator.
+func (ctx *Context) checkQuarantine(dgst digest.Digest, blob bool) bool {
+	quarantine := ctx.App.quarantine(ctx.Repository.Named().Name())
+	if quarantine == nil {
+		return true
+	}
+
+	var (
+		quarantined bool
+		err         error
+	)
+	if blob {
+		quarantined, err = quarantine.BlobQuarantined(ctx, dgst)
+	} else {
+		quarantined, err = quarantine.Quarantined(ctx, dgst)
+	}
+	if err == nil && quarantined {
+		var admin bool
+		admin, err = ctx.App.isAdmin(ctx)
+		quarantined = !admin
+	}
+	if err != nil {
+		ctx.Errors = append(ctx.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return false
+	}
+	if quarantined {
+		ctx.Errors = append(ctx.Errors, v2.ErrorCodeQuarantined.WithDetail(dgst))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/reference"
+	"github.com/gorilla/handlers"
+)
+
+// repositoryDispatcher takes the request context and builds the appropriate
+// handler for administering a whole repository.
+func repositoryDispatcher(ctx *Context, r *http.Request) http.Handler {
+	repositoryHandler := &repositoryHandler{
+		Context: ctx,
+	}
+
+	rhandler := handlers.MethodHandler{}
+
+	if !ctx.readOnly {
+		rhandler[http.MethodDelete] = http.HandlerFunc(repositoryHandler.DeleteRepository)
+		rhandler[http.MethodPost] = http.HandlerFunc(repositoryHandler.RenameRepository)
+	}
+
+	return rhandler
+}
+
+// repositoryHandler handles the deletion and renaming of repositories.
+type repositoryHandler struct {
+	*Context
+}
+
+// DeleteRepository removes the tags, manifests and layer links of the
+// repository.
+func (rh *repositoryHandler) DeleteRepository(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(rh).Debug("DeleteRepository")
+
+	if rh.App.isCache || rh.App.repoRemover == nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	if err := rh.RepositoryRemover.Remove(rh, rh.Repository.Named()); err != nil {
+		rh.appendRepositoryError(err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// RenameRepository moves the repository to the name given by the to query
+// parameter.
+func (rh *repositoryHandler) RenameRepository(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(rh).Debug("RenameRepository")
+
+	if rh.App.isCache || rh.App.repoRemover == nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	renamer, ok := rh.RepositoryRemover.(distribution.RepositoryRenamer)
+	if !ok {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	to := r.FormValue("to")
+	toRef, err := reference.WithName(to)
+	if err != nil {
+		rh.Errors = append(rh.Errors, v2.ErrorCodeNameInvalid.WithDetail(distribution.ErrRepositoryNameInvalid{
+			Name:   to,
+			Reason: err,
+		}))
+		return
+	}
+	if toRef.Name() == rh.Repository.Named().Name() {
+		rh.Errors = append(rh.Errors, v2.ErrorCodeNameConflict.WithDetail(distribution.ErrRepositoryExists{Name: to}))
+		return
+	}
+
+	if err := renamer.Rename(rh, rh.Repository.Named(), toRef); err != nil {
+		rh.appendRepositoryError(err)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (rh *repositoryHandler) appendRepositoryError(err error) {
+	switch err := err.(type) {
+	case distribution.ErrRepositoryUnknown:
+		rh.Errors = append(rh.Errors, v2.ErrorCodeNameUnknown.WithDetail(err))
+	case distribution.ErrRepositoryExists:
+		rh.Errors = append(rh.Errors, v2.ErrorCodeNameConflict.WithDetail(err))
+	default:
+		if err == distribution.ErrUnsupported {
+			rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported)
+			return
+		}
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+	}
+}
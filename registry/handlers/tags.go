@@ -9,6 +9,7 @@ import (
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/gorilla/handlers"
 )
 
@@ -33,6 +34,11 @@ type tagsAPIResponse struct {
 	Tags []string `json:"tags"`
 }
 
+type tagDetailsAPIResponse struct {
+	Name string              `json:"name"`
+	Tags []storage.TagDetail `json:"tags"`
+}
+
 // GetTags returns a json list of tags for a specific image name.
 func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
@@ -51,10 +57,54 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// do pagination if requested
 	q := r.URL.Query()
+	details, _ := strconv.ParseBool(q.Get("details"))
+	sortBy, order := q.Get("sort"), q.Get("order")
+	if sortBy == "" {
+		sortBy = "name"
+	}
+	if order == "" {
+		order = "asc"
+	}
+	if (sortBy != "name" && sortBy != "date") || (order != "asc" && order != "desc") {
+		th.Errors = append(th.Errors, v2.ErrorCodePaginationSortInvalid.WithDetail(map[string]string{"sort": sortBy, "order": order}))
+		return
+	}
+	if (details || sortBy == "date") && th.App.isCache {
+		// the details are read from the local storage, which only holds
+		// the tags which have been pulled through the cache.
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnsupported.WithDetail("tag details are not available on a pull-through cache"))
+		return
+	}
+
+	if sortBy == "date" {
+		modTimes, err := storage.TagModTimes(th, th.App.driver, th.Repository.Named().Name(), tags)
+		if err != nil {
+			th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		sort.SliceStable(tags, func(i, j int) bool {
+			ti, tj := modTimes[tags[i]], modTimes[tags[j]]
+			if !ti.Equal(tj) {
+				return ti.Before(tj)
+			}
+			return tags[i] < tags[j]
+		})
+	}
+	if order == "desc" {
+		for i, j := 0, len(tags)-1; i < j; i, j = i+1, j-1 {
+			tags[i], tags[j] = tags[j], tags[i]
+		}
+	}
+
+	// do pagination if requested
 	// get entries after latest, if any specified
-	if lastEntry := q.Get("last"); lastEntry != "" {
+	lastEntry := q.Get("last")
+	switch {
+	case lastEntry == "":
+	case sortBy != "name" || order != "asc":
+		tags = tagsAfter(tags, lastEntry, sortBy)
+	default:
 		lastEntryIndex := sort.SearchStrings(tags, lastEntry)
 
 		// as`sort.SearchStrings` can return len(tags), if the
@@ -96,14 +146,43 @@ func (th *tagsHandler) GetTags(w http.ResponseWriter, r *http.Request) {
 		tags = tags[:maxEntries]
 	}
 
+	var response interface{} = tagsAPIResponse{
+		Name: th.Repository.Named().Name(),
+		Tags: tags,
+	}
+	if details {
+		tagDetails, err := storage.TagDetails(th, th.App.driver, th.storageRepository, tags)
+		if err != nil {
+			th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		response = tagDetailsAPIResponse{
+			Name: th.Repository.Named().Name(),
+			Tags: tagDetails,
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 
 	enc := json.NewEncoder(w)
-	if err := enc.Encode(tagsAPIResponse{
-		Name: th.Repository.Named().Name(),
-		Tags: tags,
-	}); err != nil {
+	if err := enc.Encode(response); err != nil {
 		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
 }
+
+// tagsAfter returns the tags following last in tags, which are sorted in
+// descending order of name or in either order of date. When sorting by date,
+// the position of a tag which does not exist is unknown, so no tags are
+// returned.
+func tagsAfter(tags []string, last string, sortBy string) []string {
+	for i, tag := range tags {
+		if sortBy == "name" && tag < last {
+			return tags[i:]
+		}
+		if tag == last {
+			return tags[i+1:]
+		}
+	}
+	return []string{}
+}
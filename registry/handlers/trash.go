@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/reference"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+)
+
+// trashDispatcher takes the request context and builds the appropriate
+// handler for the recycle bin of a repository.
+func trashDispatcher(ctx *Context, r *http.Request) http.Handler {
+	trashHandler := &trashHandler{
+		Context: ctx,
+	}
+
+	thandler := handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(trashHandler.GetTrash),
+	}
+
+	if !ctx.readOnly {
+		thandler[http.MethodPost] = http.HandlerFunc(trashHandler.RestoreTrash)
+	}
+
+	return thandler
+}
+
+// trashHandler lists and restores soft deleted manifests and tags.
+type trashHandler struct {
+	*Context
+}
+
+// GetTrash returns the manifests and tags in the recycle bin of the
+// repository as json.
+func (th *trashHandler) GetTrash(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(th).Debug("GetTrash")
+
+	trash, ok := th.trash()
+	if !ok {
+		return
+	}
+
+	listing, err := trash.List(th)
+	if err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(listing); err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// RestoreTrash restores the manifest given by the digest query parameter or
+// the tag given by the tag query parameter.
+func (th *trashHandler) RestoreTrash(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(th).Debug("RestoreTrash")
+
+	trash, ok := th.trash()
+	if !ok {
+		return
+	}
+
+	var err error
+	switch {
+	case r.FormValue("digest") != "":
+		dgst, parseErr := digest.Parse(r.FormValue("digest"))
+		if parseErr != nil {
+			th.Errors = append(th.Errors, v2.ErrorCodeDigestInvalid.WithDetail(parseErr))
+			return
+		}
+		err = trash.RestoreManifest(th, dgst)
+	case r.FormValue("tag") != "":
+		tag := r.FormValue("tag")
+		if _, tagErr := reference.WithTag(th.Repository.Named(), tag); tagErr != nil {
+			th.Errors = append(th.Errors, v2.ErrorCodeTagInvalid.WithDetail(tagErr))
+			return
+		}
+		err = trash.RestoreTag(th, tag)
+	default:
+		th.Errors = append(th.Errors, v2.ErrorCodeTagInvalid.WithDetail("no tag or digest specified"))
+		return
+	}
+
+	if err != nil {
+		switch err := err.(type) {
+		case distribution.ErrManifestUnknownRevision, distribution.ErrTagUnknown:
+			th.Errors = append(th.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+		case distribution.ErrTagExists:
+			th.Errors = append(th.Errors, v2.ErrorCodeTagExists.WithDetail(err))
+		default:
+			th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// trash returns the recycle bin of the repository, or appends an error if
+// soft deletion is not enabled.
+func (th *trashHandler) trash() (*storage.RepositoryTrash, bool) {
+	if th.App.isCache || th.App.trashRetention == 0 {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnsupported)
+		return nil, false
+	}
+	return storage.NewRepositoryTrash(th.App.driver, th.Repository.Named().Name(), th.App.trashRetention), true
+}
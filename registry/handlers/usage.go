@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/gorilla/handlers"
+)
+
+// usageDispatcher takes the request context and builds the appropriate
+// handler for reporting storage usage.
+func usageDispatcher(ctx *Context, r *http.Request) http.Handler {
+	usageHandler := &usageHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(usageHandler.GetUsage),
+	}
+}
+
+// usageHandler reports the storage used by the repositories of the registry.
+type usageHandler struct {
+	*Context
+}
+
+// GetUsage walks all repositories and returns their storage usage as json.
+func (uh *usageHandler) GetUsage(w http.ResponseWriter, r *http.Request) {
+	if uh.App.isCache {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	report, err := storage.Usage(uh, uh.App.driver, uh.App.registry)
+	if err != nil {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(report); err != nil {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
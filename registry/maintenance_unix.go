@@ -0,0 +1,30 @@
+//go:build !windows
+
+package registry
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/handlers"
+)
+
+// handleMaintenanceSignals switches the registry into read-only mode on
+// SIGUSR1 and back out of it on SIGUSR2.
+func handleMaintenanceSignals(app *handlers.App) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+
+	go func() {
+		for sig := range signals {
+			readOnly := sig == syscall.SIGUSR1
+			if err := app.SetReadOnly(app, readOnly, "signal"); err != nil {
+				dcontext.GetLogger(app).Errorf("error setting read-only mode on %v: %v", sig, err)
+				continue
+			}
+			dcontext.GetLogger(app).Infof("read-only mode set to %t on %v", readOnly, sig)
+		}
+	}()
+}
@@ -0,0 +1,7 @@
+package registry
+
+import "github.com/distribution/distribution/v3/registry/handlers"
+
+// handleMaintenanceSignals is a no-op on windows, which has no user signals.
+// The read-only mode can be changed through the maintenance API instead.
+func handleMaintenanceSignals(app *handlers.App) {}
@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver/shardedfs"
+	"github.com/spf13/cobra"
+)
+
+var rebalanceDryRun bool
+
+// RebalanceCmd is the cobra command that corresponds to the rebalance
+// subcommand
+var RebalanceCmd = &cobra.Command{
+	Use:   "rebalance <config>",
+	Short: "`rebalance` moves blob data to the root directories of the shardedfs driver it is assigned to",
+	Long:  "`rebalance` moves the blob data which is not on the root directory of the shardedfs storage driver it is assigned to, after a root directory was added or its weight changed, and reports the usage of the disks",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, config, driver := openDriver(cmd, args)
+
+		d, ok := driver.(*shardedfs.Driver)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "configuration error: rebalance requires the shardedfs storage driver, not %s\n", config.Storage.Type())
+			os.Exit(1)
+		}
+
+		result, err := d.Rebalance(ctx, shardedfs.RebalanceOpts{DryRun: rebalanceDryRun})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to rebalance: %v", err)
+			os.Exit(1)
+		}
+		verb := "moved"
+		if rebalanceDryRun {
+			verb = "to move"
+		}
+		fmt.Printf("%d blobs checked, %d blobs %s (%s), %d duplicates removed\n",
+			result.Checked, result.Moved, verb, formatSize(result.MovedBytes), result.Removed)
+
+		usages, err := d.Usage()
+		if err == nil {
+			err = writeDiskUsageTable(os.Stdout, usages)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to report disk usage: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+func writeDiskUsageTable(w io.Writer, usages []shardedfs.DiskUsage) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROOT\tMETADATA\tWEIGHT\tSIZE\tUSED\tFREE")
+	for _, usage := range usages {
+		fmt.Fprintf(tw, "%s\t%t\t%g\t%s\t%s\t%s\n",
+			usage.Root,
+			usage.Metadata,
+			usage.Weight,
+			formatSize(int64(usage.TotalBytes)),
+			formatSize(int64(usage.TotalBytes-usage.FreeBytes)),
+			formatSize(int64(usage.FreeBytes)))
+	}
+	return tw.Flush()
+}
@@ -17,6 +17,7 @@ import (
 	gorhandlers "github.com/gorilla/handlers"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
 
@@ -25,6 +26,7 @@ import (
 	"github.com/distribution/distribution/v3/health"
 	"github.com/distribution/distribution/v3/registry/handlers"
 	"github.com/distribution/distribution/v3/registry/listener"
+	"github.com/distribution/distribution/v3/tracing"
 	"github.com/distribution/distribution/v3/uuid"
 	"github.com/distribution/distribution/v3/version"
 )
@@ -123,9 +125,10 @@ var ServeCmd = &cobra.Command{
 //
 // TODO(aaronl): It might make sense for Registry to become an interface.
 type Registry struct {
-	config *configuration.Configuration
-	app    *handlers.App
-	server *http.Server
+	config  *configuration.Configuration
+	app     *handlers.App
+	server  *http.Server
+	tracing *sdktrace.TracerProvider
 }
 
 // NewRegistry creates a new registry from a context and configuration struct.
@@ -136,6 +139,11 @@ func NewRegistry(ctx context.Context, config *configuration.Configuration) (*Reg
 		return nil, fmt.Errorf("error configuring logger: %v", err)
 	}
 
+	tp, err := tracing.Configure(config.Tracing)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring tracing: %v", err)
+	}
+
 	// inject a logger into the uuid library. warns us if there is a problem
 	// with uuid generation under low entropy.
 	uuid.Loggerf = dcontext.GetLogger(ctx).Warnf
@@ -161,9 +169,10 @@ func NewRegistry(ctx context.Context, config *configuration.Configuration) (*Reg
 	}
 
 	return &Registry{
-		app:    app,
-		config: config,
-		server: server,
+		app:     app,
+		config:  config,
+		server:  server,
+		tracing: tp,
 	}, nil
 }
 
@@ -208,11 +217,17 @@ func setDirectoryURL(directoryurl string) *acme.Client {
 func (registry *Registry) ListenAndServe() error {
 	config := registry.config
 
+	if registry.tracing != nil {
+		defer registry.shutdownTracing()
+	}
+
 	ln, err := listener.NewListener(config.HTTP.Net, config.HTTP.Addr)
 	if err != nil {
 		return err
 	}
 
+	handleMaintenanceSignals(registry.app)
+
 	if config.HTTP.TLS.Certificate != "" || config.HTTP.TLS.LetsEncrypt.CacheFile != "" {
 		if config.HTTP.TLS.MinimumTLS == "" {
 			config.HTTP.TLS.MinimumTLS = defaultTLSVersionStr
@@ -317,6 +332,15 @@ func (registry *Registry) ListenAndServe() error {
 	}
 }
 
+// shutdownTracing exports the spans recorded before the server stopped.
+func (registry *Registry) shutdownTracing() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := registry.tracing.Shutdown(ctx); err != nil {
+		dcontext.GetLogger(registry.app).Errorf("error shutting down tracing: %v", err)
+	}
+}
+
 func configureDebugServer(config *configuration.Configuration) {
 	if config.HTTP.Debug.Addr != "" {
 		go func(addr string) {
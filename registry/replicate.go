@@ -0,0 +1,52 @@
+package registry
+
+import (
+	"fmt"
+	"os"
+
+	replicate "github.com/distribution/distribution/v3/registry/storage/driver/middleware/replicate"
+	"github.com/spf13/cobra"
+)
+
+var (
+	reconcileDryRun bool
+	reconcileDelete bool
+)
+
+// ReconcileCmd is the cobra command that corresponds to the
+// reconcile-replica subcommand
+var ReconcileCmd = &cobra.Command{
+	Use:   "reconcile-replica <config>",
+	Short: "`reconcile-replica` copies content missing from the secondary driver of the replicate middleware",
+	Long:  "`reconcile-replica` walks the storage and copies the objects which are missing from the secondary driver of the replicate storage middleware, or differ from it",
+	Run: func(cmd *cobra.Command, args []string) {
+		ctx, config, driver := openDriver(cmd, args)
+
+		var options map[string]interface{}
+		for _, mw := range config.Middleware["storage"] {
+			if mw.Name == "replicate" && !mw.Disabled {
+				options = mw.Options
+			}
+		}
+		if options == nil {
+			fmt.Fprintln(os.Stderr, "configuration error: reconcile-replica requires the replicate storage middleware")
+			os.Exit(1)
+		}
+		secondary, err := replicate.SecondaryDriver(options)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			os.Exit(1)
+		}
+
+		result, err := replicate.Reconcile(ctx, driver, secondary, replicate.ReconcileOpts{
+			DryRun: reconcileDryRun,
+			Delete: reconcileDelete,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to reconcile replica: %v", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%d objects checked, %d objects copied (%d bytes), %d objects deleted\n",
+			result.Checked, result.Copied, result.CopiedBytes, result.Deleted)
+	},
+}
@@ -1,11 +1,17 @@
 package registry
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
 	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/handlers"
 	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	"github.com/distribution/distribution/v3/version"
 	"github.com/spf13/cobra"
@@ -18,6 +24,22 @@ func init() {
 	RootCmd.AddCommand(GCCmd)
 	GCCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "do everything except remove the blobs")
 	GCCmd.Flags().BoolVarP(&removeUntagged, "delete-untagged", "m", false, "delete manifests that are not currently referenced via tag")
+	RootCmd.AddCommand(DuCmd)
+	DuCmd.Flags().StringVarP(&duFormat, "format", "f", "table", "output format, one of table or json")
+	DuCmd.Flags().StringVarP(&duSort, "sort", "s", "size", "sort repositories by size, exclusive or name")
+	RootCmd.AddCommand(VerifyCmd)
+	VerifyCmd.Flags().BoolVarP(&verifyQuarantine, "quarantine", "q", false, "move blob data which does not match its digest out of the blob store")
+	VerifyCmd.Flags().Int64VarP(&verifyRateLimit, "rate-limit", "r", 0, "maximum number of bytes of blob data read per second, 0 for unlimited")
+	VerifyCmd.Flags().BoolVar(&verifySkipData, "skip-data", false, "only verify links and manifest references")
+	VerifyCmd.Flags().BoolVar(&verifyJSON, "json", false, "write the result as JSON")
+	RootCmd.AddCommand(ExportCmd)
+	RootCmd.AddCommand(ImportCmd)
+	RootCmd.AddCommand(RebuildCatalogCmd)
+	RootCmd.AddCommand(ReconcileCmd)
+	RootCmd.AddCommand(RebalanceCmd)
+	RebalanceCmd.Flags().BoolVarP(&rebalanceDryRun, "dry-run", "d", false, "report the blobs to move without moving them")
+	ReconcileCmd.Flags().BoolVarP(&reconcileDryRun, "dry-run", "d", false, "report the differences without changing the secondary driver")
+	ReconcileCmd.Flags().BoolVar(&reconcileDelete, "delete", false, "delete objects from the secondary driver which are not in the primary one")
 	RootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "show the version and exit")
 }
 
@@ -46,35 +68,18 @@ var GCCmd = &cobra.Command{
 	Short: "`garbage-collect` deletes layers not referenced by any manifests",
 	Long:  "`garbage-collect` deletes layers not referenced by any manifests",
 	Run: func(cmd *cobra.Command, args []string) {
-		config, err := resolveConfiguration(args)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
-			cmd.Usage()
-			os.Exit(1)
-		}
+		ctx, config, driver, registry := openStorage(cmd, args)
 
-		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		retention, err := trashRetention(config)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
-			os.Exit(1)
-		}
-
-		ctx := dcontext.Background()
-		ctx, err = configureLogging(ctx, config)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
-			os.Exit(1)
-		}
-
-		registry, err := storage.NewRegistry(ctx, driver)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
 			os.Exit(1)
 		}
 
 		err = storage.MarkAndSweep(ctx, driver, registry, storage.GCOpts{
 			DryRun:         dryRun,
 			RemoveUntagged: removeUntagged,
+			TrashRetention: retention,
 		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to garbage collect: %v", err)
@@ -82,3 +87,70 @@ var GCCmd = &cobra.Command{
 		}
 	},
 }
+
+// trashRetention returns the configured retention of soft deleted manifests
+// and tags, or zero for the default.
+func trashRetention(config *configuration.Configuration) (time.Duration, error) {
+	d, ok := config.Storage["delete"]
+	if !ok {
+		return 0, nil
+	}
+	r, ok := d["retention"]
+	if !ok {
+		return 0, nil
+	}
+	retention, ok := r.(string)
+	if !ok {
+		return 0, fmt.Errorf("invalid type for delete retention config: %#v", r)
+	}
+	return time.ParseDuration(retention)
+}
+
+// openStorage resolves the configuration given in args and constructs the
+// storage driver it describes, wrapped with the configured storage
+// middlewares, and the registry stored on it. It exits the process on
+// failure, so it should only be used by subcommands.
+func openStorage(cmd *cobra.Command, args []string) (context.Context, *configuration.Configuration, storagedriver.StorageDriver, distribution.Namespace) {
+	ctx, config, driver := openDriver(cmd, args)
+
+	driver, err := handlers.ApplyStorageMiddleware(driver, config.Middleware["storage"])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to configure storage middleware: %v", err)
+		os.Exit(1)
+	}
+
+	registry, err := storage.NewRegistry(ctx, driver)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+		os.Exit(1)
+	}
+
+	return ctx, config, driver, registry
+}
+
+// openDriver resolves the configuration given in args and constructs the
+// storage driver it describes, without the storage middlewares. It exits the
+// process on failure, so it should only be used by subcommands.
+func openDriver(cmd *cobra.Command, args []string) (context.Context, *configuration.Configuration, storagedriver.StorageDriver) {
+	config, err := resolveConfiguration(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		cmd.Usage()
+		os.Exit(1)
+	}
+
+	driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+		os.Exit(1)
+	}
+
+	ctx := dcontext.Background()
+	ctx, err = configureLogging(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+		os.Exit(1)
+	}
+
+	return ctx, config, driver
+}
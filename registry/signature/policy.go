@@ -0,0 +1,194 @@
+package signature
+
+import (
+	"context"
+	"path"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/opencontainers/go-digest"
+)
+
+// Rule requires the manifests of a set of repositories to be signed.
+type Rule struct {
+	// Repository is a glob pattern (https://pkg.go.dev/path#Match) matched
+	// against the repository name. An empty pattern matches every
+	// repository.
+	Repository string
+
+	// Push requires manifests to be signed before they can be tagged.
+	Push bool
+
+	// Pull requires manifests to be signed before they can be pulled.
+	Pull bool
+
+	// Keys are the keys trusted to sign manifests.
+	Keys []*PublicKey
+}
+
+// Policy enforces signature rules on pushes and pulls. The first rule
+// matching a repository applies to it.
+type Policy struct {
+	rules []Rule
+}
+
+// NewPolicy returns a policy enforcing rules. A nil policy enforces nothing.
+func NewPolicy(rules ...Rule) *Policy {
+	return &Policy{rules: rules}
+}
+
+// rule returns the rule applying to the named repository.
+func (p *Policy) rule(name string) (Rule, bool) {
+	if p == nil {
+		return Rule{}, false
+	}
+	for _, rule := range p.rules {
+		if rule.Repository == "" {
+			return rule, true
+		}
+		if matched, _ := path.Match(rule.Repository, name); matched {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+// VerifyPush checks that the manifest m with digest dgst may be pushed to
+// repo with tag. Pushes by digest are always allowed, so that a manifest can
+// be signed before it is tagged; pulls of it are still checked. A signature
+// may be pushed under the signature tag of the manifest it signs, and an
+// index of signatures under the referrers tag of that manifest.
+func (p *Policy) VerifyPush(ctx context.Context, repo distribution.Repository, m distribution.Manifest, dgst digest.Digest, tag string) error {
+	rule, ok := p.rule(repo.Named().Name())
+	if !ok || !rule.Push || tag == "" {
+		return nil
+	}
+	if ok, err := isSignature(ctx, repo, m, dgst, tag); ok || err != nil {
+		return err
+	}
+	return Verify(ctx, repo, dgst, rule.Keys)
+}
+
+// VerifyPull checks that the manifest m with digest dgst may be pulled from
+// repo, by tag if tag is set. Signatures and referrers indexes of signatures
+// can always be pulled, and a manifest referenced by a signed index can be
+// pulled by digest. m may be nil if the manifest has not been read.
+func (p *Policy) VerifyPull(ctx context.Context, repo distribution.Repository, m distribution.Manifest, dgst digest.Digest, tag string) error {
+	rule, ok := p.rule(repo.Named().Name())
+	if !ok || !rule.Pull {
+		return nil
+	}
+	if ok, err := isSignature(ctx, repo, m, dgst, tag); ok || err != nil {
+		return err
+	}
+	err := Verify(ctx, repo, dgst, rule.Keys)
+	if _, unsigned := err.(ErrUnsigned); unsigned && tag == "" {
+		if ok, perr := signedParent(ctx, repo, dgst, rule.Keys); ok || perr != nil {
+			return perr
+		}
+	}
+	return err
+}
+
+// isSignature reports whether the manifest m with digest dgst is a signature
+// of a manifest d of repo stored under the signature tag of d, or a referrers
+// index of signatures of d stored under the referrers tag of d. tag is the
+// tag m is pushed or pulled by; if it is empty, the tag of d must point at
+// dgst.
+func isSignature(ctx context.Context, repo distribution.Repository, m distribution.Manifest, dgst digest.Digest, tag string) (bool, error) {
+	if m == nil || (tag != "" && !IsAttachmentTag(tag)) {
+		return false, nil
+	}
+	signed, err := signedManifest(ctx, repo, m)
+	if err != nil {
+		return false, err
+	}
+	var signatureTag string
+	if signed != "" {
+		signatureTag = Tag(signed)
+	} else {
+		signed, err = referredManifest(ctx, repo, m)
+		if err != nil || signed == "" {
+			return false, err
+		}
+		signatureTag = ReferrersTag(signed)
+	}
+	if tag != "" {
+		return tag == signatureTag, nil
+	}
+	desc, err := repo.Tags(ctx).Get(ctx, signatureTag)
+	if err != nil {
+		if _, ok := err.(distribution.ErrTagUnknown); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return desc.Digest == dgst, nil
+}
+
+// signedParent reports whether an index referencing the manifest dgst is
+// signed by one of keys, so that the manifests of a signed multi-platform
+// image can be pulled by digest. Only the manifests named by the signature
+// and referrers tags of repo are read, as the other manifests are not signed.
+func signedParent(ctx context.Context, repo distribution.Repository, dgst digest.Digest, keys []*PublicKey) (bool, error) {
+	all, err := repo.Tags(ctx).All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	seen := make(map[digest.Digest]bool)
+	for _, tag := range all {
+		parent := signedDigest(tag)
+		if parent == "" || parent == dgst || seen[parent] {
+			continue
+		}
+		seen[parent] = true
+
+		m, err := getManifest(ctx, manifests, parent)
+		if err != nil {
+			return false, err
+		}
+		switch m.(type) {
+		case *ocischema.DeserializedImageIndex, *manifestlist.DeserializedManifestList:
+		default:
+			continue
+		}
+		for _, ref := range m.References() {
+			if ref.Digest != dgst {
+				continue
+			}
+			err := Verify(ctx, repo, parent, keys)
+			if err == nil {
+				return true, nil
+			}
+			if _, ok := err.(ErrUnsigned); !ok {
+				return false, err
+			}
+			break
+		}
+	}
+	return false, nil
+}
+
+// signedDigest returns the digest of the manifest whose signatures may be
+// stored under tag, if tag is a signature or referrers tag. It returns an
+// empty digest otherwise.
+func signedDigest(tag string) digest.Digest {
+	m := tagRegexp.FindStringSubmatch(tag)
+	if m == nil || (m[3] != "" && m[3] != ".sig") {
+		return ""
+	}
+	dgst := digest.NewDigestFromEncoded(digest.Algorithm(m[1]), m[2])
+	if dgst.Validate() != nil {
+		return ""
+	}
+	return dgst
+}
@@ -0,0 +1,347 @@
+// Package signature verifies cosign signatures of manifests stored in the
+// registry.
+//
+// Signatures are looked up the way cosign stores them in a registry without
+// a referrers API: either under the tag "<algorithm>-<hex>.sig", which points
+// at an image manifest with one layer per signature, or under the referrers
+// tag "<algorithm>-<hex>", which points at an image index of signature
+// artifacts. Each signature layer holds a simple signing payload naming the
+// signed manifest digest, and carries the signature of the payload in an
+// annotation.
+package signature
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// AnnotationSignature is the annotation of a signature layer holding the
+	// base64 encoded signature of the layer.
+	AnnotationSignature = "dev.cosignproject.cosign/signature"
+
+	// MediaTypeSimpleSigning is the media type of signature layers.
+	MediaTypeSimpleSigning = "application/vnd.dev.cosign.simplesigning.v1+json"
+
+	// payloadType is the type of the simple signing payload of image
+	// signatures.
+	payloadType = "cosign container image signature"
+
+	// maxPayloadSize limits the size of the signature payloads read.
+	maxPayloadSize = 1 << 20
+)
+
+// tagRegexp matches the tags cosign stores signatures, attestations and
+// other attachments of a manifest under.
+var tagRegexp = regexp.MustCompile(`^([a-z0-9]+)-([a-f0-9]+)(\.[a-z]+)?$`)
+
+// Tag returns the tag the signatures of the manifest dgst are stored under.
+func Tag(dgst digest.Digest) string {
+	return fmt.Sprintf("%s-%s.sig", dgst.Algorithm(), dgst.Encoded())
+}
+
+// ReferrersTag returns the tag of the image index listing the artifacts,
+// including signatures, which refer to the manifest dgst.
+func ReferrersTag(dgst digest.Digest) string {
+	return fmt.Sprintf("%s-%s", dgst.Algorithm(), dgst.Encoded())
+}
+
+// IsAttachmentTag reports whether tag is one under which signatures or other
+// artifacts referring to a manifest are stored.
+func IsAttachmentTag(tag string) bool {
+	m := tagRegexp.FindStringSubmatch(tag)
+	if m == nil {
+		return false
+	}
+	return digest.NewDigestFromEncoded(digest.Algorithm(m[1]), m[2]).Validate() == nil
+}
+
+// ErrUnsigned is returned when a manifest has no signature made by any of
+// the trusted keys.
+type ErrUnsigned struct {
+	Digest digest.Digest
+}
+
+func (err ErrUnsigned) Error() string {
+	return fmt.Sprintf("manifest %s has no valid signature", err.Digest)
+}
+
+// PublicKey is a key trusted to sign manifests.
+type PublicKey struct {
+	name string
+	key  crypto.PublicKey
+}
+
+// ParsePublicKey parses a PEM encoded ECDSA, RSA or Ed25519 public key, as
+// written by "cosign generate-key-pair".
+func ParsePublicKey(name string, data []byte) (*PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("key %s: no PEM data found", name)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %v", name, err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, *rsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("key %s: unsupported key type %T", name, key)
+	}
+	return &PublicKey{name: name, key: key}, nil
+}
+
+// LoadPublicKey reads a PEM encoded public key from path.
+func LoadPublicKey(name, path string) (*PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %v", name, err)
+	}
+	return ParsePublicKey(name, data)
+}
+
+// Name returns the name the key was configured with.
+func (k *PublicKey) Name() string {
+	return k.name
+}
+
+// verify checks that sig is a signature of payload made by the key.
+func (k *PublicKey) verify(payload, sig []byte) bool {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		h := sha256.Sum256(payload)
+		return ecdsa.VerifyASN1(key, h[:], sig)
+	case *rsa.PublicKey:
+		h := sha256.Sum256(payload)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, sig)
+	}
+	return false
+}
+
+// simpleSigning is the payload of a cosign signature.
+type simpleSigning struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+		} `json:"image"`
+		Type string `json:"type"`
+	} `json:"critical"`
+}
+
+// Verify checks that the manifest dgst of repo has a signature made by one of
+// keys. It returns ErrUnsigned if it does not.
+func Verify(ctx context.Context, repo distribution.Repository, dgst digest.Digest, keys []*PublicKey) error {
+	layers, err := signatureLayers(ctx, repo, dgst)
+	if err != nil {
+		return err
+	}
+
+	blobs := repo.Blobs(ctx)
+	for _, layer := range layers {
+		sig, err := base64.StdEncoding.DecodeString(layer.Annotations[AnnotationSignature])
+		if err != nil || layer.Size > maxPayloadSize {
+			continue
+		}
+		payload, err := blobs.Get(ctx, layer.Digest)
+		if err != nil {
+			if errors.Is(err, distribution.ErrBlobUnknown) {
+				continue
+			}
+			return err
+		}
+		if digest.FromBytes(payload) != layer.Digest || !signs(payload, dgst) {
+			continue
+		}
+		for _, key := range keys {
+			if key.verify(payload, sig) {
+				return nil
+			}
+		}
+	}
+	return ErrUnsigned{Digest: dgst}
+}
+
+// signs reports whether payload is a simple signing payload for the manifest
+// dgst.
+func signs(payload []byte, dgst digest.Digest) bool {
+	var s simpleSigning
+	if err := json.Unmarshal(payload, &s); err != nil {
+		return false
+	}
+	return s.Critical.Type == payloadType && s.Critical.Image.DockerManifestDigest == dgst
+}
+
+// signatureLayers returns the descriptors of the signature layers stored for
+// the manifest dgst, from both the signature tag and the referrers tag.
+func signatureLayers(ctx context.Context, repo distribution.Repository, dgst digest.Digest) ([]distribution.Descriptor, error) {
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var layers []distribution.Descriptor
+	for _, tag := range []string{Tag(dgst), ReferrersTag(dgst)} {
+		desc, err := repo.Tags(ctx).Get(ctx, tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			return nil, err
+		}
+		m, err := getManifest(ctx, manifests, desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			continue
+		}
+
+		switch m.(type) {
+		case *ocischema.DeserializedImageIndex, *manifestlist.DeserializedManifestList:
+			// the referrers index lists signature artifacts
+			for _, ref := range m.References() {
+				artifact, err := getManifest(ctx, manifests, ref.Digest)
+				if err != nil {
+					return nil, err
+				}
+				if artifact != nil {
+					layers = append(layers, annotatedLayers(artifact)...)
+				}
+			}
+		default:
+			layers = append(layers, annotatedLayers(m)...)
+		}
+	}
+	return layers, nil
+}
+
+// getManifest returns the manifest dgst, or nil if it does not exist.
+func getManifest(ctx context.Context, manifests distribution.ManifestService, dgst digest.Digest) (distribution.Manifest, error) {
+	m, err := manifests.Get(ctx, dgst)
+	if err != nil {
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return m, nil
+}
+
+// annotatedLayers returns the references of m carrying a signature.
+func annotatedLayers(m distribution.Manifest) []distribution.Descriptor {
+	var layers []distribution.Descriptor
+	for _, ref := range m.References() {
+		if _, ok := ref.Annotations[AnnotationSignature]; ok {
+			layers = append(layers, ref)
+		}
+	}
+	return layers
+}
+
+// signedManifest returns the digest of the manifest signed by m if m is a
+// signature manifest: an image manifest all of whose layers are simple
+// signing payloads, stored in repo, for one manifest which exists in repo.
+// It returns an empty digest otherwise.
+func signedManifest(ctx context.Context, repo distribution.Repository, m distribution.Manifest) (digest.Digest, error) {
+	var layers []distribution.Descriptor
+	switch m := m.(type) {
+	case *ocischema.DeserializedManifest:
+		layers = m.Layers
+	case *schema2.DeserializedManifest:
+		layers = m.Layers
+	}
+	if len(layers) == 0 {
+		return "", nil
+	}
+
+	var signed digest.Digest
+	blobs := repo.Blobs(ctx)
+	for _, layer := range layers {
+		if _, ok := layer.Annotations[AnnotationSignature]; !ok {
+			return "", nil
+		}
+		if layer.MediaType != MediaTypeSimpleSigning || layer.Size > maxPayloadSize {
+			return "", nil
+		}
+		payload, err := blobs.Get(ctx, layer.Digest)
+		if err != nil {
+			if errors.Is(err, distribution.ErrBlobUnknown) {
+				return "", nil
+			}
+			return "", err
+		}
+		var s simpleSigning
+		if digest.FromBytes(payload) != layer.Digest || json.Unmarshal(payload, &s) != nil || s.Critical.Type != payloadType {
+			return "", nil
+		}
+		dgst := s.Critical.Image.DockerManifestDigest
+		if dgst.Validate() != nil || (signed != "" && dgst != signed) {
+			return "", nil
+		}
+		signed = dgst
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return "", err
+	}
+	exists, err := manifests.Exists(ctx, signed)
+	if err != nil || !exists {
+		return "", err
+	}
+	return signed, nil
+}
+
+// referredManifest returns the digest of the manifest referred to by m if m
+// is a referrers index: an image index all of whose manifests are signature
+// manifests, stored in repo, for one manifest which exists in repo. It
+// returns an empty digest otherwise.
+func referredManifest(ctx context.Context, repo distribution.Repository, m distribution.Manifest) (digest.Digest, error) {
+	switch m.(type) {
+	case *ocischema.DeserializedImageIndex, *manifestlist.DeserializedManifestList:
+	default:
+		return "", nil
+	}
+	refs := m.References()
+	if len(refs) == 0 {
+		return "", nil
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return "", err
+	}
+	var referred digest.Digest
+	for _, ref := range refs {
+		artifact, err := getManifest(ctx, manifests, ref.Digest)
+		if err != nil || artifact == nil {
+			return "", err
+		}
+		signed, err := signedManifest(ctx, repo, artifact)
+		if err != nil || signed == "" || (referred != "" && signed != referred) {
+			return "", err
+		}
+		referred = signed
+	}
+	return referred, nil
+}
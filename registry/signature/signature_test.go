@@ -0,0 +1,404 @@
+package signature
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// generateKey returns a newly generated public key in PEM format and a
+// function signing payloads with the matching private key.
+func generateKey(t *testing.T, ed bool) ([]byte, func([]byte) []byte) {
+	t.Helper()
+
+	var (
+		pub  crypto.PublicKey
+		sign func([]byte) []byte
+	)
+	if ed {
+		edPub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub = edPub
+		sign = func(payload []byte) []byte {
+			return ed25519.Sign(priv, payload)
+		}
+	} else {
+		priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub = &priv.PublicKey
+		sign = func(payload []byte) []byte {
+			h := sha256.Sum256(payload)
+			sig, err := ecdsa.SignASN1(rand.Reader, priv, h[:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			return sig
+		}
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), sign
+}
+
+// putSignature stores a signature manifest for the manifest dgst in repo,
+// signed with sign, and returns its descriptor.
+func putSignature(t *testing.T, repo distribution.Repository, dgst digest.Digest, sign func([]byte) []byte) distribution.Descriptor {
+	t.Helper()
+	ctx := context.Background()
+
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"%s"},"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},"optional":null}`, repo.Named().Name(), dgst))
+	layer, err := repo.Blobs(ctx).Put(ctx, MediaTypeSimpleSigning, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer.MediaType = MediaTypeSimpleSigning
+	layer.Annotations = map[string]string{AnnotationSignature: base64.StdEncoding.EncodeToString(sign(payload))}
+
+	config, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageConfig, []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.MediaType = v1.MediaTypeImageConfig
+
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: ocischema.SchemaVersion,
+		Config:    config,
+		Layers:    []distribution.Descriptor{layer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return putManifest(t, repo, m)
+}
+
+func putManifest(t *testing.T, repo distribution.Repository, m distribution.Manifest) distribution.Descriptor {
+	t.Helper()
+	ctx := context.Background()
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := manifests.Put(ctx, m)
+	if err != nil {
+		t.Fatalf("failed to put manifest: %v", err)
+	}
+	mediaType, payload, err := m.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}
+}
+
+func newRepository(t *testing.T, name string) distribution.Repository {
+	t.Helper()
+	ctx := context.Background()
+
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, err := reference.WithName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	repo := newRepository(t, "foo/bar")
+
+	ecPEM, ecSign := generateKey(t, false)
+	edPEM, edSign := generateKey(t, true)
+	otherPEM, _ := generateKey(t, false)
+	ecKey, err := ParsePublicKey("ec", ecPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, err := ParsePublicKey("ed", edPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ParsePublicKey("other", otherPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParsePublicKey("invalid", []byte("not a key")); err == nil {
+		t.Fatalf("expected parsing an invalid key to fail")
+	}
+
+	signed := digest.FromString("signed by tag")
+	referred := digest.FromString("signed by referrers index")
+	unsigned := digest.FromString("unsigned")
+	wrongDigest := digest.FromString("signature of another manifest")
+
+	// a signature stored under the signature tag
+	sig := putSignature(t, repo, signed, ecSign)
+	if err := repo.Tags(ctx).Tag(ctx, Tag(signed), sig); err != nil {
+		t.Fatal(err)
+	}
+
+	// a signature artifact listed in the referrers index
+	sig = putSignature(t, repo, referred, edSign)
+	index, err := ocischema.FromDescriptors([]distribution.Descriptor{sig}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, ReferrersTag(referred), putManifest(t, repo, index)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a valid signature of another manifest stored under the wrong tag
+	sig = putSignature(t, repo, signed, ecSign)
+	if err := repo.Tags(ctx).Tag(ctx, Tag(wrongDigest), sig); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		dgst   digest.Digest
+		keys   []*PublicKey
+		signed bool
+	}{
+		{signed, []*PublicKey{ecKey}, true},
+		{signed, []*PublicKey{otherKey, ecKey}, true},
+		{signed, []*PublicKey{otherKey}, false},
+		{signed, nil, false},
+		{referred, []*PublicKey{edKey}, true},
+		{referred, []*PublicKey{ecKey}, false},
+		{unsigned, []*PublicKey{ecKey, edKey}, false},
+		{wrongDigest, []*PublicKey{ecKey}, false},
+	} {
+		err := Verify(ctx, repo, tc.dgst, tc.keys)
+		if tc.signed && err != nil {
+			t.Fatalf("expected %s to be signed: %v", tc.dgst, err)
+		}
+		if !tc.signed {
+			if _, ok := err.(ErrUnsigned); !ok {
+				t.Fatalf("expected %s to be unsigned, got %v", tc.dgst, err)
+			}
+		}
+	}
+}
+
+// putImage stores an image manifest with a single layer holding content in
+// repo and returns its descriptor.
+func putImage(t *testing.T, repo distribution.Repository, content string) distribution.Descriptor {
+	t.Helper()
+	ctx := context.Background()
+
+	layer, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageLayer, []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer.MediaType = v1.MediaTypeImageLayer
+	config, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageConfig, []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.MediaType = v1.MediaTypeImageConfig
+
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: ocischema.SchemaVersion,
+		Config:    config,
+		Layers:    []distribution.Descriptor{layer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return putManifest(t, repo, m)
+}
+
+func TestPolicy(t *testing.T) {
+	ctx := context.Background()
+	repo := newRepository(t, "library/app")
+	other := newRepository(t, "other/app")
+
+	keyPEM, sign := generateKey(t, false)
+	key, err := ParsePublicKey("release", keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy := NewPolicy(
+		Rule{Repository: "library/*", Push: true, Pull: true, Keys: []*PublicKey{key}},
+		Rule{Repository: "library/app"},
+	)
+
+	signed := putImage(t, repo, "signed").Digest
+	unsigned := putImage(t, repo, "unsigned").Digest
+	sig := putSignature(t, repo, signed, sign)
+	if err := repo.Tags(ctx).Tag(ctx, Tag(signed), sig); err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(dgst digest.Digest) distribution.Manifest {
+		m, err := manifests.Get(ctx, dgst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+
+	if err := policy.VerifyPush(ctx, repo, get(signed), signed, "latest"); err != nil {
+		t.Fatalf("expected tagging a signed manifest to be allowed: %v", err)
+	}
+	if _, ok := policy.VerifyPush(ctx, repo, get(unsigned), unsigned, "latest").(ErrUnsigned); !ok {
+		t.Fatalf("expected tagging an unsigned manifest to be denied")
+	}
+	if err := policy.VerifyPush(ctx, repo, get(unsigned), unsigned, ""); err != nil {
+		t.Fatalf("expected pushing by digest to be allowed: %v", err)
+	}
+	if err := policy.VerifyPush(ctx, repo, get(sig.Digest), sig.Digest, Tag(signed)); err != nil {
+		t.Fatalf("expected pushing a signature to be allowed: %v", err)
+	}
+	if _, ok := policy.VerifyPush(ctx, repo, get(sig.Digest), sig.Digest, Tag(unsigned)).(ErrUnsigned); !ok {
+		t.Fatalf("expected pushing a signature under the tag of another manifest to be denied")
+	}
+	if _, ok := policy.VerifyPush(ctx, repo, get(unsigned), unsigned, ReferrersTag(unsigned)).(ErrUnsigned); !ok {
+		t.Fatalf("expected tagging an unsigned manifest with an attachment tag to be denied")
+	}
+
+	if err := policy.VerifyPull(ctx, repo, nil, signed, "latest"); err != nil {
+		t.Fatalf("expected pulling a signed manifest to be allowed: %v", err)
+	}
+	if _, ok := policy.VerifyPull(ctx, repo, nil, unsigned, "").(ErrUnsigned); !ok {
+		t.Fatalf("expected pulling an unsigned manifest to be denied")
+	}
+	if err := policy.VerifyPull(ctx, repo, get(sig.Digest), sig.Digest, Tag(signed)); err != nil {
+		t.Fatalf("expected pulling a signature to be allowed: %v", err)
+	}
+	if err := policy.VerifyPull(ctx, repo, get(sig.Digest), sig.Digest, ""); err != nil {
+		t.Fatalf("expected pulling a signature by digest to be allowed: %v", err)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, ReferrersTag(unsigned), distribution.Descriptor{Digest: unsigned}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := policy.VerifyPull(ctx, repo, get(unsigned), unsigned, ReferrersTag(unsigned)).(ErrUnsigned); !ok {
+		t.Fatalf("expected pulling an unsigned manifest by an attachment tag to be denied")
+	}
+
+	// an image whose layers merely carry the signature annotation is not a
+	// signature
+	payload, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageLayer, []byte("not a signing payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload.MediaType = MediaTypeSimpleSigning
+	payload.Annotations = map[string]string{AnnotationSignature: "c2lnbmF0dXJl"}
+	config, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageConfig, []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.MediaType = v1.MediaTypeImageConfig
+	fake, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: ocischema.SchemaVersion,
+		Config:    config,
+		Layers:    []distribution.Descriptor{payload},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fakeDesc := putManifest(t, repo, fake)
+	if _, ok := policy.VerifyPull(ctx, repo, fake, fakeDesc.Digest, "").(ErrUnsigned); !ok {
+		t.Fatalf("expected pulling a manifest disguised as a signature to be denied")
+	}
+	if _, ok := policy.VerifyPush(ctx, repo, fake, fakeDesc.Digest, Tag(signed)).(ErrUnsigned); !ok {
+		t.Fatalf("expected pushing a manifest disguised as a signature to be denied")
+	}
+
+	// an index of signatures can be pushed and pulled under the referrers
+	// tag of the signed manifest
+	referrers, err := ocischema.FromDescriptors([]distribution.Descriptor{putSignature(t, repo, signed, sign)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrersDesc := putManifest(t, repo, referrers)
+	if err := policy.VerifyPush(ctx, repo, referrers, referrersDesc.Digest, ReferrersTag(signed)); err != nil {
+		t.Fatalf("expected pushing a referrers index to be allowed: %v", err)
+	}
+	if _, ok := policy.VerifyPush(ctx, repo, referrers, referrersDesc.Digest, ReferrersTag(unsigned)).(ErrUnsigned); !ok {
+		t.Fatalf("expected pushing a referrers index under the tag of another manifest to be denied")
+	}
+	if err := repo.Tags(ctx).Tag(ctx, ReferrersTag(signed), referrersDesc); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.VerifyPull(ctx, repo, referrers, referrersDesc.Digest, ReferrersTag(signed)); err != nil {
+		t.Fatalf("expected pulling a referrers index to be allowed: %v", err)
+	}
+	if err := policy.VerifyPull(ctx, repo, referrers, referrersDesc.Digest, ""); err != nil {
+		t.Fatalf("expected pulling a referrers index by digest to be allowed: %v", err)
+	}
+	mixed, err := ocischema.FromDescriptors([]distribution.Descriptor{putSignature(t, repo, signed, sign), putImage(t, repo, "artifact")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mixedDesc := putManifest(t, repo, mixed)
+	if _, ok := policy.VerifyPush(ctx, repo, mixed, mixedDesc.Digest, ReferrersTag(signed)).(ErrUnsigned); !ok {
+		t.Fatalf("expected pushing an index of other manifests under a referrers tag to be denied")
+	}
+
+	// the manifests of a signed index can be pulled by digest
+	child := putImage(t, repo, "child")
+	index, err := ocischema.FromDescriptors([]distribution.Descriptor{child}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := policy.VerifyPull(ctx, repo, nil, child.Digest, "").(ErrUnsigned); !ok {
+		t.Fatalf("expected pulling an unsigned manifest to be denied")
+	}
+	indexDesc := putManifest(t, repo, index)
+	if err := repo.Tags(ctx).Tag(ctx, "multi", indexDesc); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := policy.VerifyPull(ctx, repo, nil, child.Digest, "").(ErrUnsigned); !ok {
+		t.Fatalf("expected pulling a manifest of an unsigned index to be denied")
+	}
+	indexSig := putSignature(t, repo, indexDesc.Digest, sign)
+	if err := repo.Tags(ctx).Tag(ctx, Tag(indexDesc.Digest), indexSig); err != nil {
+		t.Fatal(err)
+	}
+	if err := policy.VerifyPull(ctx, repo, nil, child.Digest, ""); err != nil {
+		t.Fatalf("expected pulling a manifest of a signed index by digest to be allowed: %v", err)
+	}
+
+	// repositories not matched by a rule are not checked
+	if err := policy.VerifyPull(ctx, other, nil, unsigned, "latest"); err != nil {
+		t.Fatalf("expected pulling from an unmatched repository to be allowed: %v", err)
+	}
+	var nilPolicy *Policy
+	if err := nilPolicy.VerifyPull(ctx, repo, nil, unsigned, "latest"); err != nil {
+		t.Fatalf("expected a nil policy to allow pulls: %v", err)
+	}
+}
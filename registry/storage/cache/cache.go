@@ -3,7 +3,9 @@
 package cache
 
 import (
+	"context"
 	"fmt"
+	"strings"
 
 	"github.com/distribution/distribution/v3"
 )
@@ -16,6 +18,41 @@ type BlobDescriptorCacheProvider interface {
 	RepositoryScoped(repo string) (distribution.BlobDescriptorService, error)
 }
 
+// CatalogIndex maintains the sorted list of repositories served by the
+// catalog, so that it can be paginated without walking the storage. Names
+// are ordered as they are by a walk of the storage, with the path separator
+// sorting first.
+type CatalogIndex interface {
+	// Add adds the named repositories to the index.
+	Add(ctx context.Context, names ...string) error
+
+	// Remove removes the named repositories from the index.
+	Remove(ctx context.Context, names ...string) error
+
+	// List returns up to n repository names following last in the index.
+	List(ctx context.Context, last string, n int) ([]string, error)
+
+	// Ready reports whether the index has been built. An index which has not
+	// been built may be incomplete and must not be used for listing.
+	Ready(ctx context.Context) (bool, error)
+
+	// SetReady marks the index as built.
+	SetReady(ctx context.Context) error
+}
+
+// CatalogSortKey returns the key a repository name is sorted by in a
+// CatalogIndex. It replaces the path separator with a byte which sorts
+// before any character allowed in a repository name.
+func CatalogSortKey(name string) string {
+	return strings.ReplaceAll(name, "/", "\x00")
+}
+
+// CatalogName returns the repository name for a key returned by
+// CatalogSortKey.
+func CatalogName(key string) string {
+	return strings.ReplaceAll(key, "\x00", "/")
+}
+
 // ValidateDescriptor provides a helper function to ensure that caches have
 // common criteria for admitting descriptors.
 func ValidateDescriptor(desc distribution.Descriptor) error {
@@ -185,3 +185,69 @@ func checkBlobDescriptorCacheClear(ctx context.Context, t *testing.T, provider c
 		t.Fatalf("expected error statting deleted blob: %v", err)
 	}
 }
+
+// CheckCatalogIndex takes a catalog index implementation through a common
+// set of operations. The index must be empty.
+func CheckCatalogIndex(t *testing.T, index cache.CatalogIndex) {
+	ctx := context.Background()
+
+	ready, err := index.Ready(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error checking readiness: %v", err)
+	}
+	if ready {
+		t.Fatalf("expected a new index not to be ready")
+	}
+	if err := index.SetReady(ctx); err != nil {
+		t.Fatalf("unexpected error setting readiness: %v", err)
+	}
+	if ready, err := index.Ready(ctx); err != nil || !ready {
+		t.Fatalf("expected index to be ready: %v", err)
+	}
+
+	if err := index.Add(ctx, "foo-bar", "foo/bar", "abc", "foo/bar/baz", "foo"); err != nil {
+		t.Fatalf("unexpected error adding repositories: %v", err)
+	}
+	// adding is idempotent
+	if err := index.Add(ctx, "abc"); err != nil {
+		t.Fatalf("unexpected error adding repositories: %v", err)
+	}
+
+	// the path separator sorts first, as in a walk of the storage
+	expected := []string{"abc", "foo", "foo/bar", "foo/bar/baz", "foo-bar"}
+	names, err := index.List(ctx, "", 10)
+	if err != nil {
+		t.Fatalf("unexpected error listing repositories: %v", err)
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("unexpected repositories: %v != %v", names, expected)
+	}
+
+	names, err = index.List(ctx, "foo", 2)
+	if err != nil {
+		t.Fatalf("unexpected error listing repositories: %v", err)
+	}
+	if !reflect.DeepEqual(names, expected[2:4]) {
+		t.Fatalf("unexpected page: %v != %v", names, expected[2:4])
+	}
+
+	// last need not be in the index
+	names, err = index.List(ctx, "foo/bar/bb", 10)
+	if err != nil {
+		t.Fatalf("unexpected error listing repositories: %v", err)
+	}
+	if !reflect.DeepEqual(names, expected[4:]) {
+		t.Fatalf("unexpected page: %v != %v", names, expected[4:])
+	}
+
+	if err := index.Remove(ctx, "foo/bar", "unknown"); err != nil {
+		t.Fatalf("unexpected error removing repositories: %v", err)
+	}
+	names, err = index.List(ctx, "", 10)
+	if err != nil {
+		t.Fatalf("unexpected error listing repositories: %v", err)
+	}
+	if expected := []string{"abc", "foo", "foo/bar/baz", "foo-bar"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("unexpected repositories after removal: %v != %v", names, expected)
+	}
+}
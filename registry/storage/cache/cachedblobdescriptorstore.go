@@ -7,6 +7,10 @@ import (
 	dcontext "github.com/distribution/distribution/v3/context"
 	prometheus "github.com/distribution/distribution/v3/metrics"
 	"github.com/opencontainers/go-digest"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type cachedBlobStatter struct {
@@ -21,6 +25,9 @@ var (
 	cacheHitCount = prometheus.StorageNamespace.NewCounter("cache_hits", "The number of cache request received")
 	// cacheErrorCount is the number of cache request errors.
 	cacheErrorCount = prometheus.StorageNamespace.NewCounter("cache_errors", "The number of cache request errors")
+
+	// tracer starts the spans of the cache lookups.
+	tracer = otel.Tracer("github.com/distribution/distribution/v3/registry/storage/cache")
 )
 
 // NewCachedBlobStatter creates a new statter which prefers a cache and
@@ -35,8 +42,12 @@ func NewCachedBlobStatter(cache distribution.BlobDescriptorService, backend dist
 func (cbds *cachedBlobStatter) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
 	cacheRequestCount.Inc(1)
 
+	ctx, span := tracer.Start(ctx, "cache.Stat", trace.WithAttributes(attribute.String("blob.digest", dgst.String())))
+	defer span.End()
+
 	// try getting from cache
 	desc, cacheErr := cbds.cache.Stat(ctx, dgst)
+	span.SetAttributes(attribute.Bool("cache.hit", cacheErr == nil))
 	if cacheErr == nil {
 		cacheHitCount.Inc(1)
 		return desc, nil
@@ -45,6 +56,10 @@ func (cbds *cachedBlobStatter) Stat(ctx context.Context, dgst digest.Digest) (di
 	// couldn't get from cache; get from backend
 	desc, err := cbds.backend.Stat(ctx, dgst)
 	if err != nil {
+		if err != distribution.ErrBlobUnknown {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
 		return desc, err
 	}
 
@@ -57,6 +72,7 @@ func (cbds *cachedBlobStatter) Stat(ctx context.Context, dgst digest.Digest) (di
 		// unknown error from cache. just log and error. do not store cache as it may be trigger many set calls
 		dcontext.GetLoggerWithField(ctx, "blob", dgst).WithError(cacheErr).Error("error from cache stat(ing) blob")
 		cacheErrorCount.Inc(1)
+		span.RecordError(cacheErr)
 	}
 
 	return desc, nil
@@ -0,0 +1,84 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/distribution/distribution/v3/registry/storage/cache"
+)
+
+type inMemoryCatalogIndex struct {
+	mu    sync.RWMutex
+	keys  []string
+	ready bool
+}
+
+// NewInMemoryCatalogIndex returns a new catalog index held in memory. It is
+// lost when the registry stops and has to be rebuilt on start.
+func NewInMemoryCatalogIndex() cache.CatalogIndex {
+	return &inMemoryCatalogIndex{}
+}
+
+func (imci *inMemoryCatalogIndex) Add(ctx context.Context, names ...string) error {
+	imci.mu.Lock()
+	defer imci.mu.Unlock()
+
+	for _, name := range names {
+		key := cache.CatalogSortKey(name)
+		i := sort.SearchStrings(imci.keys, key)
+		if i < len(imci.keys) && imci.keys[i] == key {
+			continue
+		}
+		imci.keys = append(imci.keys, "")
+		copy(imci.keys[i+1:], imci.keys[i:])
+		imci.keys[i] = key
+	}
+	return nil
+}
+
+func (imci *inMemoryCatalogIndex) Remove(ctx context.Context, names ...string) error {
+	imci.mu.Lock()
+	defer imci.mu.Unlock()
+
+	for _, name := range names {
+		key := cache.CatalogSortKey(name)
+		i := sort.SearchStrings(imci.keys, key)
+		if i < len(imci.keys) && imci.keys[i] == key {
+			imci.keys = append(imci.keys[:i], imci.keys[i+1:]...)
+		}
+	}
+	return nil
+}
+
+func (imci *inMemoryCatalogIndex) List(ctx context.Context, last string, n int) ([]string, error) {
+	imci.mu.RLock()
+	defer imci.mu.RUnlock()
+
+	i := 0
+	if last != "" {
+		lastKey := cache.CatalogSortKey(last)
+		i = sort.Search(len(imci.keys), func(i int) bool {
+			return imci.keys[i] > lastKey
+		})
+	}
+
+	names := make([]string, 0, n)
+	for ; i < len(imci.keys) && len(names) < n; i++ {
+		names = append(names, cache.CatalogName(imci.keys[i]))
+	}
+	return names, nil
+}
+
+func (imci *inMemoryCatalogIndex) Ready(ctx context.Context) (bool, error) {
+	imci.mu.RLock()
+	defer imci.mu.RUnlock()
+	return imci.ready, nil
+}
+
+func (imci *inMemoryCatalogIndex) SetReady(ctx context.Context) error {
+	imci.mu.Lock()
+	defer imci.mu.Unlock()
+	imci.ready = true
+	return nil
+}
@@ -11,3 +11,9 @@ import (
 func TestInMemoryBlobInfoCache(t *testing.T) {
 	cachecheck.CheckBlobDescriptorCache(t, NewInMemoryBlobDescriptorCacheProvider(UnlimitedSize))
 }
+
+// TestInMemoryCatalogIndex checks the in memory catalog index is working
+// correctly.
+func TestInMemoryCatalogIndex(t *testing.T) {
+	cachecheck.CheckCatalogIndex(t, NewInMemoryCatalogIndex())
+}
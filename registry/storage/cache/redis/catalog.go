@@ -0,0 +1,83 @@
+package redis
+
+import (
+	"context"
+
+	"github.com/distribution/distribution/v3/registry/storage/cache"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	catalogKey      = "catalog::repositories"
+	catalogReadyKey = "catalog::ready"
+)
+
+// redisCatalogIndex stores the catalog index in a redis sorted set. All
+// members have the same score, so they are ordered lexicographically and
+// pages are read with ZRANGEBYLEX.
+type redisCatalogIndex struct {
+	pool *redis.Client
+}
+
+// NewRedisCatalogIndex returns a new redis-based catalog index using the
+// provided redis connection pool.
+func NewRedisCatalogIndex(pool *redis.Client) cache.CatalogIndex {
+	return &redisCatalogIndex{
+		pool: pool,
+	}
+}
+
+func (rci *redisCatalogIndex) Add(ctx context.Context, names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	members := make([]redis.Z, 0, len(names))
+	for _, name := range names {
+		members = append(members, redis.Z{Member: cache.CatalogSortKey(name)})
+	}
+	return rci.pool.ZAdd(ctx, catalogKey, members...).Err()
+}
+
+func (rci *redisCatalogIndex) Remove(ctx context.Context, names ...string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	members := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		members = append(members, cache.CatalogSortKey(name))
+	}
+	return rci.pool.ZRem(ctx, catalogKey, members...).Err()
+}
+
+func (rci *redisCatalogIndex) List(ctx context.Context, last string, n int) ([]string, error) {
+	min := "-"
+	if last != "" {
+		min = "(" + cache.CatalogSortKey(last)
+	}
+	keys, err := rci.pool.ZRangeByLex(ctx, catalogKey, &redis.ZRangeBy{
+		Min:   min,
+		Max:   "+",
+		Count: int64(n),
+	}).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		names = append(names, cache.CatalogName(key))
+	}
+	return names, nil
+}
+
+func (rci *redisCatalogIndex) Ready(ctx context.Context) (bool, error) {
+	n, err := rci.pool.Exists(ctx, catalogReadyKey).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (rci *redisCatalogIndex) SetReady(ctx context.Context) error {
+	return rci.pool.Set(ctx, catalogReadyKey, "1", 0).Err()
+}
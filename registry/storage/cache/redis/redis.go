@@ -201,7 +201,9 @@ func (rsrbds *repositoryScopedRedisBlobDescriptorService) Stat(ctx context.Conte
 	return upstream, nil
 }
 
-// Clear removes the descriptor from the cache and forwards to the upstream descriptor store
+// Clear removes the descriptor from the repository's cache. The global
+// descriptor is kept, as it remains valid for the other repositories
+// linking the blob.
 func (rsrbds *repositoryScopedRedisBlobDescriptorService) Clear(ctx context.Context, dgst digest.Digest) error {
 	if err := dgst.Validate(); err != nil {
 		return err
@@ -216,7 +218,14 @@ func (rsrbds *repositoryScopedRedisBlobDescriptorService) Clear(ctx context.Cont
 		return distribution.ErrBlobUnknown
 	}
 
-	return rsrbds.upstream.Clear(ctx, dgst)
+	// Drop the membership and the repository specific overrides, so that the
+	// blob is not reported as linked if the global descriptor is cached again
+	// through another repository.
+	if _, err := rsrbds.upstream.pool.SRem(ctx, rsrbds.repositoryBlobSetKey(rsrbds.repo), dgst.String()).Result(); err != nil {
+		return err
+	}
+	_, err = rsrbds.upstream.pool.Del(ctx, rsrbds.blobDescriptorHashKey(dgst)).Result()
+	return err
 }
 
 func (rsrbds *repositoryScopedRedisBlobDescriptorService) SetDescriptor(ctx context.Context, dgst digest.Digest, desc distribution.Descriptor) error {
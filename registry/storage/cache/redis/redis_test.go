@@ -48,3 +48,27 @@ func TestRedisBlobDescriptorCacheProvider(t *testing.T) {
 
 	cachecheck.CheckBlobDescriptorCache(t, NewRedisBlobDescriptorCacheProvider(pool))
 }
+
+// TestRedisCatalogIndex exercises a live redis instance using the catalog
+// index implementation.
+func TestRedisCatalogIndex(t *testing.T) {
+	if redisAddr == "" {
+		redisAddr = os.Getenv("TEST_REGISTRY_STORAGE_CACHE_REDIS_ADDR")
+	}
+	if redisAddr == "" {
+		t.Skip("please set -test.registry.storage.cache.redis.addr to test the catalog index against redis")
+	}
+
+	pool := redis.NewClient(&redis.Options{
+		Addr:       redisAddr,
+		MaxRetries: 3,
+		PoolSize:   2,
+	})
+
+	ctx := context.Background()
+	if err := pool.FlushDB(ctx).Err(); err != nil {
+		t.Fatalf("unexpected error flushing redis db: %v", err)
+	}
+
+	cachecheck.CheckCatalogIndex(t, NewRedisCatalogIndex(pool))
+}
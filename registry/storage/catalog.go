@@ -3,12 +3,17 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"path"
 	"strings"
 
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/cache"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
 )
 
 // Returns a list, or partial list, of repositories in the registry.
@@ -27,6 +32,23 @@ func (reg *registry) Repositories(ctx context.Context, repos []string, last stri
 		return 0, err
 	}
 
+	if reg.catalogIndex != nil {
+		ready, err := reg.catalogIndex.Ready(ctx)
+		if err != nil {
+			dcontext.GetLogger(ctx).Warnf("catalog index unavailable, walking storage: %v", err)
+		} else if ready {
+			names, err := reg.catalogIndex.List(ctx, last, len(repos))
+			if err != nil {
+				return 0, err
+			}
+			n := copy(repos, names)
+			if n < len(repos) {
+				return n, io.EOF
+			}
+			return n, nil
+		}
+	}
+
 	startAfter := ""
 	if last != "" {
 		startAfter, err = pathFor(manifestsPathSpec{name: last})
@@ -81,14 +103,295 @@ func (reg *registry) Enumerate(ctx context.Context, ingester func(string) error)
 	return err
 }
 
-// Remove removes a repository from storage
+// repositoryContentDirs are the directories holding the content of a
+// repository. Nested repositories live alongside them in the repository
+// directory, so a repository is removed by deleting these rather than the
+// whole directory.
+var repositoryContentDirs = []string{"_layers", "_manifests", "_quarantine", "_trash", "_uploads"}
+
+// Remove removes the tags, manifest links, layer links, quarantine records,
+// recycle bin and uploads of a repository from storage. The blobs it references are left for
+// the garbage collector.
 func (reg *registry) Remove(ctx context.Context, name reference.Named) error {
+	if !reg.deleteEnabled {
+		return distribution.ErrUnsupported
+	}
+
+	exists, err := reg.repositoryExists(ctx, name.Name())
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return distribution.ErrRepositoryUnknown{Name: name.Name()}
+	}
+
+	if err := reg.removeContent(ctx, name.Name()); err != nil {
+		return err
+	}
+	reg.removeFromCatalog(ctx, name.Name())
+	return nil
+}
+
+// removeContent deletes the content directories of the named repository.
+func (reg *registry) removeContent(ctx context.Context, name string) error {
+	// Clear the cached descriptors of the repository before removing its
+	// links, so that a concurrent stat cannot repopulate the cache from the
+	// links being removed.
+	if err := reg.clearRepositoryDescriptors(ctx, name); err != nil {
+		return err
+	}
+
+	repoDir, err := reg.repositoryPath(name)
+	if err != nil {
+		return err
+	}
+	for _, dir := range repositoryContentDirs {
+		err := reg.driver.Delete(ctx, path.Join(repoDir, dir))
+		if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename moves a repository to a new name by linking its layers, manifests
+// and tags into the new repository and removing the old one. The new name
+// must not be in use. Uploads in progress in the old repository are lost.
+//
+// If copying fails, the partial copy is removed and the old repository is
+// left untouched, so the rename can be retried. Once the copy is complete
+// the new repository is kept: if removing the old one then fails, it is left
+// for the caller to remove.
+func (reg *registry) Rename(ctx context.Context, from, to reference.Named) error {
+	if !reg.deleteEnabled {
+		return distribution.ErrUnsupported
+	}
+
+	exists, err := reg.repositoryExists(ctx, from.Name())
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return distribution.ErrRepositoryUnknown{Name: from.Name()}
+	}
+
+	exists, err = reg.repositoryExists(ctx, to.Name())
+	if err != nil {
+		return err
+	}
+	if exists {
+		return distribution.ErrRepositoryExists{Name: to.Name()}
+	}
+
+	fromDir, err := reg.repositoryPath(from.Name())
+	if err != nil {
+		return err
+	}
+	toDir, err := reg.repositoryPath(to.Name())
+	if err != nil {
+		return err
+	}
+
+	// Quarantine records are copied first, so that quarantined content is
+	// never pullable from the new repository, and layers are linked before
+	// manifests so that manifests never appear in the new repository before
+	// the blobs they reference. The recycle bin moves along with the
+	// repository.
+	for _, dir := range []string{"_quarantine", "_layers", "_manifests", "_trash"} {
+		err := reg.driver.Walk(ctx, path.Join(fromDir, dir), func(fileInfo driver.FileInfo) error {
+			if fileInfo.IsDir() {
+				return nil
+			}
+			content, err := reg.driver.GetContent(ctx, fileInfo.Path())
+			if err != nil {
+				return err
+			}
+			return reg.driver.PutContent(ctx, path.Join(toDir, strings.TrimPrefix(fileInfo.Path(), fromDir)), content)
+		})
+		if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+			if cleanupErr := reg.removeContent(ctx, to.Name()); cleanupErr != nil {
+				dcontext.GetLogger(ctx).Errorf("failed to remove partial copy of %s to %s: %v", from.Name(), to.Name(), cleanupErr)
+			}
+			return err
+		}
+	}
+	reg.addToCatalog(ctx, to.Name())
+
+	if err := reg.Remove(ctx, from); err != nil {
+		return fmt.Errorf("renamed %s to %s but failed to remove %s: %v", from.Name(), to.Name(), from.Name(), err)
+	}
+	return nil
+}
+
+// addToCatalog adds the named repositories to the catalog index, if any.
+// Failures are logged rather than returned, as the index can be rebuilt
+// from storage.
+func (reg *registry) addToCatalog(ctx context.Context, names ...string) {
+	if reg.catalogIndex == nil {
+		return
+	}
+	if err := reg.catalogIndex.Add(ctx, names...); err != nil {
+		dcontext.GetLogger(ctx).Errorf("failed to add %v to catalog index: %v", names, err)
+	}
+}
+
+// isNewRepository reports whether the named repository has no manifests
+// yet, so that pushing one must add it to the catalog index. It is only
+// checked when there is an index, and errs on the side of adding.
+func (reg *registry) isNewRepository(ctx context.Context, name string) bool {
+	if reg.catalogIndex == nil {
+		return false
+	}
+	p, err := pathFor(manifestsPathSpec{name: name})
+	if err != nil {
+		return true
+	}
+	_, err = reg.driver.Stat(ctx, p)
+	return err != nil
+}
+
+// removeFromCatalog removes the named repositories from the catalog index,
+// if any.
+func (reg *registry) removeFromCatalog(ctx context.Context, names ...string) {
+	if reg.catalogIndex == nil {
+		return
+	}
+	if err := reg.catalogIndex.Remove(ctx, names...); err != nil {
+		dcontext.GetLogger(ctx).Errorf("failed to remove %v from catalog index: %v", names, err)
+	}
+}
+
+// catalogIndexBatchSize is the number of repositories added to or read from
+// a catalog index at once while rebuilding it.
+const catalogIndexBatchSize = 1000
+
+// RebuildCatalogIndex adds every repository found in storage to the catalog
+// index, removes the repositories which no longer exist from it and marks it
+// as ready. A registry using the index keeps it up to date while the rebuild
+// runs, so the rebuild only removes repositories it confirms to be gone.
+func RebuildCatalogIndex(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, index cache.CatalogIndex) error {
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	found := make(map[string]struct{})
+	var batch []string
+	err := repositoryEnumerator.Enumerate(ctx, func(name string) error {
+		found[name] = struct{}{}
+		batch = append(batch, name)
+		if len(batch) < catalogIndexBatchSize {
+			return nil
+		}
+		err := index.Add(ctx, batch...)
+		batch = batch[:0]
+		return err
+	})
+	if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+		return fmt.Errorf("failed to enumerate repositories: %v", err)
+	}
+	if err := index.Add(ctx, batch...); err != nil {
+		return err
+	}
+
+	last := ""
+	for {
+		names, err := index.List(ctx, last, catalogIndexBatchSize)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if _, ok := found[name]; ok {
+				continue
+			}
+			// the repository may have been created since it was walked
+			manifestsPath, err := pathFor(manifestsPathSpec{name: name})
+			if err != nil {
+				return err
+			}
+			_, err = storageDriver.Stat(ctx, manifestsPath)
+			switch err.(type) {
+			case nil:
+			case driver.PathNotFoundError:
+				if err := index.Remove(ctx, name); err != nil {
+					return err
+				}
+			default:
+				return err
+			}
+		}
+		if len(names) < catalogIndexBatchSize {
+			break
+		}
+		last = names[len(names)-1]
+	}
+
+	return index.SetReady(ctx)
+}
+
+// repositoryPath returns the directory holding the named repository.
+func (reg *registry) repositoryPath(name string) (string, error) {
 	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return "", err
+	}
+	return path.Join(root, name), nil
+}
+
+// repositoryExists returns true if the named repository holds any layers or
+// manifests.
+func (reg *registry) repositoryExists(ctx context.Context, name string) (bool, error) {
+	for _, spec := range []pathSpec{layersPathSpec{name: name}, manifestsPathSpec{name: name}} {
+		p, err := pathFor(spec)
+		if err != nil {
+			return false, err
+		}
+		_, err = reg.driver.Stat(ctx, p)
+		switch err.(type) {
+		case nil:
+			return true, nil
+		case driver.PathNotFoundError:
+		default:
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+// clearRepositoryDescriptors removes the descriptors of all layers linked
+// into the named repository from the repository scoped descriptor cache.
+func (reg *registry) clearRepositoryDescriptors(ctx context.Context, name string) error {
+	if reg.blobDescriptorCacheProvider == nil {
+		return nil
+	}
+	descriptorCache, err := reg.blobDescriptorCacheProvider.RepositoryScoped(name)
+	if err != nil {
+		return err
+	}
+
+	layersPath, err := pathFor(layersPathSpec{name: name})
 	if err != nil {
 		return err
 	}
-	repoDir := path.Join(root, name.Name())
-	return reg.driver.Delete(ctx, repoDir)
+	err = reg.driver.Walk(ctx, layersPath, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" {
+			return nil
+		}
+		// links are stored as <algorithm>/<hex digest>/link
+		dir := path.Dir(fileInfo.Path())
+		dgst := digest.NewDigestFromEncoded(digest.Algorithm(path.Base(path.Dir(dir))), path.Base(dir))
+		if err := dgst.Validate(); err != nil {
+			return nil
+		}
+		if err := descriptorCache.Clear(ctx, dgst); err != nil && err != distribution.ErrBlobUnknown {
+			return err
+		}
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
 }
 
 // lessPath returns true if one path a is less than path b.
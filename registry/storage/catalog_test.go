@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"math/rand"
+	"strings"
 	"testing"
 
 	"github.com/distribution/distribution/v3"
@@ -197,6 +198,76 @@ func TestCatalogEnumerate(t *testing.T) {
 	}
 }
 
+func TestCatalogIndex(t *testing.T) {
+	env := setupFS(t)
+
+	index := memory.NewInMemoryCatalogIndex()
+	registry, err := NewRegistry(env.ctx, env.driver, CatalogIndex(index), EnableDelete)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+
+	// the storage is walked until the index is ready
+	p := make([]string, 50)
+	n, err := registry.Repositories(env.ctx, p, "")
+	if err != io.EOF || !testEq(p, env.expected, n) || n != len(env.expected) {
+		t.Fatalf("unexpected catalog before rebuild: %v, %v", p[:n], err)
+	}
+
+	if err := index.Add(env.ctx, "stale/repo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RebuildCatalogIndex(env.ctx, env.driver, registry, index); err != nil {
+		t.Fatalf("unexpected error rebuilding catalog index: %v", err)
+	}
+
+	// pages are read from the index, in the order of a walk
+	var listed []string
+	last := ""
+	for {
+		p := make([]string, 4)
+		n, err := registry.Repositories(env.ctx, p, last)
+		listed = append(listed, p[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error listing repositories: %v", err)
+		}
+		last = p[n-1]
+	}
+	if !testEq(listed, env.expected, len(env.expected)) || len(listed) != len(env.expected) {
+		t.Fatalf("unexpected catalog from index: %v", listed)
+	}
+
+	// pushing to a new repository adds it, removing one removes it
+	makeRepo(env.ctx, t, "new/repo", registry)
+	named, _ := reference.WithName("test")
+	if err := registry.(distribution.RepositoryRemover).Remove(env.ctx, named); err != nil {
+		t.Fatalf("unexpected error removing repository: %v", err)
+	}
+	names, err := index.List(env.ctx, "foo-bar/b", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "new/repo" {
+		t.Fatalf("unexpected catalog index after push and removal: %v", names)
+	}
+
+	// pushing to an existing repository leaves the index alone
+	if err := index.Remove(env.ctx, "new/repo"); err != nil {
+		t.Fatal(err)
+	}
+	makeRepo(env.ctx, t, "new/repo", registry)
+	names, err = index.List(env.ctx, "foo-bar/b", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("unexpected catalog index after push to an existing repository: %v", names)
+	}
+}
+
 func testEq(a, b []string, size int) bool {
 	for cnt := 0; cnt < size-1; cnt++ {
 		if a[cnt] != b[cnt] {
@@ -326,3 +397,144 @@ func randomFilename(length int64) string {
 	}
 	return string(b)
 }
+
+func TestRemoveAndRenameRepository(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry := createRegistry(t, d, BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)))
+
+	fooRef, _ := reference.WithName("foo")
+	bazRef, _ := reference.WithName("baz")
+	nestedRef, _ := reference.WithName("foo/nested")
+
+	foo := makeRepository(t, registry, "foo")
+	image := uploadRandomSchema2Image(t, foo)
+	if err := foo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: image.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+	uploadRandomSchema2Image(t, makeRepository(t, registry, "foo/nested"))
+
+	// populate the descriptor cache of the repository
+	layer := getAnyKey(image.layers)
+	if _, err := foo.Blobs(ctx).Stat(ctx, layer); err != nil {
+		t.Fatalf("unexpected error stating layer: %v", err)
+	}
+
+	remover := registry.(distribution.RepositoryRemover)
+	renamer := registry.(distribution.RepositoryRenamer)
+
+	if err := renamer.Rename(ctx, fooRef, nestedRef); err == nil {
+		t.Fatalf("expected renaming onto an existing repository to fail")
+	} else if _, ok := err.(distribution.ErrRepositoryExists); !ok {
+		t.Fatalf("unexpected error renaming onto an existing repository: %v", err)
+	}
+
+	if err := renamer.Rename(ctx, fooRef, bazRef); err != nil {
+		t.Fatalf("unexpected error renaming repository: %v", err)
+	}
+
+	checkCatalog := func(expected ...string) {
+		t.Helper()
+		repos := make([]string, 10)
+		n, _ := registry.Repositories(ctx, repos, "")
+		if !testEq(repos[:n], expected, len(expected)) || n != len(expected) {
+			t.Fatalf("unexpected catalog: %v != %v", repos[:n], expected)
+		}
+	}
+	checkCatalog("baz", "foo/nested")
+
+	baz := makeRepository(t, registry, "baz")
+	desc, err := baz.Tags(ctx).Get(ctx, "latest")
+	if err != nil || desc.Digest != image.manifestDigest {
+		t.Fatalf("unexpected tag in renamed repository: %v, %v", desc, err)
+	}
+	if _, err := makeManifestService(t, baz).Get(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("unexpected error fetching manifest from renamed repository: %v", err)
+	}
+	for dgst := range image.layers {
+		if _, err := baz.Blobs(ctx).Stat(ctx, dgst); err != nil {
+			t.Fatalf("unexpected error stating layer in renamed repository: %v", err)
+		}
+	}
+	if _, err := makeRepository(t, registry, "foo").Blobs(ctx).Stat(ctx, layer); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected layer to be unknown in the old repository, got %v", err)
+	}
+
+	if err := renamer.Rename(ctx, fooRef, bazRef); err == nil {
+		t.Fatalf("expected renaming an unknown repository to fail")
+	} else if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+		t.Fatalf("unexpected error renaming an unknown repository: %v", err)
+	}
+
+	if err := remover.Remove(ctx, bazRef); err != nil {
+		t.Fatalf("unexpected error removing repository: %v", err)
+	}
+	checkCatalog("foo/nested")
+	if _, err := baz.Blobs(ctx).Stat(ctx, layer); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected layer to be unknown in the removed repository, got %v", err)
+	}
+
+	if err := remover.Remove(ctx, bazRef); err == nil {
+		t.Fatalf("expected removing an unknown repository to fail")
+	} else if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+		t.Fatalf("unexpected error removing an unknown repository: %v", err)
+	}
+
+	// deletion must be enabled
+	readOnlyRegistry, err := NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	if err := readOnlyRegistry.(distribution.RepositoryRemover).Remove(ctx, nestedRef); err != distribution.ErrUnsupported {
+		t.Fatalf("expected removal to be unsupported without delete enabled, got %v", err)
+	}
+}
+
+// failingPutDriver fails to write the paths containing failPath.
+type failingPutDriver struct {
+	driver.StorageDriver
+	failPath string
+}
+
+func (d *failingPutDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	if d.failPath != "" && strings.Contains(path, d.failPath) {
+		return fmt.Errorf("PutContent error")
+	}
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+func TestRenameRepositoryFailure(t *testing.T) {
+	ctx := context.Background()
+	d := &failingPutDriver{StorageDriver: inmemory.New()}
+	registry := createRegistry(t, d)
+
+	fooRef, _ := reference.WithName("foo")
+	barRef, _ := reference.WithName("bar")
+	image := uploadRandomSchema2Image(t, makeRepository(t, registry, "foo"))
+
+	// the layers are copied before the manifests fail to be
+	d.failPath = "/repositories/bar/_manifests/"
+	renamer := registry.(distribution.RepositoryRenamer)
+	if err := renamer.Rename(ctx, fooRef, barRef); err == nil {
+		t.Fatalf("expected renaming to fail")
+	}
+
+	// the partial copy is removed and the old repository is untouched
+	repos := make([]string, 10)
+	n, _ := registry.Repositories(ctx, repos, "")
+	if n != 1 || repos[0] != "foo" {
+		t.Fatalf("unexpected catalog after failed rename: %v", repos[:n])
+	}
+	if _, err := makeManifestService(t, makeRepository(t, registry, "foo")).Get(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("unexpected error fetching manifest from old repository: %v", err)
+	}
+
+	// the rename can be retried
+	d.failPath = ""
+	if err := renamer.Rename(ctx, fooRef, barRef); err != nil {
+		t.Fatalf("unexpected error retrying rename: %v", err)
+	}
+	if _, err := makeManifestService(t, makeRepository(t, registry, "bar")).Get(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("unexpected error fetching manifest from renamed repository: %v", err)
+	}
+}
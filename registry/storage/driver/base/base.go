@@ -46,10 +46,22 @@ import (
 	prometheus "github.com/distribution/distribution/v3/metrics"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/docker/go-metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-// storageAction is the metrics of blob related operations
-var storageAction = prometheus.StorageNamespace.NewLabeledTimer("action", "The number of seconds that the storage action takes", "driver", "action")
+var (
+	// storageAction is the metrics of blob related operations
+	storageAction = prometheus.StorageNamespace.NewLabeledTimer("action", "The number of seconds that the storage action takes", "driver", "action")
+
+	// storageErrors counts the storage actions which failed
+	storageErrors = prometheus.StorageNamespace.NewLabeledCounter("action_errors", "The number of storage actions which failed", "driver", "action")
+
+	// tracer starts the OpenTelemetry spans of storage actions
+	tracer = otel.Tracer("github.com/distribution/distribution/v3/registry/storage/driver")
+)
 
 func init() {
 	metrics.Register(prometheus.StorageNamespace)
@@ -88,6 +100,34 @@ func (base *Base) setDriverName(e error) error {
 	}
 }
 
+// start starts the span of the storage action on path and returns a function
+// recording its duration and outcome, to be called with the result of the
+// action.
+func (base *Base) start(ctx context.Context, action, path string, attrs ...attribute.KeyValue) (context.Context, func(error)) {
+	ctx, span := tracer.Start(ctx, "storage."+action,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append([]attribute.KeyValue{
+			attribute.String("storage.driver", base.Name()),
+			attribute.String("storage.path", path),
+		}, attrs...)...))
+	start := time.Now()
+
+	return ctx, func(err error) {
+		storageAction.WithValues(base.Name(), action).UpdateSince(start)
+		switch err.(type) {
+		case nil:
+		case storagedriver.PathNotFoundError:
+			// missing paths are an expected answer rather than a failure
+			span.SetAttributes(attribute.Bool("storage.not_found", true))
+		default:
+			storageErrors.WithValues(base.Name(), action).Inc(1)
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
 // GetContent wraps GetContent of underlying storage driver.
 func (base *Base) GetContent(ctx context.Context, path string) ([]byte, error) {
 	ctx, done := dcontext.WithTrace(ctx)
@@ -97,9 +137,9 @@ func (base *Base) GetContent(ctx context.Context, path string) ([]byte, error) {
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
-	start := time.Now()
+	ctx, record := base.start(ctx, "GetContent", path)
 	b, e := base.StorageDriver.GetContent(ctx, path)
-	storageAction.WithValues(base.Name(), "GetContent").UpdateSince(start)
+	record(e)
 	return b, base.setDriverName(e)
 }
 
@@ -112,10 +152,10 @@ func (base *Base) PutContent(ctx context.Context, path string, content []byte) e
 		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
-	start := time.Now()
-	err := base.setDriverName(base.StorageDriver.PutContent(ctx, path, content))
-	storageAction.WithValues(base.Name(), "PutContent").UpdateSince(start)
-	return err
+	ctx, record := base.start(ctx, "PutContent", path, attribute.Int("storage.size", len(content)))
+	err := base.StorageDriver.PutContent(ctx, path, content)
+	record(err)
+	return base.setDriverName(err)
 }
 
 // Reader wraps Reader of underlying storage driver.
@@ -131,7 +171,10 @@ func (base *Base) Reader(ctx context.Context, path string, offset int64) (io.Rea
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
+	// only opening the reader is recorded, not reading from it
+	ctx, record := base.start(ctx, "Reader", path, attribute.Int64("storage.offset", offset))
 	rc, e := base.StorageDriver.Reader(ctx, path, offset)
+	record(e)
 	return rc, base.setDriverName(e)
 }
 
@@ -144,8 +187,14 @@ func (base *Base) Writer(ctx context.Context, path string, append bool) (storage
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
-	writer, e := base.StorageDriver.Writer(ctx, path, append)
-	return writer, base.setDriverName(e)
+	spanCtx, record := base.start(ctx, "Writer", path, attribute.Bool("storage.append", append))
+	writer, e := base.StorageDriver.Writer(spanCtx, path, append)
+	record(e)
+	if e != nil {
+		return nil, base.setDriverName(e)
+	}
+	// commits are recorded as siblings of the span of opening the writer
+	return &fileWriter{FileWriter: writer, ctx: ctx, base: base, path: path}, nil
 }
 
 // Stat wraps Stat of underlying storage driver.
@@ -157,9 +206,9 @@ func (base *Base) Stat(ctx context.Context, path string) (storagedriver.FileInfo
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
-	start := time.Now()
+	ctx, record := base.start(ctx, "Stat", path)
 	fi, e := base.StorageDriver.Stat(ctx, path)
-	storageAction.WithValues(base.Name(), "Stat").UpdateSince(start)
+	record(e)
 	return fi, base.setDriverName(e)
 }
 
@@ -172,9 +221,9 @@ func (base *Base) List(ctx context.Context, path string) ([]string, error) {
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
-	start := time.Now()
+	ctx, record := base.start(ctx, "List", path)
 	str, e := base.StorageDriver.List(ctx, path)
-	storageAction.WithValues(base.Name(), "List").UpdateSince(start)
+	record(e)
 	return str, base.setDriverName(e)
 }
 
@@ -189,10 +238,10 @@ func (base *Base) Move(ctx context.Context, sourcePath string, destPath string)
 		return storagedriver.InvalidPathError{Path: destPath, DriverName: base.StorageDriver.Name()}
 	}
 
-	start := time.Now()
-	err := base.setDriverName(base.StorageDriver.Move(ctx, sourcePath, destPath))
-	storageAction.WithValues(base.Name(), "Move").UpdateSince(start)
-	return err
+	ctx, record := base.start(ctx, "Move", sourcePath, attribute.String("storage.dest_path", destPath))
+	err := base.StorageDriver.Move(ctx, sourcePath, destPath)
+	record(err)
+	return base.setDriverName(err)
 }
 
 // Delete wraps Delete of underlying storage driver.
@@ -204,10 +253,10 @@ func (base *Base) Delete(ctx context.Context, path string) error {
 		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
-	start := time.Now()
-	err := base.setDriverName(base.StorageDriver.Delete(ctx, path))
-	storageAction.WithValues(base.Name(), "Delete").UpdateSince(start)
-	return err
+	ctx, record := base.start(ctx, "Delete", path)
+	err := base.StorageDriver.Delete(ctx, path)
+	record(err)
+	return base.setDriverName(err)
 }
 
 // URLFor wraps URLFor of underlying storage driver.
@@ -219,9 +268,9 @@ func (base *Base) URLFor(ctx context.Context, path string, options map[string]in
 		return "", storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
-	start := time.Now()
+	ctx, record := base.start(ctx, "URLFor", path)
 	str, e := base.StorageDriver.URLFor(ctx, path, options)
-	storageAction.WithValues(base.Name(), "URLFor").UpdateSince(start)
+	record(e)
 	return str, base.setDriverName(e)
 }
 
@@ -234,5 +283,25 @@ func (base *Base) Walk(ctx context.Context, path string, f storagedriver.WalkFn,
 		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
-	return base.setDriverName(base.StorageDriver.Walk(ctx, path, f, options...))
+	// the walk is recorded as a whole, including the time spent in f
+	ctx, record := base.start(ctx, "Walk", path)
+	err := base.StorageDriver.Walk(ctx, path, f, options...)
+	record(err)
+	return base.setDriverName(err)
+}
+
+// fileWriter records the commits of a FileWriter.
+type fileWriter struct {
+	storagedriver.FileWriter
+	ctx  context.Context
+	base *Base
+	path string
+}
+
+// Commit wraps Commit of the underlying FileWriter.
+func (w *fileWriter) Commit() error {
+	_, record := w.base.start(w.ctx, "Writer.Commit", w.path, attribute.Int64("storage.size", w.Size()))
+	err := w.FileWriter.Commit()
+	record(err)
+	return err
 }
@@ -0,0 +1,114 @@
+package base_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recordedSpan is a span recorded by recordingTracer.
+type recordedSpan struct {
+	trace.Span
+	name   string
+	parent trace.SpanContext
+	status codes.Code
+	ended  bool
+}
+
+func (s *recordedSpan) SetStatus(code codes.Code, description string) { s.status = code }
+func (s *recordedSpan) End(options ...trace.SpanEndOption)            { s.ended = true }
+
+// recordingTracer records the spans it starts.
+type recordingTracer struct {
+	mu    sync.Mutex
+	spans []*recordedSpan
+}
+
+func (t *recordingTracer) Tracer(name string, options ...trace.TracerOption) trace.Tracer {
+	return t
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &recordedSpan{
+		Span: trace.SpanFromContext(ctx),
+		name: name,
+		// the spans of the test are told apart by their parent
+		parent: trace.SpanContextFromContext(ctx),
+	}
+	t.spans = append(t.spans, span)
+	return ctx, span
+}
+
+func TestSpans(t *testing.T) {
+	tracer := &recordingTracer{}
+	otel.SetTracerProvider(tracer)
+	d := inmemory.New()
+
+	parent := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+	})
+	ctx := trace.ContextWithRemoteSpanContext(context.Background(), parent)
+
+	if err := d.PutContent(ctx, "/a", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, "/a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Stat(ctx, "/missing"); !errors.As(err, &storagedriver.PathNotFoundError{}) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := d.PutContent(ctx, "/a/b", []byte("content")); err == nil {
+		t.Fatal("expected error writing beneath a file")
+	}
+	// invalid arguments are rejected before calling the storage backend
+	if _, err := d.Reader(ctx, "/a", -1); err == nil {
+		t.Fatal("expected error reading at a negative offset")
+	}
+	fw, err := d.Writer(ctx, "/b", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	fw.Close()
+
+	expected := []struct {
+		name   string
+		status codes.Code
+	}{
+		{"storage.PutContent", codes.Unset},
+		{"storage.Stat", codes.Unset},
+		{"storage.Stat", codes.Unset}, // missing paths are not errors
+		{"storage.PutContent", codes.Error},
+		{"storage.Writer", codes.Unset},
+		{"storage.Writer.Commit", codes.Unset},
+	}
+	if len(tracer.spans) != len(expected) {
+		t.Fatalf("expected %d spans, got %d", len(expected), len(tracer.spans))
+	}
+	for i, e := range expected {
+		span := tracer.spans[i]
+		if span.name != e.name || span.status != e.status || !span.ended {
+			t.Errorf("unexpected span %d: %s, status %v, ended %v", i, span.name, span.status, span.ended)
+		}
+		if span.parent.TraceID() != parent.TraceID() || span.parent.SpanID() != parent.SpanID() {
+			t.Errorf("expected span %s to be a child of the request span", span.name)
+		}
+	}
+}
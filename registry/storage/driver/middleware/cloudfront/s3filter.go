@@ -222,3 +222,39 @@ func eligibleForS3(ctx context.Context, awsIPs *awsIPs) bool {
 	}
 	return false
 }
+
+// AWSIPRanges are the networks of AWS, or of some of its regions, kept up to
+// date from the IP ranges published by AWS. It allows other middlewares to
+// tell the clients within AWS apart as this one does.
+type AWSIPRanges struct {
+	ips *awsIPs
+}
+
+// NewAWSIPRanges returns the networks of the AWS regions, or of all of AWS if
+// regions is empty, fetched from ipRangesURL and updated every
+// updateFrequency. The defaults are used for an empty ipRangesURL and a zero
+// updateFrequency.
+func NewAWSIPRanges(ipRangesURL string, updateFrequency time.Duration, regions []string) *AWSIPRanges {
+	if ipRangesURL == "" {
+		ipRangesURL = defaultIPRangesURL
+	}
+	if updateFrequency == 0 {
+		updateFrequency = defaultUpdateFrequency
+	}
+	var awsRegion []string
+	for _, region := range regions {
+		awsRegion = append(awsRegion, strings.ToLower(strings.TrimSpace(region)))
+	}
+	return &AWSIPRanges{ips: newAWSIPs(ipRangesURL, updateFrequency, awsRegion)}
+}
+
+// Contains returns true if ip belongs to the networks. It returns false until
+// the networks are fetched.
+func (r *AWSIPRanges) Contains(ip net.IP) bool {
+	return r.ips.contains(ip)
+}
+
+// ClientIP returns the IP address of the client of the request in ctx.
+func ClientIP(ctx context.Context) (net.IP, error) {
+	return parseIPFromRequest(ctx)
+}
@@ -0,0 +1,513 @@
+// Package middleware - diskcache wrapper for storage drivers. Content read
+// from immutable paths, such as blob data and revision links, is cached on
+// local disk, so that popular content is not fetched from the storage backend
+// over and over.
+package middleware
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/docker/go-metrics"
+)
+
+// defaultMaxSize is the default size limit of the cache, 10GiB.
+const defaultMaxSize = 10 << 30
+
+// tmpDir is the directory of the cache in which objects are downloaded
+// before they are added to the cache.
+const tmpDir = ".tmp"
+
+var (
+	// blobDataPath and revisionLinkPath match the paths whose content never
+	// changes once written: blob data, which is content addressed, and the
+	// links of manifest revisions and layers, which hold the digest they are
+	// named after. These paths can still be deleted.
+	blobDataPath     = regexp.MustCompile(`^/docker/registry/v2/blobs/[^/]+/[^/]+/[^/]+/data$`)
+	revisionLinkPath = regexp.MustCompile(`^/docker/registry/v2/repositories/.+/_(manifests/revisions|layers)/[^/]+/[^/]+/link$`)
+
+	cacheRequests     = prometheus.StorageNamespace.NewLabeledCounter("diskcache_requests", "The number of disk cache requests by result", "result")
+	cacheEvictions    = prometheus.StorageNamespace.NewCounter("diskcache_evictions", "The number of objects evicted from the disk cache")
+	cacheEvictedBytes = prometheus.StorageNamespace.NewCounter("diskcache_evicted_bytes", "The number of bytes evicted from the disk cache")
+	cacheSize         = prometheus.StorageNamespace.NewGauge("diskcache_size", "The size of the objects in the disk cache", metrics.Bytes)
+
+	// errNotCached is returned when an object cannot be cached and must be
+	// read from the storage backend.
+	errNotCached = errors.New("object not cached")
+)
+
+// cacheable returns true if the content at path never changes and is to be
+// cached.
+func (d *diskCacheStorageMiddleware) cacheable(path string) bool {
+	return blobDataPath.MatchString(path) || (d.cacheLinks && revisionLinkPath.MatchString(path))
+}
+
+// affectsCache returns true if path is, or is a directory which may contain,
+// a path the cache holds. Writes to other paths, such as uploads and tags,
+// leave the cache alone. Repository names have no components starting with
+// an underscore, so the first such component tells which part of a
+// repository path is in.
+func affectsCache(path string) bool {
+	if blobDataPath.MatchString(path) || revisionLinkPath.MatchString(path) {
+		return true
+	}
+	components := strings.Split(strings.Trim(path, "/"), "/")
+	for i, component := range components {
+		if !strings.HasPrefix(component, "_") {
+			continue
+		}
+		switch component {
+		case "_layers":
+			return true
+		case "_manifests":
+			return i+1 == len(components) || components[i+1] == "revisions"
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// revalidate checks that a cached link still exists in the storage backend,
+// as it may have been deleted through another registry instance or by the
+// garbage collector, and invalidates it if it does not. Blob data is served
+// from the cache without checking.
+func (d *diskCacheStorageMiddleware) revalidate(ctx context.Context, path string) error {
+	if !revisionLinkPath.MatchString(path) {
+		return nil
+	}
+	if _, err := d.StorageDriver.Stat(ctx, path); err != nil {
+		d.cache.invalidate(path)
+		return err
+	}
+	return nil
+}
+
+// diskCacheStorageMiddleware serves reads of immutable paths from the disk
+// cache, filling it from the wrapped driver on misses. All other calls are
+// passed through, and writes, moves and deletes invalidate the paths they
+// affect.
+type diskCacheStorageMiddleware struct {
+	storagedriver.StorageDriver
+	cache      *diskCache
+	cacheLinks bool
+}
+
+var _ storagedriver.StorageDriver = &diskCacheStorageMiddleware{}
+
+// newDiskCacheStorageMiddleware constructs and returns a new disk cache
+// storage middleware.
+//
+// Required options:
+//
+//   - directory: the local directory holding the cache
+//
+// Optional options:
+//
+//   - maxsize: the size limit of the cache in bytes, 10GiB by default
+//   - cachelinks: whether to cache revision links besides blob data, false
+//     by default
+func newDiskCacheStorageMiddleware(storageDriver storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	d, ok := options["directory"]
+	if !ok {
+		return nil, fmt.Errorf("no directory provided")
+	}
+	directory, ok := d.(string)
+	if !ok || directory == "" {
+		return nil, fmt.Errorf("directory must be a non-empty string")
+	}
+
+	maxSize := int64(defaultMaxSize)
+	if m, ok := options["maxsize"]; ok {
+		switch m := m.(type) {
+		case int:
+			maxSize = int64(m)
+		case int64:
+			maxSize = m
+		case string:
+			var err error
+			maxSize, err = strconv.ParseInt(m, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("maxsize must be an integer: %v", err)
+			}
+		default:
+			return nil, fmt.Errorf("maxsize must be an integer")
+		}
+		if maxSize <= 0 {
+			return nil, fmt.Errorf("maxsize must be positive")
+		}
+	}
+
+	cacheLinks := false
+	if c, ok := options["cachelinks"]; ok {
+		switch c := c.(type) {
+		case bool:
+			cacheLinks = c
+		case string:
+			var err error
+			cacheLinks, err = strconv.ParseBool(c)
+			if err != nil {
+				return nil, fmt.Errorf("cachelinks must be a boolean: %v", err)
+			}
+		default:
+			return nil, fmt.Errorf("cachelinks must be a boolean")
+		}
+	}
+
+	cache, err := newDiskCache(directory, maxSize)
+	if err != nil {
+		return nil, err
+	}
+	return &diskCacheStorageMiddleware{StorageDriver: storageDriver, cache: cache, cacheLinks: cacheLinks}, nil
+}
+
+// GetContent returns the content at path, from the cache if it is immutable.
+func (d *diskCacheStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if !d.cacheable(path) {
+		return d.StorageDriver.GetContent(ctx, path)
+	}
+	if err := d.revalidate(ctx, path); err != nil {
+		return nil, err
+	}
+	f, _, err := d.cache.open(ctx, path, d.StorageDriver)
+	if err != nil {
+		return d.StorageDriver.GetContent(ctx, path)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// Reader returns a reader of the content at path from offset, from the
+// cache if it is immutable.
+func (d *diskCacheStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if !d.cacheable(path) {
+		return d.StorageDriver.Reader(ctx, path, offset)
+	}
+	if err := d.revalidate(ctx, path); err != nil {
+		return nil, err
+	}
+	f, size, err := d.cache.open(ctx, path, d.StorageDriver)
+	if err != nil {
+		return d.StorageDriver.Reader(ctx, path, offset)
+	}
+	if offset < 0 || offset > size {
+		f.Close()
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: d.Name()}
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// PutContent stores content at path and invalidates its cached content.
+func (d *diskCacheStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	d.invalidate(path)
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+// Writer returns a FileWriter for path and invalidates its cached content.
+func (d *diskCacheStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	d.invalidate(path)
+	return d.StorageDriver.Writer(ctx, path, append)
+}
+
+// Move moves the object at sourcePath to destPath and invalidates the
+// cached content of both.
+func (d *diskCacheStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	err := d.StorageDriver.Move(ctx, sourcePath, destPath)
+	d.invalidate(sourcePath)
+	d.invalidate(destPath)
+	return err
+}
+
+// Delete deletes the objects at and below path and invalidates their cached
+// content.
+func (d *diskCacheStorageMiddleware) Delete(ctx context.Context, path string) error {
+	err := d.StorageDriver.Delete(ctx, path)
+	d.invalidate(path)
+	return err
+}
+
+// invalidate invalidates the cached content at and below path, unless path
+// cannot hold cached content.
+func (d *diskCacheStorageMiddleware) invalidate(path string) {
+	if affectsCache(path) {
+		d.cache.invalidate(path)
+	}
+}
+
+// diskCache keeps the content of storage paths in files below its root,
+// at the same paths, evicting the least recently used files when its size
+// exceeds maxSize.
+type diskCache struct {
+	root    string
+	maxSize int64
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // of *cacheEntry, most recently used first
+	size    int64
+	fetches map[string]*fetch
+}
+
+type cacheEntry struct {
+	path string
+	size int64
+}
+
+// fetch is a download of a path into the cache, which concurrent readers of
+// the path wait for rather than downloading it themselves.
+type fetch struct {
+	done chan struct{}
+	err  error
+
+	// invalidated is set, with the cache locked, when the path is
+	// invalidated during the download, which is then discarded.
+	invalidated bool
+}
+
+// newDiskCache creates a cache in root, indexing the files left by a
+// previous run in the order they were last used.
+func newDiskCache(root string, maxSize int64) (*diskCache, error) {
+	c := &diskCache{
+		root:    root,
+		maxSize: maxSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		fetches: make(map[string]*fetch),
+	}
+
+	if err := os.RemoveAll(filepath.Join(root, tmpDir)); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(root, tmpDir), 0o755); err != nil {
+		return nil, err
+	}
+
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	err := filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if p == filepath.Join(root, tmpDir) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, file{path: "/" + filepath.ToSlash(rel), size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+	for _, f := range files {
+		c.entries[f.path] = c.lru.PushFront(&cacheEntry{path: f.path, size: f.size})
+		c.size += f.size
+	}
+	cacheSize.Set(float64(c.size))
+	c.mu.Lock()
+	c.evict(0)
+	c.mu.Unlock()
+
+	return c, nil
+}
+
+func (c *diskCache) file(path string) string {
+	return filepath.Join(c.root, filepath.FromSlash(path))
+}
+
+// open returns the cached file of path and its size, downloading it from
+// driver first if it is not cached. Concurrent downloads of the same path
+// are shared. errNotCached is returned if the object cannot be cached.
+func (c *diskCache) open(ctx context.Context, path string, driver storagedriver.StorageDriver) (*os.File, int64, error) {
+	for {
+		c.mu.Lock()
+		if element, ok := c.entries[path]; ok {
+			entry := element.Value.(*cacheEntry)
+			f, err := os.Open(c.file(path))
+			if err == nil {
+				c.lru.MoveToFront(element)
+				c.mu.Unlock()
+				cacheRequests.WithValues("hit").Inc(1)
+				now := time.Now()
+				os.Chtimes(f.Name(), now, now)
+				return f, entry.size, nil
+			}
+			// The file has been removed behind our back.
+			c.remove(element)
+		}
+
+		ft, ok := c.fetches[path]
+		if !ok {
+			ft = &fetch{done: make(chan struct{})}
+			c.fetches[path] = ft
+			c.mu.Unlock()
+
+			cacheRequests.WithValues("miss").Inc(1)
+			// The download is shared, so it must not be cancelled with the
+			// request which started it.
+			fetchCtx := dcontext.WithLogger(context.Background(), dcontext.GetLogger(ctx))
+			ft.err = c.fill(fetchCtx, path, driver, ft)
+			if ft.err != nil && ft.err != errNotCached {
+				dcontext.GetLogger(ctx).Warnf("diskcache: error caching %s: %v", path, ft.err)
+			}
+
+			c.mu.Lock()
+			delete(c.fetches, path)
+			c.mu.Unlock()
+			close(ft.done)
+		} else {
+			c.mu.Unlock()
+		}
+
+		select {
+		case <-ft.done:
+		case <-ctx.Done():
+			return nil, 0, ctx.Err()
+		}
+		if ft.err != nil {
+			return nil, 0, errNotCached
+		}
+	}
+}
+
+// fill downloads path from driver into the cache for ft. The download is
+// discarded if the path is invalidated meanwhile.
+func (c *diskCache) fill(ctx context.Context, path string, driver storagedriver.StorageDriver, ft *fetch) error {
+	fi, err := driver.Stat(ctx, path)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() || fi.Size() > c.maxSize {
+		return errNotCached
+	}
+
+	tmp, err := os.CreateTemp(filepath.Join(c.root, tmpDir), "fetch-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	rc, err := driver.Reader(ctx, path, 0)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	size, err := io.Copy(tmp, rc)
+	rc.Close()
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if size > c.maxSize {
+		return errNotCached
+	}
+
+	file := c.file(path)
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if ft.invalidated {
+		return errNotCached
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return err
+	}
+	c.evict(size)
+	c.entries[path] = c.lru.PushFront(&cacheEntry{path: path, size: size})
+	c.size += size
+	cacheSize.Set(float64(c.size))
+	return nil
+}
+
+// evict removes the least recently used files until n more bytes fit in the
+// cache. It must be called with mu held.
+func (c *diskCache) evict(n int64) {
+	for c.size+n > c.maxSize && c.lru.Len() > 0 {
+		element := c.lru.Back()
+		entry := element.Value.(*cacheEntry)
+		c.remove(element)
+		cacheEvictions.Inc(1)
+		cacheEvictedBytes.Inc(float64(entry.size))
+	}
+}
+
+// remove removes the file of an entry from the cache. It must be called
+// with mu held.
+func (c *diskCache) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	os.Remove(c.file(entry.path))
+	c.lru.Remove(element)
+	delete(c.entries, entry.path)
+	c.size -= entry.size
+	cacheSize.Set(float64(c.size))
+}
+
+// invalidate removes path and everything below it from the cache, and
+// discards the downloads of these paths in progress.
+func (c *diskCache) invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	for p, ft := range c.fetches {
+		if p == path || strings.HasPrefix(p, prefix) {
+			ft.invalidated = true
+		}
+	}
+	if element, ok := c.entries[path]; ok {
+		c.remove(element)
+	}
+	if blobDataPath.MatchString(path) || revisionLinkPath.MatchString(path) {
+		// the cached paths are files, with nothing below them
+		return
+	}
+	for p, element := range c.entries {
+		if strings.HasPrefix(p, prefix) {
+			c.remove(element)
+		}
+	}
+}
+
+func init() {
+	storagemiddleware.Register("diskcache", newDiskCacheStorageMiddleware)
+}
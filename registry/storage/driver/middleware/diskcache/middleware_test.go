@@ -0,0 +1,330 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+const (
+	blobPath = "/docker/registry/v2/blobs/sha256/ab/abcdef/data"
+	linkPath = "/docker/registry/v2/repositories/foo/bar/_layers/sha256/abcdef/link"
+	tagPath  = "/docker/registry/v2/repositories/foo/bar/_manifests/tags/latest/current/link"
+)
+
+// countingDriver counts the reads reaching the in-memory driver, and blocks
+// them until release is closed, if it is set.
+type countingDriver struct {
+	*inmemory.Driver
+	reads   int32
+	release chan struct{}
+}
+
+func (d *countingDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	atomic.AddInt32(&d.reads, 1)
+	if d.release != nil {
+		<-d.release
+	}
+	return d.Driver.Reader(ctx, path, offset)
+}
+
+func (d *countingDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	atomic.AddInt32(&d.reads, 1)
+	return d.Driver.GetContent(ctx, path)
+}
+
+func newTestMiddleware(t *testing.T, backend storagedriver.StorageDriver, dir string, maxSize int) *diskCacheStorageMiddleware {
+	t.Helper()
+	d, err := newDiskCacheStorageMiddleware(backend, map[string]interface{}{"directory": dir, "maxsize": maxSize, "cachelinks": true})
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+	return d.(*diskCacheStorageMiddleware)
+}
+
+func readAll(t *testing.T, d storagedriver.StorageDriver, path string, offset int64) []byte {
+	t.Helper()
+	rc, err := d.Reader(context.Background(), path, offset)
+	if err != nil {
+		t.Fatalf("unexpected error reading %s: %v", path, err)
+	}
+	defer rc.Close()
+	content, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("unexpected error reading %s: %v", path, err)
+	}
+	return content
+}
+
+func TestNoConfig(t *testing.T) {
+	if _, err := newDiskCacheStorageMiddleware(inmemory.New(), map[string]interface{}{}); err == nil || err.Error() != "no directory provided" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := newDiskCacheStorageMiddleware(inmemory.New(), map[string]interface{}{"directory": t.TempDir(), "maxsize": "lots"}); err == nil {
+		t.Fatal("expected error for invalid maxsize")
+	}
+}
+
+func TestReadThrough(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingDriver{Driver: inmemory.New()}
+	d := newTestMiddleware(t, backend, t.TempDir(), 1<<20)
+
+	blob := []byte("popular base layer")
+	for path, content := range map[string][]byte{blobPath: blob, linkPath: []byte("sha256:abcdef"), tagPath: []byte("sha256:abcdef")} {
+		if err := backend.PutContent(ctx, path, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		if got := readAll(t, d, blobPath, 0); !bytes.Equal(got, blob) {
+			t.Fatalf("unexpected content %q", got)
+		}
+		if got := readAll(t, d, blobPath, 8); !bytes.Equal(got, blob[8:]) {
+			t.Fatalf("unexpected content at offset %q", got)
+		}
+		if _, err := d.GetContent(ctx, linkPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reads := atomic.LoadInt32(&backend.reads); reads != 2 {
+		t.Fatalf("expected immutable paths to be read from the backend once, got %d reads", reads)
+	}
+
+	// links are not cached by default
+	noLinks, err := newDiskCacheStorageMiddleware(backend, map[string]interface{}{"directory": t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := noLinks.GetContent(ctx, linkPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := noLinks.GetContent(ctx, linkPath); err != nil {
+		t.Fatal(err)
+	}
+	if reads := atomic.LoadInt32(&backend.reads); reads != 4 {
+		t.Fatalf("expected links to be passed through, got %d reads", reads)
+	}
+
+	// mutable paths are always read from the backend
+	for i := 0; i < 3; i++ {
+		if _, err := d.GetContent(ctx, tagPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reads := atomic.LoadInt32(&backend.reads); reads != 7 {
+		t.Fatalf("expected mutable paths to be passed through, got %d reads", reads)
+	}
+
+	if _, err := d.Reader(ctx, blobPath, int64(len(blob)+1)); err == nil {
+		t.Fatal("expected error reading past the end")
+	}
+
+	// a link deleted in the backend, by another instance, is not served
+	if err := backend.Delete(ctx, linkPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.GetContent(ctx, linkPath); err == nil {
+		t.Fatal("expected link deleted in the backend to be unreadable")
+	}
+	if _, ok := d.cache.entries[linkPath]; ok {
+		t.Fatal("expected link deleted in the backend to be invalidated")
+	}
+
+	// deleting the blob invalidates it
+	if err := d.Delete(ctx, "/docker/registry/v2/blobs/sha256/ab/abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Reader(ctx, blobPath, 0); err == nil {
+		t.Fatal("expected deleted blob to be unreadable")
+	}
+}
+
+func TestEviction(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingDriver{Driver: inmemory.New()}
+	dir := t.TempDir()
+	d := newTestMiddleware(t, backend, dir, 25)
+
+	paths := []string{
+		"/docker/registry/v2/blobs/sha256/aa/aa/data",
+		"/docker/registry/v2/blobs/sha256/bb/bb/data",
+		"/docker/registry/v2/blobs/sha256/cc/cc/data",
+	}
+	for _, path := range paths {
+		if err := backend.PutContent(ctx, path, bytes.Repeat([]byte("x"), 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// objects larger than the cache are passed through
+	large := "/docker/registry/v2/blobs/sha256/dd/dd/data"
+	if err := backend.PutContent(ctx, large, bytes.Repeat([]byte("x"), 30)); err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, d, large, 0)
+	if _, ok := d.cache.entries[large]; ok {
+		t.Fatal("expected object larger than the cache not to be cached")
+	}
+
+	readAll(t, d, paths[0], 0)
+	readAll(t, d, paths[1], 0)
+	readAll(t, d, paths[0], 0)
+	// caching the third object evicts the least recently used one
+	readAll(t, d, paths[2], 0)
+
+	if _, ok := d.cache.entries[paths[1]]; ok {
+		t.Fatalf("expected %s to be evicted", paths[1])
+	}
+	for _, path := range []string{paths[0], paths[2]} {
+		if _, ok := d.cache.entries[path]; !ok {
+			t.Fatalf("expected %s to be cached", path)
+		}
+	}
+	if d.cache.size != 20 {
+		t.Fatalf("unexpected cache size %d", d.cache.size)
+	}
+
+	// the cache is restored from disk on restart
+	d = newTestMiddleware(t, backend, dir, 25)
+	if d.cache.size != 20 || len(d.cache.entries) != 2 {
+		t.Fatalf("unexpected restored cache: %d bytes, %d entries", d.cache.size, len(d.cache.entries))
+	}
+	reads := atomic.LoadInt32(&backend.reads)
+	readAll(t, d, paths[2], 0)
+	if atomic.LoadInt32(&backend.reads) != reads {
+		t.Fatal("expected restored object to be read from the cache")
+	}
+}
+
+func TestConcurrentFetches(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingDriver{Driver: inmemory.New(), release: make(chan struct{})}
+	d := newTestMiddleware(t, backend, t.TempDir(), 1<<20)
+
+	blob := []byte("popular base layer")
+	if err := backend.PutContent(ctx, blobPath, blob); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	results := make([][]byte, 10)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rc, err := d.Reader(ctx, blobPath, 0)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer rc.Close()
+			results[i], _ = io.ReadAll(rc)
+		}(i)
+	}
+	// wait for the download to start before letting it finish
+	for atomic.LoadInt32(&backend.reads) == 0 {
+		runtime.Gosched()
+	}
+	close(backend.release)
+	wg.Wait()
+
+	for _, result := range results {
+		if !bytes.Equal(result, blob) {
+			t.Fatalf("unexpected content %q", result)
+		}
+	}
+	if reads := atomic.LoadInt32(&backend.reads); reads != 1 {
+		t.Fatalf("expected concurrent reads to share one download, got %d", reads)
+	}
+}
+
+func TestInvalidationDuringFill(t *testing.T) {
+	ctx := context.Background()
+	backend := &countingDriver{Driver: inmemory.New(), release: make(chan struct{})}
+	d := newTestMiddleware(t, backend, t.TempDir(), 1<<20)
+
+	blob := []byte("popular base layer")
+	if err := backend.PutContent(ctx, blobPath, blob); err != nil {
+		t.Fatal(err)
+	}
+
+	fill := func(writes ...string) {
+		t.Helper()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			readAll(t, d, blobPath, 0)
+		}()
+		reads := atomic.LoadInt32(&backend.reads)
+		for atomic.LoadInt32(&backend.reads) == reads {
+			runtime.Gosched()
+		}
+		for _, path := range writes {
+			if err := d.PutContent(ctx, path, []byte("content")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// release the fill, and the read from the backend if it is
+		// discarded
+		for {
+			select {
+			case backend.release <- struct{}{}:
+				continue
+			case <-done:
+			}
+			break
+		}
+	}
+
+	// writes to unrelated paths during a slow fill leave it alone
+	fill(
+		"/docker/registry/v2/repositories/foo/bar/_uploads/1234/data",
+		"/docker/registry/v2/repositories/foo/bar/_uploads/1234/startedat",
+		"/docker/registry/v2/repositories/foo/bar/_uploads/1234/hashstates/sha256/0",
+		tagPath,
+		"/docker/registry/v2/blobs/sha256/cd/cdef/data",
+	)
+	if _, ok := d.cache.entries[blobPath]; !ok {
+		t.Fatal("expected the blob to be cached despite unrelated writes")
+	}
+
+	// writing the path itself during a fill discards it
+	if err := d.Delete(ctx, blobPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.PutContent(ctx, blobPath, blob); err != nil {
+		t.Fatal(err)
+	}
+	fill(blobPath)
+	if _, ok := d.cache.entries[blobPath]; ok {
+		t.Fatal("expected the fill of an invalidated blob to be discarded")
+	}
+}
+
+func TestAffectsCache(t *testing.T) {
+	for path, expected := range map[string]bool{
+		blobPath:                    true,
+		linkPath:                    true,
+		"/docker/registry/v2/blobs": true,
+		"/docker/registry/v2/repositories/foo/bar":                                true,
+		"/docker/registry/v2/repositories/foo/bar/_manifests":                     true,
+		"/docker/registry/v2/repositories/foo/bar/_manifests/revisions/sha256/ab": true,
+		"/docker/registry/v2/repositories/foo/bar/_layers/sha256":                 true,
+		tagPath: false,
+		"/docker/registry/v2/repositories/foo/bar/_manifests/tags/latest":            false,
+		"/docker/registry/v2/repositories/foo/bar/_uploads/1234":                     false,
+		"/docker/registry/v2/repositories/foo/bar/_uploads/1234/hashstates/sha256/0": false,
+	} {
+		if affectsCache(path) != expected {
+			t.Errorf("unexpected result for %s: %v", path, !expected)
+		}
+	}
+}
@@ -0,0 +1,746 @@
+// Package middleware - encrypt wrapper for storage drivers. Content written
+// through the driver is encrypted before it reaches the storage backend, so
+// that it cannot be read by anyone with access to the backend alone.
+//
+// Every object is encrypted with its own random data key using AES-256-GCM.
+// The data key is wrapped with a key-encryption key, read from a local
+// keyfile, and stored in the header of the object. The content follows the
+// header in chunks, each sealed separately, so that reads at an offset only
+// need to decrypt the chunks they cover. As in the STREAM construction, the
+// nonce of each chunk holds its index and whether it is the last one, so that
+// chunks cannot be reordered and objects cannot be truncated unnoticed. The
+// last chunk is always shorter than the others, and may be empty.
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+const (
+	// magic identifies encrypted objects. Objects without it were written
+	// before encryption was enabled and are passed through unchanged.
+	magic = "DENC"
+
+	// formatVersion is the version of the layout of encrypted objects.
+	formatVersion = 1
+
+	keySize   = 32
+	nonceSize = 12
+	tagSize   = 16
+	keyIDSize = 8
+
+	// headerSize is the size of the header of encrypted objects: the magic,
+	// the format version, the chunk size, the id of the key-encryption key,
+	// and the wrapped data key with its nonce.
+	headerSize = len(magic) + 1 + 4 + keyIDSize + nonceSize + keySize + tagSize
+
+	// defaultChunkSize is the size of the plaintext of each chunk.
+	defaultChunkSize = 64 << 10
+
+	// partialSuffix is appended to the path of an object being written to
+	// name the object holding its last, incomplete chunk between sessions.
+	partialSuffix = ".partial"
+)
+
+var errCorrupt = errors.New("encrypted object is corrupt")
+
+// keyEncryptionKey wraps and unwraps data keys.
+type keyEncryptionKey struct {
+	id   [keyIDSize]byte
+	aead cipher.AEAD
+}
+
+func newKeyEncryptionKey(key []byte) (*keyEncryptionKey, error) {
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", keySize, len(key))
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	kek := &keyEncryptionKey{aead: aead}
+	sum := sha256.Sum256(key)
+	copy(kek.id[:], sum[:])
+	return kek, nil
+}
+
+// readKeyFile reads a base64 encoded 256 bit key, as generated by
+// `openssl rand -base64 32`.
+func readKeyFile(path string) (*keyEncryptionKey, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyfile %s: %v", path, err)
+	}
+	kek, err := newKeyEncryptionKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid keyfile %s: %v", path, err)
+	}
+	return kek, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptStorageMiddleware encrypts the content written through the wrapped
+// driver and decrypts the content read through it.
+type encryptStorageMiddleware struct {
+	storagedriver.StorageDriver
+	kek       *keyEncryptionKey
+	keks      map[[keyIDSize]byte]*keyEncryptionKey
+	chunkSize int
+}
+
+var _ storagedriver.StorageDriver = &encryptStorageMiddleware{}
+
+// newEncryptStorageMiddleware constructs and returns a new encrypting
+// storage middleware.
+//
+// Required options:
+//
+//   - keyfile: path to the base64 encoded 256 bit key-encryption key
+//
+// Optional options:
+//
+//   - previouskeyfiles: keyfiles of former key-encryption keys, still used to
+//     read the objects written with them
+//   - chunksize: the size in bytes of the plaintext of each encrypted chunk,
+//     64KiB by default
+func newEncryptStorageMiddleware(storageDriver storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	k, ok := options["keyfile"]
+	if !ok {
+		return nil, fmt.Errorf("no keyfile provided")
+	}
+	keyfile, ok := k.(string)
+	if !ok {
+		return nil, fmt.Errorf("keyfile must be a string")
+	}
+	kek, err := readKeyFile(keyfile)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &encryptStorageMiddleware{
+		StorageDriver: storageDriver,
+		kek:           kek,
+		keks:          map[[keyIDSize]byte]*keyEncryptionKey{kek.id: kek},
+		chunkSize:     defaultChunkSize,
+	}
+
+	if p, ok := options["previouskeyfiles"]; ok {
+		previous, ok := p.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("previouskeyfiles must be a list of strings")
+		}
+		for _, p := range previous {
+			keyfile, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("previouskeyfiles must be a list of strings")
+			}
+			kek, err := readKeyFile(keyfile)
+			if err != nil {
+				return nil, err
+			}
+			d.keks[kek.id] = kek
+		}
+	}
+
+	if c, ok := options["chunksize"]; ok {
+		var chunkSize int
+		switch c := c.(type) {
+		case int:
+			chunkSize = c
+		case string:
+			chunkSize, err = strconv.Atoi(c)
+			if err != nil {
+				return nil, fmt.Errorf("chunksize must be an integer: %v", err)
+			}
+		default:
+			return nil, fmt.Errorf("chunksize must be an integer")
+		}
+		if chunkSize <= 0 {
+			return nil, fmt.Errorf("chunksize must be positive")
+		}
+		d.chunkSize = chunkSize
+	}
+
+	return d, nil
+}
+
+// objectKey holds the data key of an encrypted object.
+type objectKey struct {
+	aead      cipher.AEAD
+	chunkSize int
+}
+
+// chunkNonce returns the nonce of the chunk at index, marked if it is the
+// last chunk of the object. Data keys are unique to their object, so the
+// index is enough to keep nonces unique.
+func chunkNonce(index int64, last bool) []byte {
+	nonce := make([]byte, nonceSize)
+	if last {
+		nonce[3] = 1
+	}
+	binary.BigEndian.PutUint64(nonce[4:], uint64(index))
+	return nonce
+}
+
+// ciphertextOffset returns the offset in the stored object of the chunk at
+// index.
+func (k *objectKey) ciphertextOffset(index int64) int64 {
+	return int64(headerSize) + index*int64(k.chunkSize+tagSize)
+}
+
+// plaintextSize returns the size of the content of an encrypted object of
+// the given stored size.
+func (k *objectKey) plaintextSize(size int64) int64 {
+	n := size - int64(headerSize)
+	chunk := int64(k.chunkSize + tagSize)
+	plaintext := n / chunk * int64(k.chunkSize)
+	if rem := n % chunk; rem > tagSize {
+		plaintext += rem - tagSize
+	}
+	return plaintext
+}
+
+// newObjectKey generates a data key and returns it with the header of the
+// object it encrypts.
+func (d *encryptStorageMiddleware) newObjectKey() (*objectKey, []byte, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, formatVersion)
+	header = binary.BigEndian.AppendUint32(header, uint32(d.chunkSize))
+	header = append(header, d.kek.id[:]...)
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	// The preceding header fields are authenticated along with the data key.
+	aad := header
+	header = d.kek.aead.Seal(append(header, nonce...), nonce, key, aad)
+
+	return &objectKey{aead: aead, chunkSize: d.chunkSize}, header, nil
+}
+
+// isEncrypted returns true if header starts like the header of an encrypted
+// object.
+func isEncrypted(header []byte) bool {
+	return len(header) >= headerSize && string(header[:len(magic)]) == magic
+}
+
+// openObjectKey unwraps the data key from the header of an encrypted object.
+func (d *encryptStorageMiddleware) openObjectKey(header []byte) (*objectKey, error) {
+	if !isEncrypted(header) {
+		return nil, errCorrupt
+	}
+	pos := len(magic)
+	if header[pos] != formatVersion {
+		return nil, fmt.Errorf("unsupported encrypted object format version %d", header[pos])
+	}
+	pos++
+	chunkSize := int(binary.BigEndian.Uint32(header[pos:]))
+	pos += 4
+	var id [keyIDSize]byte
+	copy(id[:], header[pos:])
+	pos += keyIDSize
+
+	kek, ok := d.keks[id]
+	if !ok {
+		return nil, fmt.Errorf("encrypted object uses unknown key %x", id)
+	}
+	nonce := header[pos : pos+nonceSize]
+	key, err := kek.aead.Open(nil, nonce, header[pos+nonceSize:headerSize], header[:pos])
+	if err != nil {
+		return nil, errCorrupt
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &objectKey{aead: aead, chunkSize: chunkSize}, nil
+}
+
+// readHeader returns the header of the object at path, or nil if the object
+// is not encrypted.
+func (d *encryptStorageMiddleware) readHeader(ctx context.Context, path string) ([]byte, error) {
+	rc, err := d.StorageDriver.Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(rc, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if !isEncrypted(header) {
+		return nil, nil
+	}
+	return header, nil
+}
+
+func (d *encryptStorageMiddleware) encrypt(content []byte) ([]byte, error) {
+	key, header, err := d.newObjectKey()
+	if err != nil {
+		return nil, err
+	}
+	chunks := len(content)/key.chunkSize + 1
+	ciphertext := make([]byte, 0, len(header)+len(content)+chunks*tagSize)
+	ciphertext = append(ciphertext, header...)
+	index := int64(0)
+	for ; len(content) >= key.chunkSize; index++ {
+		ciphertext = key.aead.Seal(ciphertext, chunkNonce(index, false), content[:key.chunkSize], nil)
+		content = content[key.chunkSize:]
+	}
+	return key.aead.Seal(ciphertext, chunkNonce(index, true), content, nil), nil
+}
+
+func (d *encryptStorageMiddleware) decrypt(ciphertext []byte) ([]byte, error) {
+	key, err := d.openObjectKey(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext = ciphertext[headerSize:]
+	content := make([]byte, 0, key.plaintextSize(int64(headerSize+len(ciphertext))))
+	index := int64(0)
+	for ; len(ciphertext) >= key.chunkSize+tagSize; index++ {
+		content, err = key.aead.Open(content, chunkNonce(index, false), ciphertext[:key.chunkSize+tagSize], nil)
+		if err != nil {
+			return nil, errCorrupt
+		}
+		ciphertext = ciphertext[key.chunkSize+tagSize:]
+	}
+	// the remaining content is the last chunk, missing if the object was
+	// truncated
+	content, err = key.aead.Open(content, chunkNonce(index, true), ciphertext, nil)
+	if err != nil {
+		return nil, errCorrupt
+	}
+	return content, nil
+}
+
+// GetContent retrieves and decrypts the content stored at path.
+func (d *encryptStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	content, err := d.StorageDriver.GetContent(ctx, path)
+	if err != nil || !isEncrypted(content) {
+		return content, err
+	}
+	return d.decrypt(content)
+}
+
+// PutContent encrypts and stores content at path.
+func (d *encryptStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	ciphertext, err := d.encrypt(content)
+	if err != nil {
+		return err
+	}
+	return d.StorageDriver.PutContent(ctx, path, ciphertext)
+}
+
+// Reader decrypts the content stored at path, starting at offset. Only the
+// chunks from the one holding offset onwards are read.
+func (d *encryptStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: d.Name()}
+	}
+
+	header, err := d.readHeader(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return d.StorageDriver.Reader(ctx, path, offset)
+	}
+	key, err := d.openObjectKey(header)
+	if err != nil {
+		return nil, err
+	}
+
+	index := offset / int64(key.chunkSize)
+	rc, err := d.StorageDriver.Reader(ctx, path, key.ciphertextOffset(index))
+	if err != nil {
+		return nil, err
+	}
+	r := &chunkReader{
+		rc:    rc,
+		key:   key,
+		index: index,
+		chunk: make([]byte, 0, key.chunkSize+tagSize),
+	}
+	if err := r.skip(int(offset % int64(key.chunkSize))); err != nil {
+		rc.Close()
+		if err == io.EOF {
+			return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: d.Name()}
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// Writer returns a FileWriter which encrypts the content written to path.
+// Appending continues the encrypted object with the data key it was started
+// with.
+func (d *encryptStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	fw, err := d.StorageDriver.Writer(ctx, path, append)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &encryptWriter{
+		ctx:    ctx,
+		driver: d,
+		path:   path,
+		fw:     fw,
+	}
+	if !append || fw.Size() == 0 {
+		key, header, err := d.newObjectKey()
+		if err != nil {
+			fw.Cancel(ctx)
+			return nil, err
+		}
+		if _, err := fw.Write(header); err != nil {
+			fw.Cancel(ctx)
+			return nil, err
+		}
+		w.key = key
+		return w, nil
+	}
+
+	if err := w.resume(); err != nil {
+		fw.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+// Stat reports the size of the content of encrypted files rather than the
+// size of the stored object.
+func (d *encryptStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	fi, err := d.StorageDriver.Stat(ctx, path)
+	if err != nil || fi.IsDir() || fi.Size() < int64(headerSize) {
+		return fi, err
+	}
+
+	header, err := d.readHeader(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return fi, nil
+	}
+	key, err := d.openObjectKey(header)
+	if err != nil {
+		return nil, err
+	}
+	return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+		Path:    fi.Path(),
+		Size:    key.plaintextSize(fi.Size()),
+		ModTime: fi.ModTime(),
+		IsDir:   false,
+	}}, nil
+}
+
+// URLFor refuses to redirect to encrypted objects, which clients could not
+// read, so that their content is served through the registry.
+func (d *encryptStorageMiddleware) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	header, err := d.readHeader(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	if header != nil {
+		return "", storagedriver.ErrUnsupportedMethod{DriverName: d.Name()}
+	}
+	return d.StorageDriver.URLFor(ctx, path, options)
+}
+
+// chunkReader decrypts the chunks read from an encrypted object.
+type chunkReader struct {
+	rc    io.ReadCloser
+	key   *objectKey
+	index int64
+	chunk []byte
+	buf   []byte
+	last  bool // whether the last chunk was read
+	err   error
+}
+
+// next reads and decrypts the next chunk into buf. It returns io.EOF after
+// the last chunk, or if there is no chunk left to read.
+func (r *chunkReader) next() error {
+	if r.last {
+		return io.EOF
+	}
+	chunk := r.chunk[:r.key.chunkSize+tagSize]
+	n, err := io.ReadFull(r.rc, chunk)
+	switch {
+	case err == io.EOF:
+		return io.EOF
+	case err == io.ErrUnexpectedEOF:
+		// only the last chunk is shorter than the others
+		if n < tagSize {
+			return errCorrupt
+		}
+		r.last = true
+	case err != nil:
+		return err
+	}
+	r.buf, err = r.key.aead.Open(chunk[:0], chunkNonce(r.index, r.last), chunk[:n], nil)
+	if err != nil {
+		return errCorrupt
+	}
+	r.index++
+	return nil
+}
+
+// skip discards the first n bytes of the content of the current chunk.
+func (r *chunkReader) skip(n int) error {
+	if n == 0 {
+		return nil
+	}
+	if err := r.next(); err != nil {
+		return err
+	}
+	if n > len(r.buf) {
+		return io.EOF
+	}
+	r.buf = r.buf[n:]
+	return nil
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+		if r.err == io.EOF && !r.last {
+			// the object was truncated before its last chunk
+			r.err = errCorrupt
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *chunkReader) Close() error {
+	return r.rc.Close()
+}
+
+// encryptWriter encrypts content in chunks as it is written. The last,
+// incomplete chunk is held back until it is complete or the writer is
+// committed. If the writer is closed before, the incomplete chunk is stored
+// separately, encrypted, so that the next writer appending to the object can
+// complete it.
+type encryptWriter struct {
+	ctx     context.Context
+	driver  *encryptStorageMiddleware
+	path    string
+	fw      storagedriver.FileWriter
+	key     *objectKey
+	chunks  int64
+	pending []byte
+
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+var _ storagedriver.FileWriter = &encryptWriter{}
+
+// resume restores the state of a writer appending to an encrypted object.
+func (w *encryptWriter) resume() error {
+	header, err := w.driver.readHeader(w.ctx, w.path)
+	if err != nil {
+		return err
+	}
+	if header == nil {
+		return fmt.Errorf("cannot append encrypted content to unencrypted object %s", w.path)
+	}
+	w.key, err = w.driver.openObjectKey(header)
+	if err != nil {
+		return err
+	}
+
+	n := w.fw.Size() - int64(headerSize)
+	if n < 0 || n%int64(w.key.chunkSize+tagSize) != 0 {
+		return errCorrupt
+	}
+	w.chunks = n / int64(w.key.chunkSize+tagSize)
+
+	partial, err := w.driver.StorageDriver.GetContent(w.ctx, w.path+partialSuffix)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil
+		}
+		return err
+	}
+	if len(partial) < nonceSize {
+		return errCorrupt
+	}
+	w.pending, err = w.key.aead.Open(nil, partial[:nonceSize], partial[nonceSize:], nil)
+	if err != nil {
+		return errCorrupt
+	}
+	return nil
+}
+
+func (w *encryptWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("already closed")
+	} else if w.committed {
+		return 0, fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return 0, fmt.Errorf("already cancelled")
+	}
+
+	n := len(p)
+	for len(p) > 0 {
+		take := w.key.chunkSize - len(w.pending)
+		if take > len(p) {
+			take = len(p)
+		}
+		w.pending = append(w.pending, p[:take]...)
+		p = p[take:]
+		if len(w.pending) == w.key.chunkSize {
+			if err := w.flush(); err != nil {
+				return n - len(p), err
+			}
+		}
+	}
+	return n, nil
+}
+
+// flush seals the pending content as the next chunk.
+func (w *encryptWriter) flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	chunk := w.key.aead.Seal(nil, chunkNonce(w.chunks, false), w.pending, nil)
+	if _, err := w.fw.Write(chunk); err != nil {
+		return err
+	}
+	w.chunks++
+	w.pending = w.pending[:0]
+	return nil
+}
+
+// Size returns the size of the content written, including the content of
+// the incomplete chunk.
+func (w *encryptWriter) Size() int64 {
+	return w.chunks*int64(w.key.chunkSize) + int64(len(w.pending))
+}
+
+func (w *encryptWriter) Close() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+
+	if !w.committed && !w.cancelled {
+		if err := w.storePartial(); err != nil {
+			w.fw.Close()
+			return err
+		}
+	}
+	return w.fw.Close()
+}
+
+// storePartial stores the incomplete chunk until the next writer appending
+// to the object.
+func (w *encryptWriter) storePartial() error {
+	if len(w.pending) == 0 {
+		return w.deletePartial()
+	}
+	// Chunk nonces start with three zero bytes, these never do.
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce[4:]); err != nil {
+		return err
+	}
+	copy(nonce, []byte{0xff, 0xff, 0xff, 0xff})
+	partial := w.key.aead.Seal(bytes.Clone(nonce), nonce, w.pending, nil)
+	return w.driver.StorageDriver.PutContent(w.ctx, w.path+partialSuffix, partial)
+}
+
+func (w *encryptWriter) deletePartial() error {
+	err := w.driver.StorageDriver.Delete(w.ctx, w.path+partialSuffix)
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+func (w *encryptWriter) Cancel(ctx context.Context) error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	}
+	w.cancelled = true
+	if err := w.deletePartial(); err != nil {
+		return err
+	}
+	return w.fw.Cancel(ctx)
+}
+
+// Commit seals the incomplete chunk as the last chunk, which is empty if the
+// content fills the preceding chunks.
+func (w *encryptWriter) Commit() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return fmt.Errorf("already cancelled")
+	}
+	chunk := w.key.aead.Seal(nil, chunkNonce(w.chunks, true), w.pending, nil)
+	if _, err := w.fw.Write(chunk); err != nil {
+		return err
+	}
+	if err := w.deletePartial(); err != nil {
+		return err
+	}
+	w.committed = true
+	return w.fw.Commit()
+}
+
+func init() {
+	storagemiddleware.Register("encrypt", newEncryptStorageMiddleware)
+}
@@ -0,0 +1,310 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+// redirectingDriver is an in-memory driver which redirects to every path.
+type redirectingDriver struct {
+	*inmemory.Driver
+}
+
+func (d redirectingDriver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	return "https://storage.example.com" + path, nil
+}
+
+func newKeyFile(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "kek")
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func newTestMiddleware(t *testing.T, driver storagedriver.StorageDriver, options map[string]interface{}) storagedriver.StorageDriver {
+	t.Helper()
+	d, err := newEncryptStorageMiddleware(driver, options)
+	if err != nil {
+		t.Fatalf("unexpected error creating middleware: %v", err)
+	}
+	return d
+}
+
+func TestNoConfig(t *testing.T) {
+	if _, err := newEncryptStorageMiddleware(inmemory.New(), map[string]interface{}{}); err == nil || err.Error() != "no keyfile provided" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "short")
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString([]byte("short"))), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newEncryptStorageMiddleware(inmemory.New(), map[string]interface{}{"keyfile": path}); err == nil {
+		t.Fatal("expected error for short key")
+	}
+	if _, err := newEncryptStorageMiddleware(inmemory.New(), map[string]interface{}{"keyfile": newKeyFile(t), "chunksize": 0}); err == nil {
+		t.Fatal("expected error for zero chunk size")
+	}
+}
+
+func TestPutGetContent(t *testing.T) {
+	ctx := context.Background()
+	backend := redirectingDriver{inmemory.New()}
+	d := newTestMiddleware(t, backend, map[string]interface{}{"keyfile": newKeyFile(t), "chunksize": 16})
+
+	for _, content := range [][]byte{
+		{},
+		[]byte("short"),
+		[]byte("exactly 16 bytes"),
+		bytes.Repeat([]byte("layer contents "), 10),
+	} {
+		if err := d.PutContent(ctx, "/object", content); err != nil {
+			t.Fatalf("unexpected error putting content: %v", err)
+		}
+
+		stored, err := backend.GetContent(ctx, "/object")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(content) > 0 && bytes.Contains(stored, content[:4]) {
+			t.Fatalf("stored object contains plaintext: %q", stored)
+		}
+
+		got, err := d.GetContent(ctx, "/object")
+		if err != nil {
+			t.Fatalf("unexpected error getting content: %v", err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("unexpected content: %q != %q", got, content)
+		}
+
+		fi, err := d.Stat(ctx, "/object")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != int64(len(content)) {
+			t.Fatalf("unexpected size: %d != %d", fi.Size(), len(content))
+		}
+	}
+
+	if _, err := d.URLFor(ctx, "/object", nil); err == nil {
+		t.Fatal("expected redirects to encrypted objects to be unsupported")
+	} else if _, ok := err.(storagedriver.ErrUnsupportedMethod); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// tampering with the stored object is detected
+	stored, _ := backend.GetContent(ctx, "/object")
+	stored[len(stored)-1] ^= 1
+	if err := backend.PutContent(ctx, "/object", stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.GetContent(ctx, "/object"); err != errCorrupt {
+		t.Fatalf("expected tampered object to be rejected, got %v", err)
+	}
+}
+
+func TestWriterReader(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	d := newTestMiddleware(t, backend, map[string]interface{}{"keyfile": newKeyFile(t), "chunksize": 16})
+
+	var content []byte
+	for i, n := range []int{20, 30, 12, 5} {
+		fw, err := d.Writer(ctx, "/upload/data", i > 0)
+		if err != nil {
+			t.Fatalf("unexpected error opening writer: %v", err)
+		}
+		if fw.Size() != int64(len(content)) {
+			t.Fatalf("unexpected writer size: %d != %d", fw.Size(), len(content))
+		}
+		chunk := make([]byte, n)
+		if _, err := rand.Read(chunk); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fw.Write(chunk); err != nil {
+			t.Fatalf("unexpected error writing: %v", err)
+		}
+		content = append(content, chunk...)
+		if i == 3 {
+			if err := fw.Commit(); err != nil {
+				t.Fatalf("unexpected error committing: %v", err)
+			}
+		}
+		if err := fw.Close(); err != nil {
+			t.Fatalf("unexpected error closing: %v", err)
+		}
+	}
+
+	if _, err := backend.Stat(ctx, "/upload/data"+partialSuffix); err == nil {
+		t.Fatal("expected incomplete chunk to be removed on commit")
+	}
+
+	fi, err := d.Stat(ctx, "/upload/data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != int64(len(content)) {
+		t.Fatalf("unexpected size: %d != %d", fi.Size(), len(content))
+	}
+
+	for offset := 0; offset <= len(content); offset++ {
+		rc, err := d.Reader(ctx, "/upload/data", int64(offset))
+		if err != nil {
+			t.Fatalf("unexpected error reading at %d: %v", offset, err)
+		}
+		got, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatalf("unexpected error reading at %d: %v", offset, err)
+		}
+		if !bytes.Equal(got, content[offset:]) {
+			t.Fatalf("unexpected content at %d", offset)
+		}
+	}
+
+	if _, err := d.Reader(ctx, "/upload/data", int64(len(content)+1)); err == nil {
+		t.Fatal("expected error reading past the end")
+	}
+}
+
+func TestWriterCancel(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	d := newTestMiddleware(t, backend, map[string]interface{}{"keyfile": newKeyFile(t), "chunksize": 16})
+
+	fw, err := d.Writer(ctx, "/upload/data", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write([]byte("incomplete")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Stat(ctx, "/upload/data"+partialSuffix); err != nil {
+		t.Fatalf("expected incomplete chunk to be stored: %v", err)
+	}
+
+	fw, err = d.Writer(ctx, "/upload/data", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Cancel(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.Stat(ctx, "/upload/data"+partialSuffix); err == nil {
+		t.Fatal("expected incomplete chunk to be removed on cancel")
+	}
+}
+
+func TestUnencryptedObjects(t *testing.T) {
+	ctx := context.Background()
+	backend := redirectingDriver{inmemory.New()}
+	d := newTestMiddleware(t, backend, map[string]interface{}{"keyfile": newKeyFile(t)})
+
+	content := []byte("written before encryption was enabled")
+	if err := backend.PutContent(ctx, "/legacy", content); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := d.GetContent(ctx, "/legacy")
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("unexpected content %q: %v", got, err)
+	}
+	rc, err := d.Reader(ctx, "/legacy", 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ = io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(got, content[8:]) {
+		t.Fatalf("unexpected content %q", got)
+	}
+	fi, err := d.Stat(ctx, "/legacy")
+	if err != nil || fi.Size() != int64(len(content)) {
+		t.Fatalf("unexpected stat %v: %v", fi, err)
+	}
+	if _, err := d.URLFor(ctx, "/legacy", nil); err != nil {
+		t.Fatalf("expected redirects to unencrypted objects: %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	oldKey, newKey := newKeyFile(t), newKeyFile(t)
+
+	content := []byte("encrypted with the old key")
+	if err := newTestMiddleware(t, backend, map[string]interface{}{"keyfile": oldKey}).PutContent(ctx, "/object", content); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := newTestMiddleware(t, backend, map[string]interface{}{"keyfile": newKey}).GetContent(ctx, "/object"); err == nil {
+		t.Fatal("expected error reading with an unknown key")
+	}
+
+	d := newTestMiddleware(t, backend, map[string]interface{}{
+		"keyfile":          newKey,
+		"previouskeyfiles": []interface{}{oldKey},
+	})
+	got, err := d.GetContent(ctx, "/object")
+	if err != nil || !bytes.Equal(got, content) {
+		t.Fatalf("unexpected content %q: %v", got, err)
+	}
+}
+
+func TestTruncation(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	d := newTestMiddleware(t, backend, map[string]interface{}{"keyfile": newKeyFile(t), "chunksize": 16})
+
+	for _, size := range []int{0, 20, 32} {
+		content := make([]byte, size)
+		if _, err := rand.Read(content); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.PutContent(ctx, "/object", content); err != nil {
+			t.Fatal(err)
+		}
+		ciphertext, err := backend.GetContent(ctx, "/object")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// cut the object down to its header and at each chunk boundary
+		for cut := headerSize; cut < len(ciphertext); cut += 16 + tagSize {
+			if err := backend.PutContent(ctx, "/truncated", ciphertext[:cut]); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := d.GetContent(ctx, "/truncated"); err != errCorrupt {
+				t.Fatalf("expected %d byte object cut at %d to be corrupt, got %v", size, cut, err)
+			}
+			rc, err := d.Reader(ctx, "/truncated", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = io.ReadAll(rc)
+			rc.Close()
+			if err != errCorrupt {
+				t.Fatalf("expected reading %d byte object cut at %d to fail, got %v", size, cut, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,430 @@
+// Package middleware - faultinject wrapper for storage drivers. Calls to the
+// driver are delayed or failed according to configurable rules, to test how
+// the registry behaves when its storage backend is slow or unreliable.
+//
+// It is meant for tests and staging deployments, never for production.
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+// operations are the names of the calls faults can be injected into: the
+// methods of the storage driver, and the writes and commits of the
+// FileWriters it returns.
+var operations = []string{
+	"GetContent", "PutContent", "Reader", "Writer", "Stat", "List", "Move", "Delete", "URLFor", "Walk",
+	"Write", "Commit",
+}
+
+// ErrInjected is the cause of the errors injected by the middleware.
+var ErrInjected = errors.New("injected fault")
+
+var injectedFaults = prometheus.StorageNamespace.NewLabeledCounter("faultinject_faults", "The number of faults injected into storage driver calls", "operation", "fault")
+
+// Rule describes the faults to inject into the calls it matches.
+type Rule struct {
+	// Operations are the names of the calls the rule matches, all calls if
+	// empty.
+	Operations []string
+	// Path matches the paths of the calls the rule matches, all paths if
+	// nil.
+	Path *regexp.Regexp
+	// Latency delays the calls.
+	Latency time.Duration
+	// ErrorRate is the probability of calls failing with ErrInjected.
+	ErrorRate float64
+	// NotFoundRate is the probability of calls failing with
+	// storagedriver.PathNotFoundError.
+	NotFoundRate float64
+	// PartialReadRate is the probability of the readers returned by Reader
+	// failing with io.ErrUnexpectedEOF before the end of the content.
+	PartialReadRate float64
+}
+
+func (r *Rule) matches(op, path string) bool {
+	if len(r.Operations) > 0 && !contains(r.Operations, op) {
+		return false
+	}
+	return r.Path == nil || r.Path.MatchString(path)
+}
+
+func (r *Rule) validate() error {
+	for _, op := range r.Operations {
+		if !contains(operations, op) {
+			return fmt.Errorf("unknown operation %q", op)
+		}
+	}
+	for _, rate := range []float64{r.ErrorRate, r.NotFoundRate, r.PartialReadRate} {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("rates must be between 0 and 1")
+		}
+	}
+	if r.ErrorRate+r.NotFoundRate > 1 {
+		return fmt.Errorf("errorrate and notfoundrate must not add up to more than 1")
+	}
+	if r.Latency < 0 {
+		return fmt.Errorf("latency must not be negative")
+	}
+	return nil
+}
+
+func contains(operations []string, op string) bool {
+	for _, o := range operations {
+		if strings.EqualFold(o, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// faultInjectStorageMiddleware injects the faults of the first rule matching
+// each call before passing it to the wrapped driver.
+type faultInjectStorageMiddleware struct {
+	storagedriver.StorageDriver
+	rules []Rule
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+var _ storagedriver.StorageDriver = &faultInjectStorageMiddleware{}
+
+// New returns a driver wrapping storageDriver which injects faults according
+// to rules. The faults are chosen at random from a source seeded with seed,
+// so that runs with the same seed and calls inject the same faults.
+func New(storageDriver storagedriver.StorageDriver, seed int64, rules ...Rule) (storagedriver.StorageDriver, error) {
+	for i := range rules {
+		if err := rules[i].validate(); err != nil {
+			return nil, fmt.Errorf("invalid rule %d: %v", i, err)
+		}
+	}
+	return &faultInjectStorageMiddleware{
+		StorageDriver: storageDriver,
+		rules:         rules,
+		rand:          rand.New(rand.NewSource(seed)),
+	}, nil
+}
+
+// newFaultInjectStorageMiddleware constructs and returns a new fault
+// injecting storage middleware.
+//
+// Optional options:
+//
+//   - seed: the seed of the random faults, the current time by default
+//   - rules: the list of rules, each with the keys operations, path,
+//     latency, errorrate, notfoundrate and partialreadrate
+func newFaultInjectStorageMiddleware(storageDriver storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	seed := time.Now().UnixNano()
+	if s, ok := options["seed"]; ok {
+		switch s := s.(type) {
+		case int:
+			seed = int64(s)
+		case int64:
+			seed = s
+		case string:
+			var err error
+			seed, err = strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("seed must be an integer: %v", err)
+			}
+		default:
+			return nil, fmt.Errorf("seed must be an integer")
+		}
+	}
+
+	var rules []Rule
+	if r, ok := options["rules"]; ok {
+		list, ok := r.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("rules must be a list")
+		}
+		for i, item := range list {
+			rule, err := parseRule(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rule %d: %v", i, err)
+			}
+			rules = append(rules, rule)
+		}
+	}
+
+	return New(storageDriver, seed, rules...)
+}
+
+func parseRule(item interface{}) (Rule, error) {
+	options := map[string]interface{}{}
+	switch item := item.(type) {
+	case map[string]interface{}:
+		options = item
+	case map[interface{}]interface{}:
+		for k, v := range item {
+			key, ok := k.(string)
+			if !ok {
+				return Rule{}, fmt.Errorf("rule must be a map of strings")
+			}
+			options[key] = v
+		}
+	default:
+		return Rule{}, fmt.Errorf("rule must be a map")
+	}
+
+	var rule Rule
+	for key, value := range options {
+		var err error
+		switch key {
+		case "operations":
+			ops, ok := value.([]interface{})
+			if !ok {
+				return Rule{}, fmt.Errorf("operations must be a list")
+			}
+			for _, op := range ops {
+				s, ok := op.(string)
+				if !ok {
+					return Rule{}, fmt.Errorf("operations must be strings")
+				}
+				rule.Operations = append(rule.Operations, s)
+			}
+		case "path":
+			s, ok := value.(string)
+			if !ok {
+				return Rule{}, fmt.Errorf("path must be a string")
+			}
+			rule.Path, err = regexp.Compile(s)
+		case "latency":
+			s, ok := value.(string)
+			if !ok {
+				return Rule{}, fmt.Errorf("latency must be a duration")
+			}
+			rule.Latency, err = time.ParseDuration(s)
+		case "errorrate":
+			rule.ErrorRate, err = parseRate(value)
+		case "notfoundrate":
+			rule.NotFoundRate, err = parseRate(value)
+		case "partialreadrate":
+			rule.PartialReadRate, err = parseRate(value)
+		default:
+			return Rule{}, fmt.Errorf("unknown option %q", key)
+		}
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid %s: %v", key, err)
+		}
+	}
+	return rule, nil
+}
+
+func parseRate(value interface{}) (float64, error) {
+	switch value := value.(type) {
+	case float64:
+		return value, nil
+	case int:
+		return float64(value), nil
+	case string:
+		return strconv.ParseFloat(value, 64)
+	default:
+		return 0, fmt.Errorf("must be a number")
+	}
+}
+
+// float64 returns a random number in [0.0,1.0).
+func (d *faultInjectStorageMiddleware) float64() float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rand.Float64()
+}
+
+// int63n returns a random number in [0,n).
+func (d *faultInjectStorageMiddleware) int63n(n int64) int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.rand.Int63n(n)
+}
+
+// inject delays the call op on path and returns the error to fail it with,
+// according to the first matching rule, which is returned as well.
+func (d *faultInjectStorageMiddleware) inject(ctx context.Context, op, path string) (*Rule, error) {
+	var rule *Rule
+	for i := range d.rules {
+		if d.rules[i].matches(op, path) {
+			rule = &d.rules[i]
+			break
+		}
+	}
+	if rule == nil {
+		return nil, nil
+	}
+
+	if rule.Latency > 0 {
+		injectedFaults.WithValues(op, "latency").Inc(1)
+		t := time.NewTimer(rule.Latency)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return rule, ctx.Err()
+		}
+	}
+
+	if rule.ErrorRate == 0 && rule.NotFoundRate == 0 {
+		return rule, nil
+	}
+	switch r := d.float64(); {
+	case r < rule.NotFoundRate:
+		injectedFaults.WithValues(op, "notfound").Inc(1)
+		dcontext.GetLogger(ctx).Debugf("faultinject: %s %s: not found", op, path)
+		return rule, storagedriver.PathNotFoundError{Path: path, DriverName: d.Name()}
+	case r < rule.NotFoundRate+rule.ErrorRate:
+		injectedFaults.WithValues(op, "error").Inc(1)
+		dcontext.GetLogger(ctx).Debugf("faultinject: %s %s: error", op, path)
+		return rule, storagedriver.Error{DriverName: d.Name(), Enclosed: fmt.Errorf("%s %s: %w", op, path, ErrInjected)}
+	}
+	return rule, nil
+}
+
+func (d *faultInjectStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if _, err := d.inject(ctx, "GetContent", path); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.GetContent(ctx, path)
+}
+
+func (d *faultInjectStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	if _, err := d.inject(ctx, "PutContent", path); err != nil {
+		return err
+	}
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+// Reader returns a reader of the content at path from offset which, if the
+// matching rule says so, fails at a random point before its end.
+func (d *faultInjectStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	rule, err := d.inject(ctx, "Reader", path)
+	if err != nil {
+		return nil, err
+	}
+	rc, err := d.StorageDriver.Reader(ctx, path, offset)
+	if err != nil || rule == nil || rule.PartialReadRate == 0 || d.float64() >= rule.PartialReadRate {
+		return rc, err
+	}
+
+	var limit int64
+	if fi, err := d.StorageDriver.Stat(ctx, path); err == nil && fi.Size()-offset > 0 {
+		limit = d.int63n(fi.Size() - offset)
+	}
+	injectedFaults.WithValues("Reader", "partialread").Inc(1)
+	dcontext.GetLogger(ctx).Debugf("faultinject: Reader %s: partial read after %d bytes", path, limit)
+	return &partialReader{ReadCloser: rc, remaining: limit}, nil
+}
+
+func (d *faultInjectStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	if _, err := d.inject(ctx, "Writer", path); err != nil {
+		return nil, err
+	}
+	fw, err := d.StorageDriver.Writer(ctx, path, append)
+	if err != nil {
+		return nil, err
+	}
+	return &faultInjectWriter{FileWriter: fw, ctx: ctx, d: d, path: path}, nil
+}
+
+func (d *faultInjectStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	if _, err := d.inject(ctx, "Stat", path); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.Stat(ctx, path)
+}
+
+func (d *faultInjectStorageMiddleware) List(ctx context.Context, path string) ([]string, error) {
+	if _, err := d.inject(ctx, "List", path); err != nil {
+		return nil, err
+	}
+	return d.StorageDriver.List(ctx, path)
+}
+
+func (d *faultInjectStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	if _, err := d.inject(ctx, "Move", sourcePath); err != nil {
+		return err
+	}
+	return d.StorageDriver.Move(ctx, sourcePath, destPath)
+}
+
+func (d *faultInjectStorageMiddleware) Delete(ctx context.Context, path string) error {
+	if _, err := d.inject(ctx, "Delete", path); err != nil {
+		return err
+	}
+	return d.StorageDriver.Delete(ctx, path)
+}
+
+func (d *faultInjectStorageMiddleware) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	if _, err := d.inject(ctx, "URLFor", path); err != nil {
+		return "", err
+	}
+	return d.StorageDriver.URLFor(ctx, path, options)
+}
+
+func (d *faultInjectStorageMiddleware) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	if _, err := d.inject(ctx, "Walk", path); err != nil {
+		return err
+	}
+	return d.StorageDriver.Walk(ctx, path, f, options...)
+}
+
+// faultInjectWriter injects faults into the writes and commits of a
+// FileWriter.
+type faultInjectWriter struct {
+	storagedriver.FileWriter
+	ctx  context.Context
+	d    *faultInjectStorageMiddleware
+	path string
+}
+
+func (w *faultInjectWriter) Write(p []byte) (int, error) {
+	if _, err := w.d.inject(w.ctx, "Write", w.path); err != nil {
+		return 0, err
+	}
+	return w.FileWriter.Write(p)
+}
+
+func (w *faultInjectWriter) Commit() error {
+	if _, err := w.d.inject(w.ctx, "Commit", w.path); err != nil {
+		return err
+	}
+	return w.FileWriter.Commit()
+}
+
+// partialReader fails with io.ErrUnexpectedEOF after reading remaining
+// bytes.
+type partialReader struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (r *partialReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+// init registers the faultinject middleware backend.
+func init() {
+	storagemiddleware.Register("faultinject", newFaultInjectStorageMiddleware)
+}
@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+const (
+	blobPath = "/docker/registry/v2/blobs/sha256/ab/abcdef/data"
+	tagPath  = "/docker/registry/v2/repositories/foo/_manifests/tags/latest/current/link"
+)
+
+func isInjected(err error) bool {
+	e, ok := err.(storagedriver.Error)
+	return ok && errors.Is(e.Enclosed, ErrInjected)
+}
+
+func TestOptions(t *testing.T) {
+	d, err := newFaultInjectStorageMiddleware(inmemory.New(), map[string]interface{}{
+		"seed": 42,
+		"rules": []interface{}{
+			map[interface{}]interface{}{
+				"operations": []interface{}{"reader", "GetContent"},
+				"path":       "/blobs/",
+				"latency":    "10ms",
+				"errorrate":  0.5,
+			},
+			map[interface{}]interface{}{
+				"notfoundrate": 1,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rules := d.(*faultInjectStorageMiddleware).rules
+	if len(rules) != 2 || rules[0].Latency != 10*time.Millisecond || rules[0].ErrorRate != 0.5 || rules[1].NotFoundRate != 1 {
+		t.Fatalf("unexpected rules %+v", rules)
+	}
+
+	for _, rule := range []interface{}{
+		map[interface{}]interface{}{"operations": []interface{}{"Read"}},
+		map[interface{}]interface{}{"path": "["},
+		map[interface{}]interface{}{"errorrate": 1.5},
+		map[interface{}]interface{}{"errorrate": 0.6, "notfoundrate": 0.6},
+		map[interface{}]interface{}{"latency": "soon"},
+		map[interface{}]interface{}{"flakiness": 1},
+	} {
+		if _, err := newFaultInjectStorageMiddleware(inmemory.New(), map[string]interface{}{"rules": []interface{}{rule}}); err == nil {
+			t.Errorf("expected error for rule %v", rule)
+		}
+	}
+}
+
+func TestInjectErrors(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	if err := backend.PutContent(ctx, blobPath, []byte("layer contents")); err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.PutContent(ctx, tagPath, []byte("sha256:abcdef")); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := New(backend, 1,
+		Rule{Operations: []string{"Stat"}, Path: regexp.MustCompile("/blobs/"), NotFoundRate: 1},
+		Rule{Path: regexp.MustCompile("/blobs/"), ErrorRate: 1},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first matching rule applies
+	if _, err := d.Stat(ctx, blobPath); !errors.As(err, &storagedriver.PathNotFoundError{}) {
+		t.Fatalf("expected injected not found error, got %v", err)
+	}
+	if _, err := d.GetContent(ctx, blobPath); !isInjected(err) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	if err := d.PutContent(ctx, blobPath, nil); !isInjected(err) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+
+	// paths not matched by any rule are passed through
+	if content, err := d.GetContent(ctx, tagPath); err != nil || string(content) != "sha256:abcdef" {
+		t.Fatalf("unexpected content %q: %v", content, err)
+	}
+}
+
+func TestInjectWriterErrors(t *testing.T) {
+	ctx := context.Background()
+	d, err := New(inmemory.New(), 1, Rule{Operations: []string{"Commit"}, ErrorRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fw, err := d.Writer(ctx, blobPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fw.Close()
+	if _, err := fw.Write([]byte("layer contents")); err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if err := fw.Commit(); !isInjected(err) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+}
+
+func TestInjectRates(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	if err := backend.PutContent(ctx, blobPath, []byte("layer contents")); err != nil {
+		t.Fatal(err)
+	}
+
+	count := func(seed int64) int {
+		d, err := New(backend, seed, Rule{ErrorRate: 0.3})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var failures int
+		for i := 0; i < 1000; i++ {
+			if _, err := d.Stat(ctx, blobPath); isInjected(err) {
+				failures++
+			}
+		}
+		return failures
+	}
+
+	failures := count(7)
+	if failures < 200 || failures > 400 {
+		t.Fatalf("expected about 300 failures, got %d", failures)
+	}
+	if again := count(7); again != failures {
+		t.Fatalf("expected the same seed to inject the same faults: %d != %d", again, failures)
+	}
+}
+
+func TestLatency(t *testing.T) {
+	ctx := context.Background()
+	d, err := New(inmemory.New(), 1, Rule{Operations: []string{"List"}, Latency: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if _, err := d.List(ctx, "/"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("expected call to be delayed, took %v", elapsed)
+	}
+
+	// delays end with the context of the call
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if _, err := d.List(ctx, "/"); err != context.DeadlineExceeded {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestPartialReads(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	content := bytes.Repeat([]byte("layer contents "), 100)
+	if err := backend.PutContent(ctx, blobPath, content); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := New(backend, 1, Rule{Operations: []string{"Reader"}, PartialReadRate: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, offset := range []int64{0, 100} {
+		rc, err := d.Reader(ctx, blobPath, offset)
+		if err != nil {
+			t.Fatalf("unexpected error opening reader: %v", err)
+		}
+		read, err := io.ReadAll(rc)
+		rc.Close()
+		if err != io.ErrUnexpectedEOF {
+			t.Fatalf("expected unexpected EOF, got %v", err)
+		}
+		if int64(len(read)) >= int64(len(content))-offset || !bytes.Equal(read, content[offset:offset+int64(len(read))]) {
+			t.Fatalf("unexpected partial content of %d bytes", len(read))
+		}
+	}
+}
@@ -0,0 +1,440 @@
+// Package middleware - georedirect wrapper for storage drivers. It redirects
+// each client downloading content to the mirror of the storage backend
+// serving its network, such as the bucket or CDN of its region, and serves
+// the content itself to the clients of other networks.
+//
+// Each mirror, or target, is either a base URL, to which the path of the
+// content is appended as the redirect middleware does, or a storage driver
+// whose URLFor is used, such as a bucket in another region holding a replica
+// of the content. Targets may be health checked, so that clients are not
+// redirected to a target which is down.
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/health"
+	"github.com/distribution/distribution/v3/health/checks"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	cloudfront "github.com/distribution/distribution/v3/registry/storage/driver/middleware/cloudfront"
+)
+
+const (
+	// FallbackServe serves the content from the registry to the clients no
+	// target serves.
+	FallbackServe = "serve"
+	// FallbackDriver redirects the clients no target serves to the URL of
+	// the wrapped storage driver.
+	FallbackDriver = "driver"
+
+	defaultHealthCheckInterval  = 10 * time.Second
+	defaultHealthCheckTimeout   = 5 * time.Second
+	defaultHealthCheckThreshold = 3
+)
+
+// redirects is the number of redirects by target, "none" for the content
+// served with the fallback.
+var redirects = prometheus.StorageNamespace.NewLabeledCounter("georedirect_redirects", "The number of redirects to each georedirect target", "target")
+
+// target is a mirror of the storage backend serving some networks.
+type target struct {
+	name      string
+	networks  []*net.IPNet
+	awsRanges *cloudfront.AWSIPRanges
+
+	// either baseURL or driver is set
+	baseURL *url.URL
+	driver  storagedriver.StorageDriver
+
+	// health is nil if the target is not health checked
+	health health.Checker
+}
+
+// serves returns true if the target serves the client at ip.
+func (t *target) serves(ip net.IP) bool {
+	for _, network := range t.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return t.awsRanges != nil && t.awsRanges.Contains(ip)
+}
+
+// healthy returns the error of the last health checks of the target, if
+// they failed.
+func (t *target) healthy() error {
+	if t.health == nil {
+		return nil
+	}
+	return t.health.Check()
+}
+
+func (t *target) urlFor(ctx context.Context, urlPath string, options map[string]interface{}) (string, error) {
+	if t.driver != nil {
+		return t.driver.URLFor(ctx, urlPath, options)
+	}
+	u := *t.baseURL
+	u.Path = path.Join(t.baseURL.Path, urlPath)
+	return u.String(), nil
+}
+
+// geoRedirectStorageMiddleware redirects clients to the target serving their
+// network.
+type geoRedirectStorageMiddleware struct {
+	storagedriver.StorageDriver
+	targets  []*target
+	fallback string
+}
+
+var _ storagedriver.StorageDriver = &geoRedirectStorageMiddleware{}
+
+// newGeoRedirectStorageMiddleware constructs and returns a new georedirect
+// storage middleware.
+//
+// Required options:
+//
+//   - targets: the list of targets, each with the keys name, baseurl or
+//     driver and parameters, networks, awsregions and healthcheck
+//
+// Optional options:
+//
+//   - fallback: serve or driver, what to do for the clients no target
+//     serves, serve by default
+//   - iprangesurl: the URL of the AWS IP ranges, for the awsregions of the
+//     targets
+//   - updatefrequency: the frequency the AWS IP ranges are updated at
+func newGeoRedirectStorageMiddleware(storageDriver storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	m := &geoRedirectStorageMiddleware{
+		StorageDriver: storageDriver,
+		fallback:      FallbackServe,
+	}
+
+	if f, ok := options["fallback"]; ok {
+		switch f {
+		case FallbackServe, FallbackDriver:
+			m.fallback = f.(string)
+		default:
+			return nil, fmt.Errorf("fallback only allows the following values: serve|driver")
+		}
+	}
+
+	var ipRangesURL string
+	if i, ok := options["iprangesurl"]; ok {
+		s, ok := i.(string)
+		if !ok {
+			return nil, fmt.Errorf("iprangesurl must be a string")
+		}
+		ipRangesURL = s
+	}
+	var updateFrequency time.Duration
+	if u, ok := options["updatefrequency"]; ok {
+		var err error
+		updateFrequency, err = parseDuration(u)
+		if err != nil {
+			return nil, fmt.Errorf("invalid updatefrequency: %v", err)
+		}
+	}
+
+	t, ok := options["targets"]
+	if !ok {
+		return nil, fmt.Errorf("no targets provided")
+	}
+	list, ok := t.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("targets must be a non-empty list")
+	}
+	names := map[string]bool{}
+	for i, item := range list {
+		target, err := parseTarget(item, ipRangesURL, updateFrequency)
+		if err != nil {
+			return nil, fmt.Errorf("invalid target %d: %v", i, err)
+		}
+		if names[target.name] {
+			return nil, fmt.Errorf("duplicate target %q", target.name)
+		}
+		names[target.name] = true
+		m.targets = append(m.targets, target)
+	}
+
+	return m, nil
+}
+
+func parseTarget(item interface{}, ipRangesURL string, updateFrequency time.Duration) (*target, error) {
+	options, err := stringMap(item)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &target{}
+	var awsRegions []string
+	var healthCheck map[string]interface{}
+	for key, value := range options {
+		switch key {
+		case "name":
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("name must be a string")
+			}
+			t.name = s
+		case "baseurl":
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("baseurl must be a string")
+			}
+			u, err := url.Parse(s)
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse baseurl: %s", s)
+			}
+			if u.Scheme == "" || u.Host == "" {
+				return nil, fmt.Errorf("baseurl must have a scheme and a host")
+			}
+			t.baseURL = u
+		case "driver", "parameters":
+			// constructed below
+		case "networks":
+			cidrs, err := stringList(value)
+			if err != nil {
+				return nil, fmt.Errorf("networks %v", err)
+			}
+			for _, cidr := range cidrs {
+				_, network, err := net.ParseCIDR(strings.TrimSpace(cidr))
+				if err != nil {
+					return nil, fmt.Errorf("invalid network: %v", err)
+				}
+				t.networks = append(t.networks, network)
+			}
+		case "awsregions":
+			if s, ok := value.(string); ok {
+				// a comma separated string, as the awsregion of cloudfront
+				awsRegions = strings.Split(s, ",")
+				break
+			}
+			awsRegions, err = stringList(value)
+			if err != nil {
+				return nil, fmt.Errorf("awsregions %v", err)
+			}
+		case "healthcheck":
+			healthCheck, err = stringMap(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid healthcheck: %v", err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown option %q", key)
+		}
+	}
+
+	if t.name == "" {
+		return nil, fmt.Errorf("no name provided")
+	}
+	if d, ok := options["driver"]; ok {
+		if t.baseURL != nil {
+			return nil, fmt.Errorf("baseurl cannot be combined with driver")
+		}
+		name, ok := d.(string)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("driver must be a non-empty string")
+		}
+		parameters := map[string]interface{}{}
+		if p, ok := options["parameters"]; ok && p != nil {
+			parameters, err = stringMap(p)
+			if err != nil {
+				return nil, fmt.Errorf("invalid parameters: %v", err)
+			}
+		}
+		t.driver, err = factory.Create(name, parameters)
+		if err != nil {
+			return nil, fmt.Errorf("failed to construct %s driver: %v", name, err)
+		}
+	} else if t.baseURL == nil {
+		return nil, fmt.Errorf("no baseurl or driver provided")
+	}
+	if len(t.networks) == 0 && len(awsRegions) == 0 {
+		return nil, fmt.Errorf("no networks or awsregions provided")
+	}
+	if len(awsRegions) > 0 {
+		t.awsRanges = cloudfront.NewAWSIPRanges(ipRangesURL, updateFrequency, awsRegions)
+	}
+	if healthCheck != nil {
+		t.health, err = newHealthCheck(t, healthCheck)
+		if err != nil {
+			return nil, fmt.Errorf("invalid healthcheck: %v", err)
+		}
+	}
+	return t, nil
+}
+
+// newHealthCheck starts the periodic health checks of t, configured by
+// options, and returns their status. The target is checked with a HEAD
+// request to a URL, by default its base URL, or with a Stat of the root of
+// its driver.
+func newHealthCheck(t *target, options map[string]interface{}) (health.Checker, error) {
+	interval := defaultHealthCheckInterval
+	timeout := defaultHealthCheckTimeout
+	threshold := defaultHealthCheckThreshold
+	statusCode := 200
+	var checkURL string
+	if t.baseURL != nil {
+		checkURL = t.baseURL.String()
+	}
+
+	for key, value := range options {
+		var err error
+		switch key {
+		case "url":
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("url must be a string")
+			}
+			checkURL = s
+		case "interval":
+			interval, err = parseDuration(value)
+		case "timeout":
+			timeout, err = parseDuration(value)
+		case "threshold":
+			threshold, err = parseInt(value)
+		case "statuscode":
+			statusCode, err = parseInt(value)
+		default:
+			return nil, fmt.Errorf("unknown option %q", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %v", key, err)
+		}
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("interval must be positive")
+	}
+	if threshold <= 0 {
+		return nil, fmt.Errorf("threshold must be positive")
+	}
+
+	var check health.Checker
+	if checkURL != "" {
+		check = checks.HTTPChecker(checkURL, statusCode, timeout, nil)
+	} else {
+		driver := t.driver
+		check = health.CheckFunc(func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			_, err := driver.Stat(ctx, "/")
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				err = nil // the backend is responding
+			}
+			return err
+		})
+	}
+
+	name := t.name
+	logged := health.CheckFunc(func() error {
+		err := check.Check()
+		if err != nil {
+			dcontext.GetLogger(context.Background()).Warnf("georedirect: health check of target %s failed: %v", name, err)
+		}
+		return err
+	})
+	return health.PeriodicThresholdChecker(logged, interval, threshold), nil
+}
+
+// URLFor redirects the client of the request to the first healthy target
+// serving its network. The content is served with the fallback if there is
+// none.
+func (m *geoRedirectStorageMiddleware) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	if ip, err := cloudfront.ClientIP(ctx); err == nil {
+		for _, t := range m.targets {
+			if !t.serves(ip) {
+				continue
+			}
+			if err := t.healthy(); err != nil {
+				dcontext.GetLogger(ctx).Debugf("georedirect: skipping unhealthy target %s: %v", t.name, err)
+				continue
+			}
+			u, err := t.urlFor(ctx, path, options)
+			if err != nil {
+				return "", err
+			}
+			redirects.WithValues(t.name).Inc(1)
+			return u, nil
+		}
+	} else {
+		dcontext.GetLogger(ctx).WithError(err).Debug("georedirect: failed to parse the client ip address")
+	}
+
+	redirects.WithValues("none").Inc(1)
+	if m.fallback == FallbackDriver {
+		return m.StorageDriver.URLFor(ctx, path, options)
+	}
+	return "", storagedriver.ErrUnsupportedMethod{}
+}
+
+func stringMap(value interface{}) (map[string]interface{}, error) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		return value, nil
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, v := range value {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("must be a map of strings")
+			}
+			m[key] = v
+		}
+		return m, nil
+	default:
+		return nil, fmt.Errorf("must be a map")
+	}
+}
+
+func stringList(value interface{}) ([]string, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("must be a list")
+	}
+	var list []string
+	for _, item := range items {
+		s, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("must be a list of strings")
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+func parseDuration(value interface{}) (time.Duration, error) {
+	switch value := value.(type) {
+	case time.Duration:
+		return value, nil
+	case string:
+		return time.ParseDuration(value)
+	default:
+		return 0, fmt.Errorf("must be a duration")
+	}
+}
+
+func parseInt(value interface{}) (int, error) {
+	switch value := value.(type) {
+	case int:
+		return value, nil
+	case string:
+		return strconv.Atoi(value)
+	default:
+		return 0, fmt.Errorf("must be an integer")
+	}
+}
+
+// init registers the georedirect storage middleware.
+func init() {
+	storagemiddleware.Register("georedirect", newGeoRedirectStorageMiddleware)
+}
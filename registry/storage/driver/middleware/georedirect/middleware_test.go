@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+const blobPath = "/docker/registry/v2/blobs/sha256/ab/abcdef/data"
+
+// clientContext returns the context of a request of the client at ip.
+func clientContext(ip string) context.Context {
+	r := httptest.NewRequest(http.MethodGet, "/v2/foo/blobs/sha256:abcdef", nil)
+	r.RemoteAddr = net.JoinHostPort(ip, "40000")
+	return dcontext.WithRequest(context.Background(), r)
+}
+
+// urlDriver is a storage driver redirecting to a fixed URL.
+type urlDriver struct {
+	storagedriver.StorageDriver
+	url string
+}
+
+func (d *urlDriver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	return d.url + path, nil
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]interface{}{
+		{},
+		{"targets": []interface{}{}},
+		{"targets": []interface{}{
+			map[interface{}]interface{}{"baseurl": "https://eu.example.com", "networks": []interface{}{"10.0.0.0/8"}},
+		}},
+		{"targets": []interface{}{
+			map[interface{}]interface{}{"name": "eu", "networks": []interface{}{"10.0.0.0/8"}},
+		}},
+		{"targets": []interface{}{
+			map[interface{}]interface{}{"name": "eu", "baseurl": "https://eu.example.com"},
+		}},
+		{"targets": []interface{}{
+			map[interface{}]interface{}{"name": "eu", "baseurl": "https://eu.example.com", "networks": []interface{}{"10.0.0.0"}},
+		}},
+		{"targets": []interface{}{
+			map[interface{}]interface{}{"name": "eu", "baseurl": "https://eu.example.com", "networks": []interface{}{"10.0.0.0/8"}},
+			map[interface{}]interface{}{"name": "eu", "baseurl": "https://eu2.example.com", "networks": []interface{}{"10.0.0.0/8"}},
+		}},
+		{"fallback": "cdn", "targets": []interface{}{
+			map[interface{}]interface{}{"name": "eu", "baseurl": "https://eu.example.com", "networks": []interface{}{"10.0.0.0/8"}},
+		}},
+	} {
+		if _, err := newGeoRedirectStorageMiddleware(nil, options); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+}
+
+func TestRedirectByNetwork(t *testing.T) {
+	d, err := newGeoRedirectStorageMiddleware(inmemory.New(), map[string]interface{}{
+		"targets": []interface{}{
+			map[interface{}]interface{}{
+				"name":     "eu",
+				"baseurl":  "https://eu.example.com/registry",
+				"networks": []interface{}{"10.1.0.0/16", "2001:db8:1::/48"},
+			},
+			map[interface{}]interface{}{
+				"name":     "us",
+				"baseurl":  "https://us.example.com",
+				"networks": []interface{}{"10.2.0.0/16"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		ip       string
+		expected string
+	}{
+		{"10.1.2.3", "https://eu.example.com/registry" + blobPath},
+		{"2001:db8:1::1", "https://eu.example.com/registry" + blobPath},
+		{"10.2.2.3", "https://us.example.com" + blobPath},
+	} {
+		u, err := d.URLFor(clientContext(test.ip), blobPath, nil)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.ip, err)
+		}
+		if u != test.expected {
+			t.Errorf("expected %s for %s, got %s", test.expected, test.ip, u)
+		}
+	}
+
+	// unknown networks are served by the registry
+	if _, err := d.URLFor(clientContext("192.168.1.1"), blobPath, nil); err != (storagedriver.ErrUnsupportedMethod{}) {
+		t.Fatalf("expected an unsupported method error, got %v", err)
+	}
+	if _, err := d.URLFor(context.Background(), blobPath, nil); err != (storagedriver.ErrUnsupportedMethod{}) {
+		t.Fatalf("expected an unsupported method error without a request, got %v", err)
+	}
+}
+
+func TestFallbackDriver(t *testing.T) {
+	d, err := newGeoRedirectStorageMiddleware(&urlDriver{url: "https://bucket.example.com"}, map[string]interface{}{
+		"fallback": "driver",
+		"targets": []interface{}{
+			map[interface{}]interface{}{
+				"name":     "eu",
+				"baseurl":  "https://eu.example.com",
+				"networks": []interface{}{"10.1.0.0/16"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := d.URLFor(clientContext("192.168.1.1"), blobPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u != "https://bucket.example.com"+blobPath {
+		t.Fatalf("unexpected url %s", u)
+	}
+}
+
+func TestUnhealthyTarget(t *testing.T) {
+	status := make(chan int, 1)
+	status <- http.StatusServiceUnavailable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code := <-status
+		status <- code
+		w.WriteHeader(code)
+	}))
+	defer server.Close()
+
+	d, err := newGeoRedirectStorageMiddleware(inmemory.New(), map[string]interface{}{
+		"targets": []interface{}{
+			map[interface{}]interface{}{
+				"name":     "eu",
+				"baseurl":  server.URL,
+				"networks": []interface{}{"10.1.0.0/16"},
+				"healthcheck": map[interface{}]interface{}{
+					"interval":  "10ms",
+					"threshold": 1,
+				},
+			},
+			map[interface{}]interface{}{
+				"name":     "global",
+				"baseurl":  "https://global.example.com",
+				"networks": []interface{}{"0.0.0.0/0"},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	waitFor := func(expected string) {
+		t.Helper()
+		var u string
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if u, err = d.URLFor(clientContext("10.1.2.3"), blobPath, nil); err == nil && u == expected {
+				return
+			}
+		}
+		t.Fatalf("expected %s, got %s, %v", expected, u, err)
+	}
+
+	// the clients of the unhealthy target go to the next one serving them
+	waitFor("https://global.example.com" + blobPath)
+
+	<-status
+	status <- http.StatusOK
+	waitFor(server.URL + blobPath)
+}
+
+func TestDriverTarget(t *testing.T) {
+	d, err := newGeoRedirectStorageMiddleware(inmemory.New(), map[string]interface{}{
+		"targets": []interface{}{
+			map[interface{}]interface{}{
+				"name":     "replica",
+				"driver":   "inmemory",
+				"networks": []interface{}{"10.1.0.0/16"},
+				"healthcheck": map[interface{}]interface{}{
+					"interval": "1h",
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := d.(*geoRedirectStorageMiddleware).targets[0]
+	if target.driver == nil || target.driver.Name() != "inmemory" {
+		t.Fatalf("expected an inmemory driver target, got %v", target.driver)
+	}
+	if err := target.healthy(); err != nil {
+		t.Fatal(err)
+	}
+	// the target driver decides how the content is redirected to
+	if _, err := d.URLFor(clientContext("10.1.2.3"), blobPath, nil); err != (storagedriver.ErrUnsupportedMethod{DriverName: "inmemory"}) {
+		t.Fatalf("expected the error of the inmemory driver, got %v", err)
+	}
+}
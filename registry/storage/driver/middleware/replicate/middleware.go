@@ -0,0 +1,317 @@
+// Package middleware - replicate wrapper for storage drivers. Content
+// committed through the driver is mirrored to a secondary driver, such as a
+// bucket in another region, either synchronously or through a persistent
+// queue, and reads fail over to the secondary driver when the primary one
+// fails.
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+const (
+	modeSync  = "sync"
+	modeAsync = "async"
+)
+
+var (
+	replicationFailures = prometheus.StorageNamespace.NewCounter("replicate_failures", "The number of failed replications to the secondary driver")
+	readFailovers       = prometheus.StorageNamespace.NewCounter("replicate_read_failovers", "The number of reads served by the secondary driver after the primary one failed")
+)
+
+// replicateStorageMiddleware mirrors the writes, moves and deletes made
+// through the wrapped driver to a secondary driver.
+type replicateStorageMiddleware struct {
+	storagedriver.StorageDriver
+	secondary storagedriver.StorageDriver
+	queue     *queue // nil in sync mode
+}
+
+var _ storagedriver.StorageDriver = &replicateStorageMiddleware{}
+
+// newReplicateStorageMiddleware constructs and returns a new replicating
+// storage middleware.
+//
+// Required options:
+//
+//   - driver: the name of the secondary storage driver
+//
+// Optional options:
+//
+//   - parameters: the parameters of the secondary storage driver
+//   - mode: sync, to replicate before each call returns, which is the
+//     default, or async, to replicate in the background
+//   - queuedirectory: the local directory persisting the operations still
+//     to be replicated, required in async mode
+func newReplicateStorageMiddleware(storageDriver storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	secondary, err := SecondaryDriver(options)
+	if err != nil {
+		return nil, err
+	}
+
+	mode := modeSync
+	if m, ok := options["mode"]; ok {
+		if mode, ok = m.(string); !ok || (mode != modeSync && mode != modeAsync) {
+			return nil, fmt.Errorf("mode must be %s or %s", modeSync, modeAsync)
+		}
+	}
+
+	d := &replicateStorageMiddleware{StorageDriver: storageDriver, secondary: secondary}
+	if mode == modeAsync {
+		dir, ok := options["queuedirectory"].(string)
+		if !ok || dir == "" {
+			return nil, fmt.Errorf("queuedirectory must be a non-empty string in %s mode", modeAsync)
+		}
+		d.queue, err = newQueue(dir, d.apply)
+		if err != nil {
+			return nil, err
+		}
+		go d.queue.run(dcontext.Background())
+	}
+	return d, nil
+}
+
+// SecondaryDriver constructs the secondary driver described by the options
+// of the replicate middleware.
+func SecondaryDriver(options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	n, ok := options["driver"]
+	if !ok {
+		return nil, fmt.Errorf("no secondary driver provided")
+	}
+	name, ok := n.(string)
+	if !ok || name == "" {
+		return nil, fmt.Errorf("driver must be a non-empty string")
+	}
+
+	parameters := map[string]interface{}{}
+	if p, ok := options["parameters"]; ok && p != nil {
+		switch p := p.(type) {
+		case map[string]interface{}:
+			parameters = p
+		case map[interface{}]interface{}:
+			for k, v := range p {
+				key, ok := k.(string)
+				if !ok {
+					return nil, fmt.Errorf("parameters must be a map of strings")
+				}
+				parameters[key] = v
+			}
+		default:
+			return nil, fmt.Errorf("parameters must be a map")
+		}
+	}
+
+	driver, err := factory.Create(name, parameters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct secondary %s driver: %v", name, err)
+	}
+	return driver, nil
+}
+
+// GetContent returns the content at path, from the secondary driver if the
+// primary one fails.
+func (d *replicateStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	content, err := d.StorageDriver.GetContent(ctx, path)
+	if shouldFailover(ctx, err) {
+		if c, serr := d.secondary.GetContent(ctx, path); serr == nil {
+			logFailover(ctx, path, err)
+			return c, nil
+		}
+	}
+	return content, err
+}
+
+// Reader returns a reader of the content at path from offset, from the
+// secondary driver if the primary one fails.
+func (d *replicateStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	rc, err := d.StorageDriver.Reader(ctx, path, offset)
+	if shouldFailover(ctx, err) {
+		if src, serr := d.secondary.Reader(ctx, path, offset); serr == nil {
+			logFailover(ctx, path, err)
+			return src, nil
+		}
+	}
+	return rc, err
+}
+
+// Stat returns info about the object at path, from the secondary driver if
+// the primary one fails.
+func (d *replicateStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	fi, err := d.StorageDriver.Stat(ctx, path)
+	if shouldFailover(ctx, err) {
+		if sfi, serr := d.secondary.Stat(ctx, path); serr == nil {
+			logFailover(ctx, path, err)
+			return sfi, nil
+		}
+	}
+	return fi, err
+}
+
+// List returns the children of path, from the secondary driver if the
+// primary one fails.
+func (d *replicateStorageMiddleware) List(ctx context.Context, path string) ([]string, error) {
+	children, err := d.StorageDriver.List(ctx, path)
+	if shouldFailover(ctx, err) {
+		if sc, serr := d.secondary.List(ctx, path); serr == nil {
+			logFailover(ctx, path, err)
+			return sc, nil
+		}
+	}
+	return children, err
+}
+
+// PutContent stores content at path and replicates it.
+func (d *replicateStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	if err := d.StorageDriver.PutContent(ctx, path, content); err != nil {
+		return err
+	}
+	if d.queue == nil {
+		return d.replicated(ctx, d.secondary.PutContent(ctx, path, content))
+	}
+	return d.queue.push(operation{Op: opCopy, Path: path})
+}
+
+// Writer returns a FileWriter for path which replicates the content on
+// commit.
+func (d *replicateStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	fw, err := d.StorageDriver.Writer(ctx, path, append)
+	if err != nil {
+		return nil, err
+	}
+	return &replicatingWriter{FileWriter: fw, ctx: ctx, d: d, path: path}, nil
+}
+
+// Move moves the object at sourcePath to destPath and replicates the move.
+func (d *replicateStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	if err := d.StorageDriver.Move(ctx, sourcePath, destPath); err != nil {
+		return err
+	}
+	return d.replicate(ctx, operation{Op: opMove, Path: sourcePath, Dest: destPath})
+}
+
+// Delete deletes the objects at and below path and replicates the delete.
+func (d *replicateStorageMiddleware) Delete(ctx context.Context, path string) error {
+	if err := d.StorageDriver.Delete(ctx, path); err != nil {
+		return err
+	}
+	return d.replicate(ctx, operation{Op: opDelete, Path: path})
+}
+
+// replicate applies op to the secondary driver in sync mode, or queues it in
+// async mode.
+func (d *replicateStorageMiddleware) replicate(ctx context.Context, op operation) error {
+	if d.queue == nil {
+		return d.replicated(ctx, d.apply(ctx, op))
+	}
+	return d.queue.push(op)
+}
+
+// replicated logs and counts the failure of a synchronous replication.
+func (d *replicateStorageMiddleware) replicated(ctx context.Context, err error) error {
+	if err != nil {
+		replicationFailures.Inc(1)
+		dcontext.GetLogger(ctx).Errorf("replicate: failed to replicate to the secondary driver: %v", err)
+		return fmt.Errorf("failed to replicate to the secondary driver: %v", err)
+	}
+	return nil
+}
+
+// apply applies op to the secondary driver. Operations are idempotent: they
+// copy the current content of the primary driver rather than the content
+// they were queued with, so they can be retried and replayed in order.
+func (d *replicateStorageMiddleware) apply(ctx context.Context, op operation) error {
+	switch op.Op {
+	case opCopy:
+		return ignoreNotFound(copyObject(ctx, d.StorageDriver, d.secondary, op.Path))
+	case opMove:
+		// the source is usually an upload which was replicated on commit, so
+		// moving it is cheaper than copying the destination again
+		err := d.secondary.Move(ctx, op.Path, op.Dest)
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			err = ignoreNotFound(copyObject(ctx, d.StorageDriver, d.secondary, op.Dest))
+		}
+		return err
+	case opDelete:
+		return ignoreNotFound(d.secondary.Delete(ctx, op.Path))
+	default:
+		return fmt.Errorf("unknown operation %q", op.Op)
+	}
+}
+
+// replicatingWriter replicates the content written to path when it is
+// committed.
+type replicatingWriter struct {
+	storagedriver.FileWriter
+	ctx  context.Context
+	d    *replicateStorageMiddleware
+	path string
+}
+
+func (w *replicatingWriter) Commit() error {
+	if err := w.FileWriter.Commit(); err != nil {
+		return err
+	}
+	return w.d.replicate(w.ctx, operation{Op: opCopy, Path: w.path})
+}
+
+// copyObject copies the object at path from one driver to another.
+func copyObject(ctx context.Context, from, to storagedriver.StorageDriver, path string) error {
+	rc, err := from.Reader(ctx, path, 0)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	fw, err := to.Writer(ctx, path, false)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(fw, rc); err != nil {
+		fw.Cancel(ctx)
+		fw.Close()
+		return err
+	}
+	if err := fw.Commit(); err != nil {
+		fw.Close()
+		return err
+	}
+	return fw.Close()
+}
+
+func ignoreNotFound(err error) error {
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// shouldFailover returns true if err is a failure of the primary driver,
+// rather than an answer the secondary driver could not improve on.
+func shouldFailover(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	switch err.(type) {
+	case storagedriver.PathNotFoundError, storagedriver.InvalidPathError, storagedriver.InvalidOffsetError:
+		return false
+	}
+	return true
+}
+
+func logFailover(ctx context.Context, path string, err error) {
+	readFailovers.Inc(1)
+	dcontext.GetLogger(ctx).Warnf("replicate: read %s from the secondary driver after the primary one failed: %v", path, err)
+}
+
+// init registers the replicate middleware backend.
+func init() {
+	storagemiddleware.Register("replicate", newReplicateStorageMiddleware)
+}
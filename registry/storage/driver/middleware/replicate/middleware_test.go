@@ -0,0 +1,376 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+const (
+	uploadPath = "/docker/registry/v2/repositories/foo/_uploads/1234/data"
+	blobPath   = "/docker/registry/v2/blobs/sha256/ab/abcdef/data"
+	linkPath   = "/docker/registry/v2/repositories/foo/_layers/sha256/abcdef/link"
+)
+
+// failingDriver is an in-memory driver whose reads and writes fail while
+// failing is set.
+type failingDriver struct {
+	*inmemory.Driver
+	failing bool
+}
+
+var errUnavailable = errors.New("backend unavailable")
+
+func (d *failingDriver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if d.failing {
+		return nil, errUnavailable
+	}
+	return d.Driver.GetContent(ctx, path)
+}
+
+func (d *failingDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if d.failing {
+		return nil, errUnavailable
+	}
+	return d.Driver.Reader(ctx, path, offset)
+}
+
+func (d *failingDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	if d.failing {
+		return nil, errUnavailable
+	}
+	return d.Driver.Stat(ctx, path)
+}
+
+func (d *failingDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	if d.failing {
+		return errUnavailable
+	}
+	return d.Driver.PutContent(ctx, path, content)
+}
+
+func (d *failingDriver) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	if d.failing {
+		return nil, errUnavailable
+	}
+	return d.Driver.Writer(ctx, path, append)
+}
+
+func (d *failingDriver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	if d.failing {
+		return errUnavailable
+	}
+	return d.Driver.Move(ctx, sourcePath, destPath)
+}
+
+// pushBlob writes a blob the way the registry does: written to an upload,
+// moved into the blob store and linked into a repository.
+func pushBlob(t *testing.T, d storagedriver.StorageDriver, content []byte) {
+	t.Helper()
+	ctx := context.Background()
+	fw, err := d.Writer(ctx, uploadPath, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Commit(); err != nil {
+		t.Fatalf("unexpected error committing: %v", err)
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Move(ctx, uploadPath, blobPath); err != nil {
+		t.Fatalf("unexpected error moving: %v", err)
+	}
+	if err := d.PutContent(ctx, linkPath, []byte("sha256:abcdef")); err != nil {
+		t.Fatalf("unexpected error putting content: %v", err)
+	}
+}
+
+func checkContent(t *testing.T, d storagedriver.StorageDriver, path string, expected []byte) {
+	t.Helper()
+	content, err := d.GetContent(context.Background(), path)
+	if err != nil {
+		t.Fatalf("unexpected error reading %s: %v", path, err)
+	}
+	if !bytes.Equal(content, expected) {
+		t.Fatalf("unexpected content at %s: %q != %q", path, content, expected)
+	}
+}
+
+func checkNotFound(t *testing.T, d storagedriver.StorageDriver, path string) {
+	t.Helper()
+	if _, err := d.Stat(context.Background(), path); !isNotFound(err) {
+		t.Fatalf("expected %s not to be found, got %v", path, err)
+	}
+}
+
+func TestNoConfig(t *testing.T) {
+	if _, err := newReplicateStorageMiddleware(inmemory.New(), map[string]interface{}{}); err == nil || err.Error() != "no secondary driver provided" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := newReplicateStorageMiddleware(inmemory.New(), map[string]interface{}{"driver": "inmemory", "mode": "eventually"}); err == nil {
+		t.Fatal("expected error for invalid mode")
+	}
+	if _, err := newReplicateStorageMiddleware(inmemory.New(), map[string]interface{}{"driver": "inmemory", "mode": "async"}); err == nil {
+		t.Fatal("expected error for async mode without queue directory")
+	}
+	if _, err := newReplicateStorageMiddleware(inmemory.New(), map[string]interface{}{
+		"driver":     "inmemory",
+		"parameters": map[interface{}]interface{}{"foo": "bar"},
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestSyncReplication(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := inmemory.New(), &failingDriver{Driver: inmemory.New()}
+	d := &replicateStorageMiddleware{StorageDriver: primary, secondary: secondary}
+
+	blob := []byte("layer contents")
+	pushBlob(t, d, blob)
+	checkContent(t, secondary, blobPath, blob)
+	checkContent(t, secondary, linkPath, []byte("sha256:abcdef"))
+	checkNotFound(t, secondary, uploadPath)
+
+	if err := d.Delete(ctx, "/docker/registry/v2/repositories/foo"); err != nil {
+		t.Fatal(err)
+	}
+	checkNotFound(t, secondary, linkPath)
+
+	// failures of the secondary driver are returned
+	secondary.failing = true
+	if err := d.PutContent(ctx, linkPath, []byte("sha256:abcdef")); err == nil {
+		t.Fatal("expected error when the secondary driver fails")
+	}
+}
+
+func TestReadFailover(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := &failingDriver{Driver: inmemory.New()}, inmemory.New()
+	d := &replicateStorageMiddleware{StorageDriver: primary, secondary: secondary}
+
+	blob := []byte("layer contents")
+	pushBlob(t, d, blob)
+
+	primary.failing = true
+	checkContent(t, d, blobPath, blob)
+	rc, err := d.Reader(ctx, blobPath, 6)
+	if err != nil {
+		t.Fatalf("unexpected error reading from the secondary driver: %v", err)
+	}
+	content, _ := io.ReadAll(rc)
+	rc.Close()
+	if !bytes.Equal(content, blob[6:]) {
+		t.Fatalf("unexpected content %q", content)
+	}
+	if fi, err := d.Stat(ctx, blobPath); err != nil || fi.Size() != int64(len(blob)) {
+		t.Fatalf("unexpected stat %v: %v", fi, err)
+	}
+
+	// missing paths are not looked up in the secondary driver
+	primary.failing = false
+	if err := secondary.PutContent(ctx, "/only/secondary", []byte("stale")); err != nil {
+		t.Fatal(err)
+	}
+	checkNotFound(t, d, "/only/secondary")
+}
+
+func TestAsyncReplication(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	dir := t.TempDir()
+	primary, secondary := inmemory.New(), &failingDriver{Driver: inmemory.New(), failing: true}
+
+	d := &replicateStorageMiddleware{StorageDriver: primary, secondary: secondary}
+	var err error
+	if d.queue, err = newQueue(dir, d.apply); err != nil {
+		t.Fatal(err)
+	}
+
+	// writes succeed while the secondary driver is down
+	blob := []byte("layer contents")
+	pushBlob(t, d, blob)
+	if err := d.Delete(ctx, linkPath); err != nil {
+		t.Fatal(err)
+	}
+	if n := d.queue.len(); n != 4 {
+		t.Fatalf("expected 4 queued operations, got %d", n)
+	}
+
+	// queued operations survive a restart
+	if d.queue, err = newQueue(dir, d.apply); err != nil {
+		t.Fatal(err)
+	}
+	if n := d.queue.len(); n != 4 {
+		t.Fatalf("expected 4 queued operations after restart, got %d", n)
+	}
+
+	secondary.failing = false
+	go d.queue.run(ctx)
+	deadline := time.Now().Add(10 * time.Second)
+	for d.queue.len() > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the queue to drain")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	checkContent(t, secondary, blobPath, blob)
+	checkNotFound(t, secondary, linkPath)
+	checkNotFound(t, secondary, uploadPath)
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.Name() != lockFile {
+			t.Fatalf("expected applied operations to be removed, found %s", entry.Name())
+		}
+	}
+}
+
+func TestSharedQueueDirectory(t *testing.T) {
+	dir := t.TempDir()
+	secondary := inmemory.New()
+	var mu sync.Mutex
+	var applied []string
+	apply := func(ctx context.Context, op operation) error {
+		mu.Lock()
+		defer mu.Unlock()
+		applied = append(applied, op.Path)
+		return secondary.PutContent(ctx, op.Path, []byte(op.Path))
+	}
+
+	// a registry and a maintenance command queueing to the same directory
+	server, err := newQueue(dir, apply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	command, err := newQueue(dir, apply)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.rescanInterval = 10 * time.Millisecond
+	command.rescanInterval = 10 * time.Millisecond
+	for i := 0; i < 10; i++ {
+		for _, q := range []*queue{server, command} {
+			if err := q.push(operation{Op: opCopy, Path: fmt.Sprintf("/%d", i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if n := server.len(); n != 20 {
+		t.Fatalf("expected the operations of both processes to be queued, got %d", n)
+	}
+
+	// only the process holding the lock applies the operations, and the
+	// other one takes over when it stops
+	serverCtx, stopServer := context.WithCancel(context.Background())
+	serverDone := make(chan struct{})
+	go func() {
+		defer close(serverDone)
+		server.run(serverCtx)
+	}()
+	waitDrained := func() {
+		t.Helper()
+		deadline := time.Now().Add(10 * time.Second)
+		for server.len() > 0 {
+			if time.Now().After(deadline) {
+				t.Fatal("timed out waiting for the queue to drain")
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitDrained()
+
+	commandCtx, stopCommand := context.WithCancel(context.Background())
+	defer stopCommand()
+	go command.run(commandCtx)
+
+	// operations queued by another process are picked up by a rescan
+	if err := command.push(operation{Op: opCopy, Path: "/command"}); err != nil {
+		t.Fatal(err)
+	}
+	waitDrained()
+	stopServer()
+	<-serverDone
+
+	if err := server.push(operation{Op: opCopy, Path: "/takeover"}); err != nil {
+		t.Fatal(err)
+	}
+	waitDrained()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(applied) != 22 {
+		t.Fatalf("expected each operation to be applied once, got %d: %v", len(applied), applied)
+	}
+	for i := 0; i < 10; i++ {
+		for _, path := range applied[2*i : 2*i+2] {
+			if path != fmt.Sprintf("/%d", i) {
+				t.Fatalf("unexpected order of applied operations: %v", applied)
+			}
+		}
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	ctx := context.Background()
+	primary, secondary := inmemory.New(), inmemory.New()
+
+	for path, content := range map[string]string{
+		blobPath:   "layer contents",
+		linkPath:   "sha256:abcdef",
+		uploadPath: "in progress",
+	} {
+		if err := primary.PutContent(ctx, path, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := secondary.PutContent(ctx, blobPath, []byte("truncated")); err != nil {
+		t.Fatal(err)
+	}
+	// a link to another digest of the same length, from a lost re-tag
+	if err := secondary.PutContent(ctx, linkPath, []byte("sha256:012345")); err != nil {
+		t.Fatal(err)
+	}
+	if err := secondary.PutContent(ctx, "/docker/registry/v2/blobs/sha256/de/deleted/data", []byte("gone")); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := Reconcile(ctx, primary, secondary, ReconcileOpts{DryRun: true, Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Checked != 2 || result.Copied != 2 || result.Deleted != 1 {
+		t.Fatalf("unexpected dry run result %+v", result)
+	}
+	checkContent(t, secondary, blobPath, []byte("truncated"))
+
+	if _, err := Reconcile(ctx, primary, secondary, ReconcileOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	checkContent(t, secondary, blobPath, []byte("layer contents"))
+	checkContent(t, secondary, linkPath, []byte("sha256:abcdef"))
+	checkNotFound(t, secondary, uploadPath)
+	checkContent(t, secondary, "/docker/registry/v2/blobs/sha256/de/deleted/data", []byte("gone"))
+
+	result, err = Reconcile(ctx, primary, secondary, ReconcileOpts{Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Copied != 0 || result.Deleted != 1 {
+		t.Fatalf("unexpected result %+v", result)
+	}
+	checkNotFound(t, secondary, "/docker/registry/v2/blobs/sha256/de/deleted/data")
+}
@@ -0,0 +1,246 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+)
+
+const (
+	opCopy   = "copy"
+	opMove   = "move"
+	opDelete = "delete"
+
+	// queueFileSuffix is the suffix of the files holding queued operations.
+	queueFileSuffix = ".json"
+
+	// lockFile is the file of the queue directory locked by the process
+	// applying the queued operations.
+	lockFile = ".lock"
+
+	// staleTmpAge is the age after which the temporary files of pushes
+	// which did not complete are removed.
+	staleTmpAge = time.Hour
+
+	// defaultRescanInterval is the interval at which the queue directory
+	// is scanned for operations pushed by other processes, and at which a
+	// process waiting for the lock of the directory tries to take it.
+	defaultRescanInterval = 10 * time.Second
+
+	minRetryDelay = time.Second
+	maxRetryDelay = time.Minute
+)
+
+var queueLength = prometheus.StorageNamespace.NewGauge("replicate_pending", "The gauge of operations waiting to be replicated to the secondary driver", metrics.Total)
+
+// operation is a change to replicate to the secondary driver.
+type operation struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+	Dest string `json:"dest,omitempty"`
+}
+
+// queue persists operations in a local directory, one file per operation,
+// and applies them in the order they were pushed, retrying each until it
+// succeeds. Operations left in the directory are resumed on start.
+//
+// The directory may be shared by several processes, such as the registry and
+// the maintenance commands using the same configuration. Each process pushes
+// its operations under names of its own, and only the process holding the
+// lock of the directory applies them, picking up the operations pushed by the
+// others when it scans the directory.
+type queue struct {
+	dir            string
+	apply          func(context.Context, operation) error
+	rescanInterval time.Duration
+
+	mu     sync.Mutex
+	last   int64 // time of the last operation pushed, in nanoseconds
+	notify chan struct{}
+}
+
+func newQueue(dir string, apply func(context.Context, operation) error) (*queue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &queue{dir: dir, apply: apply, rescanInterval: defaultRescanInterval, notify: make(chan struct{}, 1)}, nil
+}
+
+// pending returns the names of the files of the queued operations, in order.
+func (q *queue) pending() ([]string, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), queueFileSuffix) {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// push persists op and queues it.
+func (q *queue) push(op operation) error {
+	content, err := json.Marshal(op)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// The files are named after the time of the operation, zero padded for
+	// them to sort in order, and kept increasing should the clock go back.
+	// The process id keeps the names of processes sharing the directory
+	// apart.
+	now := time.Now().UnixNano()
+	if now <= q.last {
+		now = q.last + 1
+	}
+	name := fmt.Sprintf("%020d-%d%s", now, os.Getpid(), queueFileSuffix)
+	tmp := filepath.Join(q.dir, name+".tmp")
+	if err := os.WriteFile(tmp, content, 0o644); err != nil {
+		return fmt.Errorf("failed to queue replication: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(q.dir, name)); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to queue replication: %v", err)
+	}
+	q.last = now
+
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// len returns the number of queued operations.
+func (q *queue) len() int {
+	names, _ := q.pending()
+	return len(names)
+}
+
+// run applies the queued operations until ctx is done, once it holds the
+// lock of the directory.
+func (q *queue) run(ctx context.Context) {
+	lock, err := q.lock(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			dcontext.GetLogger(ctx).Errorf("replicate: failed to lock the queue directory, operations are not replicated: %v", err)
+		}
+		return
+	}
+	defer lock.Close()
+	q.removeStale()
+
+	delay := minRetryDelay
+	for {
+		names, err := q.pending()
+		if err == nil {
+			queueLength.Set(float64(len(names)))
+			if len(names) == 0 {
+				select {
+				case <-q.notify:
+				case <-time.After(q.rescanInterval):
+				case <-ctx.Done():
+					return
+				}
+				continue
+			}
+			err = q.applyFile(ctx, names[0])
+		}
+
+		if err != nil {
+			replicationFailures.Inc(1)
+			dcontext.GetLogger(ctx).Errorf("replicate: failed to replicate to the secondary driver, retrying in %v: %v", delay, err)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+			if delay *= 2; delay > maxRetryDelay {
+				delay = maxRetryDelay
+			}
+			continue
+		}
+		delay = minRetryDelay
+	}
+}
+
+// lock takes the lock of the directory, waiting for the process holding it
+// to release it. The lock is released by closing the returned file.
+func (q *queue) lock(ctx context.Context) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(q.dir, lockFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		locked, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if locked {
+			return f, nil
+		}
+		select {
+		case <-time.After(q.rescanInterval):
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// removeStale removes the temporary files left behind by pushes which did
+// not complete. Recent ones may belong to pushes in progress in other
+// processes, and are left alone.
+func (q *queue) removeStale() {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		if info, err := entry.Info(); err == nil && time.Since(info.ModTime()) > staleTmpAge {
+			os.Remove(filepath.Join(q.dir, entry.Name()))
+		}
+	}
+}
+
+// applyFile applies the operation persisted in the named file and removes
+// the file once it succeeded.
+func (q *queue) applyFile(ctx context.Context, name string) error {
+	path := filepath.Join(q.dir, name)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var op operation
+	if err := json.Unmarshal(content, &op); err != nil {
+		// a corrupt operation can never succeed, so it is dropped to
+		// unblock the queue, and left to the reconciliation
+		dcontext.GetLogger(ctx).Errorf("replicate: dropping corrupt queued operation %s: %v", path, err)
+		return os.Remove(path)
+	}
+	if err := q.apply(ctx, op); err != nil {
+		return fmt.Errorf("%s %s: %v", op.Op, op.Path, err)
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"path"
+	"regexp"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// maxCompareSize is the size up to which the content of objects is compared
+// by Reconcile, rather than their size only.
+const maxCompareSize = 1 << 20
+
+// blobDataPath matches the paths of blob data, which is content addressed:
+// an object at such a path of the right size holds the right content.
+var blobDataPath = regexp.MustCompile(`^/docker/registry/v2/blobs/[^/]+/[^/]+/[^/]+/data$`)
+
+// ReconcileOpts configures Reconcile.
+type ReconcileOpts struct {
+	// DryRun reports the differences without changing the secondary driver.
+	DryRun bool
+	// Delete removes the objects from the secondary driver which are not
+	// in the primary one.
+	Delete bool
+}
+
+// ReconcileResult counts the objects checked and changed by Reconcile.
+type ReconcileResult struct {
+	Checked     int   `json:"checked"`
+	Copied      int   `json:"copied"`
+	CopiedBytes int64 `json:"copiedBytes"`
+	Deleted     int   `json:"deleted"`
+}
+
+// Reconcile copies the objects of the primary driver which are missing from
+// the secondary driver, or differ from it, to the secondary driver. Objects
+// are compared by size, and by content too unless they are blob data or
+// larger than maxCompareSize, so that links rewritten with a digest of the
+// same length are caught.
+// It catches up with the writes the middleware could not replicate, such as
+// those made before it was enabled or dropped from a lost queue. Uploads in
+// progress are skipped.
+func Reconcile(ctx context.Context, primary, secondary storagedriver.StorageDriver, opts ReconcileOpts) (ReconcileResult, error) {
+	var result ReconcileResult
+
+	err := primary.Walk(ctx, "/", func(fi storagedriver.FileInfo) error {
+		if fi.IsDir() {
+			if path.Base(fi.Path()) == "_uploads" {
+				return storagedriver.ErrSkipDir
+			}
+			return nil
+		}
+		result.Checked++
+
+		sfi, err := secondary.Stat(ctx, fi.Path())
+		switch err.(type) {
+		case nil:
+			if !sfi.IsDir() && sfi.Size() == fi.Size() {
+				same, err := sameContent(ctx, primary, secondary, fi)
+				if err != nil || same {
+					return ignoreNotFound(err)
+				}
+			}
+		case storagedriver.PathNotFoundError:
+		default:
+			return err
+		}
+
+		dcontext.GetLogger(ctx).Infof("replicate: copying %s", fi.Path())
+		result.Copied++
+		result.CopiedBytes += fi.Size()
+		if opts.DryRun {
+			return nil
+		}
+		return ignoreNotFound(copyObject(ctx, primary, secondary, fi.Path()))
+	})
+	if err != nil && !isNotFound(err) {
+		return result, err
+	}
+
+	if !opts.Delete {
+		return result, nil
+	}
+	err = secondary.Walk(ctx, "/", func(fi storagedriver.FileInfo) error {
+		if fi.IsDir() {
+			if path.Base(fi.Path()) == "_uploads" {
+				return storagedriver.ErrSkipDir
+			}
+			return nil
+		}
+		if _, err := primary.Stat(ctx, fi.Path()); !isNotFound(err) {
+			return err
+		}
+
+		dcontext.GetLogger(ctx).Infof("replicate: deleting %s", fi.Path())
+		result.Deleted++
+		if opts.DryRun {
+			return nil
+		}
+		return ignoreNotFound(secondary.Delete(ctx, fi.Path()))
+	})
+	if err != nil && !isNotFound(err) {
+		return result, err
+	}
+	return result, nil
+}
+
+// sameContent reports whether the object described by fi has the same
+// content in the primary and the secondary driver, given that it has the same
+// size in both. Blob data and large objects are not compared.
+func sameContent(ctx context.Context, primary, secondary storagedriver.StorageDriver, fi storagedriver.FileInfo) (bool, error) {
+	if fi.Size() > maxCompareSize || blobDataPath.MatchString(fi.Path()) {
+		return true, nil
+	}
+	content, err := primary.GetContent(ctx, fi.Path())
+	if err != nil {
+		return false, err
+	}
+	replica, err := secondary.GetContent(ctx, fi.Path())
+	if err != nil {
+		if isNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return bytes.Equal(content, replica), nil
+}
+
+func isNotFound(err error) bool {
+	_, ok := err.(storagedriver.PathNotFoundError)
+	return ok
+}
@@ -0,0 +1,378 @@
+// Package middleware - signedurl wrapper for storage drivers. It redirects
+// clients downloading content to a CDN, or any web server, with expiring
+// URLs signed with a secret shared with the CDN, so that the CDN can serve
+// the content from the storage backend while only serving it to clients the
+// registry authorized.
+//
+// The URLs are signed in one of the following formats:
+//
+//   - query: the expiry time, the id of the signing key and an HMAC-SHA256
+//     signature of the URL path and the preceding query parameters are added
+//     to the query string.
+//   - nginx: the md5 and expires query parameters checked by the
+//     secure_link module of nginx, configured with
+//     secure_link_md5 "$secure_link_expires$uri <secret>".
+//   - secdownload: the path prefix checked by the mod_secdownload module of
+//     lighttpd, with the hmac-sha256 algorithm.
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+const (
+	// FormatQuery adds the expiry time, key id and HMAC-SHA256 signature to
+	// the query string.
+	FormatQuery = "query"
+	// FormatNginx adds the query parameters of the nginx secure_link module.
+	FormatNginx = "nginx"
+	// FormatSecDownload prefixes the path as the lighttpd mod_secdownload
+	// module expects.
+	FormatSecDownload = "secdownload"
+
+	defaultDuration = 20 * time.Minute
+)
+
+// signingKey is a secret shared with the CDN.
+type signingKey struct {
+	id        string
+	secret    []byte
+	notBefore time.Time
+}
+
+// queryParams are the names of the query parameters of the query format.
+type queryParams struct {
+	expires   string
+	keyID     string
+	signature string
+}
+
+// signedURLStorageMiddleware redirects to expiring signed URLs of a CDN.
+type signedURLStorageMiddleware struct {
+	storagedriver.StorageDriver
+	baseURL  *url.URL
+	format   string
+	duration time.Duration
+	params   queryParams
+	encoding *base64.Encoding // nil for hex encoded signatures
+	// keys are sorted from the most recent to the oldest
+	keys []signingKey
+	now  func() time.Time
+}
+
+var _ storagedriver.StorageDriver = &signedURLStorageMiddleware{}
+
+// newSignedURLStorageMiddleware constructs and returns a new signing
+// redirect storage middleware.
+//
+// Required options:
+//
+//   - baseurl: the SCHEME://HOST[/PATH] at which the CDN serves the content
+//   - key, keyfile or keys: the secret, the file holding it, or the list of
+//     keys, each with the keys id, secret or secretfile, and notbefore
+//
+// Optional options:
+//
+//   - keyid: the id of the key given by key or keyfile
+//   - format: query, nginx or secdownload, query by default
+//   - duration: the time the URLs are valid for, 20m by default
+//   - expiresparam, keyidparam, signatureparam: the names of the query
+//     parameters of the query format
+//   - encoding: hex or base64url, the encoding of the signatures of the query
+//     format, hex by default
+func newSignedURLStorageMiddleware(storageDriver storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	m := &signedURLStorageMiddleware{
+		StorageDriver: storageDriver,
+		format:        FormatQuery,
+		duration:      defaultDuration,
+		params: queryParams{
+			expires:   "expires",
+			keyID:     "keyid",
+			signature: "signature",
+		},
+		now: time.Now,
+	}
+
+	b, ok := options["baseurl"]
+	if !ok {
+		return nil, fmt.Errorf("no baseurl provided")
+	}
+	baseURL, ok := b.(string)
+	if !ok {
+		return nil, fmt.Errorf("baseurl must be a string")
+	}
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse signedurl baseurl: %s", baseURL)
+	}
+	if u.Scheme == "" {
+		return nil, fmt.Errorf("no scheme specified for signedurl baseurl")
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("no host specified for signedurl baseurl")
+	}
+	m.baseURL = u
+
+	if f, ok := options["format"]; ok {
+		format, ok := f.(string)
+		if !ok {
+			return nil, fmt.Errorf("format must be a string")
+		}
+		switch format {
+		case FormatQuery, FormatNginx, FormatSecDownload:
+			m.format = format
+		default:
+			return nil, fmt.Errorf("format only allows the following values: query|nginx|secdownload")
+		}
+	}
+
+	if d, ok := options["duration"]; ok {
+		switch d := d.(type) {
+		case time.Duration:
+			m.duration = d
+		case string:
+			m.duration, err = time.ParseDuration(d)
+			if err != nil {
+				return nil, fmt.Errorf("invalid duration: %s", err)
+			}
+		default:
+			return nil, fmt.Errorf("duration must be a duration")
+		}
+		if m.duration <= 0 {
+			return nil, fmt.Errorf("duration must be positive")
+		}
+	}
+
+	for name, param := range map[string]*string{
+		"expiresparam":   &m.params.expires,
+		"keyidparam":     &m.params.keyID,
+		"signatureparam": &m.params.signature,
+	} {
+		if p, ok := options[name]; ok {
+			s, ok := p.(string)
+			if !ok || s == "" {
+				return nil, fmt.Errorf("%s must be a non-empty string", name)
+			}
+			*param = s
+		}
+	}
+
+	if e, ok := options["encoding"]; ok {
+		switch e {
+		case "hex":
+		case "base64url":
+			m.encoding = base64.RawURLEncoding
+		default:
+			return nil, fmt.Errorf("encoding only allows the following values: hex|base64url")
+		}
+	}
+
+	m.keys, err = parseKeys(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// parseKeys reads the signing keys from the key, keyfile and keyid options,
+// or from the keys option.
+func parseKeys(options map[string]interface{}) ([]signingKey, error) {
+	_, hasKey := options["key"]
+	_, hasKeyFile := options["keyfile"]
+	list, hasKeys := options["keys"]
+
+	var keys []signingKey
+	switch {
+	case hasKeys && (hasKey || hasKeyFile):
+		return nil, fmt.Errorf("keys cannot be combined with key or keyfile")
+	case hasKeys:
+		items, ok := list.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("keys must be a list")
+		}
+		for i, item := range items {
+			key, err := parseKey(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key %d: %v", i, err)
+			}
+			keys = append(keys, key)
+		}
+	case hasKey || hasKeyFile:
+		key, err := parseKey(map[string]interface{}{
+			"id":         options["keyid"],
+			"secret":     options["key"],
+			"secretfile": options["keyfile"],
+		})
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no key, keyfile or keys provided")
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].notBefore.After(keys[j].notBefore)
+	})
+	return keys, nil
+}
+
+func parseKey(item interface{}) (signingKey, error) {
+	options := map[string]interface{}{}
+	switch item := item.(type) {
+	case map[string]interface{}:
+		options = item
+	case map[interface{}]interface{}:
+		for k, v := range item {
+			key, ok := k.(string)
+			if !ok {
+				return signingKey{}, fmt.Errorf("key must be a map of strings")
+			}
+			options[key] = v
+		}
+	default:
+		return signingKey{}, fmt.Errorf("key must be a map")
+	}
+
+	if options["secret"] != nil && options["secretfile"] != nil {
+		return signingKey{}, fmt.Errorf("secret cannot be combined with secretfile")
+	}
+
+	var key signingKey
+	for name, value := range options {
+		if value == nil {
+			continue
+		}
+		switch name {
+		case "id":
+			s, ok := value.(string)
+			if !ok {
+				return signingKey{}, fmt.Errorf("id must be a string")
+			}
+			key.id = s
+		case "secret":
+			s, ok := value.(string)
+			if !ok {
+				return signingKey{}, fmt.Errorf("secret must be a string")
+			}
+			key.secret = []byte(s)
+		case "secretfile":
+			s, ok := value.(string)
+			if !ok {
+				return signingKey{}, fmt.Errorf("secretfile must be a string")
+			}
+			content, err := os.ReadFile(s)
+			if err != nil {
+				return signingKey{}, fmt.Errorf("failed to read secretfile: %v", err)
+			}
+			key.secret = []byte(strings.TrimSpace(string(content)))
+		case "notbefore":
+			switch v := value.(type) {
+			case time.Time:
+				key.notBefore = v
+			case string:
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					return signingKey{}, fmt.Errorf("invalid notbefore: %v", err)
+				}
+				key.notBefore = t
+			default:
+				return signingKey{}, fmt.Errorf("notbefore must be a RFC 3339 time")
+			}
+		default:
+			return signingKey{}, fmt.Errorf("unknown option %q", name)
+		}
+	}
+	if len(key.secret) == 0 {
+		return signingKey{}, fmt.Errorf("no secret provided")
+	}
+	return key, nil
+}
+
+// activeKey returns the most recent key valid at now.
+func (m *signedURLStorageMiddleware) activeKey(now time.Time) (signingKey, bool) {
+	for _, key := range m.keys {
+		if !key.notBefore.After(now) {
+			return key, true
+		}
+	}
+	return signingKey{}, false
+}
+
+// URLFor returns a signed URL of the CDN for the content at path, valid for
+// the configured duration. Only GET and HEAD requests are redirected.
+func (m *signedURLStorageMiddleware) URLFor(ctx context.Context, urlPath string, options map[string]interface{}) (string, error) {
+	if method, ok := options["method"].(string); ok && method != "GET" && method != "HEAD" {
+		return "", storagedriver.ErrUnsupportedMethod{}
+	}
+
+	now := m.now()
+	key, ok := m.activeKey(now)
+	if !ok {
+		// serve the content from the registry until a key is valid
+		dcontext.GetLogger(ctx).Warn("signedurl: no signing key is valid yet")
+		return "", storagedriver.ErrUnsupportedMethod{}
+	}
+	expires := now.Add(m.duration).Unix()
+
+	u := *m.baseURL
+	switch m.format {
+	case FormatNginx:
+		u.Path = path.Join(m.baseURL.Path, urlPath)
+		sum := md5.Sum([]byte(strconv.FormatInt(expires, 10) + u.Path + " " + string(key.secret)))
+		u.RawQuery = "md5=" + base64.RawURLEncoding.EncodeToString(sum[:]) + "&expires=" + strconv.FormatInt(expires, 10)
+	case FormatSecDownload:
+		protected := fmt.Sprintf("/%08x", expires) + path.Join("/", urlPath)
+		token := base64.RawURLEncoding.EncodeToString(m.sign(key, protected))
+		u.Path = path.Join(m.baseURL.Path, token) + protected
+	default:
+		u.Path = path.Join(m.baseURL.Path, urlPath)
+		query := url.Values{}
+		query.Set(m.params.expires, strconv.FormatInt(expires, 10))
+		if key.id != "" {
+			query.Set(m.params.keyID, key.id)
+		}
+		u.RawQuery = query.Encode()
+		signature := m.sign(key, u.EscapedPath()+"?"+u.RawQuery)
+		u.RawQuery += "&" + url.QueryEscape(m.params.signature) + "=" + m.encode(signature)
+	}
+	return u.String(), nil
+}
+
+// sign returns the HMAC-SHA256 of message with key.
+func (m *signedURLStorageMiddleware) sign(key signingKey, message string) []byte {
+	mac := hmac.New(sha256.New, key.secret)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+func (m *signedURLStorageMiddleware) encode(signature []byte) string {
+	if m.encoding == nil {
+		return hex.EncodeToString(signature)
+	}
+	return m.encoding.EncodeToString(signature)
+}
+
+// init registers the signedurl storage middleware.
+func init() {
+	storagemiddleware.Register("signedurl", newSignedURLStorageMiddleware)
+}
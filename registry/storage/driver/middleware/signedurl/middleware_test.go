@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+const blobPath = "/docker/registry/v2/blobs/sha256/ab/abcdef/data"
+
+var now = time.Unix(1700000000, 0)
+
+func newMiddleware(t *testing.T, options map[string]interface{}) *signedURLStorageMiddleware {
+	t.Helper()
+	d, err := newSignedURLStorageMiddleware(inmemory.New(), options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := d.(*signedURLStorageMiddleware)
+	m.now = func() time.Time { return now }
+	return m
+}
+
+func hmacSHA256(secret, message string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
+
+func TestInvalidOptions(t *testing.T) {
+	for _, options := range []map[string]interface{}{
+		{"key": "secret"},
+		{"baseurl": "cdn.example.com", "key": "secret"},
+		{"baseurl": "https://cdn.example.com"},
+		{"baseurl": "https://cdn.example.com", "key": "secret", "format": "cloudfront"},
+		{"baseurl": "https://cdn.example.com", "key": "secret", "duration": "-1m"},
+		{"baseurl": "https://cdn.example.com", "key": "secret", "encoding": "base32"},
+		{"baseurl": "https://cdn.example.com", "key": "secret", "keys": []interface{}{}},
+		{"baseurl": "https://cdn.example.com", "keys": []interface{}{
+			map[interface{}]interface{}{"id": "a"},
+		}},
+		{"baseurl": "https://cdn.example.com", "keys": []interface{}{
+			map[interface{}]interface{}{"secret": "a", "notbefore": "tomorrow"},
+		}},
+	} {
+		if _, err := newSignedURLStorageMiddleware(nil, options); err == nil {
+			t.Errorf("expected an error for %v", options)
+		}
+	}
+}
+
+func TestQueryFormat(t *testing.T) {
+	m := newMiddleware(t, map[string]interface{}{
+		"baseurl":  "https://cdn.example.com/registry",
+		"key":      "secret",
+		"keyid":    "k1",
+		"duration": "10m",
+	})
+
+	signed, err := m.URLFor(context.Background(), blobPath, map[string]interface{}{"method": "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Host != "cdn.example.com" || u.Path != "/registry"+blobPath {
+		t.Fatalf("unexpected url %s", signed)
+	}
+	query := u.Query()
+	if query.Get("expires") != "1700000600" || query.Get("keyid") != "k1" {
+		t.Fatalf("unexpected query %s", u.RawQuery)
+	}
+
+	// the signature covers the path and the query preceding it
+	unsigned, _, _ := strings.Cut(signed, "&signature=")
+	message := strings.TrimPrefix(unsigned, "https://cdn.example.com")
+	if query.Get("signature") != hex.EncodeToString(hmacSHA256("secret", message)) {
+		t.Fatalf("unexpected signature in %s", signed)
+	}
+}
+
+func TestQueryFormatParameters(t *testing.T) {
+	m := newMiddleware(t, map[string]interface{}{
+		"baseurl":        "https://cdn.example.com",
+		"key":            "secret",
+		"expiresparam":   "e",
+		"signatureparam": "s",
+		"encoding":       "base64url",
+	})
+
+	signed, err := m.URLFor(context.Background(), blobPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "https://cdn.example.com" + blobPath + "?e=1700001200"
+	signature := base64.RawURLEncoding.EncodeToString(hmacSHA256("secret", blobPath+"?e=1700001200"))
+	if signed != expected+"&s="+signature {
+		t.Fatalf("unexpected url %s", signed)
+	}
+}
+
+func TestNginxFormat(t *testing.T) {
+	m := newMiddleware(t, map[string]interface{}{
+		"baseurl": "https://cdn.example.com/r",
+		"key":     "secret",
+		"format":  "nginx",
+	})
+
+	signed, err := m.URLFor(context.Background(), blobPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// echo -n '1700001200/r/docker/... secret' | openssl md5 -binary | openssl base64 | tr +/ -_ | tr -d =
+	sum := md5.Sum([]byte("1700001200/r" + blobPath + " secret"))
+	expected := "https://cdn.example.com/r" + blobPath + "?md5=" + base64.RawURLEncoding.EncodeToString(sum[:]) + "&expires=1700001200"
+	if signed != expected {
+		t.Fatalf("expected %s, got %s", expected, signed)
+	}
+}
+
+func TestSecDownloadFormat(t *testing.T) {
+	m := newMiddleware(t, map[string]interface{}{
+		"baseurl": "https://cdn.example.com/dl/",
+		"key":     "secret",
+		"format":  "secdownload",
+	})
+
+	signed, err := m.URLFor(context.Background(), blobPath, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	protected := "/6553f5b0" + blobPath
+	token := base64.RawURLEncoding.EncodeToString(hmacSHA256("secret", protected))
+	if expected := "https://cdn.example.com/dl/" + token + protected; signed != expected {
+		t.Fatalf("expected %s, got %s", expected, signed)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	secretFile := filepath.Join(dir, "next")
+	if err := os.WriteFile(secretFile, []byte("next-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m := newMiddleware(t, map[string]interface{}{
+		"baseurl": "https://cdn.example.com",
+		"keys": []interface{}{
+			map[interface{}]interface{}{"id": "old", "secret": "old-secret", "notbefore": "2020-01-01T00:00:00Z"},
+			map[interface{}]interface{}{"id": "next", "secretfile": secretFile, "notbefore": now.Add(time.Hour).Format(time.RFC3339)},
+			map[interface{}]interface{}{"id": "current", "secret": "current-secret", "notbefore": now.Add(-time.Hour).Format(time.RFC3339)},
+		},
+	})
+
+	for _, test := range []struct {
+		at     time.Time
+		keyID  string
+		secret string
+	}{
+		{now, "current", "current-secret"},
+		{now.Add(2 * time.Hour), "next", "next-secret"},
+	} {
+		key, ok := m.activeKey(test.at)
+		if !ok || key.id != test.keyID || string(key.secret) != test.secret {
+			t.Errorf("expected key %s at %v, got %s", test.keyID, test.at, key.id)
+		}
+	}
+
+	m.keys = m.keys[:1] // only the next key
+	if _, err := m.URLFor(context.Background(), blobPath, nil); err != (storagedriver.ErrUnsupportedMethod{}) {
+		t.Fatalf("expected the registry to serve the content until a key is valid, got %v", err)
+	}
+}
+
+func TestUnsupportedMethod(t *testing.T) {
+	m := newMiddleware(t, map[string]interface{}{
+		"baseurl": "https://cdn.example.com",
+		"key":     "secret",
+	})
+	if _, err := m.URLFor(context.Background(), blobPath, map[string]interface{}{"method": "HEAD"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.URLFor(context.Background(), blobPath, map[string]interface{}{"method": "PUT"}); err != (storagedriver.ErrUnsupportedMethod{}) {
+		t.Fatalf("expected an unsupported method error, got %v", err)
+	}
+}
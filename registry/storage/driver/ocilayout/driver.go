@@ -0,0 +1,506 @@
+// Package ocilayout provides a read-only storage driver which serves OCI
+// image layout directories as repositories.
+//
+// Every directory below the root directory which contains an oci-layout file
+// is a repository named by its path relative to the root. The driver presents
+// the layouts in the storage layout of the registry: blobs/<alg>/<hex> of a
+// layout become blobs and layer links of its repository, the manifests
+// reachable from index.json become manifest revisions and entries of
+// index.json with an org.opencontainers.image.ref.name annotation become
+// tags. Layouts are rescanned in the background when the refresh interval
+// has passed, and the previous scan is served until the rescan completes.
+package ocilayout
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	driverName             = "ocilayout"
+	defaultRefreshInterval = 10 * time.Second
+
+	// storageRoot is the prefix of all paths used by the registry, see
+	// registry/storage/paths.go.
+	storageRoot = "/docker/registry/v2"
+)
+
+// DriverParameters represents all configuration options available for the
+// ocilayout driver
+type DriverParameters struct {
+	// RootDirectory is the directory holding the OCI image layouts.
+	RootDirectory string
+
+	// RefreshInterval is the time after which the layouts are rescanned.
+	RefreshInterval time.Duration
+}
+
+func init() {
+	factory.Register(driverName, &ocilayoutDriverFactory{})
+}
+
+// ocilayoutDriverFactory implements the factory.StorageDriverFactory interface
+type ocilayoutDriverFactory struct{}
+
+func (factory *ocilayoutDriverFactory) Create(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return FromParameters(parameters)
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a read-only storagedriver.StorageDriver implementation serving
+// the OCI image layouts below RootDirectory.
+type Driver struct {
+	baseEmbed
+}
+
+var _ storagedriver.StorageDriver = &Driver{}
+
+// FromParameters constructs a new Driver with a given parameters map
+// Required parameters:
+// - rootdirectory
+// Optional parameters:
+// - refreshinterval
+func FromParameters(parameters map[string]interface{}) (*Driver, error) {
+	params := DriverParameters{
+		RefreshInterval: defaultRefreshInterval,
+	}
+
+	rootDir, ok := parameters["rootdirectory"]
+	if !ok || fmt.Sprint(rootDir) == "" {
+		return nil, fmt.Errorf("no rootdirectory parameter provided")
+	}
+	params.RootDirectory = fmt.Sprint(rootDir)
+
+	if interval, ok := parameters["refreshinterval"]; ok {
+		d, err := time.ParseDuration(fmt.Sprint(interval))
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid refreshinterval %v", interval)
+		}
+		params.RefreshInterval = d
+	}
+
+	return New(params), nil
+}
+
+// New constructs a new Driver with the given parameters.
+func New(params DriverParameters) *Driver {
+	return &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: &driver{
+					rootDirectory:   params.RootDirectory,
+					refreshInterval: params.RefreshInterval,
+				},
+			},
+		},
+	}
+}
+
+type driver struct {
+	rootDirectory   string
+	refreshInterval time.Duration
+
+	mu        sync.Mutex
+	tree      *node
+	scannedAt time.Time
+	scanning  bool
+}
+
+// Implement the storagedriver.StorageDriver interface
+
+func (d *driver) Name() string {
+	return driverName
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
+	rc, err := d.Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// PutContent is not supported, the driver is read-only.
+func (d *driver) PutContent(ctx context.Context, subPath string, contents []byte) error {
+	return storagedriver.ErrUnsupportedMethod{DriverName: driverName}
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	n, err := d.lookup(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if n.isDir() {
+		return nil, storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+	}
+
+	if n.file == "" {
+		if offset > int64(len(n.content)) {
+			return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: driverName}
+		}
+		return io.NopCloser(bytes.NewReader(n.content[offset:])), nil
+	}
+
+	f, err := os.Open(n.file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, storagedriver.PathNotFoundError{Path: path, DriverName: driverName}
+		}
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Writer is not supported, the driver is read-only.
+func (d *driver) Writer(ctx context.Context, subPath string, append bool) (storagedriver.FileWriter, error) {
+	return nil, storagedriver.ErrUnsupportedMethod{DriverName: driverName}
+}
+
+// Stat retrieves the FileInfo for the given path, including the current size
+// in bytes and the creation time.
+func (d *driver) Stat(ctx context.Context, subPath string) (storagedriver.FileInfo, error) {
+	n, err := d.lookup(ctx, subPath)
+	if err != nil {
+		return nil, err
+	}
+
+	fi := storagedriver.FileInfoFields{
+		Path:    subPath,
+		IsDir:   n.isDir(),
+		ModTime: n.modTime,
+		Size:    int64(len(n.content)),
+	}
+	if n.file != "" {
+		osfi, err := os.Stat(n.file)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, storagedriver.PathNotFoundError{Path: subPath, DriverName: driverName}
+			}
+			return nil, err
+		}
+		fi.Size = osfi.Size()
+		fi.ModTime = osfi.ModTime()
+	}
+	return storagedriver.FileInfoInternal{FileInfoFields: fi}, nil
+}
+
+// List returns a list of the objects that are direct descendants of the given
+// path.
+func (d *driver) List(ctx context.Context, subPath string) ([]string, error) {
+	n, err := d.lookup(ctx, subPath)
+	if err != nil {
+		return nil, err
+	}
+	if !n.isDir() {
+		return nil, storagedriver.PathNotFoundError{Path: subPath, DriverName: driverName}
+	}
+
+	keys := make([]string, 0, len(n.children))
+	for name := range n.children {
+		keys = append(keys, path.Join(subPath, name))
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Move is not supported, the driver is read-only.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	return storagedriver.ErrUnsupportedMethod{DriverName: driverName}
+}
+
+// Delete is not supported, the driver is read-only.
+func (d *driver) Delete(ctx context.Context, subPath string) error {
+	return storagedriver.ErrUnsupportedMethod{DriverName: driverName}
+}
+
+// URLFor returns a URL which may be used to retrieve the content stored at
+// the given path.
+func (d *driver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	return "", storagedriver.ErrUnsupportedMethod{DriverName: driverName}
+}
+
+// Walk traverses a filesystem defined within driver, starting
+// from the given path, calling f on each file and directory
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return storagedriver.WalkFallback(ctx, d, path, f, options...)
+}
+
+// root returns the storage tree. The first call scans the layouts, later
+// calls start a rescan in the background once the refresh interval has
+// passed and return the current tree meanwhile.
+func (d *driver) root(ctx context.Context) (*node, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.tree == nil {
+		tree, err := scan(ctx, d.rootDirectory)
+		if err != nil {
+			return nil, err
+		}
+		d.tree = tree
+		d.scannedAt = time.Now()
+	} else if !d.scanning && time.Since(d.scannedAt) >= d.refreshInterval {
+		d.scanning = true
+		go d.rescan(dcontext.Background())
+	}
+	return d.tree, nil
+}
+
+// rescan scans the layouts and replaces the storage tree with the result.
+// The current tree is kept if the scan fails.
+func (d *driver) rescan(ctx context.Context) {
+	tree, err := scan(ctx, d.rootDirectory)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.scanning = false
+	// a failed scan is retried after the refresh interval as well
+	d.scannedAt = time.Now()
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("ocilayout: failed to rescan %s: %v", d.rootDirectory, err)
+		return
+	}
+	d.tree = tree
+}
+
+// lookup returns the node at subPath.
+func (d *driver) lookup(ctx context.Context, subPath string) (*node, error) {
+	n, err := d.root(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range strings.Split(strings.Trim(subPath, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		if !n.isDir() {
+			return nil, storagedriver.PathNotFoundError{Path: subPath, DriverName: driverName}
+		}
+		child, ok := n.children[name]
+		if !ok {
+			return nil, storagedriver.PathNotFoundError{Path: subPath, DriverName: driverName}
+		}
+		n = child
+	}
+	return n, nil
+}
+
+// node is a directory or file of the storage tree presented to the
+// registry. Files either hold their content, as links do, or are backed by a
+// file of a layout.
+type node struct {
+	children map[string]*node
+	content  []byte
+	file     string
+	modTime  time.Time
+}
+
+func newDir(modTime time.Time) *node {
+	return &node{children: make(map[string]*node), modTime: modTime}
+}
+
+func (n *node) isDir() bool {
+	return n.children != nil
+}
+
+// add places the file at p, creating parent directories as needed. Existing
+// files are kept.
+func (n *node) add(p string, file *node) {
+	names := strings.Split(strings.Trim(p, "/"), "/")
+	for _, name := range names[:len(names)-1] {
+		child, ok := n.children[name]
+		if !ok {
+			child = newDir(file.modTime)
+			n.children[name] = child
+		}
+		n = child
+	}
+	if _, ok := n.children[names[len(names)-1]]; !ok {
+		n.children[names[len(names)-1]] = file
+	}
+}
+
+// scan builds the storage tree from the layouts below root. Layouts which
+// cannot be read are logged and skipped.
+func scan(ctx context.Context, root string) (*node, error) {
+	tree := newDir(time.Now())
+	tree.add(path.Join(storageRoot, "repositories"), newDir(time.Now()))
+
+	err := filepath.WalkDir(root, func(p string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if entry.Name() == "blobs" && isLayout(filepath.Dir(p)) {
+			return filepath.SkipDir
+		}
+		if !isLayout(p) {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if _, err := reference.WithName(name); err != nil {
+			// the layout cannot be addressed as a repository
+			return nil
+		}
+		if err := addLayout(tree, name, p); err != nil {
+			dcontext.GetLogger(ctx).Warnf("ocilayout: skipping %s: %v", p, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tree, nil
+}
+
+func isLayout(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, v1.ImageLayoutFile))
+	return err == nil
+}
+
+// addLayout adds the blobs, manifests and tags of the layout in dir to the
+// storage tree as the repository name.
+func addLayout(tree *node, name, dir string) error {
+	indexPath := filepath.Join(dir, "index.json")
+	fi, err := os.Stat(indexPath)
+	if err != nil {
+		return err
+	}
+	modTime := fi.ModTime()
+
+	content, err := os.ReadFile(indexPath)
+	if err != nil {
+		return err
+	}
+	var index v1.Index
+	if err := json.Unmarshal(content, &index); err != nil {
+		return fmt.Errorf("invalid index of %s: %v", dir, err)
+	}
+
+	named, err := reference.WithName(name)
+	if err != nil {
+		return err
+	}
+	repository := path.Join(storageRoot, "repositories", name)
+	link := func(p string, dgst digest.Digest) {
+		tree.add(p, &node{content: []byte(dgst), modTime: modTime})
+	}
+
+	algorithms, err := os.ReadDir(filepath.Join(dir, "blobs"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, algorithm := range algorithms {
+		if !algorithm.IsDir() {
+			continue
+		}
+		blobs, err := os.ReadDir(filepath.Join(dir, "blobs", algorithm.Name()))
+		if err != nil {
+			return err
+		}
+		for _, blob := range blobs {
+			dgst := digest.NewDigestFromEncoded(digest.Algorithm(algorithm.Name()), blob.Name())
+			if blob.IsDir() || dgst.Validate() != nil {
+				continue
+			}
+			tree.add(path.Join(storageRoot, "blobs", algorithm.Name(), dgst.Encoded()[:2], dgst.Encoded(), "data"), &node{
+				file:    filepath.Join(dir, "blobs", algorithm.Name(), blob.Name()),
+				modTime: modTime,
+			})
+			link(path.Join(repository, "_layers", algorithm.Name(), dgst.Encoded(), "link"), dgst)
+		}
+	}
+
+	manifests := make(map[digest.Digest]struct{})
+	for _, desc := range index.Manifests {
+		if err := addManifests(manifests, dir, desc); err != nil {
+			return err
+		}
+		// entries without a valid tag are only served by digest
+		tag, err := storage.RefNameTag(named, desc.Annotations)
+		if err != nil || tag == "" {
+			continue
+		}
+		link(path.Join(repository, "_manifests", "tags", tag, "current", "link"), desc.Digest)
+		link(path.Join(repository, "_manifests", "tags", tag, "index", desc.Digest.Algorithm().String(), desc.Digest.Encoded(), "link"), desc.Digest)
+	}
+	for dgst := range manifests {
+		link(path.Join(repository, "_manifests", "revisions", dgst.Algorithm().String(), dgst.Encoded(), "link"), dgst)
+	}
+	if len(manifests) == 0 {
+		// a repository exists once it has a _manifests directory
+		tree.add(path.Join(repository, "_manifests"), newDir(modTime))
+	}
+	return nil
+}
+
+// addManifests collects the digests of the manifest described by desc and
+// of the manifests it references. Manifests missing from the layout are
+// skipped.
+func addManifests(manifests map[digest.Digest]struct{}, dir string, desc v1.Descriptor) error {
+	if _, ok := manifests[desc.Digest]; ok || desc.Digest.Validate() != nil {
+		return nil
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "blobs", desc.Digest.Algorithm().String(), desc.Digest.Encoded()))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	manifests[desc.Digest] = struct{}{}
+
+	// only manifest lists and image indexes reference other manifests
+	var index struct {
+		Manifests []v1.Descriptor `json:"manifests"`
+	}
+	if err := json.Unmarshal(content, &index); err != nil {
+		return nil
+	}
+	for _, child := range index.Manifests {
+		if err := addManifests(manifests, dir, child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package ocilayout
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// writeLayout writes an OCI image layout holding a single image to dir and
+// returns the digests of its manifest and layer.
+func writeLayout(t *testing.T, dir string, refName string) (digest.Digest, digest.Digest) {
+	t.Helper()
+
+	writeBlob := func(content []byte) v1.Descriptor {
+		dgst := digest.FromBytes(content)
+		p := filepath.Join(dir, "blobs", dgst.Algorithm().String(), dgst.Encoded())
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return v1.Descriptor{Digest: dgst, Size: int64(len(content))}
+	}
+	writeJSON := func(name string, v interface{}) []byte {
+		content, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != "" {
+			if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return content
+	}
+
+	config := writeBlob([]byte(`{"architecture":"amd64","os":"linux"}`))
+	config.MediaType = v1.MediaTypeImageConfig
+	layer := writeBlob([]byte("layer content"))
+	layer.MediaType = v1.MediaTypeImageLayer
+
+	manifest := writeBlob(writeJSON("", struct {
+		v1.Manifest
+		MediaType string `json:"mediaType"`
+	}{
+		Manifest: v1.Manifest{
+			Versioned: specs.Versioned{SchemaVersion: 2},
+			Config:    config,
+			Layers:    []v1.Descriptor{layer},
+		},
+		MediaType: v1.MediaTypeImageManifest,
+	}))
+	manifest.MediaType = v1.MediaTypeImageManifest
+	manifest.Annotations = map[string]string{v1.AnnotationRefName: refName}
+
+	writeJSON(v1.ImageLayoutFile, v1.ImageLayout{Version: v1.ImageLayoutVersion})
+	writeJSON("index.json", v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: []v1.Descriptor{manifest},
+	})
+	return manifest.Digest, layer.Digest
+}
+
+func TestServeLayouts(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	manifestDigest, layerDigest := writeLayout(t, filepath.Join(root, "build", "app"), "1.0")
+	writeLayout(t, filepath.Join(root, "build", "app", "debug"), "example.com/app:debug")
+	// directories which are not layouts are ignored
+	if err := os.MkdirAll(filepath.Join(root, "other"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	d := New(DriverParameters{RootDirectory: root})
+	registry, err := storage.NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repos := make([]string, 10)
+	n, err := registry.Repositories(ctx, repos, "")
+	if err != nil && n == 0 {
+		t.Fatalf("failed to list repositories: %v", err)
+	}
+	if n != 2 || repos[0] != "build/app" || repos[1] != "build/app/debug" {
+		t.Fatalf("unexpected repositories: %v", repos[:n])
+	}
+
+	named, _ := reference.WithName("build/app")
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := repo.Tags(ctx).All(ctx)
+	if err != nil {
+		t.Fatalf("failed to list tags: %v", err)
+	}
+	if len(tags) != 1 || tags[0] != "1.0" {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+	desc, err := repo.Tags(ctx).Get(ctx, "1.0")
+	if err != nil {
+		t.Fatalf("failed to get tag: %v", err)
+	}
+	if desc.Digest != manifestDigest {
+		t.Fatalf("unexpected digest of tag: %s != %s", desc.Digest, manifestDigest)
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := manifests.Get(ctx, manifestDigest)
+	if err != nil {
+		t.Fatalf("failed to get manifest: %v", err)
+	}
+	if references := manifest.References(); len(references) != 2 || references[1].Digest != layerDigest {
+		t.Fatalf("unexpected references: %v", references)
+	}
+
+	content, err := repo.Blobs(ctx).Get(ctx, layerDigest)
+	if err != nil {
+		t.Fatalf("failed to get layer: %v", err)
+	}
+	if string(content) != "layer content" {
+		t.Fatalf("unexpected layer content: %q", content)
+	}
+
+	// layers of other layouts are not linked into the repository
+	unknown := digest.FromString("unknown")
+	if _, err := repo.Blobs(ctx).Stat(ctx, unknown); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected ErrBlobUnknown, got %v", err)
+	}
+
+	debug, _ := reference.WithName("build/app/debug")
+	repo, err = registry.Repository(ctx, debug)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Tags(ctx).Get(ctx, "debug"); err != nil {
+		t.Fatalf("expected tag to be taken from a full reference name: %v", err)
+	}
+
+	if err := d.PutContent(ctx, "/docker/registry/v2/foo", []byte("bar")); err == nil {
+		t.Fatalf("expected writes to fail")
+	} else if _, ok := err.(storagedriver.ErrUnsupportedMethod); !ok {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+}
+
+func TestRescan(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	writeLayout(t, filepath.Join(root, "app"), "1.0")
+
+	if _, err := FromParameters(map[string]interface{}{"rootdirectory": root, "refreshinterval": "0s"}); err == nil {
+		t.Fatal("expected a zero refresh interval to be rejected")
+	}
+
+	d := New(DriverParameters{RootDirectory: root, RefreshInterval: time.Millisecond})
+	repositories := "/docker/registry/v2/repositories"
+	if _, err := d.Stat(ctx, path.Join(repositories, "app")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// the new layout is served once a rescan started by a later request
+	// has completed
+	writeLayout(t, filepath.Join(root, "other"), "1.0")
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		time.Sleep(time.Millisecond)
+		_, err := d.Stat(ctx, path.Join(repositories, "other"))
+		if err == nil {
+			break
+		}
+		if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the new layout was not picked up by a rescan")
+		}
+	}
+}
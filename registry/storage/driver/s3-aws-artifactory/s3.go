@@ -39,6 +39,7 @@ import (
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/base"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/s3-aws/awscreds"
 )
 
 const driverName = "s3awsartifactory"
@@ -118,6 +119,7 @@ type DriverParameters struct {
 	UseDualStack                bool
 	Accelerate                  bool
 	MetadataPath                string
+	Credentials                 awscreds.Parameters
 }
 
 func init() {
@@ -445,6 +447,11 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 		}
 	}
 
+	credentials, err := awscreds.FromParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+
 	params := DriverParameters{
 		fmt.Sprint(accessKey),
 		fmt.Sprint(secretKey),
@@ -470,6 +477,7 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 		useDualStackBool,
 		accelerateBool,
 		fmt.Sprint(artyMeta),
+		credentials,
 	}
 
 	return New(params)
@@ -545,6 +553,16 @@ func New(params DriverParameters) (*Driver, error) {
 		})
 	}
 
+	// temporary credentials are obtained with the static credentials, if any,
+	// or with those of the default chain
+	if params.Credentials.Enabled() {
+		creds, err := params.Credentials.Credentials(awsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure temporary credentials: %v", err)
+		}
+		awsConfig.WithCredentials(creds)
+	}
+
 	sess, err := session.NewSession(awsConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create new session with aws config: %v", err)
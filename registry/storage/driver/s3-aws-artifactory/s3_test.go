@@ -25,6 +25,7 @@ import (
 
 	"github.com/distribution/distribution/v3/context"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/s3-aws/awscreds"
 	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
 )
 
@@ -143,6 +144,7 @@ func init() {
 			useDualStackBool,
 			accelerateBool,
 			"/metadata",
+			awscreds.Parameters{},
 		}
 
 		return New(parameters)
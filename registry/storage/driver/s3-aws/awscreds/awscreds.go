@@ -0,0 +1,165 @@
+// Package awscreds configures the temporary credentials the S3 storage
+// drivers obtain from AWS STS, by assuming a role, possibly in another
+// account and with an external ID, or by exchanging a web identity token,
+// such as the service account token of a Kubernetes pod.
+//
+// The credentials are refreshed before they expire, so that requests, such
+// as the parts of long uploads, are not made with expired credentials. Web
+// identity token files are read again at each refresh, as their tokens are
+// rotated.
+package awscreds
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	defaultRoleSessionName = "docker-registry"
+	defaultExpiryWindow    = time.Minute
+)
+
+// Parameters configure the temporary credentials of a driver.
+type Parameters struct {
+	// RoleARN is the role to assume, if any.
+	RoleARN string
+	// ExternalID is passed when assuming RoleARN, as third parties require.
+	ExternalID string
+	// RoleSessionName names the sessions of the roles assumed.
+	RoleSessionName string
+	// Duration is the lifetime of the credentials, the STS default if zero.
+	Duration time.Duration
+	// WebIdentityTokenFile is the file holding the web identity token
+	// exchanged for the credentials of WebIdentityRoleARN, if any.
+	WebIdentityTokenFile string
+	WebIdentityRoleARN   string
+	// STSEndpoint is the endpoint of STS, the regional one if empty.
+	STSEndpoint string
+	// ExpiryWindow is how long before they expire the credentials are
+	// refreshed.
+	ExpiryWindow time.Duration
+}
+
+// FromParameters reads the Parameters from the parameters of a driver:
+// assumerolearn, externalid, rolesessionname, assumeroleduration,
+// webidentitytokenfile, webidentityrolearn, stsendpoint and
+// credentialsexpirywindow.
+func FromParameters(parameters map[string]interface{}) (Parameters, error) {
+	p := Parameters{
+		RoleSessionName: defaultRoleSessionName,
+		ExpiryWindow:    defaultExpiryWindow,
+	}
+
+	for name, value := range map[string]*string{
+		"assumerolearn":        &p.RoleARN,
+		"externalid":           &p.ExternalID,
+		"rolesessionname":      &p.RoleSessionName,
+		"webidentitytokenfile": &p.WebIdentityTokenFile,
+		"webidentityrolearn":   &p.WebIdentityRoleARN,
+		"stsendpoint":          &p.STSEndpoint,
+	} {
+		switch v := parameters[name].(type) {
+		case nil:
+		case string:
+			if v != "" {
+				*value = v
+			}
+		default:
+			return Parameters{}, fmt.Errorf("the %s parameter should be a string", name)
+		}
+	}
+
+	for name, value := range map[string]*time.Duration{
+		"assumeroleduration":      &p.Duration,
+		"credentialsexpirywindow": &p.ExpiryWindow,
+	} {
+		switch v := parameters[name].(type) {
+		case nil:
+		case string:
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				return Parameters{}, fmt.Errorf("the %s parameter should be a duration: %v", name, err)
+			}
+			*value = d
+		case time.Duration:
+			*value = v
+		default:
+			return Parameters{}, fmt.Errorf("the %s parameter should be a duration", name)
+		}
+		if *value < 0 {
+			return Parameters{}, fmt.Errorf("the %s parameter should not be negative", name)
+		}
+	}
+
+	if p.WebIdentityTokenFile != "" && p.WebIdentityRoleARN == "" {
+		return Parameters{}, fmt.Errorf("the webidentityrolearn parameter is required with webidentitytokenfile")
+	}
+	if p.WebIdentityTokenFile == "" && p.WebIdentityRoleARN != "" {
+		return Parameters{}, fmt.Errorf("the webidentitytokenfile parameter is required with webidentityrolearn")
+	}
+	if p.ExternalID != "" && p.RoleARN == "" {
+		return Parameters{}, fmt.Errorf("the externalid parameter requires assumerolearn")
+	}
+	return p, nil
+}
+
+// Enabled returns true if the parameters configure temporary credentials.
+func (p Parameters) Enabled() bool {
+	return p.RoleARN != "" || p.WebIdentityTokenFile != ""
+}
+
+// Credentials returns the temporary credentials configured by p, obtained
+// from the STS of the region of config with its credentials, or those of the
+// default chain if it has none. If both a web identity and a role are
+// configured, the role is assumed with the credentials of the web identity.
+func (p Parameters) Credentials(config *aws.Config) (*credentials.Credentials, error) {
+	stsConfig := aws.NewConfig().
+		WithRegion(aws.StringValue(config.Region)).
+		WithCredentials(config.Credentials)
+	if p.STSEndpoint != "" {
+		stsConfig.WithEndpoint(p.STSEndpoint)
+	}
+	sess, err := session.NewSession(stsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sts session: %v", err)
+	}
+
+	var creds *credentials.Credentials
+	if p.WebIdentityTokenFile != "" {
+		creds = credentials.NewCredentials(stscreds.NewWebIdentityRoleProviderWithOptions(
+			sts.New(sess),
+			p.WebIdentityRoleARN,
+			p.RoleSessionName,
+			stscreds.FetchTokenPath(p.WebIdentityTokenFile),
+			func(provider *stscreds.WebIdentityRoleProvider) {
+				provider.Duration = p.Duration
+				provider.ExpiryWindow = p.ExpiryWindow
+			},
+		))
+	}
+
+	if p.RoleARN != "" {
+		svc := sts.New(sess)
+		if creds != nil {
+			svc = sts.New(sess, aws.NewConfig().WithCredentials(creds))
+		}
+		creds = stscreds.NewCredentialsWithClient(svc, p.RoleARN, func(provider *stscreds.AssumeRoleProvider) {
+			provider.RoleSessionName = p.RoleSessionName
+			if p.Duration > 0 {
+				provider.Duration = p.Duration
+			}
+			if p.ExternalID != "" {
+				provider.ExternalID = aws.String(p.ExternalID)
+			}
+			provider.ExpiryWindow = p.ExpiryWindow
+		})
+	}
+
+	return creds, nil
+}
@@ -0,0 +1,220 @@
+package awscreds
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+func TestFromParameters(t *testing.T) {
+	p, err := FromParameters(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Enabled() || p.RoleSessionName != defaultRoleSessionName || p.ExpiryWindow != defaultExpiryWindow {
+		t.Fatalf("unexpected default parameters %+v", p)
+	}
+
+	p, err = FromParameters(map[string]interface{}{
+		"assumerolearn":           "arn:aws:iam::123456789012:role/registry",
+		"externalid":              "registry-external-id",
+		"assumeroleduration":      "2h",
+		"credentialsexpirywindow": 5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Enabled() || p.ExternalID != "registry-external-id" || p.Duration != 2*time.Hour || p.ExpiryWindow != 5*time.Minute {
+		t.Fatalf("unexpected parameters %+v", p)
+	}
+
+	for _, parameters := range []map[string]interface{}{
+		{"assumerolearn": 12},
+		{"assumeroleduration": "two hours", "assumerolearn": "arn:aws:iam::123456789012:role/registry"},
+		{"credentialsexpirywindow": "-1m"},
+		{"externalid": "registry-external-id"},
+		{"webidentitytokenfile": "/var/run/secrets/token"},
+		{"webidentityrolearn": "arn:aws:iam::123456789012:role/registry"},
+	} {
+		if _, err := FromParameters(parameters); err == nil {
+			t.Errorf("expected an error for %v", parameters)
+		}
+	}
+}
+
+const credentialsResponse = `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <%[1]sResult>
+    <Credentials>
+      <AccessKeyId>%[2]s</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>token</SessionToken>
+      <Expiration>%[3]s</Expiration>
+    </Credentials>
+  </%[1]sResult>
+  <ResponseMetadata>
+    <RequestId>c6104cbe-af31-11e0-8154-cbc7ccf896c7</RequestId>
+  </ResponseMetadata>
+</%[1]sResponse>`
+
+// stsServer is a fake STS recording the requests it received.
+type stsServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+}
+
+func newSTSServer(t *testing.T) *stsServer {
+	s := &stsServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, r)
+		s.mu.Unlock()
+
+		action := r.Form.Get("Action")
+		accessKey := "ASSUMED"
+		if action == "AssumeRoleWithWebIdentity" {
+			accessKey = "WEBIDENTITY"
+		}
+		expiration := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+		fmt.Fprintf(w, credentialsResponse, action, accessKey, expiration)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *stsServer) request(i int) *http.Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if i >= len(s.requests) {
+		return nil
+	}
+	return s.requests[i]
+}
+
+func TestAssumeRole(t *testing.T) {
+	server := newSTSServer(t)
+	p := Parameters{
+		RoleARN:         "arn:aws:iam::123456789012:role/registry",
+		ExternalID:      "registry-external-id",
+		RoleSessionName: "test",
+		Duration:        time.Hour,
+		STSEndpoint:     server.URL,
+		ExpiryWindow:    time.Minute,
+	}
+	config := aws.NewConfig().
+		WithRegion("us-east-1").
+		WithCredentials(credentials.NewStaticCredentials("STATIC", "secret", ""))
+
+	creds, err := p.Credentials(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := creds.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.AccessKeyID != "ASSUMED" {
+		t.Fatalf("unexpected access key %s", value.AccessKeyID)
+	}
+
+	r := server.request(0)
+	if r.Form.Get("Action") != "AssumeRole" ||
+		r.Form.Get("RoleArn") != p.RoleARN ||
+		r.Form.Get("ExternalId") != p.ExternalID ||
+		r.Form.Get("RoleSessionName") != "test" ||
+		r.Form.Get("DurationSeconds") != "3600" {
+		t.Fatalf("unexpected request %v", r.Form)
+	}
+	// the role is assumed with the static credentials
+	if !strings.Contains(r.Header.Get("Authorization"), "Credential=STATIC/") {
+		t.Fatalf("unexpected authorization %s", r.Header.Get("Authorization"))
+	}
+}
+
+func TestWebIdentity(t *testing.T) {
+	server := newSTSServer(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("first-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := Parameters{
+		WebIdentityTokenFile: tokenFile,
+		WebIdentityRoleARN:   "arn:aws:iam::123456789012:role/web",
+		RoleARN:              "arn:aws:iam::210987654321:role/registry",
+		RoleSessionName:      "test",
+		STSEndpoint:          server.URL,
+		ExpiryWindow:         time.Minute,
+	}
+
+	creds, err := p.Credentials(aws.NewConfig().WithRegion("us-east-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, err := creds.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.AccessKeyID != "ASSUMED" {
+		t.Fatalf("unexpected access key %s", value.AccessKeyID)
+	}
+
+	web, assume := server.request(0), server.request(1)
+	if web == nil || web.Form.Get("Action") != "AssumeRoleWithWebIdentity" ||
+		web.Form.Get("WebIdentityToken") != "first-token" ||
+		web.Form.Get("RoleArn") != p.WebIdentityRoleARN {
+		t.Fatalf("unexpected web identity request %v", web)
+	}
+	// the role is assumed with the credentials of the web identity
+	if assume == nil || assume.Form.Get("Action") != "AssumeRole" ||
+		!strings.Contains(assume.Header.Get("Authorization"), "Credential=WEBIDENTITY/") {
+		t.Fatalf("unexpected assume role request %v", assume)
+	}
+}
+
+func TestWebIdentityTokenRefresh(t *testing.T) {
+	server := newSTSServer(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("first-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := Parameters{
+		WebIdentityTokenFile: tokenFile,
+		WebIdentityRoleARN:   "arn:aws:iam::123456789012:role/web",
+		RoleSessionName:      "test",
+		STSEndpoint:          server.URL,
+		ExpiryWindow:         time.Minute,
+	}
+
+	creds, err := p.Credentials(aws.NewConfig().WithRegion("us-east-1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := creds.Get(); err != nil || value.AccessKeyID != "WEBIDENTITY" {
+		t.Fatalf("unexpected credentials %v, %v", value, err)
+	}
+
+	// the token file is read again when the credentials are refreshed
+	if err := os.WriteFile(tokenFile, []byte("second-token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	creds.Expire()
+	if _, err := creds.Get(); err != nil {
+		t.Fatal(err)
+	}
+	if r := server.request(1); r == nil || r.Form.Get("WebIdentityToken") != "second-token" {
+		t.Fatalf("expected the token to be read again, got %v", r)
+	}
+}
@@ -39,6 +39,7 @@ import (
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/base"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/s3-aws/awscreds"
 )
 
 const driverName = "s3aws"
@@ -117,6 +118,7 @@ type DriverParameters struct {
 	SessionToken                string
 	UseDualStack                bool
 	Accelerate                  bool
+	Credentials                 awscreds.Parameters
 }
 
 func init() {
@@ -139,8 +141,9 @@ func init() {
 		validObjectACLs[objectACL] = struct{}{}
 	}
 
-	// Register this as the default s3 driver in addition to s3aws
-	factory.Register("s3", &s3DriverFactory{})
+	// The s3 name is left to the s3awsartifactory driver, which registers
+	// it too, so that existing configurations keep their meaning when both
+	// drivers are built into the registry.
 	factory.Register(driverName, &s3DriverFactory{})
 }
 
@@ -437,6 +440,11 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 		return nil, fmt.Errorf("the accelerate parameter should be a boolean")
 	}
 
+	credentials, err := awscreds.FromParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+
 	params := DriverParameters{
 		fmt.Sprint(accessKey),
 		fmt.Sprint(secretKey),
@@ -461,6 +469,7 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 		fmt.Sprint(sessionToken),
 		useDualStackBool,
 		accelerateBool,
+		credentials,
 	}
 
 	return New(params)
@@ -536,6 +545,16 @@ func New(params DriverParameters) (*Driver, error) {
 		})
 	}
 
+	// temporary credentials are obtained with the static credentials, if any,
+	// or with those of the default chain
+	if params.Credentials.Enabled() {
+		creds, err := params.Credentials.Credentials(awsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("failed to configure temporary credentials: %v", err)
+		}
+		awsConfig.WithCredentials(creds)
+	}
+
 	sess, err := session.NewSession(awsConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create new session with aws config: %v", err)
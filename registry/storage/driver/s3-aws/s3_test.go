@@ -18,6 +18,7 @@ import (
 
 	"github.com/distribution/distribution/v3/context"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/s3-aws/awscreds"
 	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
 )
 
@@ -135,6 +136,7 @@ func init() {
 			sessionToken,
 			useDualStackBool,
 			accelerateBool,
+			awscreds.Parameters{},
 		}
 
 		return New(parameters)
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd
+
+package shardedfs
+
+import "fmt"
+
+// diskSpace is not supported on this platform.
+func diskSpace(dir string) (total, free uint64, err error) {
+	return 0, 0, fmt.Errorf("disk usage is not supported on this platform")
+}
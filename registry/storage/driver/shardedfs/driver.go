@@ -0,0 +1,536 @@
+// Package shardedfs provides a storage driver which spreads the blob data of
+// the registry across the root directories of several local disks.
+//
+// Each blob data directory, blobs/<alg>/<xx>/<hex> of the storage layout of
+// the registry, is assigned to one of the root directories by weighted
+// rendezvous hashing of its digest, so that adding a root directory only
+// reassigns the share of the blobs it takes on. All other paths, such as
+// repositories and uploads, are kept on the metadata directory.
+//
+// Blob data is looked up on all root directories when it is not found on the
+// one it is assigned to, so that the registry keeps serving blobs written
+// before a root directory was added until they are moved by Rebalance.
+package shardedfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/base"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/filesystem"
+	"github.com/distribution/distribution/v3/uuid"
+)
+
+const (
+	driverName           = "shardedfs"
+	defaultMaxThreads    = uint64(100)
+	defaultUsageInterval = time.Minute
+
+	// minThreads is the minimum value for the maxthreads configuration
+	// parameter, as for the filesystem driver.
+	minThreads = uint64(25)
+
+	// blobsRoot is the directory of the blob data of the registry, see
+	// registry/storage/paths.go.
+	blobsRoot = "/docker/registry/v2/blobs"
+
+	// tmpRoot is the directory of each root directory in which blob data
+	// moved from another root directory is written before it is renamed in
+	// place.
+	tmpRoot = "/.shardedfs"
+)
+
+// RootDirectory is a directory holding blob data.
+type RootDirectory struct {
+	Path string
+	// Weight is the share of the blobs assigned to the directory relative to
+	// the other directories, typically its capacity. Directories with a zero
+	// weight are not assigned blobs anymore and are drained by Rebalance.
+	Weight float64
+}
+
+// DriverParameters represents all configuration options available for the
+// shardedfs driver
+type DriverParameters struct {
+	RootDirectories []RootDirectory
+	// MetadataDirectory holds all paths but blob data. It may be one of the
+	// root directories.
+	MetadataDirectory string
+	// MaxThreads limits the concurrent filesystem operations on each
+	// directory.
+	MaxThreads uint64
+	// UsageInterval is the interval at which the usage of the disks is
+	// reported in the metrics, zero disables the reports.
+	UsageInterval time.Duration
+}
+
+func init() {
+	factory.Register(driverName, &shardedfsDriverFactory{})
+}
+
+// shardedfsDriverFactory implements the factory.StorageDriverFactory interface
+type shardedfsDriverFactory struct{}
+
+func (factory *shardedfsDriverFactory) Create(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return FromParameters(parameters)
+}
+
+// shard is a directory holding registry files.
+type shard struct {
+	root   string
+	weight float64
+	driver storagedriver.StorageDriver
+}
+
+type driver struct {
+	// metadata holds all paths but blob data
+	metadata *shard
+	// shards hold blob data
+	shards []*shard
+	// roots are the distinct directories, metadata first
+	roots []*shard
+}
+
+type baseEmbed struct {
+	base.Base
+}
+
+// Driver is a storagedriver.StorageDriver implementation storing blob data on
+// several local root directories and all other paths on a metadata directory.
+type Driver struct {
+	baseEmbed
+	driver *driver
+}
+
+var _ storagedriver.StorageDriver = &Driver{}
+
+// FromParameters constructs a new Driver with a given parameters map
+// Required parameters:
+// - rootdirectories
+// Optional parameters:
+// - metadatadirectory
+// - maxthreads
+// - usageinterval
+func FromParameters(parameters map[string]interface{}) (*Driver, error) {
+	params, err := fromParametersImpl(parameters)
+	if err != nil {
+		return nil, err
+	}
+	return New(*params), nil
+}
+
+func fromParametersImpl(parameters map[string]interface{}) (*DriverParameters, error) {
+	params := &DriverParameters{
+		UsageInterval: defaultUsageInterval,
+	}
+
+	list, ok := parameters["rootdirectories"].([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("rootdirectories must be a non-empty list")
+	}
+	seen := map[string]bool{}
+	var totalWeight float64
+	for i, item := range list {
+		root, err := parseRootDirectory(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root directory %d: %v", i, err)
+		}
+		if seen[root.Path] {
+			return nil, fmt.Errorf("duplicate root directory %s", root.Path)
+		}
+		seen[root.Path] = true
+		totalWeight += root.Weight
+		params.RootDirectories = append(params.RootDirectories, root)
+	}
+	if totalWeight == 0 {
+		return nil, fmt.Errorf("at least one root directory must have a positive weight")
+	}
+
+	params.MetadataDirectory = params.RootDirectories[0].Path
+	if dir, ok := parameters["metadatadirectory"]; ok {
+		s, ok := dir.(string)
+		if !ok || s == "" {
+			return nil, fmt.Errorf("metadatadirectory must be a non-empty string")
+		}
+		params.MetadataDirectory = path.Clean(s)
+	}
+
+	var err error
+	params.MaxThreads, err = base.GetLimitFromParameter(parameters["maxthreads"], minThreads, defaultMaxThreads)
+	if err != nil {
+		return nil, fmt.Errorf("maxthreads config error: %s", err.Error())
+	}
+
+	if interval, ok := parameters["usageinterval"]; ok {
+		d, err := time.ParseDuration(fmt.Sprint(interval))
+		if err != nil || d < 0 {
+			return nil, fmt.Errorf("invalid usageinterval %v", interval)
+		}
+		params.UsageInterval = d
+	}
+
+	return params, nil
+}
+
+// parseRootDirectory reads a root directory given as a path, or as a map with
+// the keys path and weight.
+func parseRootDirectory(item interface{}) (RootDirectory, error) {
+	options := map[string]interface{}{}
+	switch item := item.(type) {
+	case string:
+		options["path"] = item
+	case map[string]interface{}:
+		options = item
+	case map[interface{}]interface{}:
+		for k, v := range item {
+			options[fmt.Sprint(k)] = v
+		}
+	default:
+		return RootDirectory{}, fmt.Errorf("root directory must be a path or a map")
+	}
+
+	root := RootDirectory{Weight: 1}
+	for name, value := range options {
+		switch name {
+		case "path":
+			s, ok := value.(string)
+			if !ok {
+				return RootDirectory{}, fmt.Errorf("path must be a string")
+			}
+			root.Path = s
+		case "weight":
+			w, err := strconv.ParseFloat(fmt.Sprint(value), 64)
+			if err != nil || w < 0 || math.IsInf(w, 0) {
+				return RootDirectory{}, fmt.Errorf("weight must be a non-negative number")
+			}
+			root.Weight = w
+		default:
+			return RootDirectory{}, fmt.Errorf("unknown option %q", name)
+		}
+	}
+	if root.Path == "" {
+		return RootDirectory{}, fmt.Errorf("no path provided")
+	}
+	root.Path = path.Clean(root.Path)
+	return root, nil
+}
+
+// New constructs a new Driver with the given parameters.
+func New(params DriverParameters) *Driver {
+	newShard := func(root string, weight float64) *shard {
+		return &shard{
+			root:   root,
+			weight: weight,
+			driver: filesystem.New(filesystem.DriverParameters{
+				RootDirectory: root,
+				MaxThreads:    params.MaxThreads,
+			}),
+		}
+	}
+
+	d := &driver{}
+	for _, root := range params.RootDirectories {
+		s := newShard(root.Path, root.Weight)
+		if root.Path == params.MetadataDirectory {
+			d.metadata = s
+		}
+		d.shards = append(d.shards, s)
+	}
+	if d.metadata == nil {
+		d.metadata = newShard(params.MetadataDirectory, 0)
+	}
+	d.roots = []*shard{d.metadata}
+	for _, s := range d.shards {
+		if s != d.metadata {
+			d.roots = append(d.roots, s)
+		}
+	}
+
+	sd := &Driver{
+		baseEmbed: baseEmbed{
+			Base: base.Base{
+				StorageDriver: d,
+			},
+		},
+		driver: d,
+	}
+	if params.UsageInterval > 0 {
+		go sd.reportUsage(params.UsageInterval)
+	}
+	return sd
+}
+
+// Implement the storagedriver.StorageDriver interface
+
+func (d *driver) Name() string {
+	return driverName
+}
+
+// blobDigest returns the digest of the blob data directory holding subPath,
+// if subPath is in one.
+func blobDigest(subPath string) (string, bool) {
+	if !strings.HasPrefix(subPath, blobsRoot+"/") {
+		return "", false
+	}
+	// <alg>/<xx>/<hex>[/...]
+	parts := strings.SplitN(strings.TrimPrefix(subPath, blobsRoot+"/"), "/", 4)
+	if len(parts) < 3 || parts[2] == "" {
+		return "", false
+	}
+	return parts[0] + ":" + parts[2], true
+}
+
+// isShared returns true if subPath is a directory which may exist on all root
+// directories, blobsRoot, its ancestors and the directories of blobsRoot
+// above the blob data directories.
+func isShared(subPath string) bool {
+	if subPath == "/" || strings.HasPrefix(blobsRoot+"/", subPath+"/") {
+		return true
+	}
+	_, ok := blobDigest(subPath)
+	return !ok && strings.HasPrefix(subPath, blobsRoot+"/")
+}
+
+// assign returns the shard the blob data of dgst is assigned to, the shard
+// with the highest weighted rendezvous hash score.
+func (d *driver) assign(dgst string) *shard {
+	var (
+		assigned  *shard
+		bestScore float64
+	)
+	for _, s := range d.shards {
+		if s.weight == 0 {
+			continue
+		}
+		sum := sha256.Sum256([]byte(s.root + "\x00" + dgst))
+		// a uniform number in (0, 1)
+		x := (float64(binary.BigEndian.Uint64(sum[:8])>>11) + 0.5) / (1 << 53)
+		score := -s.weight / math.Log(x)
+		if assigned == nil || score > bestScore {
+			assigned, bestScore = s, score
+		}
+	}
+	return assigned
+}
+
+// target returns the shard new content at subPath is written to.
+func (d *driver) target(subPath string) *shard {
+	if dgst, ok := blobDigest(subPath); ok {
+		return d.assign(dgst)
+	}
+	return d.metadata
+}
+
+// candidates returns the shards which may hold subPath, in the order in
+// which they are searched.
+func (d *driver) candidates(subPath string) []*shard {
+	if dgst, ok := blobDigest(subPath); ok {
+		assigned := d.assign(dgst)
+		candidates := []*shard{assigned}
+		for _, s := range d.shards {
+			if s != assigned {
+				candidates = append(candidates, s)
+			}
+		}
+		return candidates
+	}
+	if isShared(subPath) {
+		return d.roots
+	}
+	return []*shard{d.metadata}
+}
+
+// locate returns the first shard holding subPath.
+func (d *driver) locate(ctx context.Context, subPath string) (*shard, storagedriver.FileInfo, error) {
+	for _, s := range d.candidates(subPath) {
+		fi, err := s.driver.Stat(ctx, subPath)
+		switch err.(type) {
+		case nil:
+			return s, fi, nil
+		case storagedriver.PathNotFoundError:
+		default:
+			return nil, nil, err
+		}
+	}
+	return nil, nil, storagedriver.PathNotFoundError{Path: subPath}
+}
+
+// GetContent retrieves the content stored at "path" as a []byte.
+func (d *driver) GetContent(ctx context.Context, subPath string) ([]byte, error) {
+	rc, err := d.Reader(ctx, subPath, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	return io.ReadAll(rc)
+}
+
+// PutContent stores the []byte content at a location designated by "path".
+func (d *driver) PutContent(ctx context.Context, subPath string, contents []byte) error {
+	return d.target(subPath).driver.PutContent(ctx, subPath, contents)
+}
+
+// Reader retrieves an io.ReadCloser for the content stored at "path" with a
+// given byte offset.
+func (d *driver) Reader(ctx context.Context, subPath string, offset int64) (io.ReadCloser, error) {
+	for _, s := range d.candidates(subPath) {
+		rc, err := s.driver.Reader(ctx, subPath, offset)
+		switch err.(type) {
+		case nil:
+			return rc, nil
+		case storagedriver.PathNotFoundError:
+		default:
+			return nil, err
+		}
+	}
+	return nil, storagedriver.PathNotFoundError{Path: subPath}
+}
+
+// Writer returns a FileWriter which will store the content written to it at
+// the location designated by "path" after the call to Commit. Appending
+// writers write to the shard already holding the content, if any.
+func (d *driver) Writer(ctx context.Context, subPath string, append bool) (storagedriver.FileWriter, error) {
+	s := d.target(subPath)
+	if append {
+		existing, _, err := d.locate(ctx, subPath)
+		switch err.(type) {
+		case nil:
+			s = existing
+		case storagedriver.PathNotFoundError:
+		default:
+			return nil, err
+		}
+	}
+	return s.driver.Writer(ctx, subPath, append)
+}
+
+// Stat retrieves the FileInfo for the given path, including the current size
+// in bytes and the creation time.
+func (d *driver) Stat(ctx context.Context, subPath string) (storagedriver.FileInfo, error) {
+	_, fi, err := d.locate(ctx, subPath)
+	return fi, err
+}
+
+// List returns a list of the objects that are direct descendants of the given
+// path, merged across the shards holding it.
+func (d *driver) List(ctx context.Context, subPath string) ([]string, error) {
+	var (
+		found bool
+		keys  []string
+		seen  = map[string]bool{}
+	)
+	for _, s := range d.candidates(subPath) {
+		children, err := s.driver.List(ctx, subPath)
+		switch err.(type) {
+		case nil:
+		case storagedriver.PathNotFoundError:
+			continue
+		default:
+			return nil, err
+		}
+		found = true
+		for _, child := range children {
+			if !seen[child] && child != tmpRoot {
+				seen[child] = true
+				keys = append(keys, child)
+			}
+		}
+	}
+	if !found {
+		return nil, storagedriver.PathNotFoundError{Path: subPath}
+	}
+	return keys, nil
+}
+
+// Move moves an object stored at sourcePath to destPath, removing the original
+// object. Objects are copied if the paths are on different shards.
+func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
+	source, _, err := d.locate(ctx, sourcePath)
+	if err != nil {
+		return err
+	}
+	dest := d.target(destPath)
+	if source == dest {
+		return source.driver.Move(ctx, sourcePath, destPath)
+	}
+	if _, err := copyFile(ctx, source, dest, sourcePath, destPath); err != nil {
+		return err
+	}
+	return source.driver.Delete(ctx, sourcePath)
+}
+
+// copyFile copies the file at sourcePath of source to destPath of dest,
+// through a temporary file renamed in place so that the file is never seen
+// partially written, and returns its size.
+func copyFile(ctx context.Context, source, dest *shard, sourcePath, destPath string) (int64, error) {
+	rc, err := source.driver.Reader(ctx, sourcePath, 0)
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+
+	tmpPath := path.Join(tmpRoot, uuid.Generate().String())
+	fw, err := dest.driver.Writer(ctx, tmpPath, false)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(fw, rc)
+	if err == nil {
+		err = fw.Commit()
+	}
+	if err != nil {
+		fw.Cancel(ctx)
+		return 0, err
+	}
+	if err := fw.Close(); err != nil {
+		return 0, err
+	}
+	if err := dest.driver.Move(ctx, tmpPath, destPath); err != nil {
+		dest.driver.Delete(ctx, tmpPath)
+		return 0, err
+	}
+	return n, nil
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths,
+// on all the shards holding them.
+func (d *driver) Delete(ctx context.Context, subPath string) error {
+	found := false
+	for _, s := range d.candidates(subPath) {
+		err := s.driver.Delete(ctx, subPath)
+		switch err.(type) {
+		case nil:
+			found = true
+		case storagedriver.PathNotFoundError:
+		default:
+			return err
+		}
+	}
+	if !found {
+		return storagedriver.PathNotFoundError{Path: subPath}
+	}
+	return nil
+}
+
+// URLFor returns a URL which may be used to retrieve the content stored at the given path.
+// May return an UnsupportedMethodErr in certain StorageDriver implementations.
+func (d *driver) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	return "", storagedriver.ErrUnsupportedMethod{}
+}
+
+// Walk traverses a filesystem defined within driver, starting
+// from the given path, calling f on each file and directory
+func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn, options ...func(*storagedriver.WalkOptions)) error {
+	return storagedriver.WalkFallback(ctx, d, path, f, options...)
+}
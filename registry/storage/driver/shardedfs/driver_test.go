@@ -0,0 +1,309 @@
+package shardedfs
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+	"gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { check.TestingT(t) }
+
+func init() {
+	root, err := os.MkdirTemp("", "driver-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(root)
+
+	drvr, err := FromParameters(map[string]interface{}{
+		"rootdirectories": []interface{}{
+			filepath.Join(root, "a"),
+			filepath.Join(root, "b"),
+		},
+		"metadatadirectory": filepath.Join(root, "metadata"),
+		"usageinterval":     "0",
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	testsuites.RegisterSuite(func() (storagedriver.StorageDriver, error) {
+		return drvr, nil
+	}, testsuites.NeverSkip)
+}
+
+func TestFromParametersImpl(t *testing.T) {
+	params, err := fromParametersImpl(map[string]interface{}{
+		"rootdirectories": []interface{}{
+			"/mnt/disk1/",
+			map[interface{}]interface{}{"path": "/mnt/disk2", "weight": 2},
+			map[interface{}]interface{}{"path": "/mnt/disk3", "weight": "0"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := DriverParameters{
+		RootDirectories: []RootDirectory{
+			{Path: "/mnt/disk1", Weight: 1},
+			{Path: "/mnt/disk2", Weight: 2},
+			{Path: "/mnt/disk3", Weight: 0},
+		},
+		MetadataDirectory: "/mnt/disk1",
+		MaxThreads:        defaultMaxThreads,
+		UsageInterval:     defaultUsageInterval,
+	}
+	if !reflect.DeepEqual(*params, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, *params)
+	}
+
+	for _, parameters := range []map[string]interface{}{
+		{},
+		{"rootdirectories": []interface{}{}},
+		{"rootdirectories": "/mnt/disk1"},
+		{"rootdirectories": []interface{}{"/mnt/disk1", "/mnt/disk1/"}},
+		{"rootdirectories": []interface{}{map[interface{}]interface{}{"weight": 1}}},
+		{"rootdirectories": []interface{}{map[interface{}]interface{}{"path": "/mnt/disk1", "weight": -1}}},
+		{"rootdirectories": []interface{}{map[interface{}]interface{}{"path": "/mnt/disk1", "weight": 0}}},
+		{"rootdirectories": []interface{}{map[interface{}]interface{}{"path": "/mnt/disk1", "size": 1}}},
+		{"rootdirectories": []interface{}{"/mnt/disk1"}, "maxthreads": "fail"},
+		{"rootdirectories": []interface{}{"/mnt/disk1"}, "usageinterval": "-1m"},
+	} {
+		if _, err := fromParametersImpl(parameters); err == nil {
+			t.Errorf("expected an error for %v", parameters)
+		}
+	}
+}
+
+// blobDataPath returns the path of the blob data of the n-th test digest.
+func blobDataPath(n int) string {
+	hex := fmt.Sprintf("%064x", n)
+	return path.Join(blobsRoot, "sha256", hex[:2], hex, "data")
+}
+
+func newTestDriver(t *testing.T, roots ...string) *Driver {
+	t.Helper()
+	var list []interface{}
+	for _, root := range roots {
+		list = append(list, root)
+	}
+	d, err := FromParameters(map[string]interface{}{
+		"rootdirectories": list,
+		"usageinterval":   "0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
+
+func TestBlobsAreSharded(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	roots := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "c")}
+	d := newTestDriver(t, roots...)
+
+	const blobs = 60
+	perRoot := map[string]int{}
+	for i := 0; i < blobs; i++ {
+		p := blobDataPath(i)
+		if err := d.PutContent(ctx, p, []byte(p)); err != nil {
+			t.Fatal(err)
+		}
+		found := 0
+		for _, root := range roots {
+			if exists(filepath.Join(root, p)) {
+				perRoot[root]++
+				found++
+			}
+		}
+		if found != 1 {
+			t.Fatalf("expected %s on a single root directory, found it on %d", p, found)
+		}
+		content, err := d.GetContent(ctx, p)
+		if err != nil || string(content) != p {
+			t.Fatalf("unexpected content of %s: %q, %v", p, content, err)
+		}
+	}
+	for _, root := range roots {
+		if perRoot[root] == 0 {
+			t.Errorf("expected blobs on %s", root)
+		}
+	}
+
+	// the blob directories of all root directories are listed
+	var listed int
+	err := d.Walk(ctx, blobsRoot, func(fi storagedriver.FileInfo) error {
+		if path.Base(fi.Path()) == "data" {
+			listed++
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if listed != blobs {
+		t.Fatalf("expected %d blobs to be listed, got %d", blobs, listed)
+	}
+
+	// other paths are kept on the metadata directory
+	link := "/docker/registry/v2/repositories/foo/_layers/sha256/abc/link"
+	if err := d.PutContent(ctx, link, []byte("sha256:abc")); err != nil {
+		t.Fatal(err)
+	}
+	if !exists(filepath.Join(roots[0], link)) {
+		t.Fatalf("expected %s on the metadata directory", link)
+	}
+
+	if err := d.Delete(ctx, blobsRoot); err != nil {
+		t.Fatal(err)
+	}
+	for _, root := range roots {
+		if exists(filepath.Join(root, blobsRoot)) {
+			t.Errorf("expected the blobs of %s to be deleted", root)
+		}
+	}
+}
+
+func TestMoveAcrossRoots(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	metadata, blobs := filepath.Join(dir, "metadata"), filepath.Join(dir, "blobs")
+	d, err := FromParameters(map[string]interface{}{
+		"rootdirectories":   []interface{}{blobs},
+		"metadatadirectory": metadata,
+		"usageinterval":     "0",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upload := "/docker/registry/v2/repositories/foo/_uploads/id/data"
+	if err := d.PutContent(ctx, upload, []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Move(ctx, upload, blobDataPath(1)); err != nil {
+		t.Fatal(err)
+	}
+	if exists(filepath.Join(metadata, upload)) || !exists(filepath.Join(blobs, blobDataPath(1))) {
+		t.Fatal("expected the upload to be moved to the blobs directory")
+	}
+	if content, err := d.GetContent(ctx, blobDataPath(1)); err != nil || string(content) != "content" {
+		t.Fatalf("unexpected content %q, %v", content, err)
+	}
+	// the temporary files are not listed
+	if children, err := d.List(ctx, "/"); err != nil || !reflect.DeepEqual(children, []string{"/docker"}) {
+		t.Fatalf("unexpected children of the root %v, %v", children, err)
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	roots := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+
+	const blobs = 40
+	d := newTestDriver(t, roots...)
+	for i := 0; i < blobs; i++ {
+		if err := d.PutContent(ctx, blobDataPath(i), []byte("blob")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a disk is added
+	roots = append(roots, filepath.Join(dir, "c"))
+	d = newTestDriver(t, roots...)
+	var reassigned int
+	for i := 0; i < blobs; i++ {
+		if d.driver.assign(fmt.Sprintf("sha256:%064x", i)).root == roots[2] {
+			reassigned++
+		}
+		// blobs are served before they are moved
+		if _, err := d.GetContent(ctx, blobDataPath(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if reassigned == 0 || reassigned == blobs {
+		t.Fatalf("expected a share of the blobs to be reassigned, got %d", reassigned)
+	}
+
+	result, err := d.Rebalance(ctx, RebalanceOpts{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Moved != reassigned || exists(filepath.Join(roots[2], blobsRoot)) {
+		t.Fatalf("unexpected dry run %+v", result)
+	}
+
+	result, err = d.Rebalance(ctx, RebalanceOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := RebalanceResult{Checked: blobs, Moved: reassigned, MovedBytes: int64(reassigned * len("blob"))}
+	if result != expected {
+		t.Fatalf("expected %+v, got %+v", expected, result)
+	}
+	for i := 0; i < blobs; i++ {
+		assigned := d.driver.assign(fmt.Sprintf("sha256:%064x", i)).root
+		for _, root := range roots {
+			if exists(filepath.Join(root, blobDataPath(i))) != (root == assigned) {
+				t.Fatalf("expected blob %d on %s only", i, assigned)
+			}
+		}
+		if content, err := d.GetContent(ctx, blobDataPath(i)); err != nil || string(content) != "blob" {
+			t.Fatalf("unexpected content %q, %v", content, err)
+		}
+	}
+
+	// duplicates left by an interrupted rebalance are removed
+	for _, root := range roots {
+		p := filepath.Join(root, blobDataPath(0))
+		if err := os.MkdirAll(filepath.Dir(p), 0o777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte("blob"), 0o666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err = d.Rebalance(ctx, RebalanceOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Moved != 0 || result.Removed != 2 {
+		t.Fatalf("expected the duplicates to be removed, got %+v", result)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	dir := t.TempDir()
+	roots := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}
+	for _, root := range roots {
+		if err := os.Mkdir(root, 0o777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	usages, err := newTestDriver(t, roots...).Usage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usages) != 2 || !usages[0].Metadata || usages[0].Root != roots[0] || usages[1].Metadata {
+		t.Fatalf("unexpected usages %+v", usages)
+	}
+	if usages[0].TotalBytes == 0 || usages[0].FreeBytes > usages[0].TotalBytes {
+		t.Fatalf("unexpected disk usage %+v", usages[0])
+	}
+}
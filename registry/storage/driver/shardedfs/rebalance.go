@@ -0,0 +1,150 @@
+package shardedfs
+
+import (
+	"context"
+	"path"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// RebalanceOpts configure Rebalance.
+type RebalanceOpts struct {
+	// DryRun reports the blobs to move without moving them.
+	DryRun bool
+}
+
+// RebalanceResult reports the blobs checked and moved by Rebalance.
+type RebalanceResult struct {
+	// Checked is the number of blob data directories found.
+	Checked int
+	// Moved is the number of blob data directories moved to the root
+	// directory they are assigned to, and MovedBytes the size of their files.
+	Moved      int
+	MovedBytes int64
+	// Removed is the number of blob data directories removed from a root
+	// directory as the one they are assigned to already holds them.
+	Removed int
+}
+
+// Rebalance moves the blob data directories which are not on the root
+// directory they are assigned to, after a root directory was added or its
+// weight changed. The registry may keep serving content while the blobs are
+// moved, as each file is copied to a temporary file renamed in place before
+// the original is removed.
+func (d *Driver) Rebalance(ctx context.Context, opts RebalanceOpts) (RebalanceResult, error) {
+	var result RebalanceResult
+	// moved are the blobs moved to a root directory yet to be walked
+	moved := map[string]bool{}
+	for _, s := range d.driver.shards {
+		err := forEachBlobDir(ctx, s, func(dir, dgst string) error {
+			if moved[dgst] {
+				return nil
+			}
+			result.Checked++
+			assigned := d.driver.assign(dgst)
+			if assigned == s {
+				return nil
+			}
+
+			logger := dcontext.GetLoggerWithFields(ctx, map[interface{}]interface{}{
+				"digest": dgst,
+				"from":   s.root,
+				"to":     assigned.root,
+			})
+			if _, err := assigned.driver.Stat(ctx, path.Join(dir, "data")); err == nil {
+				result.Removed++
+				if opts.DryRun {
+					logger.Info("would remove the duplicate of the blob")
+					return nil
+				}
+				logger.Info("removing the duplicate of the blob")
+				return s.driver.Delete(ctx, dir)
+			} else if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+				return err
+			}
+
+			size, err := moveDir(ctx, s, assigned, dir, opts.DryRun)
+			if err != nil {
+				return err
+			}
+			result.Moved++
+			result.MovedBytes += size
+			moved[dgst] = true
+			if opts.DryRun {
+				logger.Info("would move the blob")
+			} else {
+				logger.Info("moved the blob")
+			}
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// forEachBlobDir calls f with the path and digest of each blob data directory
+// of s.
+func forEachBlobDir(ctx context.Context, s *shard, f func(dir, dgst string) error) error {
+	list := func(p string) ([]string, error) {
+		children, err := s.driver.List(ctx, p)
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return children, err
+	}
+
+	algorithms, err := list(blobsRoot)
+	if err != nil {
+		return err
+	}
+	for _, algorithm := range algorithms {
+		prefixes, err := list(algorithm)
+		if err != nil {
+			return err
+		}
+		for _, prefix := range prefixes {
+			dirs, err := list(prefix)
+			if err != nil {
+				return err
+			}
+			for _, dir := range dirs {
+				dgst, ok := blobDigest(dir)
+				if !ok {
+					continue
+				}
+				if err := f(dir, dgst); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// moveDir moves the files of dir from source to dest and returns their size.
+func moveDir(ctx context.Context, source, dest *shard, dir string, dryRun bool) (int64, error) {
+	var (
+		size  int64
+		files []string
+	)
+	err := source.driver.Walk(ctx, dir, func(fi storagedriver.FileInfo) error {
+		if !fi.IsDir() {
+			size += fi.Size()
+			files = append(files, fi.Path())
+		}
+		return nil
+	})
+	if err != nil || dryRun {
+		return size, err
+	}
+
+	for _, file := range files {
+		if _, err := copyFile(ctx, source, dest, file, file); err != nil {
+			return 0, err
+		}
+	}
+	return size, source.driver.Delete(ctx, dir)
+}
@@ -0,0 +1,56 @@
+package shardedfs
+
+import (
+	"context"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+)
+
+// diskBytes is the size and free space of the disks of the root directories.
+var diskBytes = prometheus.StorageNamespace.NewLabeledGauge("shardedfs_disk", "The size and free space of the disks of the root directories of the shardedfs driver", metrics.Bytes, "root", "state")
+
+// DiskUsage is the usage of the disk of a root directory.
+type DiskUsage struct {
+	Root string `json:"root"`
+	// Metadata is true for the metadata directory.
+	Metadata bool `json:"metadata"`
+	// Weight is the share of the blobs assigned to the directory, zero for a
+	// metadata directory holding no blob data.
+	Weight     float64 `json:"weight"`
+	TotalBytes uint64  `json:"totalBytes"`
+	FreeBytes  uint64  `json:"freeBytes"`
+}
+
+// Usage returns the usage of the disks of the root directories, the metadata
+// directory first, and reports it in the metrics.
+func (d *Driver) Usage() ([]DiskUsage, error) {
+	var usages []DiskUsage
+	for _, s := range d.driver.roots {
+		total, free, err := diskSpace(s.root)
+		if err != nil {
+			return nil, err
+		}
+		diskBytes.WithValues(s.root, "total").Set(float64(total))
+		diskBytes.WithValues(s.root, "free").Set(float64(free))
+		usages = append(usages, DiskUsage{
+			Root:       s.root,
+			Metadata:   s == d.driver.metadata,
+			Weight:     s.weight,
+			TotalBytes: total,
+			FreeBytes:  free,
+		})
+	}
+	return usages, nil
+}
+
+// reportUsage reports the usage of the disks in the metrics every interval.
+func (d *Driver) reportUsage(interval time.Duration) {
+	for ; ; time.Sleep(interval) {
+		if _, err := d.Usage(); err != nil {
+			dcontext.GetLogger(context.Background()).Warnf("shardedfs: failed to report disk usage: %v", err)
+		}
+	}
+}
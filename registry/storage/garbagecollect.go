@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
@@ -18,6 +19,11 @@ func emit(format string, a ...interface{}) {
 type GCOpts struct {
 	DryRun         bool
 	RemoveUntagged bool
+
+	// TrashRetention is the time soft deleted manifests and tags are kept
+	// in the recycle bin. Entries older than this are purged; the content
+	// of younger ones is kept. Zero selects DefaultTrashRetention.
+	TrashRetention time.Duration
 }
 
 // ManifestDel contains manifest structure which will be deleted
@@ -40,28 +46,30 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
 		emit(repoName)
 
-		var err error
-		named, err := reference.WithName(repoName)
-		if err != nil {
-			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
-		}
-		repository, err := registry.Repository(ctx, named)
+		// Soft deleted manifests keep their content until they expire, and
+		// soft deleted tags keep their manifest from being removed as
+		// untagged.
+		trashed, err := NewRepositoryTrash(storageDriver, repoName, opts.TrashRetention).purge(ctx, opts.DryRun)
 		if err != nil {
-			return fmt.Errorf("failed to construct repository: %v", err)
+			return fmt.Errorf("failed to purge trash of %s: %v", repoName, err)
 		}
-
-		manifestService, err := repository.Manifests(ctx)
-		if err != nil {
-			return fmt.Errorf("failed to construct manifest service: %v", err)
+		// The manifests referenced by trashed ones, such as the children of
+		// an index, are kept along with their blobs, so that restoring the
+		// trashed manifest restores a complete image.
+		kept := make(map[digest.Digest]struct{})
+		for _, entry := range trashed.Tags {
+			kept[entry.Digest] = struct{}{}
 		}
-
-		manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
-		if !ok {
-			return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+		for _, entry := range trashed.Manifests {
+			emit("%s: marking trashed manifest %s", repoName, entry.Digest)
+			markSet[entry.Digest] = struct{}{}
+			if err := markTrashedReferences(ctx, registry, repoName, entry.References, markSet, kept); err != nil {
+				return err
+			}
 		}
 
-		err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
-			if opts.RemoveUntagged {
+		return enumerateManifests(ctx, registry, repoName, func(repository distribution.Repository, manifestService distribution.ManifestService, dgst digest.Digest) error {
+			if _, ok := kept[dgst]; opts.RemoveUntagged && !ok {
 				// fetch all tags where this manifest is the latest one
 				tags, err := repository.Tags(ctx).Lookup(ctx, distribution.Descriptor{Digest: dgst})
 				if err != nil {
@@ -97,17 +105,6 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 
 			return nil
 		})
-
-		// In certain situations such as unfinished uploads, deleting all
-		// tags in S3 or removing the _manifests folder manually, this
-		// error may be of type PathNotFound.
-		//
-		// In these cases we can continue marking other manifests safely.
-		if _, ok := err.(driver.PathNotFoundError); ok {
-			return nil
-		}
-
-		return err
 	})
 	if err != nil {
 		return fmt.Errorf("failed to mark: %v", err)
@@ -149,3 +146,84 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 
 	return err
 }
+
+// markTrashedReferences marks the blobs and manifests referenced by a trashed
+// manifest of the named repository, and the blobs and manifests those
+// manifests reference in turn. The referenced manifests are added to kept.
+func markTrashedReferences(ctx context.Context, registry distribution.Namespace, repoName string, references []digest.Digest, markSet, kept map[digest.Digest]struct{}) error {
+	named, err := reference.WithName(repoName)
+	if err != nil {
+		return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+	}
+	repository, err := registry.Repository(ctx, named)
+	if err != nil {
+		return fmt.Errorf("failed to construct repository: %v", err)
+	}
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+
+	for len(references) > 0 {
+		dgst := references[0]
+		references = references[1:]
+		markSet[dgst] = struct{}{}
+		if _, ok := kept[dgst]; ok {
+			continue
+		}
+
+		// references to layers and configs are not manifests of the
+		// repository
+		manifest, err := manifestService.Get(ctx, dgst)
+		if err != nil {
+			if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+				continue
+			}
+			return fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
+		}
+		emit("%s: marking manifest %s referenced by a trashed manifest", repoName, dgst)
+		kept[dgst] = struct{}{}
+		for _, descriptor := range manifest.References() {
+			references = append(references, descriptor.Digest)
+		}
+	}
+	return nil
+}
+
+// enumerateManifests constructs the named repository and calls ingester with
+// each of its manifest revisions.
+func enumerateManifests(ctx context.Context, registry distribution.Namespace, repoName string, ingester func(repository distribution.Repository, manifestService distribution.ManifestService, dgst digest.Digest) error) error {
+	named, err := reference.WithName(repoName)
+	if err != nil {
+		return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+	}
+	repository, err := registry.Repository(ctx, named)
+	if err != nil {
+		return fmt.Errorf("failed to construct repository: %v", err)
+	}
+
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+
+	manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	}
+
+	err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		return ingester(repository, manifestService, dgst)
+	})
+
+	// In certain situations such as unfinished uploads, deleting all
+	// tags in S3 or removing the _manifests folder manually, this
+	// error may be of type PathNotFound.
+	//
+	// In these cases we can continue with other manifests safely.
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	}
+
+	return err
+}
@@ -16,6 +16,7 @@ type manifestListHandler struct {
 	repository distribution.Repository
 	blobStore  distribution.BlobStore
 	ctx        context.Context
+	policies   []ManifestPolicy
 }
 
 var _ ManifestHandler = &manifestListHandler{}
@@ -49,6 +50,10 @@ func (ms *manifestListHandler) Put(ctx context.Context, manifestList distributio
 		return "", fmt.Errorf("unrecognized manifest list schema version %d, expected %d", schemaVersion, expectedSchemaVersion)
 	}
 
+	if err := checkManifestPolicies(ms.policies, manifestList); err != nil {
+		return "", err
+	}
+
 	if err := ms.verifyManifest(ms.ctx, manifestList, skipDependencyVerification); err != nil {
 		return "", err
 	}
@@ -0,0 +1,217 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// ManifestPolicy restricts the content of manifests pushed to the
+// repositories matching Repository, a path.Match pattern; an empty pattern
+// matches every repository. Zero values leave the corresponding property
+// unrestricted.
+type ManifestPolicy struct {
+	Repository string
+
+	// MediaTypes lists the media types of the manifests which may be pushed.
+	MediaTypes []string
+
+	// ArtifactTypes lists the artifact types of the manifests which may be
+	// pushed. The artifact type of an image manifest without an explicit
+	// artifactType is the media type of its config.
+	ArtifactTypes []string
+
+	// MaxSize is the maximum size of the manifest in bytes.
+	MaxSize int64
+
+	// MaxLayerSize is the maximum size of a layer in bytes.
+	MaxLayerSize int64
+
+	// MaxLayers is the maximum number of layers of an image manifest.
+	MaxLayers int
+
+	// Annotations lists the annotations manifests must have. Docker
+	// manifests, which cannot carry annotations, are rejected.
+	Annotations []string
+
+	// Platforms lists the platforms, as os/architecture[/variant], which
+	// image indexes and manifest lists must reference. A platform without a
+	// variant is satisfied by any variant. Image manifests are not affected.
+	Platforms []string
+}
+
+// matches returns true if the policy applies to the named repository.
+func (policy ManifestPolicy) matches(name string) bool {
+	if policy.Repository == "" {
+		return true
+	}
+	ok, _ := path.Match(policy.Repository, name)
+	return ok
+}
+
+// ManifestPolicies is a functional option for NewRegistry. Manifests pushed
+// to a repository must satisfy every policy matching it, or the push fails
+// with distribution.ErrManifestPolicy.
+func ManifestPolicies(policies ...ManifestPolicy) RegistryOption {
+	return func(registry *registry) error {
+		for _, policy := range policies {
+			if _, err := path.Match(policy.Repository, ""); err != nil {
+				return fmt.Errorf("invalid manifest policy repository pattern %q: %v", policy.Repository, err)
+			}
+			for _, platform := range policy.Platforms {
+				if _, _, _, err := parsePlatform(platform); err != nil {
+					return fmt.Errorf("invalid manifest policy platform: %v", err)
+				}
+			}
+		}
+		registry.manifestPolicies = append(registry.manifestPolicies, policies...)
+		return nil
+	}
+}
+
+// manifestPoliciesFor returns the manifest policies applying to the named
+// repository.
+func (reg *registry) manifestPoliciesFor(name string) []ManifestPolicy {
+	var policies []ManifestPolicy
+	for _, policy := range reg.manifestPolicies {
+		if policy.matches(name) {
+			policies = append(policies, policy)
+		}
+	}
+	return policies
+}
+
+// parsePlatform splits a platform of the form os/architecture[/variant].
+func parsePlatform(platform string) (os, architecture, variant string, err error) {
+	parts := strings.Split(platform, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return "", "", "", fmt.Errorf("platform %q is not of the form os/architecture[/variant]", platform)
+	}
+	if len(parts) == 3 {
+		variant = parts[2]
+	}
+	return parts[0], parts[1], variant, nil
+}
+
+// checkManifestPolicies returns an ErrManifestPolicy for the first rule of
+// the policies the manifest breaks, or nil if it satisfies all of them.
+func checkManifestPolicies(policies []ManifestPolicy, mnfst distribution.Manifest) error {
+	if len(policies) == 0 {
+		return nil
+	}
+
+	mediaType, payload, err := mnfst.Payload()
+	if err != nil {
+		return err
+	}
+
+	var (
+		artifactType string
+		layers       []distribution.Descriptor
+		annotations  map[string]string
+		platforms    []v1.Platform
+		isIndex      bool
+	)
+	// The artifactType field is not part of the manifest types, so it is
+	// read from the payload.
+	var artifact struct {
+		ArtifactType string `json:"artifactType"`
+	}
+	if err := json.Unmarshal(payload, &artifact); err != nil {
+		return err
+	}
+	artifactType = artifact.ArtifactType
+
+	switch m := mnfst.(type) {
+	case *schema2.DeserializedManifest:
+		artifactType = m.Config.MediaType
+		layers = m.Layers
+	case *ocischema.DeserializedManifest:
+		if artifactType == "" {
+			artifactType = m.Config.MediaType
+		}
+		layers = m.Layers
+		annotations = m.Annotations
+	case *ocischema.DeserializedImageIndex:
+		annotations = m.Annotations
+		isIndex = true
+		for _, desc := range m.Manifests {
+			if desc.Platform != nil {
+				platforms = append(platforms, *desc.Platform)
+			}
+		}
+	case *manifestlist.DeserializedManifestList:
+		isIndex = true
+		for _, desc := range m.Manifests {
+			platforms = append(platforms, v1.Platform{
+				OS:           desc.Platform.OS,
+				Architecture: desc.Platform.Architecture,
+				Variant:      desc.Platform.Variant,
+			})
+		}
+	}
+
+	for _, policy := range policies {
+		if len(policy.MediaTypes) > 0 && !contains(policy.MediaTypes, mediaType) {
+			return distribution.ErrManifestPolicy{Reason: fmt.Sprintf("media type %q is not allowed", mediaType)}
+		}
+		if len(policy.ArtifactTypes) > 0 && !contains(policy.ArtifactTypes, artifactType) {
+			return distribution.ErrManifestPolicy{Reason: fmt.Sprintf("artifact type %q is not allowed", artifactType)}
+		}
+		if policy.MaxSize > 0 && int64(len(payload)) > policy.MaxSize {
+			return distribution.ErrManifestPolicy{Reason: fmt.Sprintf("manifest size %d exceeds the maximum of %d bytes", len(payload), policy.MaxSize)}
+		}
+		if policy.MaxLayers > 0 && len(layers) > policy.MaxLayers {
+			return distribution.ErrManifestPolicy{Reason: fmt.Sprintf("manifest has %d layers, more than the maximum of %d", len(layers), policy.MaxLayers)}
+		}
+		if policy.MaxLayerSize > 0 {
+			for _, layer := range layers {
+				if layer.Size > policy.MaxLayerSize {
+					return distribution.ErrManifestPolicy{Reason: fmt.Sprintf("layer %s size %d exceeds the maximum of %d bytes", layer.Digest, layer.Size, policy.MaxLayerSize)}
+				}
+			}
+		}
+		for _, annotation := range policy.Annotations {
+			if annotations[annotation] == "" {
+				return distribution.ErrManifestPolicy{Reason: fmt.Sprintf("required annotation %q is missing", annotation)}
+			}
+		}
+		if isIndex {
+			for _, platform := range policy.Platforms {
+				if !hasPlatform(platforms, platform) {
+					return distribution.ErrManifestPolicy{Reason: fmt.Sprintf("required platform %q is missing", platform)}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// hasPlatform returns true if one of the platforms matches the required
+// platform, given as os/architecture[/variant].
+func hasPlatform(platforms []v1.Platform, required string) bool {
+	os, architecture, variant, _ := parsePlatform(required)
+	for _, platform := range platforms {
+		if platform.OS == os && platform.Architecture == architecture && (variant == "" || platform.Variant == variant) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestManifestPolicies(t *testing.T) {
+	ctx := context.Background()
+	registry := createRegistry(t, inmemory.New(), ManifestPolicies(
+		ManifestPolicy{
+			Repository:   "apps/*",
+			MediaTypes:   []string{v1.MediaTypeImageManifest, v1.MediaTypeImageIndex},
+			MaxLayers:    2,
+			MaxLayerSize: 1 << 20,
+			Annotations:  []string{"org.opencontainers.image.source"},
+			Platforms:    []string{"linux/amd64", "linux/arm64"},
+		},
+		ManifestPolicy{
+			Repository:    "charts/*",
+			ArtifactTypes: []string{"application/vnd.cncf.helm.config.v1+json"},
+			MaxSize:       1024,
+		},
+	))
+
+	put := func(name string, m distribution.Manifest) error {
+		t.Helper()
+		repo := makeRepository(t, registry, name)
+		_, err := makeManifestService(t, repo).Put(ctx, m)
+		return err
+	}
+
+	image := func(name, configType string, annotations map[string]string, layers int) distribution.Manifest {
+		t.Helper()
+		blobs := makeRepository(t, registry, name).Blobs(ctx)
+		config, err := blobs.Put(ctx, configType, []byte("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.MediaType = configType
+		m := ocischema.Manifest{
+			Versioned: manifest.Versioned{
+				SchemaVersion: 2,
+				MediaType:     v1.MediaTypeImageManifest,
+			},
+			Config:      config,
+			Annotations: annotations,
+		}
+		for i := 0; i < layers; i++ {
+			layer, err := blobs.Put(ctx, v1.MediaTypeImageLayerGzip, []byte{byte(i)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.Layers = append(m.Layers, layer)
+		}
+		dm, err := ocischema.FromStruct(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return dm
+	}
+
+	source := map[string]string{"org.opencontainers.image.source": "https://example.com/app"}
+
+	if err := put("apps/web", image("apps/web", v1.MediaTypeImageConfig, source, 2)); err != nil {
+		t.Fatalf("unexpected error pushing compliant image: %v", err)
+	}
+	// repositories not selected by a policy are unrestricted
+	if err := put("other/web", image("other/web", v1.MediaTypeImageConfig, nil, 3)); err != nil {
+		t.Fatalf("unexpected error pushing to unrestricted repository: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		repo     string
+		manifest distribution.Manifest
+	}{
+		{"missing annotation", "apps/web", image("apps/web", v1.MediaTypeImageConfig, nil, 1)},
+		{"too many layers", "apps/web", image("apps/web", v1.MediaTypeImageConfig, source, 3)},
+		{"artifact type", "charts/web", image("charts/web", v1.MediaTypeImageConfig, nil, 0)},
+	} {
+		if _, ok := put(tc.repo, tc.manifest).(distribution.ErrManifestPolicy); !ok {
+			t.Errorf("%s: expected manifest policy error", tc.name)
+		}
+	}
+
+	if err := put("charts/web", image("charts/web", "application/vnd.cncf.helm.config.v1+json", nil, 0)); err != nil {
+		t.Fatalf("unexpected error pushing chart: %v", err)
+	}
+
+	// docker manifests are not allowed by the media types of the policy
+	blobs := makeRepository(t, registry, "apps/web").Blobs(ctx)
+	config, err := blobs.Put(ctx, schema2.MediaTypeImageConfig, []byte("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docker, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    config,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := put("apps/web", docker).(distribution.ErrManifestPolicy); !ok {
+		t.Errorf("expected docker manifest to be rejected")
+	}
+
+	// image indexes must reference the required platforms
+	amd64 := image("apps/web", v1.MediaTypeImageConfig, source, 1)
+	arm64 := image("apps/web", v1.MediaTypeImageConfig, source, 2)
+	for _, m := range []distribution.Manifest{amd64, arm64} {
+		if err := put("apps/web", m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	descriptor := func(m distribution.Manifest, architecture, variant string) distribution.Descriptor {
+		mediaType, payload, _ := m.Payload()
+		return distribution.Descriptor{
+			MediaType: mediaType,
+			Digest:    digest.FromBytes(payload),
+			Size:      int64(len(payload)),
+			Platform:  &v1.Platform{OS: "linux", Architecture: architecture, Variant: variant},
+		}
+	}
+
+	index, err := ocischema.FromDescriptors([]distribution.Descriptor{descriptor(amd64, "amd64", "")}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := put("apps/web", index).(distribution.ErrManifestPolicy); !ok {
+		t.Errorf("expected index without arm64 to be rejected")
+	}
+
+	// a required platform without variant is satisfied by any variant
+	index, err = ocischema.FromDescriptors([]distribution.Descriptor{
+		descriptor(amd64, "amd64", ""),
+		descriptor(arm64, "arm64", "v8"),
+	}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := put("apps/web", index); err != nil {
+		t.Fatalf("unexpected error pushing index: %v", err)
+	}
+
+	// manifest lists are subject to the media types of the policy
+	list, err := manifestlist.FromDescriptors([]manifestlist.ManifestDescriptor{
+		{Descriptor: descriptor(amd64, "amd64", ""), Platform: manifestlist.PlatformSpec{OS: "linux", Architecture: "amd64"}},
+		{Descriptor: descriptor(arm64, "arm64", ""), Platform: manifestlist.PlatformSpec{OS: "linux", Architecture: "arm64"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := put("apps/web", list).(distribution.ErrManifestPolicy); !ok {
+		t.Errorf("expected manifest list to be rejected")
+	}
+}
+
+func TestManifestPoliciesInvalid(t *testing.T) {
+	for _, policy := range []ManifestPolicy{
+		{Repository: "["},
+		{Platforms: []string{"linux"}},
+		{Platforms: []string{"linux/arm/v7/extra"}},
+	} {
+		if _, err := NewRegistry(context.Background(), inmemory.New(), ManifestPolicies(policy)); err == nil {
+			t.Errorf("expected error for policy %+v", policy)
+		}
+	}
+}
@@ -128,23 +128,58 @@ func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ..
 func (ms *manifestStore) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Put")
 
+	var handler ManifestHandler
 	switch manifest.(type) {
 	case *schema2.DeserializedManifest:
-		return ms.schema2Handler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.schema2Handler
 	case *ocischema.DeserializedManifest:
-		return ms.ocischemaHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.ocischemaHandler
 	case *manifestlist.DeserializedManifestList:
-		return ms.manifestListHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.manifestListHandler
 	case *ocischema.DeserializedImageIndex:
-		return ms.ocischemaIndexHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		handler = ms.ocischemaIndexHandler
+	default:
+		return "", fmt.Errorf("unrecognized manifest type %T", manifest)
 	}
 
-	return "", fmt.Errorf("unrecognized manifest type %T", manifest)
+	// the first manifest creates the repository
+	name := ms.repository.Named().Name()
+	isNew := ms.repository.isNewRepository(ctx, name)
+
+	dgst, err := handler.Put(ctx, manifest, ms.skipDependencyVerification)
+	if err != nil {
+		return "", err
+	}
+
+	if isNew {
+		ms.repository.addToCatalog(ctx, name)
+	}
+	return dgst, nil
 }
 
 // Delete removes the revision of the specified manifest.
 func (ms *manifestStore) Delete(ctx context.Context, dgst digest.Digest) error {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Delete")
+
+	if ms.repository.softDelete {
+		manifest, err := ms.Get(ctx, dgst)
+		if err != nil {
+			if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+				return distribution.ErrBlobUnknown
+			}
+			return err
+		}
+		var references []digest.Digest
+		for _, descriptor := range manifest.References() {
+			references = append(references, descriptor.Digest)
+		}
+
+		trash := NewRepositoryTrash(ms.blobStore.driver, ms.repository.Named().Name(), 0)
+		if err := trash.putManifest(ctx, dgst, references); err != nil {
+			return err
+		}
+	}
+
 	return ms.blobStore.Delete(ctx, dgst)
 }
 
@@ -0,0 +1,607 @@
+package storage
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// OCILayoutResult summarizes an export or import of an OCI image layout.
+type OCILayoutResult struct {
+	// Manifests is the number of manifests copied.
+	Manifests int `json:"manifests"`
+
+	// Blobs is the number of layers and configs copied. Blobs already
+	// present in the target are not counted.
+	Blobs int `json:"blobs"`
+
+	// Bytes is the size of the copied blobs.
+	Bytes int64 `json:"bytes"`
+
+	// Tags are the tags copied.
+	Tags []string `json:"tags"`
+}
+
+// ExportOCILayout writes the manifests of a repository, along with the
+// manifests, layers and configs they reference, to an OCI image layout at
+// dst. If ref carries a tag or digest only that manifest is exported,
+// otherwise all tags of the repository are. A ref carrying both exports the
+// manifest of the digest under the tag. Tags are recorded with the
+// org.opencontainers.image.ref.name annotation in the index of the layout.
+//
+// If dst ends in ".tar" the layout is written as an uncompressed tarball,
+// otherwise as a directory which must not exist or be empty.
+func ExportOCILayout(ctx context.Context, registry distribution.Namespace, ref reference.Named, dst string) (OCILayoutResult, error) {
+	repository, err := registry.Repository(ctx, reference.TrimNamed(ref))
+	if err != nil {
+		return OCILayoutResult{}, err
+	}
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return OCILayoutResult{}, err
+	}
+
+	// collect the manifests to be listed in the index of the layout
+	var roots []v1.Descriptor
+	switch ref := ref.(type) {
+	case reference.Digested:
+		root := v1.Descriptor{Digest: ref.Digest()}
+		// a reference with both keeps its tag, applied to the digest
+		if tagged, ok := ref.(reference.Tagged); ok {
+			root.Annotations = map[string]string{v1.AnnotationRefName: tagged.Tag()}
+		}
+		roots = append(roots, root)
+	case reference.Tagged:
+		desc, err := repository.Tags(ctx).Get(ctx, ref.Tag())
+		if err != nil {
+			return OCILayoutResult{}, err
+		}
+		roots = append(roots, v1.Descriptor{
+			Digest:      desc.Digest,
+			Annotations: map[string]string{v1.AnnotationRefName: ref.Tag()},
+		})
+	default:
+		tags, err := repository.Tags(ctx).All(ctx)
+		if err != nil {
+			return OCILayoutResult{}, err
+		}
+		for _, tag := range tags {
+			desc, err := repository.Tags(ctx).Get(ctx, tag)
+			if err != nil {
+				return OCILayoutResult{}, err
+			}
+			roots = append(roots, v1.Descriptor{
+				Digest:      desc.Digest,
+				Annotations: map[string]string{v1.AnnotationRefName: tag},
+			})
+		}
+	}
+
+	w, err := newLayoutWriter(dst)
+	if err != nil {
+		return OCILayoutResult{}, err
+	}
+
+	e := &layoutExporter{
+		ctx:       ctx,
+		manifests: manifestService,
+		blobs:     repository.Blobs(ctx),
+		w:         w,
+		written:   make(map[digest.Digest]struct{}),
+		result:    OCILayoutResult{Tags: []string{}},
+	}
+	if err := e.export(roots); err != nil {
+		w.Close()
+		return e.result, err
+	}
+	return e.result, w.Close()
+}
+
+// ImportOCILayout loads the OCI image layout at src, a directory or an
+// uncompressed tarball, into the named repository. The manifests listed in
+// the index of the layout are imported with everything they reference and
+// tagged with their org.opencontainers.image.ref.name annotation, if any.
+// Manifests are stored unchanged, so their annotations and digests are
+// preserved.
+func ImportOCILayout(ctx context.Context, registry distribution.Namespace, name reference.Named, src string) (OCILayoutResult, error) {
+	repository, err := registry.Repository(ctx, name)
+	if err != nil {
+		return OCILayoutResult{}, err
+	}
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return OCILayoutResult{}, err
+	}
+
+	r, err := newLayoutReader(src)
+	if err != nil {
+		return OCILayoutResult{}, err
+	}
+	defer r.Close()
+
+	var layout v1.ImageLayout
+	if err := readLayoutJSON(r, v1.ImageLayoutFile, &layout); err != nil {
+		return OCILayoutResult{}, fmt.Errorf("%s is not an OCI image layout: %v", src, err)
+	}
+	if layout.Version != v1.ImageLayoutVersion {
+		return OCILayoutResult{}, fmt.Errorf("unsupported OCI image layout version %q", layout.Version)
+	}
+	var index v1.Index
+	if err := readLayoutJSON(r, "index.json", &index); err != nil {
+		return OCILayoutResult{}, err
+	}
+
+	im := &layoutImporter{
+		ctx:       ctx,
+		manifests: manifestService,
+		blobs:     repository.Blobs(ctx),
+		r:         r,
+		imported:  make(map[digest.Digest]struct{}),
+		result:    OCILayoutResult{Tags: []string{}},
+	}
+	for _, desc := range index.Manifests {
+		tag, err := RefNameTag(name, desc.Annotations)
+		if err != nil {
+			return im.result, err
+		}
+		if err := im.importManifest(desc.MediaType, desc.Digest, desc.Size); err != nil {
+			return im.result, err
+		}
+		if tag == "" {
+			continue
+		}
+		err = repository.Tags(ctx).Tag(ctx, tag, distribution.Descriptor{
+			MediaType: desc.MediaType,
+			Digest:    desc.Digest,
+			Size:      desc.Size,
+		})
+		if err != nil {
+			return im.result, fmt.Errorf("failed to tag %s as %s: %v", desc.Digest, tag, err)
+		}
+		im.result.Tags = append(im.result.Tags, tag)
+	}
+	return im.result, nil
+}
+
+// RefNameTag returns the tag of the repository name given by the
+// org.opencontainers.image.ref.name annotation of an OCI image layout index
+// entry, which holds either a plain tag or a full reference. It returns an
+// empty tag if there is no such annotation.
+func RefNameTag(name reference.Named, annotations map[string]string) (string, error) {
+	refName := annotations[v1.AnnotationRefName]
+	if refName == "" {
+		return "", nil
+	}
+	tag := refName
+	if ref, err := reference.Parse(refName); err == nil {
+		if tagged, ok := ref.(reference.Tagged); ok {
+			tag = tagged.Tag()
+		}
+	}
+	if _, err := reference.WithTag(name, tag); err != nil {
+		return "", fmt.Errorf("invalid reference name %q: %v", refName, err)
+	}
+	return tag, nil
+}
+
+// isManifestMediaType returns true for the media types of manifests which
+// may be referenced by a manifest list or image index.
+func isManifestMediaType(mediaType string) bool {
+	switch mediaType {
+	case v1.MediaTypeImageManifest, v1.MediaTypeImageIndex, schema2.MediaTypeManifest, manifestlist.MediaTypeManifestList:
+		return true
+	}
+	return false
+}
+
+func layoutBlobPath(dgst digest.Digest) string {
+	return path.Join("blobs", dgst.Algorithm().String(), dgst.Encoded())
+}
+
+type layoutExporter struct {
+	ctx       context.Context
+	manifests distribution.ManifestService
+	blobs     distribution.BlobStore
+	w         layoutWriter
+	written   map[digest.Digest]struct{}
+	result    OCILayoutResult
+}
+
+func (e *layoutExporter) export(roots []v1.Descriptor) error {
+	layout, err := json.Marshal(v1.ImageLayout{Version: v1.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	if err := e.w.writeFile(v1.ImageLayoutFile, int64(len(layout)), bytes.NewReader(layout)); err != nil {
+		return err
+	}
+
+	for i, root := range roots {
+		desc, err := e.exportManifest(root.Digest)
+		if err != nil {
+			return err
+		}
+		roots[i].MediaType = desc.MediaType
+		roots[i].Size = desc.Size
+		if tag := root.Annotations[v1.AnnotationRefName]; tag != "" {
+			e.result.Tags = append(e.result.Tags, tag)
+		}
+	}
+
+	index, err := json.MarshalIndent(v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageIndex,
+		Manifests: roots,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return e.w.writeFile("index.json", int64(len(index)), bytes.NewReader(index))
+}
+
+// exportManifest writes the manifest and everything it references.
+func (e *layoutExporter) exportManifest(dgst digest.Digest) (distribution.Descriptor, error) {
+	manifest, err := e.manifests.Get(e.ctx, dgst)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	desc := distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}
+	if _, ok := e.written[dgst]; ok {
+		return desc, nil
+	}
+
+	for _, ref := range manifest.References() {
+		if isManifestMediaType(ref.MediaType) {
+			_, err = e.exportManifest(ref.Digest)
+		} else {
+			err = e.exportBlob(ref)
+		}
+		if err != nil {
+			return distribution.Descriptor{}, err
+		}
+	}
+
+	if err := e.w.writeFile(layoutBlobPath(dgst), desc.Size, bytes.NewReader(payload)); err != nil {
+		return distribution.Descriptor{}, err
+	}
+	e.written[dgst] = struct{}{}
+	e.result.Manifests++
+	return desc, nil
+}
+
+func (e *layoutExporter) exportBlob(ref distribution.Descriptor) error {
+	if len(ref.URLs) > 0 {
+		// foreign layers are not stored in the registry
+		return nil
+	}
+	if _, ok := e.written[ref.Digest]; ok {
+		return nil
+	}
+
+	desc, err := e.blobs.Stat(e.ctx, ref.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to stat blob %s: %v", ref.Digest, err)
+	}
+	rc, err := e.blobs.Open(e.ctx, ref.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to open blob %s: %v", ref.Digest, err)
+	}
+	defer rc.Close()
+
+	if err := e.w.writeFile(layoutBlobPath(ref.Digest), desc.Size, rc); err != nil {
+		return err
+	}
+	e.written[ref.Digest] = struct{}{}
+	e.result.Blobs++
+	e.result.Bytes += desc.Size
+	return nil
+}
+
+type layoutImporter struct {
+	ctx       context.Context
+	manifests distribution.ManifestService
+	blobs     distribution.BlobStore
+	r         layoutReader
+	imported  map[digest.Digest]struct{}
+	result    OCILayoutResult
+}
+
+// importManifest imports everything the manifest references, then the
+// manifest itself.
+func (im *layoutImporter) importManifest(mediaType string, dgst digest.Digest, size int64) error {
+	if _, ok := im.imported[dgst]; ok {
+		return nil
+	}
+
+	payload, err := readLayoutBlob(im.r, dgst, size)
+	if err != nil {
+		return err
+	}
+	manifest, _, err := distribution.UnmarshalManifest(mediaType, payload)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal manifest %s: %v", dgst, err)
+	}
+
+	for _, ref := range manifest.References() {
+		if isManifestMediaType(ref.MediaType) {
+			err = im.importManifest(ref.MediaType, ref.Digest, ref.Size)
+		} else {
+			err = im.importBlob(ref)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := im.manifests.Put(im.ctx, manifest); err != nil {
+		return fmt.Errorf("failed to put manifest %s: %v", dgst, err)
+	}
+	im.imported[dgst] = struct{}{}
+	im.result.Manifests++
+	return nil
+}
+
+func (im *layoutImporter) importBlob(ref distribution.Descriptor) error {
+	if len(ref.URLs) > 0 {
+		// foreign layers are not stored in the registry
+		return nil
+	}
+	if _, ok := im.imported[ref.Digest]; ok {
+		return nil
+	}
+
+	_, err := im.blobs.Stat(im.ctx, ref.Digest)
+	switch err {
+	case nil:
+		im.imported[ref.Digest] = struct{}{}
+		return nil
+	case distribution.ErrBlobUnknown:
+	default:
+		return err
+	}
+
+	rc, err := im.r.open(layoutBlobPath(ref.Digest))
+	if err != nil {
+		return fmt.Errorf("failed to open blob %s: %v", ref.Digest, err)
+	}
+	defer rc.Close()
+
+	bw, err := im.blobs.Create(im.ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(bw, rc); err != nil {
+		bw.Cancel(im.ctx)
+		return fmt.Errorf("failed to copy blob %s: %v", ref.Digest, err)
+	}
+	if _, err := bw.Commit(im.ctx, distribution.Descriptor{MediaType: ref.MediaType, Digest: ref.Digest, Size: ref.Size}); err != nil {
+		bw.Cancel(im.ctx)
+		return fmt.Errorf("failed to commit blob %s: %v", ref.Digest, err)
+	}
+
+	im.imported[ref.Digest] = struct{}{}
+	im.result.Blobs++
+	im.result.Bytes += ref.Size
+	return nil
+}
+
+// layoutWriter writes the files of an OCI image layout.
+type layoutWriter interface {
+	// writeFile writes size bytes from r to the file at name, relative to
+	// the root of the layout.
+	writeFile(name string, size int64, r io.Reader) error
+
+	Close() error
+}
+
+func newLayoutWriter(dst string) (layoutWriter, error) {
+	if strings.HasSuffix(dst, ".tar") {
+		f, err := os.Create(dst)
+		if err != nil {
+			return nil, err
+		}
+		return &tarLayoutWriter{f: f, tw: tar.NewWriter(f)}, nil
+	}
+
+	entries, err := os.ReadDir(dst)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		return nil, fmt.Errorf("%s is not empty", dst)
+	}
+	return &dirLayoutWriter{root: dst}, nil
+}
+
+type dirLayoutWriter struct {
+	root string
+}
+
+func (w *dirLayoutWriter) writeFile(name string, size int64, r io.Reader) error {
+	p := filepath.Join(w.root, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(f, r, size); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (w *dirLayoutWriter) Close() error {
+	return nil
+}
+
+type tarLayoutWriter struct {
+	f  *os.File
+	tw *tar.Writer
+}
+
+func (w *tarLayoutWriter) writeFile(name string, size int64, r io.Reader) error {
+	err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0o644,
+		Size:     size,
+		// a fixed modification time keeps archives of the same content
+		// identical
+		ModTime: time.Unix(0, 0),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(w.tw, r, size)
+	return err
+}
+
+func (w *tarLayoutWriter) Close() error {
+	if err := w.tw.Close(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+// layoutReader reads the files of an OCI image layout.
+type layoutReader interface {
+	// open opens the file at name, relative to the root of the layout.
+	open(name string) (io.ReadCloser, error)
+
+	Close() error
+}
+
+func newLayoutReader(src string) (layoutReader, error) {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &dirLayoutReader{root: src}, nil
+	}
+	return newTarLayoutReader(src)
+}
+
+func readLayoutJSON(r layoutReader, name string, v interface{}) error {
+	rc, err := r.open(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return json.NewDecoder(rc).Decode(v)
+}
+
+// readLayoutBlob reads the blob and verifies its content against dgst.
+func readLayoutBlob(r layoutReader, dgst digest.Digest, size int64) ([]byte, error) {
+	if err := dgst.Validate(); err != nil {
+		return nil, err
+	}
+	rc, err := r.open(layoutBlobPath(dgst))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open blob %s: %v", dgst, err)
+	}
+	defer rc.Close()
+
+	p, err := io.ReadAll(io.LimitReader(rc, size+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(p)) != size || dgst.Algorithm().FromBytes(p) != dgst {
+		return nil, fmt.Errorf("content of blob %s does not match its descriptor", dgst)
+	}
+	return p, nil
+}
+
+type dirLayoutReader struct {
+	root string
+}
+
+func (r *dirLayoutReader) open(name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(r.root, filepath.FromSlash(name)))
+}
+
+func (r *dirLayoutReader) Close() error {
+	return nil
+}
+
+// tarLayoutReader reads a layout from an uncompressed tarball. The tarball
+// is indexed once, files are then read in place.
+type tarLayoutReader struct {
+	f       *os.File
+	entries map[string]tarLayoutEntry
+}
+
+type tarLayoutEntry struct {
+	offset int64
+	size   int64
+}
+
+func newTarLayoutReader(src string) (*tarLayoutReader, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	r := &tarLayoutReader{f: f, entries: make(map[string]tarLayoutEntry)}
+
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to read %s: %v", src, err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		// the tar reader consumes exactly the headers, leaving the file
+		// positioned at the content of the entry
+		offset, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		r.entries[path.Clean(strings.TrimPrefix(hdr.Name, "./"))] = tarLayoutEntry{offset: offset, size: hdr.Size}
+	}
+	return r, nil
+}
+
+func (r *tarLayoutReader) open(name string) (io.ReadCloser, error) {
+	entry, ok := r.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return io.NopCloser(io.NewSectionReader(r.f, entry.offset, entry.size)), nil
+}
+
+func (r *tarLayoutReader) Close() error {
+	return r.f.Close()
+}
@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/reference"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestOCILayoutExportImport(t *testing.T) {
+	ctx := context.Background()
+
+	source := createRegistry(t, inmemory.New())
+	repo := makeRepository(t, source, "foo/bar")
+	manifestService := makeManifestService(t, repo)
+	image := uploadRandomSchema2Image(t, repo)
+
+	mediaType, payload, err := image.manifest.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := ocischema.FromDescriptors([]distribution.Descriptor{{
+		MediaType: mediaType,
+		Digest:    image.manifestDigest,
+		Size:      int64(len(payload)),
+		Platform:  &v1.Platform{Architecture: "amd64", OS: "linux"},
+	}}, map[string]string{"org.example.key": "value"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexDigest, err := manifestService.Put(ctx, index)
+	if err != nil {
+		t.Fatalf("failed to put index: %v", err)
+	}
+
+	tags := repo.Tags(ctx)
+	if err := tags.Tag(ctx, "latest", distribution.Descriptor{Digest: image.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tags.Tag(ctx, "multi", distribution.Descriptor{Digest: indexDigest}); err != nil {
+		t.Fatal(err)
+	}
+
+	fooRef, _ := reference.WithName("foo/bar")
+	for _, dst := range []string{filepath.Join(t.TempDir(), "layout"), filepath.Join(t.TempDir(), "layout.tar")} {
+		result, err := ExportOCILayout(ctx, source, fooRef, dst)
+		if err != nil {
+			t.Fatalf("failed to export %s: %v", dst, err)
+		}
+		if result.Manifests != 2 || result.Blobs != 3 || len(result.Tags) != 2 {
+			t.Fatalf("unexpected export result for %s: %+v", dst, result)
+		}
+
+		target := createRegistry(t, inmemory.New())
+		targetRef, _ := reference.WithName("imported/bar")
+		result, err = ImportOCILayout(ctx, target, targetRef, dst)
+		if err != nil {
+			t.Fatalf("failed to import %s: %v", dst, err)
+		}
+		if result.Manifests != 2 || result.Blobs != 3 || len(result.Tags) != 2 {
+			t.Fatalf("unexpected import result for %s: %+v", dst, result)
+		}
+
+		imported := makeRepository(t, target, "imported/bar")
+		for tag, expected := range map[string]interface{}{"latest": image.manifestDigest, "multi": indexDigest} {
+			desc, err := imported.Tags(ctx).Get(ctx, tag)
+			if err != nil {
+				t.Fatalf("expected tag %s to be imported: %v", tag, err)
+			}
+			if desc.Digest != expected {
+				t.Fatalf("unexpected digest of tag %s: %s != %s", tag, desc.Digest, expected)
+			}
+		}
+
+		manifest, err := makeManifestService(t, imported).Get(ctx, indexDigest)
+		if err != nil {
+			t.Fatalf("failed to get imported index: %v", err)
+		}
+		if annotations := manifest.(*ocischema.DeserializedImageIndex).Annotations; annotations["org.example.key"] != "value" {
+			t.Fatalf("expected index annotations to be preserved: %v", annotations)
+		}
+		for dgst := range image.layers {
+			if _, err := imported.Blobs(ctx).Stat(ctx, dgst); err != nil {
+				t.Fatalf("expected layer %s to be imported: %v", dgst, err)
+			}
+		}
+	}
+
+	// exporting a single tag only lists that tag in the index
+	dst := filepath.Join(t.TempDir(), "latest")
+	latestRef, _ := reference.WithTag(fooRef, "latest")
+	if _, err := ExportOCILayout(ctx, source, latestRef, dst); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	content, err := os.ReadFile(filepath.Join(dst, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var layoutIndex v1.Index
+	if err := json.Unmarshal(content, &layoutIndex); err != nil {
+		t.Fatal(err)
+	}
+	if len(layoutIndex.Manifests) != 1 || layoutIndex.Manifests[0].Digest != image.manifestDigest ||
+		layoutIndex.Manifests[0].Annotations[v1.AnnotationRefName] != "latest" || layoutIndex.Manifests[0].MediaType != mediaType {
+		t.Fatalf("unexpected index: %+v", layoutIndex)
+	}
+
+	if _, err := ExportOCILayout(ctx, source, fooRef, dst); err == nil {
+		t.Fatalf("expected exporting into a non-empty directory to fail")
+	}
+
+	// a reference with both a tag and a digest exports the digest with the tag
+	dst = filepath.Join(t.TempDir(), "pinned")
+	pinnedRef, _ := reference.WithDigest(latestRef, indexDigest)
+	if _, err := ExportOCILayout(ctx, source, pinnedRef, dst); err != nil {
+		t.Fatalf("failed to export: %v", err)
+	}
+	content, err = os.ReadFile(filepath.Join(dst, "index.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layoutIndex = v1.Index{}
+	if err := json.Unmarshal(content, &layoutIndex); err != nil {
+		t.Fatal(err)
+	}
+	if len(layoutIndex.Manifests) != 1 || layoutIndex.Manifests[0].Digest != indexDigest ||
+		layoutIndex.Manifests[0].Annotations[v1.AnnotationRefName] != "latest" {
+		t.Fatalf("unexpected index: %+v", layoutIndex)
+	}
+}
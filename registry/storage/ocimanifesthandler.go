@@ -18,6 +18,7 @@ type ocischemaManifestHandler struct {
 	blobStore    distribution.BlobStore
 	ctx          context.Context
 	manifestURLs manifestURLs
+	policies     []ManifestPolicy
 }
 
 var _ ManifestHandler = &ocischemaManifestHandler{}
@@ -41,6 +42,10 @@ func (ms *ocischemaManifestHandler) Put(ctx context.Context, manifest distributi
 		return "", fmt.Errorf("non-ocischema manifest put to ocischemaManifestHandler: %T", manifest)
 	}
 
+	if err := checkManifestPolicies(ms.policies, m); err != nil {
+		return "", err
+	}
+
 	if err := ms.verifyManifest(ms.ctx, *m, skipDependencyVerification); err != nil {
 		return "", err
 	}
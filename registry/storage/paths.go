@@ -27,6 +27,9 @@ const (
 //	├── blobs
 //	│   └── <algorithm>
 //	│       └── <split directory content addressable storage>
+//	├── corrupt
+//	│   └── blobs
+//	│       └── <blob data quarantined by the verifier>
 //	└── repositories
 //	    └── <name>
 //	        ├── _layers
@@ -43,6 +46,19 @@ const (
 //	        │               └── <algorithm>
 //	        │                   └── <hex digest>
 //	        │                       └── link
+//	        ├── _quarantine
+//	        │   ├── blobs
+//	        │   │   └── <algorithm>
+//	        │   │       └── <hex digest>
+//	        │   └── manifests
+//	        │       └── <algorithm>
+//	        │           └── <hex digest>
+//	        ├── _trash
+//	        │   ├── manifests
+//	        │   │   └── <algorithm>
+//	        │   │       └── <hex digest>
+//	        │   └── tags
+//	        │       └── <tag>
 //	        └── _uploads
 //	            └── <id>
 //	                ├── data
@@ -98,6 +114,18 @@ const (
 //	layerLinkPathSpec:            <root>/v2/repositories/<name>/_layers/<algorithm>/<hex digest>/link
 //	layersPathSpec:               <root>/v2/repositories/<name>/_layers
 //
+//	Quarantine:
+//
+//	quarantinePathSpec:            <root>/v2/repositories/<name>/_quarantine
+//	quarantineBlobPathSpec:        <root>/v2/repositories/<name>/_quarantine/blobs/<algorithm>/<hex digest>
+//	quarantineManifestPathSpec:    <root>/v2/repositories/<name>/_quarantine/manifests/<algorithm>/<hex digest>
+//
+//	Trash:
+//
+//	trashPathSpec:                 <root>/v2/repositories/<name>/_trash
+//	trashManifestPathSpec:         <root>/v2/repositories/<name>/_trash/manifests/<algorithm>/<hex digest>
+//	trashTagPathSpec:              <root>/v2/repositories/<name>/_trash/tags/<tag>
+//
 //	Uploads:
 //
 //	uploadDataPathSpec:             <root>/v2/repositories/<name>/_uploads/<id>/data
@@ -110,6 +138,7 @@ const (
 //	blobPathSpec:                   <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>
 //	blobDataPathSpec:               <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
 //	blobMediaTypePathSpec:          <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
+//	corruptBlobDataPathSpec:        <root>/v2/corrupt/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
 //
 // For more information on the semantic meaning of each path and their
 // contents, please see the path spec documentation.
@@ -214,6 +243,33 @@ func pathFor(spec pathSpec) (string, error) {
 		return path.Join(path.Join(append(blobLinkPathComponents, components...)...), "link"), nil
 	case layersPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_layers")...), nil
+	case quarantinePathSpec:
+		return path.Join(append(repoPrefix, v.name, "_quarantine")...), nil
+	case quarantineBlobPathSpec:
+		components, err := digestPathComponents(v.digest, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(repoPrefix, v.name, "_quarantine", "blobs"), components...)...), nil
+	case quarantineManifestPathSpec:
+		components, err := digestPathComponents(v.revision, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(repoPrefix, v.name, "_quarantine", "manifests"), components...)...), nil
+	case trashPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_trash")...), nil
+	case trashManifestPathSpec:
+		components, err := digestPathComponents(v.revision, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(repoPrefix, v.name, "_trash", "manifests"), components...)...), nil
+	case trashTagPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_trash", "tags", v.tag)...), nil
 	case blobsPathSpec:
 		blobsPathPrefix := append(rootPrefix, "blobs")
 		return path.Join(blobsPathPrefix...), nil
@@ -234,6 +290,15 @@ func pathFor(spec pathSpec) (string, error) {
 		components = append(components, "data")
 		blobPathPrefix := append(rootPrefix, "blobs")
 		return path.Join(append(blobPathPrefix, components...)...), nil
+	case corruptBlobDataPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		components = append(components, "data")
+		corruptPathPrefix := append(rootPrefix, "corrupt", "blobs")
+		return path.Join(append(corruptPathPrefix, components...)...), nil
 
 	case uploadDataPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "data")...), nil
@@ -357,6 +422,58 @@ type layersPathSpec struct {
 
 func (layersPathSpec) pathSpec() {}
 
+// quarantinePathSpec contains the path of the quarantine records of a
+// repository.
+type quarantinePathSpec struct {
+	name string
+}
+
+func (quarantinePathSpec) pathSpec() {}
+
+// quarantineBlobPathSpec describes the path of the record of a blob which
+// was added to a repository while quarantine was enforced and is not yet
+// referenced by a released manifest.
+type quarantineBlobPathSpec struct {
+	name   string
+	digest digest.Digest
+}
+
+func (quarantineBlobPathSpec) pathSpec() {}
+
+// quarantineManifestPathSpec describes the path of the quarantine record of a
+// manifest revision.
+type quarantineManifestPathSpec struct {
+	name     string
+	revision digest.Digest
+}
+
+func (quarantineManifestPathSpec) pathSpec() {}
+
+// trashPathSpec contains the path of the recycle bin of a repository, which
+// holds the records of soft deleted manifests and tags.
+type trashPathSpec struct {
+	name string
+}
+
+func (trashPathSpec) pathSpec() {}
+
+// trashManifestPathSpec describes the path of the record of a soft deleted
+// manifest revision.
+type trashManifestPathSpec struct {
+	name     string
+	revision digest.Digest
+}
+
+func (trashManifestPathSpec) pathSpec() {}
+
+// trashTagPathSpec describes the path of the record of a soft deleted tag.
+type trashTagPathSpec struct {
+	name string
+	tag  string
+}
+
+func (trashTagPathSpec) pathSpec() {}
+
 // layerLinkPathSpec specifies a path for a blob link, which is a file with a
 // blob id. The blob link will contain a content addressable blob id reference
 // into the blob store. The format of the contents is as follows:
@@ -405,6 +522,14 @@ type blobDataPathSpec struct {
 
 func (blobDataPathSpec) pathSpec() {}
 
+// corruptBlobDataPathSpec contains the path of blob data which failed
+// verification and was moved out of the blob store.
+type corruptBlobDataPathSpec struct {
+	digest digest.Digest
+}
+
+func (corruptBlobDataPathSpec) pathSpec() {}
+
 // uploadDataPathSpec defines the path parameters of the data file for
 // uploads.
 type uploadDataPathSpec struct {
@@ -0,0 +1,248 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// Quarantine states of a manifest.
+const (
+	// QuarantineStateQuarantined is the state of a manifest which is stored
+	// but may only be pulled by registry administrators.
+	QuarantineStateQuarantined = "quarantined"
+
+	// QuarantineStateReleased is the state of a manifest which has been
+	// released from quarantine.
+	QuarantineStateReleased = "released"
+)
+
+// QuarantineRecord records the quarantine state of a manifest.
+type QuarantineRecord struct {
+	// Digest is the digest of the manifest.
+	Digest digest.Digest `json:"digest"`
+
+	// State is either QuarantineStateQuarantined or
+	// QuarantineStateReleased.
+	State string `json:"state"`
+
+	// References are the digests of the blobs and manifests referenced by
+	// the manifest. Blobs first added to the repository for the manifest are
+	// released along with it.
+	References []digest.Digest `json:"references,omitempty"`
+
+	// QuarantinedAt is the time the manifest was pushed.
+	QuarantinedAt time.Time `json:"quarantinedAt"`
+
+	// QuarantinedBy is the name of the user who pushed the manifest, if
+	// known.
+	QuarantinedBy string `json:"quarantinedBy,omitempty"`
+
+	// ReleasedAt is the time the manifest was released, if it was.
+	ReleasedAt *time.Time `json:"releasedAt,omitempty"`
+
+	// ReleasedBy is the name of the user who released the manifest, if
+	// known.
+	ReleasedBy string `json:"releasedBy,omitempty"`
+}
+
+// RepositoryQuarantine keeps the quarantine state of the manifests of a
+// repository, and of the blobs added to it for them. Manifests are
+// quarantined when they are first pushed, and blobs when they are first
+// uploaded or mounted into the repository, until an administrator releases
+// the manifest referencing them.
+type RepositoryQuarantine struct {
+	driver driver.StorageDriver
+	name   string
+}
+
+// NewRepositoryQuarantine returns the quarantine of the named repository.
+func NewRepositoryQuarantine(storageDriver driver.StorageDriver, name string) *RepositoryQuarantine {
+	return &RepositoryQuarantine{
+		driver: storageDriver,
+		name:   name,
+	}
+}
+
+// List returns the records of the quarantined manifests, sorted by digest.
+func (q *RepositoryQuarantine) List(ctx context.Context) ([]QuarantineRecord, error) {
+	records := []QuarantineRecord{}
+
+	root, err := pathFor(quarantinePathSpec{name: q.name})
+	if err != nil {
+		return nil, err
+	}
+	err = q.driver.Walk(ctx, path.Join(root, "manifests"), func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+		record, err := q.get(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		if record.State == QuarantineStateQuarantined {
+			records = append(records, record)
+		}
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+		return nil, err
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Digest < records[j].Digest
+	})
+	return records, nil
+}
+
+// Get returns the quarantine record of the manifest dgst. It fails with
+// distribution.ErrManifestUnknownRevision if the manifest was never
+// quarantined.
+func (q *RepositoryQuarantine) Get(ctx context.Context, dgst digest.Digest) (QuarantineRecord, error) {
+	recordPath, err := pathFor(quarantineManifestPathSpec{name: q.name, revision: dgst})
+	if err != nil {
+		return QuarantineRecord{}, err
+	}
+	record, err := q.get(ctx, recordPath)
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return QuarantineRecord{}, distribution.ErrManifestUnknownRevision{Name: q.name, Revision: dgst}
+	}
+	return record, err
+}
+
+// Quarantined reports whether the manifest dgst is quarantined.
+func (q *RepositoryQuarantine) Quarantined(ctx context.Context, dgst digest.Digest) (bool, error) {
+	record, err := q.Get(ctx, dgst)
+	if err != nil {
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return record.State == QuarantineStateQuarantined, nil
+}
+
+// Quarantine puts the manifest dgst, which references the given blobs and
+// manifests, into quarantine. It should be called before the manifest is
+// stored, so that it is never pullable.
+func (q *RepositoryQuarantine) Quarantine(ctx context.Context, dgst digest.Digest, references []digest.Digest) error {
+	recordPath, err := pathFor(quarantineManifestPathSpec{name: q.name, revision: dgst})
+	if err != nil {
+		return err
+	}
+	return q.put(ctx, recordPath, QuarantineRecord{
+		Digest:        dgst,
+		State:         QuarantineStateQuarantined,
+		References:    references,
+		QuarantinedAt: time.Now().UTC(),
+		QuarantinedBy: dcontext.GetStringValue(ctx, auth.UserNameKey),
+	})
+}
+
+// Remove deletes the quarantine record of the manifest dgst. It undoes
+// Quarantine when the manifest could not be stored after all, so that no
+// record is left for a manifest which does not exist.
+func (q *RepositoryQuarantine) Remove(ctx context.Context, dgst digest.Digest) error {
+	recordPath, err := pathFor(quarantineManifestPathSpec{name: q.name, revision: dgst})
+	if err != nil {
+		return err
+	}
+	if err := q.driver.Delete(ctx, recordPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// Release releases the manifest dgst, and the blobs it references, from
+// quarantine. It fails with distribution.ErrManifestUnknownRevision if the
+// manifest is not quarantined.
+func (q *RepositoryQuarantine) Release(ctx context.Context, dgst digest.Digest) error {
+	record, err := q.Get(ctx, dgst)
+	if err != nil {
+		return err
+	}
+	if record.State != QuarantineStateQuarantined {
+		return distribution.ErrManifestUnknownRevision{Name: q.name, Revision: dgst}
+	}
+
+	// blobs are released first, so that a failure leaves the manifest
+	// quarantined and the release can be retried
+	for _, ref := range record.References {
+		blobPath, err := pathFor(quarantineBlobPathSpec{name: q.name, digest: ref})
+		if err != nil {
+			return err
+		}
+		if err := q.driver.Delete(ctx, blobPath); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	record.State = QuarantineStateReleased
+	record.ReleasedAt = &now
+	record.ReleasedBy = dcontext.GetStringValue(ctx, auth.UserNameKey)
+
+	recordPath, err := pathFor(quarantineManifestPathSpec{name: q.name, revision: dgst})
+	if err != nil {
+		return err
+	}
+	return q.put(ctx, recordPath, record)
+}
+
+// QuarantineBlob puts the blob dgst into quarantine until a manifest
+// referencing it is released. It should be called before the blob is linked
+// into the repository, and only if it was not linked before.
+func (q *RepositoryQuarantine) QuarantineBlob(ctx context.Context, dgst digest.Digest) error {
+	blobPath, err := pathFor(quarantineBlobPathSpec{name: q.name, digest: dgst})
+	if err != nil {
+		return err
+	}
+	return q.driver.PutContent(ctx, blobPath, []byte(dgst))
+}
+
+// BlobQuarantined reports whether the blob dgst is quarantined.
+func (q *RepositoryQuarantine) BlobQuarantined(ctx context.Context, dgst digest.Digest) (bool, error) {
+	blobPath, err := pathFor(quarantineBlobPathSpec{name: q.name, digest: dgst})
+	if err != nil {
+		return false, err
+	}
+	if _, err := q.driver.Stat(ctx, blobPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (q *RepositoryQuarantine) put(ctx context.Context, recordPath string, record QuarantineRecord) error {
+	content, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return q.driver.PutContent(ctx, recordPath, content)
+}
+
+func (q *RepositoryQuarantine) get(ctx context.Context, recordPath string) (QuarantineRecord, error) {
+	content, err := q.driver.GetContent(ctx, recordPath)
+	if err != nil {
+		return QuarantineRecord{}, err
+	}
+	var record QuarantineRecord
+	if err := json.Unmarshal(content, &record); err != nil {
+		return QuarantineRecord{}, err
+	}
+	return record, nil
+}
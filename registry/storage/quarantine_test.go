@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestQuarantine(t *testing.T) {
+	ctx := context.Background()
+	q := NewRepositoryQuarantine(inmemory.New(), "foo/bar")
+
+	manifest := digest.FromString("manifest")
+	layer := digest.FromString("layer")
+	base := digest.FromString("base layer")
+
+	if err := q.QuarantineBlob(ctx, layer); err != nil {
+		t.Fatalf("failed to quarantine blob: %v", err)
+	}
+	if err := q.Quarantine(ctx, manifest, []digest.Digest{base, layer}); err != nil {
+		t.Fatalf("failed to quarantine manifest: %v", err)
+	}
+
+	if quarantined, err := q.Quarantined(ctx, manifest); err != nil || !quarantined {
+		t.Fatalf("expected manifest to be quarantined: %v", err)
+	}
+	if quarantined, err := q.BlobQuarantined(ctx, layer); err != nil || !quarantined {
+		t.Fatalf("expected layer to be quarantined: %v", err)
+	}
+	// blobs which were in the repository before are not quarantined
+	if quarantined, err := q.BlobQuarantined(ctx, base); err != nil || quarantined {
+		t.Fatalf("expected base layer not to be quarantined: %v", err)
+	}
+
+	records, err := q.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list quarantine: %v", err)
+	}
+	if len(records) != 1 || records[0].Digest != manifest || records[0].State != QuarantineStateQuarantined {
+		t.Fatalf("unexpected quarantine records: %v", records)
+	}
+
+	if err := q.Release(ctx, manifest); err != nil {
+		t.Fatalf("failed to release manifest: %v", err)
+	}
+	if quarantined, err := q.Quarantined(ctx, manifest); err != nil || quarantined {
+		t.Fatalf("expected manifest to be released: %v", err)
+	}
+	if quarantined, err := q.BlobQuarantined(ctx, layer); err != nil || quarantined {
+		t.Fatalf("expected layer to be released: %v", err)
+	}
+	record, err := q.Get(ctx, manifest)
+	if err != nil {
+		t.Fatalf("failed to get quarantine record: %v", err)
+	}
+	if record.State != QuarantineStateReleased || record.ReleasedAt == nil {
+		t.Fatalf("unexpected quarantine record: %v", record)
+	}
+	if records, err := q.List(ctx); err != nil || len(records) != 0 {
+		t.Fatalf("expected no quarantined manifests, got %v: %v", records, err)
+	}
+
+	// releasing twice fails
+	if _, ok := q.Release(ctx, manifest).(distribution.ErrManifestUnknownRevision); !ok {
+		t.Fatalf("expected releasing a released manifest to fail")
+	}
+	if _, ok := q.Release(ctx, layer).(distribution.ErrManifestUnknownRevision); !ok {
+		t.Fatalf("expected releasing an unknown manifest to fail")
+	}
+
+	// removing a record undoes quarantining a manifest which was not stored
+	failed := digest.FromString("failed manifest")
+	if err := q.Quarantine(ctx, failed, nil); err != nil {
+		t.Fatalf("failed to quarantine manifest: %v", err)
+	}
+	if err := q.Remove(ctx, failed); err != nil {
+		t.Fatalf("failed to remove quarantine record: %v", err)
+	}
+	if _, ok := q.Release(ctx, failed).(distribution.ErrManifestUnknownRevision); !ok {
+		t.Fatalf("expected releasing a removed manifest to fail")
+	}
+	if err := q.Remove(ctx, failed); err != nil {
+		t.Fatalf("unexpected error removing a missing record: %v", err)
+	}
+}
@@ -2,6 +2,8 @@ package storage
 
 import (
 	"context"
+	"fmt"
+	"path"
 	"regexp"
 
 	"github.com/distribution/distribution/v3"
@@ -18,9 +20,13 @@ type registry struct {
 	statter                      *blobStatter // global statter service.
 	blobDescriptorCacheProvider  cache.BlobDescriptorCacheProvider
 	deleteEnabled                bool
+	softDelete                   bool
 	resumableDigestEnabled       bool
 	blobDescriptorServiceFactory distribution.BlobDescriptorServiceFactory
 	manifestURLs                 manifestURLs
+	immutableTags                []ImmutableTagRule
+	manifestPolicies             []ManifestPolicy
+	catalogIndex                 cache.CatalogIndex
 	driver                       storagedriver.StorageDriver
 }
 
@@ -30,6 +36,25 @@ type manifestURLs struct {
 	deny  *regexp.Regexp
 }
 
+// ImmutableTagRule selects tags which may not be pointed at a different
+// manifest once they exist. Repository is a path.Match pattern; an empty
+// pattern matches every repository. Tag is matched against the tag name and
+// should be anchored by the caller if partial matches are not wanted.
+type ImmutableTagRule struct {
+	Repository string
+	Tag        *regexp.Regexp
+}
+
+// matches returns true if the rule protects tag in the named repository.
+func (rule ImmutableTagRule) matches(name, tag string) bool {
+	if rule.Repository != "" {
+		if ok, _ := path.Match(rule.Repository, name); !ok {
+			return false
+		}
+	}
+	return rule.Tag.MatchString(tag)
+}
+
 // RegistryOption is the type used for functional options for NewRegistry.
 type RegistryOption func(*registry) error
 
@@ -47,6 +72,16 @@ func EnableDelete(registry *registry) error {
 	return nil
 }
 
+// EnableSoftDelete is a functional option for NewRegistry. It enables
+// deletion on the registry, but deleted manifests and tags are recorded in
+// the recycle bin of their repository, from which they can be restored
+// until the garbage collector purges them. See RepositoryTrash.
+func EnableSoftDelete(registry *registry) error {
+	registry.deleteEnabled = true
+	registry.softDelete = true
+	return nil
+}
+
 // DisableDigestResumption is a functional option for NewRegistry. It should be
 // used if the registry is acting as a caching proxy.
 func DisableDigestResumption(registry *registry) error {
@@ -70,6 +105,25 @@ func ManifestURLsDenyRegexp(r *regexp.Regexp) RegistryOption {
 	}
 }
 
+// ImmutableTags is a functional option for NewRegistry. Tags matching any
+// of the rules can be created and re-pushed with the same manifest, but
+// attempts to point them at a different manifest fail with
+// distribution.ErrTagImmutable.
+func ImmutableTags(rules ...ImmutableTagRule) RegistryOption {
+	return func(registry *registry) error {
+		for _, rule := range rules {
+			if rule.Tag == nil {
+				return fmt.Errorf("immutable tag rule for repository %q has no tag expression", rule.Repository)
+			}
+			if _, err := path.Match(rule.Repository, ""); err != nil {
+				return fmt.Errorf("invalid immutable tag repository pattern %q: %v", rule.Repository, err)
+			}
+		}
+		registry.immutableTags = append(registry.immutableTags, rules...)
+		return nil
+	}
+}
+
 // BlobDescriptorServiceFactory returns a functional option for NewRegistry. It sets the
 // factory to create BlobDescriptorServiceFactory middleware.
 func BlobDescriptorServiceFactory(factory distribution.BlobDescriptorServiceFactory) RegistryOption {
@@ -99,6 +153,17 @@ func BlobDescriptorCacheProvider(blobDescriptorCacheProvider cache.BlobDescripto
 	}
 }
 
+// CatalogIndex returns a functional option for NewRegistry. Once the index
+// is ready, the registry lists repositories from it instead of walking the
+// storage, and it keeps the index up to date as repositories are created,
+// removed and renamed. See RebuildCatalogIndex.
+func CatalogIndex(index cache.CatalogIndex) RegistryOption {
+	return func(registry *registry) error {
+		registry.catalogIndex = index
+		return nil
+	}
+}
+
 // NewRegistry creates a new registry instance from the provided driver. The
 // resulting registry may be shared by multiple goroutines but is cheap to
 // allocate. If the Redirect option is specified, the backend blob server will
@@ -221,10 +286,13 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 		linkDirectoryPathSpec: manifestDirectoryPathSpec,
 	}
 
+	policies := repo.registry.manifestPoliciesFor(repo.name.Name())
+
 	manifestListHandler := &manifestListHandler{
 		ctx:        ctx,
 		repository: repo,
 		blobStore:  blobStore,
+		policies:   policies,
 	}
 
 	ms := &manifestStore{
@@ -236,6 +304,7 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 			repository:   repo,
 			blobStore:    blobStore,
 			manifestURLs: repo.registry.manifestURLs,
+			policies:     policies,
 		},
 		manifestListHandler: manifestListHandler,
 		ocischemaHandler: &ocischemaManifestHandler{
@@ -243,6 +312,7 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 			repository:   repo,
 			blobStore:    blobStore,
 			manifestURLs: repo.registry.manifestURLs,
+			policies:     policies,
 		},
 		ocischemaIndexHandler: &ocischemaIndexHandler{
 			manifestListHandler: manifestListHandler,
@@ -23,6 +23,7 @@ type schema2ManifestHandler struct {
 	blobStore    distribution.BlobStore
 	ctx          context.Context
 	manifestURLs manifestURLs
+	policies     []ManifestPolicy
 }
 
 var _ ManifestHandler = &schema2ManifestHandler{}
@@ -46,6 +47,10 @@ func (ms *schema2ManifestHandler) Put(ctx context.Context, manifest distribution
 		return "", fmt.Errorf("non-schema2 manifest put to schema2ManifestHandler: %T", manifest)
 	}
 
+	if err := checkManifestPolicies(ms.policies, m); err != nil {
+		return "", err
+	}
+
 	if err := ms.verifyManifest(ms.ctx, *m, skipDependencyVerification); err != nil {
 		return "", err
 	}
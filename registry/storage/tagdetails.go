@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// TagDetail describes a tag and the manifest it points at.
+type TagDetail struct {
+	// Name is the name of the tag.
+	Name string `json:"name"`
+
+	// Digest is the digest of the manifest the tag points at.
+	Digest digest.Digest `json:"digest"`
+
+	// MediaType is the media type of the manifest. It is empty if the
+	// manifest cannot be read.
+	MediaType string `json:"mediaType,omitempty"`
+
+	// Size is the size of the manifest in bytes.
+	Size int64 `json:"size,omitempty"`
+
+	// LastModified is the time the tag was last pointed at a manifest.
+	LastModified time.Time `json:"lastModified"`
+}
+
+// TagModTimes returns the times the given tags of the named repository were
+// last pointed at a manifest, taken from their current links. Tags which do
+// not exist are left out.
+func TagModTimes(ctx context.Context, storageDriver driver.StorageDriver, name string, tags []string) (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time, len(tags))
+	for _, tag := range tags {
+		currentPath, err := pathFor(manifestTagCurrentPathSpec{name: name, tag: tag})
+		if err != nil {
+			return nil, err
+		}
+		fi, err := storageDriver.Stat(ctx, currentPath)
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				continue
+			}
+			return nil, err
+		}
+		modTimes[tag] = fi.ModTime()
+	}
+	return modTimes, nil
+}
+
+// TagDetails returns the details of the given tags of a repository, in the
+// same order. Tags which do not exist, for example because they were deleted
+// after being listed, are left out. The manifests are read from
+// storageDriver as blobs, without being deserialized or reported as pulled.
+func TagDetails(ctx context.Context, storageDriver driver.StorageDriver, repo distribution.Repository, tags []string) ([]TagDetail, error) {
+	name := repo.Named().Name()
+	modTimes, err := TagModTimes(ctx, storageDriver, name, tags)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := &blobStore{driver: storageDriver, statter: &blobStatter{driver: storageDriver}}
+	details := make([]TagDetail, 0, len(tags))
+	for _, tag := range tags {
+		modTime, ok := modTimes[tag]
+		if !ok {
+			continue
+		}
+		desc, err := repo.Tags(ctx).Get(ctx, tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			return nil, err
+		}
+
+		detail := TagDetail{
+			Name:         tag,
+			Digest:       desc.Digest,
+			LastModified: modTime,
+		}
+		linkPath, err := pathFor(manifestRevisionLinkPathSpec{name: name, revision: desc.Digest})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := storageDriver.Stat(ctx, linkPath); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				// the tag points at a deleted manifest
+				details = append(details, detail)
+				continue
+			}
+			return nil, err
+		}
+		blob, err := blobs.statter.Stat(ctx, desc.Digest)
+		switch {
+		case err == nil:
+			detail.Size = blob.Size
+			detail.MediaType, err = manifestMediaType(ctx, blobs, desc.Digest)
+			if err != nil && !errors.Is(err, distribution.ErrBlobUnknown) {
+				return nil, err
+			}
+		case errors.Is(err, distribution.ErrBlobUnknown):
+			// the manifest was removed by the garbage collector
+		default:
+			return nil, err
+		}
+		details = append(details, detail)
+	}
+	return details, nil
+}
+
+// manifestMediaType returns the media type of the manifest dgst, read from its
+// content without deserializing it.
+func manifestMediaType(ctx context.Context, blobs distribution.BlobProvider, dgst digest.Digest) (string, error) {
+	content, err := blobs.Get(ctx, dgst)
+	if err != nil {
+		return "", err
+	}
+	var versioned struct {
+		manifest.Versioned
+		Manifests json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(content, &versioned); err != nil {
+		// the details are informational, so unreadable manifests are
+		// listed without a media type
+		return "", nil
+	}
+	switch {
+	case versioned.MediaType != "":
+		return versioned.MediaType, nil
+	case versioned.SchemaVersion != 2:
+		return "", nil
+	case versioned.Manifests != nil:
+		// OCI image index without a media type in the content
+		return v1.MediaTypeImageIndex, nil
+	default:
+		return v1.MediaTypeImageManifest, nil
+	}
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestTagDetails(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+
+	registry := createRegistry(t, d)
+	repo := makeRepository(t, registry, "foo/bar")
+	image := uploadRandomSchema2Image(t, repo)
+	_, payload, err := image.manifest.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := repo.Tags(ctx)
+	for _, tag := range []string{"b", "a", "c"} {
+		if err := tags.Tag(ctx, tag, distribution.Descriptor{Digest: image.manifestDigest}); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := tags.Untag(ctx, "c"); err != nil {
+		t.Fatal(err)
+	}
+
+	modTimes, err := TagModTimes(ctx, d, "foo/bar", []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("failed to get tag modification times: %v", err)
+	}
+	if len(modTimes) != 2 || !modTimes["b"].Before(modTimes["a"]) {
+		t.Fatalf("unexpected tag modification times: %v", modTimes)
+	}
+
+	// removed tags are left out
+	details, err := TagDetails(ctx, d, repo, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("failed to get tag details: %v", err)
+	}
+	if len(details) != 2 || details[0].Name != "a" || details[1].Name != "b" {
+		t.Fatalf("unexpected tag details: %+v", details)
+	}
+	for _, detail := range details {
+		if detail.Digest != image.manifestDigest || detail.MediaType != schema2.MediaTypeManifest ||
+			detail.Size != int64(len(payload)) || !detail.LastModified.Equal(modTimes[detail.Name]) {
+			t.Fatalf("unexpected details of tag %s: %+v", detail.Name, detail)
+		}
+	}
+
+	// the media type of OCI manifests which do not carry it is inferred
+	blobs := &blobStore{driver: d, statter: &blobStatter{driver: d}}
+	for content, expected := range map[string]string{
+		`{"schemaVersion":2,"mediaType":"` + schema2.MediaTypeManifest + `"}`: schema2.MediaTypeManifest,
+		`{"schemaVersion":2,"manifests":[]}`:                                  v1.MediaTypeImageIndex,
+		`{"schemaVersion":2,"layers":[]}`:                                     v1.MediaTypeImageManifest,
+		`{"schemaVersion":1}`:                                                 "",
+		`not json`:                                                            "",
+	} {
+		desc, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mediaType, err := manifestMediaType(ctx, blobs, desc.Digest)
+		if err != nil || mediaType != expected {
+			t.Fatalf("unexpected media type of %s: %q != %q, %v", content, mediaType, expected, err)
+		}
+	}
+}
@@ -55,7 +55,9 @@ func (ts *tagStore) All(ctx context.Context) ([]string, error) {
 }
 
 // Tag tags the digest with the given tag, updating the the store to point at
-// the current tag. The digest must point to a manifest.
+// the current tag. The digest must point to a manifest. If the tag is
+// protected by an immutability rule and already points to a different
+// digest, distribution.ErrTagImmutable is returned.
 func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
 	currentPath, err := pathFor(manifestTagCurrentPathSpec{
 		name: ts.repository.Named().Name(),
@@ -65,6 +67,20 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descr
 		return err
 	}
 
+	if ts.immutable(tag) {
+		current, err := ts.blobStore.readlink(ctx, currentPath)
+		switch err.(type) {
+		case nil:
+			if current != desc.Digest {
+				return distribution.ErrTagImmutable{Tag: tag, Digest: current}
+			}
+		case storagedriver.PathNotFoundError:
+			// first push of the tag
+		default:
+			return err
+		}
+	}
+
 	lbs := ts.linkedBlobStore(ctx, tag)
 
 	// Link into the index
@@ -76,6 +92,18 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descr
 	return ts.blobStore.link(ctx, currentPath, desc.Digest)
 }
 
+// immutable returns true if tag is protected by one of the registry's
+// immutability rules.
+func (ts *tagStore) immutable(tag string) bool {
+	name := ts.repository.Named().Name()
+	for _, rule := range ts.repository.immutableTags {
+		if rule.matches(name, tag) {
+			return true
+		}
+	}
+	return false
+}
+
 // resolve the current revision for name and tag.
 func (ts *tagStore) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
 	currentPath, err := pathFor(manifestTagCurrentPathSpec{
@@ -109,6 +137,25 @@ func (ts *tagStore) Untag(ctx context.Context, tag string) error {
 		return err
 	}
 
+	if ts.repository.softDelete {
+		currentPath, err := pathFor(manifestTagCurrentPathSpec{
+			name: ts.repository.Named().Name(),
+			tag:  tag,
+		})
+		if err != nil {
+			return err
+		}
+		dgst, err := ts.blobStore.readlink(ctx, currentPath)
+		if err != nil {
+			return err
+		}
+
+		trash := NewRepositoryTrash(ts.blobStore.driver, ts.repository.Named().Name(), 0)
+		if err := trash.putTag(ctx, tag, dgst); err != nil {
+			return err
+		}
+	}
+
 	return ts.blobStore.driver.Delete(ctx, tagPath)
 }
 
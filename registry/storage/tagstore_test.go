@@ -3,6 +3,7 @@ package storage
 import (
 	"context"
 	"reflect"
+	"regexp"
 	"testing"
 
 	"github.com/distribution/distribution/v3"
@@ -91,6 +92,62 @@ func TestTagStoreTag(t *testing.T) {
 	}
 }
 
+func TestTagStoreImmutable(t *testing.T) {
+	ctx := context.Background()
+	reg, err := NewRegistry(ctx, inmemory.New(), ImmutableTags(
+		ImmutableTagRule{Repository: "a/*", Tag: regexp.MustCompile(`^v[0-9]+\.[0-9]+\.[0-9]+$`)},
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	descA := distribution.Descriptor{Digest: "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"}
+	descB := distribution.Descriptor{Digest: "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"}
+
+	for _, tc := range []struct {
+		repo      string
+		tag       string
+		immutable bool
+	}{
+		{repo: "a/b", tag: "v1.2.3", immutable: true},
+		{repo: "a/b", tag: "latest", immutable: false},
+		{repo: "a/b", tag: "v1.2.3-rc1", immutable: false},
+		{repo: "c/d", tag: "v1.2.3", immutable: false},
+	} {
+		repoRef, _ := reference.WithName(tc.repo)
+		repo, err := reg.Repository(ctx, repoRef)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tags := repo.Tags(ctx)
+
+		if err := tags.Tag(ctx, tc.tag, descA); err != nil {
+			t.Fatalf("%s:%s: unexpected error on first tag: %v", tc.repo, tc.tag, err)
+		}
+
+		// re-tagging the same digest is always allowed
+		if err := tags.Tag(ctx, tc.tag, descA); err != nil {
+			t.Fatalf("%s:%s: unexpected error re-tagging same digest: %v", tc.repo, tc.tag, err)
+		}
+
+		err = tags.Tag(ctx, tc.tag, descB)
+		if tc.immutable {
+			if _, ok := err.(distribution.ErrTagImmutable); !ok {
+				t.Fatalf("%s:%s: expected ErrTagImmutable, got %v", tc.repo, tc.tag, err)
+			}
+			d, err := tags.Get(ctx, tc.tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Digest != descA.Digest {
+				t.Errorf("%s:%s: immutable tag was modified", tc.repo, tc.tag)
+			}
+		} else if err != nil {
+			t.Fatalf("%s:%s: unexpected error overwriting mutable tag: %v", tc.repo, tc.tag, err)
+		}
+	}
+}
+
 func TestTagStoreUnTag(t *testing.T) {
 	env := testTagStore(t)
 	tags := env.ts
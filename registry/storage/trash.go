@@ -0,0 +1,345 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// DefaultTrashRetention is the time soft deleted manifests and tags are kept
+// in the recycle bin if no retention is configured.
+const DefaultTrashRetention = 7 * 24 * time.Hour
+
+// TrashEntry records a soft deleted manifest or tag.
+type TrashEntry struct {
+	// Digest is the digest of the deleted manifest, or of the manifest the
+	// deleted tag pointed at.
+	Digest digest.Digest `json:"digest"`
+
+	// Tag is the name of the deleted tag. It is empty for manifests.
+	Tag string `json:"tag,omitempty"`
+
+	// References are the digests of the blobs and manifests referenced by a
+	// deleted manifest. They are kept alive by the garbage collector until
+	// the entry expires.
+	References []digest.Digest `json:"references,omitempty"`
+
+	// DeletedAt is the time of the deletion.
+	DeletedAt time.Time `json:"deletedAt"`
+
+	// DeletedBy is the name of the user who deleted the manifest or tag, if
+	// known.
+	DeletedBy string `json:"deletedBy,omitempty"`
+
+	// ExpiresAt is the time after which the entry can no longer be restored
+	// and is purged by the garbage collector. It is computed from the
+	// retention when entries are listed and is not stored.
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// TrashListing holds the live entries of the recycle bin of a repository.
+type TrashListing struct {
+	// Manifests are the deleted manifests, sorted by digest.
+	Manifests []TrashEntry `json:"manifests"`
+
+	// Tags are the deleted tags, sorted by name.
+	Tags []TrashEntry `json:"tags"`
+}
+
+// RepositoryTrash provides access to the recycle bin of a repository, which
+// holds the manifests and tags deleted while soft deletion is enabled.
+// Entries older than the retention are treated as gone, even before the
+// garbage collector purges them.
+type RepositoryTrash struct {
+	driver    driver.StorageDriver
+	name      string
+	retention time.Duration
+}
+
+// NewRepositoryTrash returns the recycle bin of the named repository. A
+// retention of zero selects DefaultTrashRetention.
+func NewRepositoryTrash(storageDriver driver.StorageDriver, name string, retention time.Duration) *RepositoryTrash {
+	if retention <= 0 {
+		retention = DefaultTrashRetention
+	}
+	return &RepositoryTrash{
+		driver:    storageDriver,
+		name:      name,
+		retention: retention,
+	}
+}
+
+// List returns the entries of the recycle bin which have not expired.
+func (t *RepositoryTrash) List(ctx context.Context) (TrashListing, error) {
+	listing, _, err := t.entries(ctx)
+	return listing, err
+}
+
+// RestoreManifest links the deleted manifest back into the repository,
+// together with the deleted tags which pointed at it and have not been
+// reused since.
+func (t *RepositoryTrash) RestoreManifest(ctx context.Context, dgst digest.Digest) error {
+	entry, err := t.manifestEntry(ctx, dgst)
+	if err != nil {
+		return err
+	}
+	if err := t.restoreManifest(ctx, entry); err != nil {
+		return err
+	}
+
+	listing, err := t.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, tagEntry := range listing.Tags {
+		if tagEntry.Digest != dgst {
+			continue
+		}
+		err := t.restoreTag(ctx, tagEntry)
+		if _, ok := err.(distribution.ErrTagExists); ok {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RestoreTag points the deleted tag back at its manifest, restoring the
+// manifest as well if it was deleted. It fails with distribution.ErrTagExists
+// if the tag has been pointed at a different manifest since.
+func (t *RepositoryTrash) RestoreTag(ctx context.Context, tag string) error {
+	entry, err := t.tagEntry(ctx, tag)
+	if err != nil {
+		return err
+	}
+
+	revisionPath, err := pathFor(manifestRevisionLinkPathSpec{name: t.name, revision: entry.Digest})
+	if err != nil {
+		return err
+	}
+	if _, err := t.driver.Stat(ctx, revisionPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+		manifestEntry, err := t.manifestEntry(ctx, entry.Digest)
+		if err != nil {
+			return err
+		}
+		if err := t.restoreManifest(ctx, manifestEntry); err != nil {
+			return err
+		}
+	}
+
+	return t.restoreTag(ctx, entry)
+}
+
+// putManifest records the deletion of a manifest revision.
+func (t *RepositoryTrash) putManifest(ctx context.Context, dgst digest.Digest, references []digest.Digest) error {
+	entryPath, err := pathFor(trashManifestPathSpec{name: t.name, revision: dgst})
+	if err != nil {
+		return err
+	}
+	return t.put(ctx, entryPath, TrashEntry{Digest: dgst, References: references})
+}
+
+// putTag records the deletion of a tag.
+func (t *RepositoryTrash) putTag(ctx context.Context, tag string, dgst digest.Digest) error {
+	entryPath, err := pathFor(trashTagPathSpec{name: t.name, tag: tag})
+	if err != nil {
+		return err
+	}
+	return t.put(ctx, entryPath, TrashEntry{Digest: dgst, Tag: tag})
+}
+
+func (t *RepositoryTrash) put(ctx context.Context, entryPath string, entry TrashEntry) error {
+	entry.DeletedAt = time.Now().UTC()
+	entry.DeletedBy = dcontext.GetStringValue(ctx, auth.UserNameKey)
+
+	content, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return t.driver.PutContent(ctx, entryPath, content)
+}
+
+func (t *RepositoryTrash) manifestEntry(ctx context.Context, dgst digest.Digest) (TrashEntry, error) {
+	entryPath, err := pathFor(trashManifestPathSpec{name: t.name, revision: dgst})
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	entry, err := t.get(ctx, entryPath)
+	if _, ok := err.(driver.PathNotFoundError); ok || (err == nil && t.expired(entry)) {
+		return TrashEntry{}, distribution.ErrManifestUnknownRevision{Name: t.name, Revision: dgst}
+	}
+	return entry, err
+}
+
+func (t *RepositoryTrash) tagEntry(ctx context.Context, tag string) (TrashEntry, error) {
+	entryPath, err := pathFor(trashTagPathSpec{name: t.name, tag: tag})
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	entry, err := t.get(ctx, entryPath)
+	if _, ok := err.(driver.PathNotFoundError); ok || (err == nil && t.expired(entry)) {
+		return TrashEntry{}, distribution.ErrTagUnknown{Tag: tag}
+	}
+	return entry, err
+}
+
+func (t *RepositoryTrash) get(ctx context.Context, entryPath string) (TrashEntry, error) {
+	content, err := t.driver.GetContent(ctx, entryPath)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+	var entry TrashEntry
+	if err := json.Unmarshal(content, &entry); err != nil {
+		return TrashEntry{}, err
+	}
+	entry.ExpiresAt = entry.DeletedAt.Add(t.retention)
+	return entry, nil
+}
+
+func (t *RepositoryTrash) expired(entry TrashEntry) bool {
+	return !time.Now().Before(entry.ExpiresAt)
+}
+
+// restoreManifest relinks the manifest revision and removes its entry.
+func (t *RepositoryTrash) restoreManifest(ctx context.Context, entry TrashEntry) error {
+	// the manifest may have been garbage collected if the entry expired
+	// during a collection
+	blobPath, err := pathFor(blobDataPathSpec{digest: entry.Digest})
+	if err != nil {
+		return err
+	}
+	if _, err := t.driver.Stat(ctx, blobPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return distribution.ErrManifestUnknownRevision{Name: t.name, Revision: entry.Digest}
+		}
+		return err
+	}
+
+	revisionPath, err := pathFor(manifestRevisionLinkPathSpec{name: t.name, revision: entry.Digest})
+	if err != nil {
+		return err
+	}
+	if err := t.driver.PutContent(ctx, revisionPath, []byte(entry.Digest)); err != nil {
+		return err
+	}
+
+	entryPath, err := pathFor(trashManifestPathSpec{name: t.name, revision: entry.Digest})
+	if err != nil {
+		return err
+	}
+	return t.driver.Delete(ctx, entryPath)
+}
+
+// restoreTag points the tag at its manifest again and removes its entry.
+func (t *RepositoryTrash) restoreTag(ctx context.Context, entry TrashEntry) error {
+	currentPath, err := pathFor(manifestTagCurrentPathSpec{name: t.name, tag: entry.Tag})
+	if err != nil {
+		return err
+	}
+	current, err := t.driver.GetContent(ctx, currentPath)
+	switch err.(type) {
+	case nil:
+		if digest.Digest(current) != entry.Digest {
+			return distribution.ErrTagExists{Tag: entry.Tag}
+		}
+	case driver.PathNotFoundError:
+		indexPath, err := pathFor(manifestTagIndexEntryLinkPathSpec{name: t.name, tag: entry.Tag, revision: entry.Digest})
+		if err != nil {
+			return err
+		}
+		if err := t.driver.PutContent(ctx, indexPath, []byte(entry.Digest)); err != nil {
+			return err
+		}
+		if err := t.driver.PutContent(ctx, currentPath, []byte(entry.Digest)); err != nil {
+			return err
+		}
+	default:
+		return err
+	}
+
+	entryPath, err := pathFor(trashTagPathSpec{name: t.name, tag: entry.Tag})
+	if err != nil {
+		return err
+	}
+	return t.driver.Delete(ctx, entryPath)
+}
+
+// entries walks the recycle bin, returning the live entries and the paths
+// of the expired ones.
+func (t *RepositoryTrash) entries(ctx context.Context) (TrashListing, []string, error) {
+	listing := TrashListing{
+		Manifests: []TrashEntry{},
+		Tags:      []TrashEntry{},
+	}
+	var expired []string
+
+	root, err := pathFor(trashPathSpec{name: t.name})
+	if err != nil {
+		return listing, nil, err
+	}
+	err = t.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+		entry, err := t.get(ctx, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		if t.expired(entry) {
+			expired = append(expired, fileInfo.Path())
+			return nil
+		}
+		if strings.HasPrefix(fileInfo.Path(), path.Join(root, "tags")+"/") {
+			listing.Tags = append(listing.Tags, entry)
+		} else {
+			listing.Manifests = append(listing.Manifests, entry)
+		}
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+		return listing, nil, err
+	}
+
+	sort.Slice(listing.Manifests, func(i, j int) bool {
+		return listing.Manifests[i].Digest < listing.Manifests[j].Digest
+	})
+	sort.Slice(listing.Tags, func(i, j int) bool {
+		return listing.Tags[i].Tag < listing.Tags[j].Tag
+	})
+	return listing, expired, nil
+}
+
+// purge removes the expired entries of the recycle bin, unless dryRun is
+// set, and returns the live ones.
+func (t *RepositoryTrash) purge(ctx context.Context, dryRun bool) (TrashListing, error) {
+	listing, expired, err := t.entries(ctx)
+	if err != nil {
+		return listing, err
+	}
+	for _, entryPath := range expired {
+		emit("%s: purging expired trash entry %s", t.name, entryPath)
+		if dryRun {
+			continue
+		}
+		if err := t.driver.Delete(ctx, entryPath); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return listing, err
+			}
+		}
+	}
+	return listing, nil
+}
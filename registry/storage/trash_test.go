@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestSoftDelete(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver, EnableSoftDelete)
+	repo := makeRepository(t, registry, "foo/bar")
+	manifestService := makeManifestService(t, repo)
+	image := uploadRandomSchema2Image(t, repo)
+
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: image.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+	if err := repo.Tags(ctx).Untag(ctx, "latest"); err != nil {
+		t.Fatalf("failed to untag manifest: %v", err)
+	}
+	if err := manifestService.Delete(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("failed to delete manifest: %v", err)
+	}
+
+	if _, err := manifestService.Get(ctx, image.manifestDigest); err == nil {
+		t.Fatalf("expected soft deleted manifest to be hidden")
+	}
+	if _, err := repo.Tags(ctx).Get(ctx, "latest"); err == nil {
+		t.Fatalf("expected soft deleted tag to be hidden")
+	}
+
+	trash := NewRepositoryTrash(inmemoryDriver, "foo/bar", 0)
+	listing, err := trash.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list trash: %v", err)
+	}
+	if len(listing.Manifests) != 1 || listing.Manifests[0].Digest != image.manifestDigest {
+		t.Fatalf("unexpected trashed manifests: %v", listing.Manifests)
+	}
+	if len(listing.Tags) != 1 || listing.Tags[0].Tag != "latest" || listing.Tags[0].Digest != image.manifestDigest {
+		t.Fatalf("unexpected trashed tags: %v", listing.Tags)
+	}
+	if listing.Manifests[0].ExpiresAt.Sub(listing.Manifests[0].DeletedAt) != DefaultTrashRetention {
+		t.Errorf("unexpected expiry: %v", listing.Manifests[0])
+	}
+
+	// the content of trashed manifests survives garbage collection
+	before := allBlobs(t, registry)
+	err = MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{RemoveUntagged: true})
+	if err != nil {
+		t.Fatalf("failed to garbage collect: %v", err)
+	}
+	if after := allBlobs(t, registry); len(after) != len(before) {
+		t.Fatalf("expected %d blobs after garbage collection, got %d", len(before), len(after))
+	}
+
+	if err := trash.RestoreManifest(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("failed to restore manifest: %v", err)
+	}
+	if _, err := manifestService.Get(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("expected restored manifest to be served: %v", err)
+	}
+	desc, err := repo.Tags(ctx).Get(ctx, "latest")
+	if err != nil {
+		t.Fatalf("expected tag to be restored with its manifest: %v", err)
+	}
+	if desc.Digest != image.manifestDigest {
+		t.Fatalf("unexpected digest of restored tag: %s", desc.Digest)
+	}
+	listing, err = trash.List(ctx)
+	if err != nil {
+		t.Fatalf("failed to list trash: %v", err)
+	}
+	if len(listing.Manifests) != 0 || len(listing.Tags) != 0 {
+		t.Fatalf("expected trash to be empty after restoring: %v", listing)
+	}
+
+	// expired entries can no longer be restored and are purged
+	if err := manifestService.Delete(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("failed to delete manifest: %v", err)
+	}
+	err = MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{TrashRetention: time.Nanosecond})
+	if err != nil {
+		t.Fatalf("failed to garbage collect: %v", err)
+	}
+	if _, ok := allBlobs(t, registry)[image.manifestDigest]; ok {
+		t.Fatalf("expected expired manifest to be garbage collected")
+	}
+	err = NewRepositoryTrash(inmemoryDriver, "foo/bar", time.Nanosecond).RestoreManifest(ctx, image.manifestDigest)
+	if _, ok := err.(distribution.ErrManifestUnknownRevision); !ok {
+		t.Fatalf("expected ErrManifestUnknownRevision restoring an expired manifest, got %v", err)
+	}
+}
+
+func TestSoftDeleteIndex(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver, EnableSoftDelete)
+	repo := makeRepository(t, registry, "foo/bar")
+	manifestService := makeManifestService(t, repo)
+
+	tagged := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: tagged.manifestDigest}); err != nil {
+		t.Fatalf("failed to tag manifest: %v", err)
+	}
+
+	// an index of two untagged images
+	var descriptors []distribution.Descriptor
+	for i := 0; i < 2; i++ {
+		image := uploadRandomSchema2Image(t, repo)
+		mediaType, payload, err := image.manifest.Payload()
+		if err != nil {
+			t.Fatalf("failed to get manifest payload: %v", err)
+		}
+		descriptors = append(descriptors, distribution.Descriptor{MediaType: mediaType, Digest: image.manifestDigest, Size: int64(len(payload))})
+	}
+	index, err := ocischema.FromDescriptors(descriptors, nil)
+	if err != nil {
+		t.Fatalf("failed to build index: %v", err)
+	}
+	indexDigest, err := manifestService.Put(ctx, index)
+	if err != nil {
+		t.Fatalf("failed to put index: %v", err)
+	}
+	if err := manifestService.Delete(ctx, indexDigest); err != nil {
+		t.Fatalf("failed to delete index: %v", err)
+	}
+
+	// the images of the trashed index and their layers survive garbage
+	// collection, even though they are untagged
+	before := allBlobs(t, registry)
+	err = MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{RemoveUntagged: true})
+	if err != nil {
+		t.Fatalf("failed to garbage collect: %v", err)
+	}
+	if after := allBlobs(t, registry); len(after) != len(before) {
+		t.Fatalf("expected %d blobs after garbage collection, got %d", len(before), len(after))
+	}
+
+	if err := NewRepositoryTrash(inmemoryDriver, "foo/bar", 0).RestoreManifest(ctx, indexDigest); err != nil {
+		t.Fatalf("failed to restore index: %v", err)
+	}
+	for _, desc := range append(descriptors, distribution.Descriptor{Digest: indexDigest}) {
+		if _, err := manifestService.Get(ctx, desc.Digest); err != nil {
+			t.Fatalf("expected manifest %s of the restored index to be served: %v", desc.Digest, err)
+		}
+	}
+}
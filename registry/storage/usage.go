@@ -0,0 +1,179 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// RepositoryUsage describes the storage consumed by a single repository.
+type RepositoryUsage struct {
+	// Name is the name of the repository.
+	Name string `json:"name"`
+
+	// Manifests is the number of manifest revisions in the repository.
+	Manifests int `json:"manifests"`
+
+	// Tags is the number of tags in the repository.
+	Tags int `json:"tags"`
+
+	// Blobs is the number of distinct blobs, including manifests,
+	// referenced by the repository.
+	Blobs int `json:"blobs"`
+
+	// Size is the logical size of the repository: the sum of the sizes of
+	// all distinct blobs it references.
+	Size int64 `json:"size"`
+
+	// SharedSize is the part of Size made up of blobs which are also
+	// referenced by other repositories.
+	SharedSize int64 `json:"sharedSize"`
+
+	// ExclusiveSize is the part of Size made up of blobs which are only
+	// referenced by this repository. This is the space reclaimed by
+	// deleting the repository and running the garbage collector.
+	ExclusiveSize int64 `json:"exclusiveSize"`
+
+	// OldestPush and NewestPush are the earliest and latest times at which
+	// a manifest revision was linked into the repository. They are zero if
+	// the repository holds no manifests.
+	OldestPush time.Time `json:"oldestPush"`
+	NewestPush time.Time `json:"newestPush"`
+}
+
+// UsageReport describes the storage consumed by the repositories of a
+// registry.
+type UsageReport struct {
+	// Repositories holds the usage of each repository, sorted by name.
+	Repositories []RepositoryUsage `json:"repositories"`
+
+	// Size is the sum of the sizes of all distinct blobs referenced by any
+	// repository. Blobs which are not referenced by any manifest are not
+	// included.
+	Size int64 `json:"size"`
+}
+
+// Usage walks all repositories of registry and computes their storage usage.
+// Only blobs reachable from manifests are accounted for; sizes are taken from
+// the manifest descriptors, so the walk does not stat every blob.
+func Usage(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace) (UsageReport, error) {
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return UsageReport{}, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	// blobSizes records the size of every referenced blob and blobRepos
+	// the number of repositories referencing it.
+	blobSizes := make(map[digest.Digest]int64)
+	blobRepos := make(map[digest.Digest]int)
+	repoBlobs := make(map[string]map[digest.Digest]struct{})
+
+	var usages []RepositoryUsage
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		usage := RepositoryUsage{Name: repoName}
+		blobs := make(map[digest.Digest]struct{})
+
+		addBlob := func(dgst digest.Digest, size int64) {
+			if _, ok := blobs[dgst]; ok {
+				return
+			}
+			blobs[dgst] = struct{}{}
+			blobRepos[dgst]++
+			if size > blobSizes[dgst] {
+				blobSizes[dgst] = size
+			}
+		}
+
+		err := enumerateManifests(ctx, registry, repoName, func(repository distribution.Repository, manifestService distribution.ManifestService, dgst digest.Digest) error {
+			usage.Manifests++
+
+			manifest, err := manifestService.Get(ctx, dgst)
+			if err != nil {
+				return fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
+			}
+			_, payload, err := manifest.Payload()
+			if err != nil {
+				return fmt.Errorf("failed to retrieve payload for manifest %v: %v", dgst, err)
+			}
+			addBlob(dgst, int64(len(payload)))
+			for _, descriptor := range manifest.References() {
+				addBlob(descriptor.Digest, descriptor.Size)
+			}
+
+			linkPath, err := pathFor(manifestRevisionLinkPathSpec{name: repoName, revision: dgst})
+			if err != nil {
+				return err
+			}
+			fi, err := storageDriver.Stat(ctx, linkPath)
+			if err != nil {
+				return fmt.Errorf("failed to stat manifest link for digest %v: %v", dgst, err)
+			}
+			if pushed := fi.ModTime(); !pushed.IsZero() {
+				if usage.OldestPush.IsZero() || pushed.Before(usage.OldestPush) {
+					usage.OldestPush = pushed
+				}
+				if pushed.After(usage.NewestPush) {
+					usage.NewestPush = pushed
+				}
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+		tags, err := repository.Tags(ctx).All(ctx)
+		switch err.(type) {
+		case nil, distribution.ErrRepositoryUnknown:
+		default:
+			return fmt.Errorf("failed to retrieve tags for %s: %v", repoName, err)
+		}
+		usage.Tags = len(tags)
+
+		repoBlobs[repoName] = blobs
+		usages = append(usages, usage)
+		return nil
+	})
+	if err != nil {
+		return UsageReport{}, err
+	}
+
+	report := UsageReport{Repositories: usages}
+	for i := range report.Repositories {
+		usage := &report.Repositories[i]
+		for dgst := range repoBlobs[usage.Name] {
+			size := blobSizes[dgst]
+			usage.Blobs++
+			usage.Size += size
+			if blobRepos[dgst] > 1 {
+				usage.SharedSize += size
+			} else {
+				usage.ExclusiveSize += size
+			}
+		}
+	}
+	for _, size := range blobSizes {
+		report.Size += size
+	}
+
+	sort.Slice(report.Repositories, func(i, j int) bool {
+		return report.Repositories[i].Name < report.Repositories[j].Name
+	})
+
+	return report, nil
+}
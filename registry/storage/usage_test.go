@@ -0,0 +1,79 @@
+package storage
+
+import (
+	"io"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+// imageSize returns the size of the manifest and all blobs it references.
+func imageSize(t *testing.T, manifest distribution.Manifest) int64 {
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(payload))
+	for _, d := range manifest.References() {
+		size += d.Size
+	}
+	return size
+}
+
+func TestUsage(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repoA := makeRepository(t, registry, "a")
+	repoB := makeRepository(t, registry, "b")
+
+	image1 := uploadRandomSchema2Image(t, repoA)
+	if err := repoA.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: image1.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+
+	// share image1, including its empty config, with repository b
+	if _, err := repoB.Blobs(ctx).Put(ctx, "application/octet-stream", []byte{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, rs := range image1.layers {
+		if _, err := rs.Seek(0, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+	}
+	uploadImage(t, repoB, image1)
+	image2 := uploadRandomSchema2Image(t, repoB)
+
+	report, err := Usage(ctx, inmemoryDriver, registry)
+	if err != nil {
+		t.Fatalf("failed to compute usage: %v", err)
+	}
+
+	if len(report.Repositories) != 2 {
+		t.Fatalf("unexpected number of repositories: %d", len(report.Repositories))
+	}
+
+	size1 := imageSize(t, image1.manifest)
+	size2 := imageSize(t, image2.manifest)
+
+	a, b := report.Repositories[0], report.Repositories[1]
+	if a.Name != "a" || b.Name != "b" {
+		t.Fatalf("unexpected repositories: %s, %s", a.Name, b.Name)
+	}
+
+	if a.Manifests != 1 || a.Tags != 1 || a.Size != size1 || a.SharedSize != size1 || a.ExclusiveSize != 0 {
+		t.Errorf("unexpected usage for a: %+v", a)
+	}
+	if b.Manifests != 2 || b.Tags != 0 || b.Size != size1+size2 || b.SharedSize != size1 || b.ExclusiveSize != size2 {
+		t.Errorf("unexpected usage for b: %+v", b)
+	}
+	if report.Size != size1+size2 {
+		t.Errorf("unexpected total size: %d != %d", report.Size, size1+size2)
+	}
+	if a.OldestPush.IsZero() || b.NewestPush.Before(b.OldestPush) {
+		t.Errorf("unexpected push times: %+v, %+v", a, b)
+	}
+}
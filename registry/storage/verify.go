@@ -0,0 +1,420 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/cache"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// VerifyOpts contains options for the integrity verifier
+type VerifyOpts struct {
+	// Quarantine moves blob data which does not match its digest out of
+	// the blob store, so that it is no longer served and can be pushed
+	// again.
+	Quarantine bool
+
+	// RateLimit limits the number of bytes of blob data read per second.
+	// Zero disables the limit.
+	RateLimit int64
+
+	// SkipBlobData skips re-hashing blob data and only verifies links and
+	// manifest references.
+	SkipBlobData bool
+
+	// BlobDescriptorCache is the blob descriptor cache of the registry, if
+	// any. The descriptors of quarantined blobs are cleared from it, so that
+	// the registry no longer reports them as present.
+	BlobDescriptorCache cache.BlobDescriptorCacheProvider
+}
+
+// Kinds of problems reported by Verify.
+const (
+	// ProblemDigestMismatch is reported for blob data which does not hash
+	// to the digest in its path.
+	ProblemDigestMismatch = "digest-mismatch"
+
+	// ProblemUnreadableBlob is reported for blob data which could not be
+	// read.
+	ProblemUnreadableBlob = "unreadable-blob"
+
+	// ProblemInvalidLink is reported for link files whose content is not
+	// the digest in their path.
+	ProblemInvalidLink = "invalid-link"
+
+	// ProblemDanglingLink is reported for layer and manifest revision links
+	// pointing at blobs which do not exist.
+	ProblemDanglingLink = "dangling-link"
+
+	// ProblemUnreadableManifest is reported for manifest revisions which
+	// could not be fetched or parsed.
+	ProblemUnreadableManifest = "unreadable-manifest"
+
+	// ProblemMissingReference is reported for manifests referencing blobs
+	// which do not exist.
+	ProblemMissingReference = "missing-reference"
+)
+
+// VerifyProblem describes an integrity problem found by Verify.
+type VerifyProblem struct {
+	// Kind is one of the Problem constants.
+	Kind string `json:"kind"`
+
+	// Repository is the repository the problem was found in, if any.
+	Repository string `json:"repository,omitempty"`
+
+	// Digest is the digest of the affected blob.
+	Digest digest.Digest `json:"digest"`
+
+	// Path is the storage path of the affected object.
+	Path string `json:"path"`
+
+	// Detail describes the problem.
+	Detail string `json:"detail,omitempty"`
+
+	// Quarantined is true if the affected object was moved out of the blob
+	// store.
+	Quarantined bool `json:"quarantined,omitempty"`
+}
+
+func (p VerifyProblem) String() string {
+	s := fmt.Sprintf("%s: %s %s", p.Kind, p.Digest, p.Path)
+	if p.Repository != "" {
+		s = p.Repository + ": " + s
+	}
+	if p.Detail != "" {
+		s += ": " + p.Detail
+	}
+	if p.Quarantined {
+		s += " (quarantined)"
+	}
+	return s
+}
+
+// VerifyResult summarizes a run of Verify.
+type VerifyResult struct {
+	Blobs     int             `json:"blobs"`
+	Bytes     int64           `json:"bytes"`
+	Links     int             `json:"links"`
+	Manifests int             `json:"manifests"`
+	Problems  []VerifyProblem `json:"problems"`
+}
+
+// Verify checks the integrity of the registry's storage. It re-hashes blob
+// data against the digest in its path, checks that the layer and manifest
+// revision links of every repository point at existing blobs and that the
+// blobs referenced by every manifest exist. Problems are collected in the
+// result; an error is only returned if the storage could not be walked.
+func Verify(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts VerifyOpts) (VerifyResult, error) {
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return VerifyResult{}, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	v := &verifier{
+		ctx:         ctx,
+		driver:      storageDriver,
+		opts:        opts,
+		limiter:     newRateLimiter(opts.RateLimit),
+		existing:    make(map[digest.Digest]bool),
+		quarantined: make(map[digest.Digest]bool),
+	}
+
+	if !opts.SkipBlobData {
+		err := registry.Blobs().Enumerate(ctx, func(dgst digest.Digest) error {
+			return v.verifyBlob(dgst)
+		})
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return v.result, fmt.Errorf("error enumerating blobs: %v", err)
+			}
+		}
+	}
+
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		if err := v.clearRepositoryCache(repoName); err != nil {
+			return err
+		}
+
+		layers, err := pathFor(layersPathSpec{name: repoName})
+		if err != nil {
+			return err
+		}
+		if err := v.verifyLinks(repoName, layers); err != nil {
+			return err
+		}
+
+		revisions, err := pathFor(manifestRevisionsPathSpec{name: repoName})
+		if err != nil {
+			return err
+		}
+		if err := v.verifyLinks(repoName, revisions); err != nil {
+			return err
+		}
+
+		return enumerateManifests(ctx, registry, repoName, func(repository distribution.Repository, manifestService distribution.ManifestService, dgst digest.Digest) error {
+			return v.verifyManifest(repoName, manifestService, dgst)
+		})
+	})
+	if err != nil {
+		return v.result, fmt.Errorf("error enumerating repositories: %v", err)
+	}
+
+	return v.result, nil
+}
+
+type verifier struct {
+	ctx     context.Context
+	driver  driver.StorageDriver
+	opts    VerifyOpts
+	limiter *rateLimiter
+	result  VerifyResult
+
+	// existing caches whether the data of a blob exists
+	existing map[digest.Digest]bool
+
+	// quarantined holds the blobs moved out of the blob store
+	quarantined map[digest.Digest]bool
+}
+
+func (v *verifier) report(problem VerifyProblem) {
+	dcontext.GetLogger(v.ctx).Warnf("verify: %s", problem)
+	v.result.Problems = append(v.result.Problems, problem)
+}
+
+// verifyBlob re-hashes the data of the blob and compares it to dgst.
+func (v *verifier) verifyBlob(dgst digest.Digest) error {
+	dataPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+	v.result.Blobs++
+
+	if !dgst.Algorithm().Available() {
+		dcontext.GetLogger(v.ctx).Warnf("verify: skipping %s: unsupported digest algorithm", dgst)
+		return nil
+	}
+
+	rc, err := v.driver.Reader(v.ctx, dataPath, 0)
+	if err != nil {
+		v.report(VerifyProblem{Kind: ProblemUnreadableBlob, Digest: dgst, Path: dataPath, Detail: err.Error()})
+		return nil
+	}
+	defer rc.Close()
+
+	verifier := dgst.Verifier()
+	n, err := io.Copy(verifier, v.limiter.reader(v.ctx, rc))
+	v.result.Bytes += n
+	if err != nil {
+		if err == v.ctx.Err() {
+			return err
+		}
+		v.report(VerifyProblem{Kind: ProblemUnreadableBlob, Digest: dgst, Path: dataPath, Detail: err.Error()})
+		return nil
+	}
+
+	if !verifier.Verified() {
+		problem := VerifyProblem{Kind: ProblemDigestMismatch, Digest: dgst, Path: dataPath, Detail: fmt.Sprintf("%d bytes do not match digest", n)}
+		if v.opts.Quarantine {
+			if err := v.quarantine(dgst, dataPath); err != nil {
+				problem.Detail += fmt.Sprintf("; failed to quarantine: %v", err)
+			} else {
+				problem.Quarantined = true
+			}
+		}
+		v.report(problem)
+	}
+
+	return nil
+}
+
+// quarantine moves the blob data at dataPath out of the blob store.
+func (v *verifier) quarantine(dgst digest.Digest, dataPath string) error {
+	quarantinePath, err := pathFor(corruptBlobDataPathSpec{digest: dgst})
+	if err != nil {
+		return err
+	}
+	if err := v.driver.Move(v.ctx, dataPath, quarantinePath); err != nil {
+		return err
+	}
+	v.existing[dgst] = false
+	v.quarantined[dgst] = true
+
+	if v.opts.BlobDescriptorCache != nil {
+		if err := v.opts.BlobDescriptorCache.Clear(v.ctx, dgst); err != nil && err != distribution.ErrBlobUnknown {
+			dcontext.GetLogger(v.ctx).Warnf("verify: failed to clear cached descriptor of %s: %v", dgst, err)
+		}
+	}
+	return nil
+}
+
+// clearRepositoryCache clears the descriptors of the quarantined blobs from
+// the blob descriptor cache of the named repository.
+func (v *verifier) clearRepositoryCache(repoName string) error {
+	if v.opts.BlobDescriptorCache == nil || len(v.quarantined) == 0 {
+		return nil
+	}
+	descriptors, err := v.opts.BlobDescriptorCache.RepositoryScoped(repoName)
+	if err != nil {
+		return err
+	}
+	for dgst := range v.quarantined {
+		if err := descriptors.Clear(v.ctx, dgst); err != nil && err != distribution.ErrBlobUnknown {
+			dcontext.GetLogger(v.ctx).Warnf("verify: failed to clear cached descriptor of %s in %s: %v", dgst, repoName, err)
+		}
+	}
+	return nil
+}
+
+// verifyLinks checks that every link file below root contains the digest in
+// its path and points at existing blob data.
+func (v *verifier) verifyLinks(repoName, root string) error {
+	err := v.driver.Walk(v.ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" {
+			return nil
+		}
+		linkPath := fileInfo.Path()
+		v.result.Links++
+
+		// links are stored as <algorithm>/<hex digest>/link
+		dir := path.Dir(linkPath)
+		expected := digest.NewDigestFromEncoded(digest.Algorithm(path.Base(path.Dir(dir))), path.Base(dir))
+
+		content, err := v.driver.GetContent(v.ctx, linkPath)
+		if err != nil {
+			v.report(VerifyProblem{Kind: ProblemInvalidLink, Repository: repoName, Digest: expected, Path: linkPath, Detail: err.Error()})
+			return nil
+		}
+		linked, err := digest.Parse(string(content))
+		if err != nil || linked != expected {
+			v.report(VerifyProblem{Kind: ProblemInvalidLink, Repository: repoName, Digest: expected, Path: linkPath, Detail: fmt.Sprintf("link contains %q", content)})
+			return nil
+		}
+
+		exists, err := v.blobExists(linked)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			v.report(VerifyProblem{Kind: ProblemDanglingLink, Repository: repoName, Digest: linked, Path: linkPath, Detail: "linked blob does not exist"})
+		}
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// verifyManifest checks that all blobs referenced by the manifest exist.
+func (v *verifier) verifyManifest(repoName string, manifestService distribution.ManifestService, dgst digest.Digest) error {
+	linkPath, err := pathFor(manifestRevisionLinkPathSpec{name: repoName, revision: dgst})
+	if err != nil {
+		return err
+	}
+
+	// dangling revision links have already been reported
+	if exists, err := v.blobExists(dgst); err != nil || !exists {
+		return err
+	}
+	v.result.Manifests++
+
+	manifest, err := manifestService.Get(v.ctx, dgst)
+	if err != nil {
+		v.report(VerifyProblem{Kind: ProblemUnreadableManifest, Repository: repoName, Digest: dgst, Path: linkPath, Detail: err.Error()})
+		return nil
+	}
+
+	for _, descriptor := range manifest.References() {
+		if len(descriptor.URLs) > 0 {
+			// foreign layers are not stored in the registry
+			continue
+		}
+		exists, err := v.blobExists(descriptor.Digest)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			v.report(VerifyProblem{Kind: ProblemMissingReference, Repository: repoName, Digest: descriptor.Digest, Path: linkPath, Detail: fmt.Sprintf("referenced by manifest %s", dgst)})
+		}
+	}
+	return nil
+}
+
+// blobExists returns true if the data of the blob exists in the blob store.
+func (v *verifier) blobExists(dgst digest.Digest) (bool, error) {
+	if exists, ok := v.existing[dgst]; ok {
+		return exists, nil
+	}
+	dataPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return false, nil
+	}
+	_, err = v.driver.Stat(v.ctx, dataPath)
+	switch err.(type) {
+	case nil:
+		v.existing[dgst] = true
+	case driver.PathNotFoundError:
+		v.existing[dgst] = false
+	default:
+		return false, err
+	}
+	return v.existing[dgst], nil
+}
+
+// rateLimiter throttles reads to a number of bytes per second. The time
+// spent between reads, such as while links are checked, is not credited to
+// later reads, so that they do not burst above the rate.
+type rateLimiter struct {
+	rate int64
+	due  time.Time // when the bytes read so far are paid for
+}
+
+func newRateLimiter(rate int64) *rateLimiter {
+	return &rateLimiter{rate: rate}
+}
+
+// reader wraps r so that reads from it are throttled.
+func (rl *rateLimiter) reader(ctx context.Context, r io.Reader) io.Reader {
+	if rl.rate <= 0 {
+		return r
+	}
+	return &rateLimitedReader{ctx: ctx, r: r, limiter: rl}
+}
+
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *rateLimiter
+}
+
+func (rlr *rateLimitedReader) Read(p []byte) (int, error) {
+	rl := rlr.limiter
+	if int64(len(p)) > rl.rate {
+		p = p[:rl.rate]
+	}
+	n, err := rlr.r.Read(p)
+
+	now := time.Now()
+	if rl.due.Before(now) {
+		rl.due = now
+	}
+	rl.due = rl.due.Add(time.Duration(float64(n) / float64(rl.rate) * float64(time.Second)))
+	if wait := rl.due.Sub(now); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-rlr.ctx.Done():
+			return n, rlr.ctx.Err()
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/cache/memory"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func countProblems(result VerifyResult, kind string) int {
+	n := 0
+	for _, problem := range result.Problems {
+		if problem.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+func TestVerify(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	descriptorCache := memory.NewInMemoryBlobDescriptorCacheProvider(memory.DefaultSize)
+	registry := createRegistry(t, inmemoryDriver, BlobDescriptorCacheProvider(descriptorCache))
+	repo := makeRepository(t, registry, "foo/bar")
+	image := uploadRandomSchema2Image(t, repo)
+
+	result, err := Verify(ctx, inmemoryDriver, registry, VerifyOpts{})
+	if err != nil {
+		t.Fatalf("unexpected error verifying: %v", err)
+	}
+	if len(result.Problems) != 0 {
+		t.Fatalf("unexpected problems in a consistent registry: %v", result.Problems)
+	}
+	if result.Blobs != len(allBlobs(t, registry)) {
+		t.Errorf("expected %d blobs to be verified, got %d", len(allBlobs(t, registry)), result.Blobs)
+	}
+	if result.Manifests != 1 {
+		t.Errorf("expected 1 manifest to be verified, got %d", result.Manifests)
+	}
+
+	// corrupt the data of a layer
+	corrupted := getAnyKey(image.layers)
+	dataPath, err := pathFor(blobDataPathSpec{digest: corrupted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inmemoryDriver.PutContent(ctx, dataPath, []byte("corrupted")); err != nil {
+		t.Fatal(err)
+	}
+
+	// point a link at a digest other than the one in its path
+	var other digest.Digest
+	for dgst := range image.layers {
+		if dgst != corrupted {
+			other = dgst
+			break
+		}
+	}
+	linkPath, err := pathFor(layerLinkPathSpec{name: "foo/bar", digest: other})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := inmemoryDriver.PutContent(ctx, linkPath, []byte(corrupted)); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = Verify(ctx, inmemoryDriver, registry, VerifyOpts{})
+	if err != nil {
+		t.Fatalf("unexpected error verifying: %v", err)
+	}
+	if n := countProblems(result, ProblemDigestMismatch); n != 1 {
+		t.Errorf("expected 1 digest mismatch, got %d: %v", n, result.Problems)
+	}
+	if n := countProblems(result, ProblemInvalidLink); n != 1 {
+		t.Errorf("expected 1 invalid link, got %d: %v", n, result.Problems)
+	}
+	if len(result.Problems) != 2 {
+		t.Errorf("unexpected problems: %v", result.Problems)
+	}
+
+	// cache the descriptor of the corrupted blob
+	if _, err := repo.Blobs(ctx).Stat(ctx, corrupted); err != nil {
+		t.Fatalf("unexpected error statting blob: %v", err)
+	}
+
+	// quarantining removes the corrupted data from the blob store, which
+	// leaves the layer link and the manifest reference dangling
+	result, err = Verify(ctx, inmemoryDriver, registry, VerifyOpts{Quarantine: true, RateLimit: 1 << 26, BlobDescriptorCache: descriptorCache})
+	if err != nil {
+		t.Fatalf("unexpected error verifying: %v", err)
+	}
+	for _, problem := range result.Problems {
+		if problem.Kind == ProblemDigestMismatch && !problem.Quarantined {
+			t.Errorf("expected %s to be quarantined", problem.Digest)
+		}
+	}
+	if n := countProblems(result, ProblemDanglingLink); n != 1 {
+		t.Errorf("expected 1 dangling link, got %d: %v", n, result.Problems)
+	}
+	if n := countProblems(result, ProblemMissingReference); n != 1 {
+		t.Errorf("expected 1 missing reference, got %d: %v", n, result.Problems)
+	}
+
+	if _, err := inmemoryDriver.Stat(ctx, dataPath); err == nil {
+		t.Errorf("expected corrupted data to be removed from the blob store")
+	} else if _, ok := err.(driver.PathNotFoundError); !ok {
+		t.Fatal(err)
+	}
+	quarantinePath, err := pathFor(corruptBlobDataPathSpec{digest: corrupted})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inmemoryDriver.Stat(ctx, quarantinePath); err != nil {
+		t.Errorf("expected corrupted data to be quarantined: %v", err)
+	}
+	if _, ok := allBlobs(t, registry)[corrupted]; ok {
+		t.Errorf("expected quarantined blob not to be enumerated")
+	}
+	if _, err := repo.Blobs(ctx).Stat(ctx, corrupted); err != distribution.ErrBlobUnknown {
+		t.Errorf("expected quarantined blob to be unknown, got %v", err)
+	}
+	if _, err := descriptorCache.Stat(ctx, corrupted); err != distribution.ErrBlobUnknown {
+		t.Errorf("expected quarantined blob to be cleared from the cache, got %v", err)
+	}
+}
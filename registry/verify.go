@@ -0,0 +1,94 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/cache"
+	rediscache "github.com/distribution/distribution/v3/registry/storage/cache/redis"
+	"github.com/spf13/cobra"
+)
+
+var (
+	verifyQuarantine bool
+	verifyRateLimit  int64
+	verifySkipData   bool
+	verifyJSON       bool
+)
+
+// VerifyCmd is the cobra command that corresponds to the verify subcommand
+var VerifyCmd = &cobra.Command{
+	Use:   "verify <config>",
+	Short: "`verify` checks the integrity of the blob store",
+	Long:  "`verify` re-hashes blob data, checks that repository links point at existing blobs and that the blobs referenced by manifests exist. It exits with status 2 if any problems are found",
+	Run: func(cmd *cobra.Command, args []string) {
+		if verifyRateLimit < 0 {
+			fmt.Fprintln(os.Stderr, "rate limit must not be negative")
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		ctx, config, driver, registry := openStorage(cmd, args)
+
+		opts := storage.VerifyOpts{
+			Quarantine:   verifyQuarantine,
+			RateLimit:    verifyRateLimit,
+			SkipBlobData: verifySkipData,
+		}
+		if verifyQuarantine {
+			opts.BlobDescriptorCache = descriptorCache(config)
+		}
+
+		result, err := storage.Verify(ctx, driver, registry, opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to verify: %v", err)
+			os.Exit(1)
+		}
+
+		if verifyJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(result); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to write result: %v", err)
+				os.Exit(1)
+			}
+		} else {
+			for _, problem := range result.Problems {
+				fmt.Println(problem)
+			}
+			fmt.Printf("\n%d blobs (%s), %d links and %d manifests checked, %d problems found\n",
+				result.Blobs, formatSize(result.Bytes), result.Links, result.Manifests, len(result.Problems))
+		}
+
+		if len(result.Problems) > 0 {
+			os.Exit(2)
+		}
+	},
+}
+
+// descriptorCache returns the blob descriptor cache the registry is
+// configured with, for quarantined blobs to be cleared from it. Only a redis
+// cache is shared with the running registries: an inmemory cache lives in
+// each registry process and keeps serving the descriptors of quarantined
+// blobs until the process restarts.
+func descriptorCache(config *configuration.Configuration) cache.BlobDescriptorCacheProvider {
+	cc := config.Storage["cache"]
+	v, ok := cc["blobdescriptor"]
+	if !ok {
+		v = cc["layerinfo"]
+	}
+	switch v {
+	case "redis":
+		if config.Redis.Addr == "" {
+			return nil
+		}
+		return rediscache.NewRedisBlobDescriptorCacheProvider(handlers.NewRedisClient(config.Redis))
+	case "inmemory":
+		fmt.Fprintln(os.Stderr, "warning: the inmemory blob descriptor caches of running registries are not cleared of quarantined blobs, restart them")
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+// Package tracing configures the OpenTelemetry SDK exporting the spans
+// recorded by the registry.
+//
+// The registry is instrumented with the OpenTelemetry API only. This package
+// provides the TracerProvider recording the spans, which samples a ratio of
+// the traces and exports their spans in batches to an OTLP/HTTP endpoint, a
+// file or the standard output.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+
+	"github.com/distribution/distribution/v3/configuration"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/version"
+	"github.com/docker/go-metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const (
+	// ExporterOTLPHTTP posts the spans to an OTLP/HTTP endpoint.
+	ExporterOTLPHTTP = "otlphttp"
+	// ExporterFile appends the spans to a file.
+	ExporterFile = "file"
+	// ExporterStdout writes the spans to the standard output.
+	ExporterStdout = "stdout"
+
+	defaultServiceName = "registry"
+)
+
+var exportErrors = prometheus.TracingNamespace.NewCounter("export_errors", "The number of failed exports of batches of spans")
+
+func init() {
+	metrics.Register(prometheus.TracingNamespace)
+}
+
+// NewProvider returns a TracerProvider configured by config.
+func NewProvider(config configuration.Tracing) (*sdktrace.TracerProvider, error) {
+	ratio := config.SampleRatio
+	if ratio == 0 {
+		ratio = 1
+	}
+	if ratio < 0 || ratio > 1 {
+		return nil, fmt.Errorf("tracing sampleratio must be between 0 and 1")
+	}
+
+	serviceName := config.ServiceName
+	if serviceName == "" {
+		serviceName = defaultServiceName
+	}
+	attributes := []attribute.KeyValue{
+		attribute.String("service.name", serviceName),
+		attribute.String("service.version", version.Version),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		attributes = append(attributes, attribute.String("host.name", hostname))
+	}
+
+	var exporter sdktrace.SpanExporter
+	switch config.Exporter {
+	case ExporterOTLPHTTP, "":
+		if config.Endpoint == "" {
+			return nil, fmt.Errorf("tracing endpoint is required for the %s exporter", ExporterOTLPHTTP)
+		}
+		var err error
+		exporter, err = newHTTPExporter(config.Endpoint, config.Headers)
+		if err != nil {
+			return nil, err
+		}
+	case ExporterFile:
+		if config.Path == "" {
+			return nil, fmt.Errorf("tracing path is required for the %s exporter", ExporterFile)
+		}
+		var err error
+		exporter, err = newFileExporter(config.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open tracing file: %v", err)
+		}
+	case ExporterStdout:
+		var err error
+		exporter, err = stdouttrace.New()
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q", config.Exporter)
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithResource(resource.NewSchemaless(attributes...)),
+		// the decision of the client is followed for the traces it started
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithBatcher(countingExporter{exporter}),
+	), nil
+}
+
+// Configure installs the TracerProvider configured by config as the global
+// TracerProvider, and the W3C trace context and baggage as the global
+// propagators. It returns nil if tracing is disabled.
+func Configure(config configuration.Tracing) (*sdktrace.TracerProvider, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+	provider, err := NewProvider(config)
+	if err != nil {
+		return nil, err
+	}
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider, nil
+}
+
+// newHTTPExporter returns an exporter posting the spans to the /v1/traces
+// path of the OTLP/HTTP receiver at endpoint.
+func newHTTPExporter(endpoint string, headers map[string]string) (sdktrace.SpanExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid tracing endpoint %q", endpoint)
+	}
+	urlPath := u.Path
+	if path.Base(urlPath) != "traces" {
+		urlPath = path.Join("/", urlPath, "v1/traces")
+	}
+
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(urlPath),
+		otlptracehttp.WithHeaders(headers),
+	}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	return otlptracehttp.New(context.Background(), options...)
+}
+
+// fileExporter appends the spans to a file, closed on shutdown.
+type fileExporter struct {
+	sdktrace.SpanExporter
+	f *os.File
+}
+
+func newFileExporter(path string) (*fileExporter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &fileExporter{SpanExporter: exporter, f: f}, nil
+}
+
+func (e *fileExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+	if closeErr := e.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// countingExporter counts the failed exports of the wrapped exporter.
+type countingExporter struct {
+	sdktrace.SpanExporter
+}
+
+func (e countingExporter) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	err := e.SpanExporter.ExportSpans(ctx, spans)
+	if err != nil {
+		exportErrors.Inc(1)
+	}
+	return err
+}
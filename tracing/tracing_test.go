@@ -0,0 +1,188 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestNewProviderValidation(t *testing.T) {
+	for _, config := range []configuration.Tracing{
+		{Enabled: true, SampleRatio: 2, Exporter: ExporterStdout},
+		{Enabled: true, Exporter: ExporterOTLPHTTP},
+		{Enabled: true, Exporter: ExporterFile},
+		{Enabled: true, Exporter: "zipkin"},
+	} {
+		if _, err := NewProvider(config); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+
+	provider, err := Configure(configuration.Tracing{})
+	if err != nil || provider != nil {
+		t.Fatalf("expected no provider when tracing is disabled, got %v, %v", provider, err)
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	p, err := NewProvider(configuration.Tracing{
+		Enabled:     true,
+		ServiceName: "test-registry",
+		Exporter:    ExporterFile,
+		Path:        path,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := p.Tracer("test")
+	ctx, parent := tracer.Start(context.Background(), "parent", trace.WithSpanKind(trace.SpanKindServer))
+	_, child := tracer.Start(ctx, "child", trace.WithAttributes(attribute.Int("size", 42)))
+	child.RecordError(errors.New("failed"))
+	child.SetStatus(codes.Error, "failed")
+	child.End()
+	parent.End()
+	// spans ended twice are only exported once
+	parent.End()
+
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var spans []exportedSpan
+	decoder := json.NewDecoder(f)
+	for {
+		var span exportedSpan
+		if err := decoder.Decode(&span); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if !hasAttribute(span.Resource, "service.name", "test-registry") {
+			t.Errorf("expected the service name in the resource, got %+v", span.Resource)
+		}
+		if span.InstrumentationLibrary.Name != "test" {
+			t.Errorf("unexpected scope %q", span.InstrumentationLibrary.Name)
+		}
+		spans = append(spans, span)
+	}
+
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	c, s := spans[0], spans[1]
+	if c.Name != "child" || s.Name != "parent" {
+		t.Fatalf("unexpected spans %q, %q", c.Name, s.Name)
+	}
+	if c.SpanContext.TraceID != s.SpanContext.TraceID || c.Parent.SpanID != s.SpanContext.SpanID || s.Parent.SpanID != "0000000000000000" {
+		t.Errorf("expected child to be a child of parent: %+v, %+v", c, s)
+	}
+	if s.SpanKind != trace.SpanKindServer || c.SpanKind != trace.SpanKindInternal {
+		t.Errorf("unexpected kinds %d, %d", s.SpanKind, c.SpanKind)
+	}
+	if c.Status.Description != "failed" {
+		t.Errorf("unexpected status %+v", c.Status)
+	}
+	if len(c.Events) != 1 || c.Events[0].Name != "exception" {
+		t.Errorf("expected an exception event, got %+v", c.Events)
+	}
+	if len(c.Attributes) != 1 || c.Attributes[0].Key != "size" || c.Attributes[0].Value.Value != float64(42) {
+		t.Errorf("unexpected attributes %+v", c.Attributes)
+	}
+}
+
+func TestHTTPExporter(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	bodies := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		requests <- r
+		bodies <- body
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(configuration.Tracing{
+		Enabled:  true,
+		Exporter: ExporterOTLPHTTP,
+		Endpoint: server.URL,
+		Headers:  map[string]string{"Authorization": "Bearer secret"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := p.Tracer("test").Start(context.Background(), "span")
+	span.End()
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-requests
+	if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" {
+		t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+	}
+	if r.Header.Get("Content-Type") != "application/x-protobuf" || r.Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("unexpected headers %v", r.Header)
+	}
+	if len(<-bodies) == 0 {
+		t.Error("expected the spans in the request body")
+	}
+}
+
+// exportedSpan is the part of the spans written by the file exporter checked
+// by the tests.
+type exportedSpan struct {
+	Name        string
+	SpanContext exportedSpanContext
+	Parent      exportedSpanContext
+	SpanKind    trace.SpanKind
+	Attributes  []exportedKeyValue
+	Events      []struct {
+		Name string
+	}
+	Status struct {
+		Description string
+	}
+	Resource               []exportedKeyValue
+	InstrumentationLibrary struct {
+		Name string
+	}
+}
+
+type exportedSpanContext struct {
+	TraceID string
+	SpanID  string
+}
+
+type exportedKeyValue struct {
+	Key   string
+	Value struct {
+		Value interface{}
+	}
+}
+
+func hasAttribute(attrs []exportedKeyValue, key, value string) bool {
+	for _, kv := range attrs {
+		if kv.Key == key && kv.Value.Value == value {
+			return true
+		}
+	}
+	return false
+}